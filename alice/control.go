@@ -0,0 +1,154 @@
+package alice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/net"
+)
+
+var (
+	errNoOngoingSwap = errors.New("no ongoing swap with given ID")
+	errCannotAbort   = errors.New("cannot abort swap after our ETH has been locked, refund it instead")
+	errCannotClaim   = errors.New("only Bob can claim, we receive the XMR once he does")
+	errTimelock      = errors.New("not allowed by the swap contract's timelock")
+)
+
+// getOngoingSwap returns the ongoing swap with the given ID.
+func (a *alice) getOngoingSwap(id common.SwapID) (*swapState, error) {
+	a.swapMu.Lock()
+	defer a.swapMu.Unlock()
+
+	s, has := a.swapStates[id]
+	if !has {
+		return nil, errNoOngoingSwap
+	}
+
+	return s, nil
+}
+
+// Cancel aborts the swap with the given ID. It's only possible before our ETH has been locked.
+func (a *alice) Cancel(id common.SwapID) error {
+	s, err := a.getOngoingSwap(id)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	err = checkCanAbort(s.status.Status())
+	if err == nil && s.txInFlight != "" {
+		// the contract may be being deployed
+		err = fmt.Errorf("%w: %s", errTxInFlight, s.txInFlight)
+	}
+
+	if err == nil {
+		err = s.setStatus(common.CompletedAbort)
+	}
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("swap aborted by user: id=%s", id)
+	s.cancel()
+	a.removeSwap(id)
+
+	if err = a.net.CloseSwap(id); err != nil {
+		log.Debugf("failed to close swap stream: id=%s err=%s", id, err)
+	}
+
+	return nil
+}
+
+func checkCanAbort(status common.Status) error {
+	switch status {
+	case common.ExpectingKeys, common.KeysExchanged:
+		return nil
+	default:
+		if status.IsComplete() {
+			return fmt.Errorf("swap has already completed with status %s", status)
+		}
+
+		return errCannotAbort
+	}
+}
+
+// Refund calls Refund() on the contract of the swap with the given ID, returning the hash of the transaction.
+// It returns an error if the swap's timelocks don't allow a refund yet.
+func (a *alice) Refund(id common.SwapID) (string, error) {
+	s, err := a.getOngoingSwap(id)
+	if err != nil {
+		return "", err
+	}
+
+	switch status := s.status.Status(); status {
+	case common.ETHLocked, common.XMRLocked, common.ContractReady:
+	default:
+		return "", fmt.Errorf("cannot refund swap with status %s", status)
+	}
+
+	if err = s.checkRefundAllowed(); err != nil {
+		return "", err
+	}
+
+	txHash, err := s.refundIfOngoing()
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("refunded by user: id=%s tx hash=%s", id, txHash)
+
+	// let Bob know, so that he can regain control of his XMR
+	if err = a.net.SendSwapMessage(id, &net.NotifyRefund{
+		TxHash: txHash,
+	}); err != nil {
+		log.Warnf("failed to send refund message: id=%s err=%s", id, err)
+	}
+
+	s.cancel()
+	return txHash, nil
+}
+
+// checkRefundAllowed returns an error if the contract doesn't allow us to refund now.
+// We can refund before t0 if we haven't called Ready(), or any time after t1.
+func (s *swapState) checkRefundAllowed() error {
+	t0, t1, err := s.getTimeouts()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !now.Before(t1) {
+		return nil
+	}
+
+	if !now.Before(t0) {
+		return fmt.Errorf("%w: refund is possible before t0 (%s) or after t1 (%s)",
+			errTimelock, t0.Format(time.RFC3339), t1.Format(time.RFC3339))
+	}
+
+	ready, err := s.isReady()
+	if err != nil {
+		return err
+	}
+
+	if ready {
+		return fmt.Errorf("%w: Ready() was called, so refund is only possible after t1 (%s)",
+			errTimelock, t1.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Claim isn't possible for Alice; Bob claims the ETH.
+func (a *alice) Claim(_ common.SwapID) (string, error) {
+	return "", errCannotClaim
+}
+
+// FeeSettings returns the fees paid by our transactions, and the network's current fees.
+func (a *alice) FeeSettings() (*chain.FeeSettings, error) {
+	return a.fees.Settings(a.ctx)
+}
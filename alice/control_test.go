@@ -0,0 +1,71 @@
+package alice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/monero"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlice_Cancel(t *testing.T) {
+	a, s := newTestAlice(t)
+	a.net = new(mockNet)
+	a.swapStates[s.id] = s
+
+	err := a.Cancel(s.id)
+	require.NoError(t, err)
+	require.Equal(t, common.CompletedAbort, s.status.Status())
+
+	_, err = a.getOngoingSwap(s.id)
+	require.Equal(t, errNoOngoingSwap, err)
+}
+
+func TestAlice_Cancel_AfterLock(t *testing.T) {
+	a, s := newTestAlice(t)
+	defer s.cancel()
+	a.net = new(mockNet)
+	a.swapStates[s.id] = s
+
+	err := s.status.Transition(common.KeysExchanged)
+	require.NoError(t, err)
+	err = s.status.Transition(common.ETHLocked)
+	require.NoError(t, err)
+
+	err = a.Cancel(s.id)
+	require.Equal(t, errCannotAbort, err)
+	require.Equal(t, common.ETHLocked, s.status.Status())
+}
+
+func TestAlice_Refund(t *testing.T) {
+	a, s := newTestAlice(t)
+	defer s.cancel()
+	a.net = new(mockNet)
+	a.swapStates[s.id] = s
+
+	_, err := a.Refund(s.id)
+	require.Error(t, err)
+
+	_, err = s.generateKeys()
+	require.NoError(t, err)
+
+	bobPrivKeys, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	s.setBobKeys(bobPrivKeys.SpendKey().Public(), bobPrivKeys.ViewKey())
+	err = s.status.Transition(common.KeysExchanged)
+	require.NoError(t, err)
+
+	_, err = s.deployAndLockETH(common.NewEtherAmount(1))
+	require.NoError(t, err)
+
+	// once Ready() is called, we can't refund until t1
+	err = s.ready()
+	require.NoError(t, err)
+
+	_, err = a.Refund(s.id)
+	require.True(t, errors.Is(err, errTimelock))
+	require.Equal(t, common.ETHLocked, s.status.Status())
+}
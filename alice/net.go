@@ -2,71 +2,172 @@ package alice
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
 	"github.com/noot/atomic-swap/net"
 
 	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	errNoSwapID    = errors.New("swap ID must be set")
+	errSwapIDInUse = errors.New("swap ID has already been used")
 )
 
 func (a *alice) Provides() common.ProvidesCoin {
 	return common.ProvidesETH
 }
 
-// InitiateProtocol is called when an RPC call is made from the user to initiate a swap.
-// The input units are ether and monero.
-func (a *alice) InitiateProtocol(providesAmount, desiredAmount float64) (net.SwapState, error) {
-	if err := a.initiate(common.EtherToWei(providesAmount), common.MoneroToPiconero(desiredAmount)); err != nil {
+// InitiateProtocol is called once a peer has agreed to the terms of a swap that the user initiated with it.
+func (a *alice) InitiateProtocol(who peer.ID, terms *net.Terms) (net.SwapState, error) {
+	s, err := a.initiate(who, terms.SwapID, common.EtherToWei(terms.ProvidesAmount),
+		common.MoneroToPiconero(terms.DesiredAmount), terms.Timeout)
+	if err != nil {
 		return nil, err
 	}
 
-	return a.swapState, nil
+	return s, nil
 }
 
 // HandleInitiateMessage is called when we receive a network message from a peer that they wish to initiate a swap.
-func (a *alice) HandleInitiateMessage(msg *net.InitiateMessage) (net.SwapState, net.Message, error) {
+func (a *alice) HandleInitiateMessage(who peer.ID, msg *net.InitiateMessage) (net.SwapState, net.Message, error) {
 	if msg.Provides != common.ProvidesXMR {
 		return nil, nil, errors.New("peer does not provide XMR")
 	}
 
-	// TODO: allow user to accept/reject this via RPC
 	str := color.New(color.Bold).Sprintf("**incoming swap with want amount %v**", msg.DesiredAmount)
 	log.Info(str)
 
+	// nothing is generated or locked until the swap is accepted
+	if err := a.waitForAcceptance(who, msg); err != nil {
+		return nil, nil, err
+	}
+
 	// the other party initiated, saying what they will provide and what they desire.
 	// we initiate our protocol, saying we will provide what they desire and vice versa.
-	if err := a.initiate(common.EtherToWei(msg.DesiredAmount), common.MoneroToPiconero(msg.ProvidesAmount)); err != nil {
+	s, err := a.initiate(who, msg.SwapID, common.EtherToWei(msg.DesiredAmount),
+		common.MoneroToPiconero(msg.ProvidesAmount), msg.Timeout)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	resp, err := a.swapState.handleSendKeysMessage(msg.SendKeysMessage)
+	s.Lock()
+	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	// Bob's keys came with his message, so ours are needed before the contract can be deployed
+	if _, err = s.generateKeys(); err != nil {
+		return s, nil, err
+	}
+
+	resp, err := s.handleSendKeysMessage(msg.SendKeysMessage)
 	if err != nil {
-		return nil, nil, err
+		// return the swap state, so that the network calls ProtocolComplete() on it
+		return s, nil, err
 	}
 
-	return a.swapState, resp, nil
+	return s, resp, nil
 }
 
-func (a *alice) initiate(providesAmount common.EtherAmount, desiredAmount common.MoneroAmount) error {
+func (a *alice) initiate(who peer.ID, id common.SwapID, providesAmount common.EtherAmount, desiredAmount common.MoneroAmount,
+	timeout time.Duration) (*swapState, error) {
 	a.swapMu.Lock()
 	defer a.swapMu.Unlock()
 
-	if a.swapState != nil {
-		return errors.New("protocol already in progress")
+	if err := a.checkSwapID(id); err != nil {
+		return nil, err
+	}
+
+	// Bob must have time to lock his XMR before we start refunding, while leaving time to sign
+	// the refund, which starts by t0 minus the signing latency and refund buffer
+	if latency := a.signingLatency(); latency != 0 {
+		contractTimeout := timeout
+		if contractTimeout == 0 {
+			contractTimeout = common.SwapTimeout
+		}
+
+		if contractTimeout < latency*2+refundBuffer {
+			return nil, fmt.Errorf("%w: timeout=%s signing latency=%s", errTimeoutTooShort, contractTimeout, latency)
+		}
 	}
 
 	balance, err := a.ethClient.BalanceAt(a.ctx, a.auth.From, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// check user's balance and that they actualy have what they will provide
 	if balance.Cmp(providesAmount.BigInt()) <= 0 {
-		return errors.New("balance lower than amount to be provided")
+		return nil, errors.New("balance lower than amount to be provided")
 	}
 
-	a.swapState = newSwapState(a, providesAmount, desiredAmount)
-	log.Info(color.New(color.Bold).Sprintf("**initiated swap with ID=%d**", a.swapState.id))
+	s := newSwapState(a, id, providesAmount, desiredAmount)
+	s.counterparty = who
+	s.timeout = timeout
+	a.swapStates[s.id] = s
+
+	log.Info(color.New(color.Bold).Sprintf("**initiated swap with ID=%s**", s.id))
 	log.Info(color.New(color.Bold).Sprint("DO NOT EXIT THIS PROCESS OR FUNDS MAY BE LOST!"))
+	return s, nil
+}
+
+// checkSwapID returns an error if the given ID is unset or has already been used by another swap.
+// It must be called with swapMu held.
+func (a *alice) checkSwapID(id common.SwapID) error {
+	if id.IsZero() {
+		return errNoSwapID
+	}
+
+	if _, has := a.swapStates[id]; has {
+		return errSwapIDInUse
+	}
+
+	_, err := a.db.GetSwap(id)
+	if err == nil {
+		return errSwapIDInUse
+	}
+
+	if !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// removeSwap removes the swap with the given ID from the set of ongoing swaps.
+func (a *alice) removeSwap(id common.SwapID) {
+	a.swapMu.Lock()
+	defer a.swapMu.Unlock()
+	delete(a.swapStates, id)
+}
+
+// waitForAcceptance queues an incoming swap until it's accepted, either by the user or by the request policy.
+func (a *alice) waitForAcceptance(who peer.ID, msg *net.InitiateMessage) error {
+	a.swapMu.Lock()
+	err := a.checkSwapID(msg.SwapID)
+	a.swapMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	req := &common.SwapRequest{
+		ID:             msg.SwapID,
+		Peer:           who.Pretty(),
+		Provides:       msg.Provides,
+		ProvidesAmount: msg.ProvidesAmount,
+		DesiredAmount:  msg.DesiredAmount,
+	}
+
+	a.events.Publish(common.NewRequestEvent(req))
+	if err = a.requests.Wait(a.ctx, req); err != nil {
+		log.Infof("swap request wasn't accepted: id=%s err=%s", req.ID, err)
+		a.events.Publish(common.NewRequestDeclinedEvent(req.ID, err))
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,148 @@
+package alice
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/monero"
+	"github.com/noot/atomic-swap/net"
+)
+
+// stubFees sends transactions straight to a simulated chain, and checks that the swap's lock
+// isn't held while they're sent.
+type stubFees struct {
+	a       *alice
+	backend *backends.SimulatedBackend
+}
+
+func (f *stubFees) Transact(ctx context.Context, auth *bind.TransactOpts, _ time.Time, send chain.TransactFunc) (*ethtypes.Receipt, error) {
+	f.a.swapMu.Lock()
+	var swaps []*swapState
+	for _, s := range f.a.swapStates {
+		swaps = append(swaps, s)
+	}
+	f.a.swapMu.Unlock()
+
+	unlocked := make(chan struct{})
+	go func() {
+		for _, s := range swaps {
+			s.Lock()
+			s.Unlock() //nolint:staticcheck
+		}
+		close(unlocked)
+	}()
+
+	select {
+	case <-unlocked:
+	case <-time.After(time.Second * 5):
+		return nil, errors.New("swap lock is held while sending a transaction")
+	}
+
+	tx, err := send(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	f.backend.Commit()
+	return f.backend.TransactionReceipt(ctx, tx.Hash())
+}
+
+func (f *stubFees) Settings(context.Context) (*chain.FeeSettings, error) {
+	return &chain.FeeSettings{}, nil
+}
+
+// newSimulatedAlice returns an alice whose transactions go to a simulated chain, so that the
+// protocol can run without ganache or monero-wallet-rpc.
+func newSimulatedAlice(t *testing.T) *alice {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	signer, err := chain.NewKeySignerFromHex(common.DefaultPrivKeyAlice)
+	require.NoError(t, err)
+
+	funds := new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		signer.Address(): {Balance: funds},
+	}, 30000000)
+	t.Cleanup(func() {
+		_ = backend.Close()
+	})
+
+	ks, err := keystore.NewKeystore([]byte(""))
+	require.NoError(t, err)
+
+	basepath := t.TempDir()
+	database, err := db.NewDatabase(basepath, ks)
+	require.NoError(t, err)
+
+	chainID := big.NewInt(1337)
+	a := &alice{
+		ctx:        ctx,
+		basepath:   basepath,
+		env:        common.Development,
+		ethClient:  backend,
+		chainID:    chainID,
+		auth:       chain.NewTransactOpts(ctx, signer, chainID),
+		callOpts:   &bind.CallOpts{From: signer.Address(), Context: ctx},
+		txTracker:  chain.NewTxTracker(backend, nil),
+		net:        new(mockNet),
+		ks:         ks,
+		db:         database,
+		events:     common.NewEventFeed(),
+		requests:   common.NewRequestQueue(common.RequestPolicy{AutoAccept: true}),
+		swapStates: make(map[common.SwapID]*swapState),
+	}
+
+	a.fees = &stubFees{a: a, backend: backend}
+	return a
+}
+
+func TestAlice_HandleInitiateMessage(t *testing.T) {
+	a := newSimulatedAlice(t)
+
+	bobKeys, err := monero.GenerateKeys()
+	require.NoError(t, err)
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+
+	msg := &net.InitiateMessage{
+		SwapID:         id,
+		Provides:       common.ProvidesXMR,
+		ProvidesAmount: 1,
+		DesiredAmount:  0.05,
+		SendKeysMessage: &net.SendKeysMessage{
+			PublicSpendKey: bobKeys.SpendKey().Public().Hex(),
+			PrivateViewKey: bobKeys.ViewKey().Hex(),
+			EthAddress:     "0x",
+		},
+	}
+
+	ss, resp, err := a.HandleInitiateMessage(peer.ID("bob"), msg)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, net.NotifyContractDeployedType, resp.Type())
+
+	s := ss.(*swapState)
+	defer s.cancel()
+	require.Equal(t, common.ETHLocked, s.Status())
+	require.Empty(t, s.txInFlight)
+	require.Equal(t, resp.(*net.NotifyContractDeployed).Address, s.contractAddr.String())
+
+	balance, err := a.ethClient.BalanceAt(a.ctx, s.contractAddr, nil)
+	require.NoError(t, err)
+	require.Equal(t, common.EtherToWei(0.05).BigInt(), balance)
+}
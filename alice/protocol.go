@@ -2,7 +2,6 @@ package alice
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -14,10 +13,13 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 
+	"github.com/noot/atomic-swap/chain"
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/keystore"
 	"github.com/noot/atomic-swap/monero"
 	"github.com/noot/atomic-swap/net"
 	"github.com/noot/atomic-swap/swap-contract"
@@ -27,9 +29,27 @@ import (
 
 var (
 	log                    = logging.Logger("alice")
-	defaultTimeoutDuration = big.NewInt(60 * 60 * 24) // 1 day = 60s * 60min * 24hr
+	defaultTimeoutDuration = big.NewInt(int64(common.SwapTimeout / time.Second))
+
+	errNoKeystore      = errors.New("must provide keystore")
+	errNoSigner        = errors.New("must provide ethereum signer")
+	errTimeoutTooShort = errors.New("swap timeout is too short to get our transactions signed offline in time")
+	errTxInFlight      = errors.New("a transaction is already being sent for this swap")
 )
 
+// ethBackend is the subset of *ethclient.Client that Alice uses.
+type ethBackend interface {
+	bind.ContractBackend
+	chain.Backend
+	BalanceAt(ctx context.Context, account ethcommon.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// feeManager sends our transactions; it's implemented by *chain.FeeManager.
+type feeManager interface {
+	Transact(ctx context.Context, auth *bind.TransactOpts, deadline time.Time, send chain.TransactFunc) (*ethtypes.Receipt, error)
+	Settings(ctx context.Context) (*chain.FeeSettings, error)
+}
+
 // alice implements the functions that will be called by a user who owns ETH
 // and wishes to swap for XMR.
 type alice struct {
@@ -39,16 +59,30 @@ type alice struct {
 
 	client monero.Client
 
-	ethPrivKey *ecdsa.PrivateKey
-	ethClient  *ethclient.Client
-	auth       *bind.TransactOpts
-	callOpts   *bind.CallOpts
+	ethClient ethBackend
+	chainID   *big.Int
+	auth      *bind.TransactOpts
+	offline   *chain.OfflineSigner // set if our transactions are signed offline
+	callOpts  *bind.CallOpts
+	txTracker *chain.TxTracker
+	fees      feeManager
 
 	net net.MessageSender
+	ks  *keystore.Keystore
+	db  *db.Database
+
+	// ensures concurrent swaps don't switch the wallet open in monero-wallet-rpc from under each other
+	walletMu sync.Mutex
+
+	// ongoing swaps, keyed by swap ID
+	swapMu     sync.Mutex
+	swapStates map[common.SwapID]*swapState
 
-	// non-nil if a swap is currently happening, nil otherwise
-	swapMu    sync.Mutex
-	swapState *swapState
+	// receives events for every swap
+	events *common.EventFeed
+
+	// incoming swaps waiting to be accepted
+	requests *common.RequestQueue
 }
 
 type Config struct {
@@ -56,18 +90,22 @@ type Config struct {
 	Basepath             string
 	MoneroWalletEndpoint string
 	EthereumEndpoint     string
-	EthereumPrivateKey   string
 	Environment          common.Environment
 	ChainID              int64
+	EthereumSigner       chain.Signer         // signs our transactions
+	Confirmations        uint64               // blocks a transaction must be included under to be final
+	Fees                 *chain.FeeConfig     // fees paid by our transactions; optional
+	Keystore             *keystore.Keystore   // used to encrypt swap secrets
+	Events               *common.EventFeed    // receives swap events; optional
+	Requests             *common.RequestQueue // holds incoming swaps until they are accepted; optional
 }
 
 // NewAlice returns a new instance of Alice.
 // It accepts an endpoint to a monero-wallet-rpc instance where Alice will generate
 // the account in which the XMR will be deposited.
 func NewAlice(cfg *Config) (*alice, error) {
-	pk, err := crypto.HexToECDSA(cfg.EthereumPrivateKey)
-	if err != nil {
-		return nil, err
+	if cfg.EthereumSigner == nil {
+		return nil, errNoSigner
 	}
 
 	ec, err := ethclient.Dial(cfg.EthereumEndpoint)
@@ -75,12 +113,42 @@ func NewAlice(cfg *Config) (*alice, error) {
 		return nil, err
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(pk, big.NewInt(cfg.ChainID))
+	if cfg.Keystore == nil {
+		return nil, errNoKeystore
+	}
+
+	database, err := db.NewDatabase(cfg.Basepath, cfg.Keystore)
 	if err != nil {
 		return nil, err
 	}
 
-	pub := pk.Public().(*ecdsa.PublicKey)
+	events := cfg.Events
+	if events == nil {
+		events = common.NewEventFeed()
+	}
+
+	// by default, every incoming swap must be accepted by the user
+	requests := cfg.Requests
+	if requests == nil {
+		requests = common.NewRequestQueue(common.RequestPolicy{})
+	}
+
+	tracker := chain.NewTxTracker(ec, &chain.TrackerConfig{
+		Confirmations: cfg.Confirmations,
+	})
+
+	// every replacement of a stuck transaction would have to be signed offline too
+	fees := cfg.Fees
+	offline, isOffline := cfg.EthereumSigner.(*chain.OfflineSigner)
+	if isOffline {
+		fees = &chain.FeeConfig{NoReplacements: true}
+		if cfg.Fees != nil {
+			*fees = *cfg.Fees
+			fees.NoReplacements = true
+		}
+	}
+
+	chainID := big.NewInt(cfg.ChainID)
 
 	// TODO: check that Alice's monero-wallet-cli endpoint has wallet-dir configured
 
@@ -88,12 +156,20 @@ func NewAlice(cfg *Config) (*alice, error) {
 		ctx:        cfg.Ctx,
 		basepath:   cfg.Basepath,
 		env:        cfg.Environment,
-		ethPrivKey: pk,
 		ethClient:  ec,
+		txTracker:  tracker,
+		fees:       chain.NewFeeManager(ec, tracker, fees),
 		client:     monero.NewClient(cfg.MoneroWalletEndpoint),
-		auth:       auth,
+		chainID:    chainID,
+		auth:       chain.NewTransactOpts(cfg.Ctx, cfg.EthereumSigner, chainID),
+		offline:    offline,
+		ks:         cfg.Keystore,
+		db:         database,
+		events:     events,
+		requests:   requests,
+		swapStates: make(map[common.SwapID]*swapState),
 		callOpts: &bind.CallOpts{
-			From:    crypto.PubkeyToAddress(*pub),
+			From:    cfg.EthereumSigner.Address(),
 			Context: cfg.Ctx,
 		},
 	}, nil
@@ -103,6 +179,27 @@ func (a *alice) SetMessageSender(n net.MessageSender) {
 	a.net = n
 }
 
+// transactOpts returns options for sending one of our transactions. If our transactions are signed
+// offline, the transaction is exported along with the label and deadline, so that the user knows
+// what they're signing and by when.
+func (a *alice) transactOpts(ctx context.Context, label string, deadline time.Time) *bind.TransactOpts {
+	if a.offline == nil {
+		auth := *a.auth
+		return &auth
+	}
+
+	return chain.NewTransactOpts(ctx, a.offline.WithLabel(ctx, label, deadline), a.chainID)
+}
+
+// signingLatency returns how long we expect it takes to get one of our transactions signed.
+func (a *alice) signingLatency() time.Duration {
+	if a.offline == nil {
+		return 0
+	}
+
+	return a.offline.Latency()
+}
+
 // generateKeys generates Alice's monero spend and view keys (S_b, V_b)
 // It returns Alice's public spend key
 func (s *swapState) generateKeys() (*monero.PublicKeyPair, error) {
@@ -116,12 +213,16 @@ func (s *swapState) generateKeys() (*monero.PublicKeyPair, error) {
 		return nil, err
 	}
 
-	fp := fmt.Sprintf("%s/%d/alice-secret", s.alice.basepath, s.id)
-	if err := monero.WriteKeysToFile(fp, s.privkeys, s.alice.env); err != nil {
+	fp := fmt.Sprintf("%s/%s/alice-secret", s.alice.basepath, s.id)
+	if err := monero.WriteKeysToFile(fp, s.privkeys, s.alice.env, s.alice.ks); err != nil {
 		return nil, err
 	}
 
 	s.pubkeys = s.privkeys.PublicKeyPair()
+	if err := s.persist(); err != nil {
+		return nil, err
+	}
+
 	return s.pubkeys, nil
 }
 
@@ -149,22 +250,36 @@ func (s *swapState) deployAndLockETH(amount common.EtherAmount) (ethcommon.Addre
 	copy(pka[:], common.Reverse(pkAlice))
 	copy(pkb[:], common.Reverse(pkBob))
 
-	// TODO: put auth in swapState
-	s.alice.auth.Value = amount.BigInt()
-	defer func() {
-		s.alice.auth.Value = nil
-	}()
+	timeout := defaultTimeoutDuration
+	if s.timeout != 0 {
+		timeout = big.NewInt(int64(s.timeout / time.Second))
+	}
+
+	var (
+		address  ethcommon.Address
+		contract *swap.Swap
+	)
+
+	// replacements of a deployment with the same nonce deploy to the same address
+	label := fmt.Sprintf("deploy swap contract for swap %s", s.id)
+	bobAddress := s.bobAddress
+	receipt, err := s.transact(label, time.Time{}, amount.BigInt(), func(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+		var (
+			tx  *ethtypes.Transaction
+			err error
+		)
+
+		address, tx, contract, err = swap.DeploySwap(opts, s.alice.ethClient, pkb, pka, bobAddress, timeout)
+		if err == nil {
+			log.Debugf("deploying Swap.sol, amount=%s txHash=%s", amount, tx.Hash())
+		}
 
-	address, tx, swap, err := swap.DeploySwap(s.alice.auth, s.alice.ethClient, pkb, pka, s.bobAddress, defaultTimeoutDuration)
+		return tx, err
+	})
 	if err != nil {
 		return ethcommon.Address{}, fmt.Errorf("failed to deploy Swap.sol: %w", err)
 	}
 
-	log.Debugf("deploying Swap.sol, amount=%s txHash=%s", amount, tx.Hash())
-	if _, ok := common.WaitForReceipt(s.ctx, s.alice.ethClient, tx.Hash()); !ok {
-		return ethcommon.Address{}, errors.New("failed to deploy Swap.sol")
-	}
-
 	balance, err := s.alice.ethClient.BalanceAt(s.ctx, address, nil)
 	if err != nil {
 		return ethcommon.Address{}, err
@@ -172,7 +287,17 @@ func (s *swapState) deployAndLockETH(amount common.EtherAmount) (ethcommon.Addre
 
 	log.Debug("contract balance: ", balance)
 
-	s.contract = swap
+	s.contract = contract
+	s.contractAddr = address
+	s.deployTxHash = receipt.TxHash.String()
+	if err := s.setStatus(common.ETHLocked); err != nil {
+		return ethcommon.Address{}, err
+	}
+
+	if err := s.watchContract(receipt.BlockNumber); err != nil {
+		return ethcommon.Address{}, err
+	}
+
 	return address, nil
 }
 
@@ -180,74 +305,96 @@ func (s *swapState) deployAndLockETH(amount common.EtherAmount) (ethcommon.Addre
 // call Claim(). Ready() should only be called once Alice sees Bob lock his XMR.
 // If time t_0 has passed, there is no point of calling Ready().
 func (s *swapState) ready() error {
-	tx, err := s.contract.SetReady(s.alice.auth)
+	receipt, err := s.transact(fmt.Sprintf("set ready for swap %s", s.id), s.t0, nil, s.contract.SetReady)
 	if err != nil {
-		return err
-	}
-
-	if _, ok := common.WaitForReceipt(s.ctx, s.alice.ethClient, tx.Hash()); !ok {
-		return errors.New("failed to set IsReady to true in Swap.sol")
+		return fmt.Errorf("failed to set IsReady to true in Swap.sol: %w", err)
 	}
 
+	s.readyTxHash = receipt.TxHash.String()
 	return nil
 }
 
-// watchForClaim watches for Bob to call Claim() on the Swap contract.
-// When Claim() is called, revealing Bob's secret s_b, the secret key corresponding
-// to (s_a + s_b) will be sent over this channel, allowing Alice to claim the XMR it contains.
-func (s *swapState) watchForClaim() (<-chan *monero.PrivateKeyPair, error) { //nolint:unused
-	watchOpts := &bind.WatchOpts{
-		Context: s.ctx,
-	}
+// transact sends one of the swap's transactions, and waits for it to be confirmed. It must be called
+// with the swap's lock held, which is released meanwhile: getting the transaction signed and
+// confirmed can take a while, and the contract watcher and RPC calls shouldn't have to wait for it.
+// As the swap may have changed by the time it returns, callers must check its status again.
+func (s *swapState) transact(label string, deadline time.Time, value *big.Int, send chain.TransactFunc) (*ethtypes.Receipt, error) {
+	auth := s.alice.transactOpts(s.ctx, label, deadline)
+	auth.Value = value
+
+	s.txInFlight = label
+	s.Unlock()
+	receipt, err := s.alice.fees.Transact(s.ctx, auth, deadline, send)
+	s.Lock()
+	s.txInFlight = ""
+	s.lastTx = time.Now()
+
+	return receipt, err
+}
 
-	out := make(chan *monero.PrivateKeyPair)
-	ch := make(chan *swap.SwapClaimed)
-	defer close(out)
+// watchContract starts watching the swap contract's events from the given block, or from the
+// current block if it's nil. The events drive the swap even if the stream with Bob is closed:
+// when Bob claims, we claim the XMR using the secret he revealed. It's only started once.
+func (s *swapState) watchContract(fromBlock *big.Int) error {
+	if s.watching {
+		return nil
+	}
 
-	// watch for Refund() event on chain, calculate unlock key as result
-	sub, err := s.contract.WatchClaimed(watchOpts, ch)
+	w, err := chain.NewWatcher(s.ctx, s.alice.ethClient, s.contractAddr, &chain.WatcherConfig{
+		FromBlock:     fromBlock,
+		Confirmations: s.alice.txTracker.Confirmations(),
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to watch swap contract: %w", err)
 	}
 
-	defer sub.Unsubscribe()
-
+	s.watching = true
 	go func() {
-		log.Debug("watching for claim...")
-		for {
-			select {
-			case claim := <-ch:
-				if claim == nil {
-					continue
-				}
-
-				// got Bob's secret
-				sb := claim.S
-				skB, err := monero.NewPrivateSpendKey(sb[:])
-				if err != nil {
-					log.Error("failed to convert Bob's secret into a key: ", err)
-					return
-				}
-
-				vkA, err := skB.View()
-				if err != nil {
-					log.Error("failed to get view key from Bob's secret spend key: ", err)
-					return
-				}
-
-				skAB := monero.SumPrivateSpendKeys(skB, s.privkeys.SpendKey())
-				vkAB := monero.SumPrivateViewKeys(vkA, s.privkeys.ViewKey())
-				kpAB := monero.NewPrivateKeyPair(skAB, vkAB)
-
-				out <- kpAB
-				return
-			case <-s.ctx.Done():
-				return
-			}
+		for ev := range w.Events() {
+			s.handleContractEvent(ev)
 		}
 	}()
 
-	return out, nil
+	return nil
+}
+
+// handleContractEvent acts on an event emitted by the swap contract. Claimed and Refunded events
+// complete the swap, whether or not Bob told us about them.
+func (s *swapState) handleContractEvent(ev *chain.Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.status.Status().IsComplete() {
+		return
+	}
+
+	switch ev.Type {
+	case chain.Ready:
+		// we called Ready() ourselves
+		return
+	case chain.Claimed:
+		s.claimTxHash = ev.TxHash.String()
+		address, err := s.claimMonero(ev.Secret)
+		if err != nil {
+			log.Errorf("failed to claim XMR: id=%s err=%s", s.id, err)
+			s.events.Publish(common.NewFailedEvent(s.id, s.status.Status(), err))
+			return
+		}
+
+		close(s.claimedCh)
+		log.Info("successfully created monero wallet from our secrets: address=", address)
+	case chain.Refunded:
+		s.refundTxHash = ev.TxHash.String()
+		if err := s.setStatus(common.CompletedRefund); err != nil {
+			log.Errorf("failed to update swap status: id=%s err=%s", s.id, err)
+			return
+		}
+	}
+
+	// the contract has settled the swap, so there's nothing left to exchange with Bob
+	if err := s.alice.net.CloseSwap(s.id); err != nil {
+		log.Debugf("failed to close swap stream: id=%s err=%s", s.id, err)
+	}
 }
 
 // refund calls the Refund() method in the Swap contract, revealing Alice's secret
@@ -262,22 +409,103 @@ func (s *swapState) refund() (string, error) {
 		return "", errors.New("contract is nil")
 	}
 
+	if s.txInFlight != "" {
+		return "", fmt.Errorf("%w: %s", errTxInFlight, s.txInFlight)
+	}
+
 	log.Infof("attempting to call Refund()...")
-	tx, err := s.contract.Refund(s.alice.auth, sc)
+	// before t0, we can only refund until t0. after t1, we can refund whenever
+	var deadline time.Time
+	if time.Now().Before(s.t0) {
+		deadline = s.t0
+	}
+
+	contract := s.contract
+	receipt, err := s.transact(fmt.Sprintf("refund swap %s", s.id), deadline, nil, func(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+		return contract.Refund(opts, sc)
+	})
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to call Refund in Swap.sol: %w", err)
+	}
+
+	s.refundTxHash = receipt.TxHash.String()
+
+	// the contract watcher may have seen the refund first
+	if s.status.Status() != common.CompletedRefund {
+		if err = s.setStatus(common.CompletedRefund); err != nil {
+			log.Errorf("failed to update swap status: id=%s err=%s", s.id, err)
+		}
+	}
+
+	return s.refundTxHash, nil
+}
+
+// refundIfOngoing calls refund(), unless the swap has already been claimed or refunded.
+// It's called by the refund timers, which race with the contract watcher.
+func (s *swapState) refundIfOngoing() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	if status := s.status.Status(); status.IsComplete() || s.claimTxHash != "" {
+		return "", fmt.Errorf("swap has already been settled with status %s", status)
+	}
+
+	return s.refund()
+}
+
+// checkLockedXMR creates a view-only wallet for the given address and checks that it
+// contains at least the amount of XMR we're expecting.
+func (s *swapState) checkLockedXMR(vk *monero.PrivateViewKey, address monero.Address) error {
+	s.alice.walletMu.Lock()
+	defer s.alice.walletMu.Unlock()
+
+	t := time.Now().Format("2006-Jan-2-15:04:05")
+	walletName := fmt.Sprintf("alice-viewonly-wallet-%s", t)
+	if err := s.alice.client.GenerateViewOnlyWalletFromKeys(vk, address, walletName, ""); err != nil {
+		return fmt.Errorf("failed to generate view-only wallet to verify locked XMR: %w", err)
 	}
 
-	if _, ok := common.WaitForReceipt(s.ctx, s.alice.ethClient, tx.Hash()); !ok {
-		return "", errors.New("failed to call Refund in Swap.sol")
+	if s.alice.env != common.Development {
+		// wait for 2 new blocks, otherwise balance might be 0
+		// TODO: check transaction hash
+		if err := monero.WaitForBlocks(s.alice.client); err != nil {
+			return err
+		}
+
+		if err := monero.WaitForBlocks(s.alice.client); err != nil {
+			return err
+		}
+	}
+
+	if err := s.alice.client.Refresh(); err != nil {
+		return fmt.Errorf("failed to refresh client: %w", err)
+	}
+
+	balance, err := s.alice.client.GetBalance(0)
+	if err != nil {
+		return fmt.Errorf("failed to get balance: %w", err)
 	}
 
-	s.success = true
-	return tx.Hash().String(), nil
+	log.Debugf("checking locked wallet, address=%s balance=%v", address, balance.Balance)
+
+	// TODO: also check that the balance isn't unlocked only after an unreasonable amount of blocks
+	if balance.Balance < float64(s.desiredAmount) {
+		return fmt.Errorf("locked XMR amount is less than expected: got %v, expected %v", balance.Balance, float64(s.desiredAmount))
+	}
+
+	if err := s.alice.client.CloseWallet(); err != nil {
+		return fmt.Errorf("failed to close wallet: %w", err)
+	}
+
+	return nil
 }
 
 // createMoneroWallet creates Alice's monero wallet after Bob calls Claim().
 func (s *swapState) createMoneroWallet(kpAB *monero.PrivateKeyPair) (monero.Address, error) {
+	s.alice.walletMu.Lock()
+	defer s.alice.walletMu.Unlock()
+
 	t := time.Now().Format("2006-Jan-2-15:04:05")
 	walletName := fmt.Sprintf("alice-swap-wallet-%s", t)
 	if err := s.alice.client.GenerateFromKeys(kpAB, walletName, "", s.alice.env); err != nil {
@@ -296,20 +524,19 @@ func (s *swapState) createMoneroWallet(kpAB *monero.PrivateKeyPair) (monero.Addr
 	}
 
 	log.Info("wallet balance: ", balance.Balance)
-	s.success = true
 	return kpAB.Address(s.alice.env), nil
 }
 
 // handleNotifyClaimed handles Bob's reveal after he calls Claim().
 // it calls `createMoneroWallet` to create Alice's wallet, allowing her to own the XMR.
 func (s *swapState) handleNotifyClaimed(txHash string) (monero.Address, error) {
-	receipt, ok := common.WaitForReceipt(s.ctx, s.alice.ethClient, ethcommon.HexToHash(txHash))
-	if !ok {
-		return "", errors.New("failed check Claim transaction receipt")
+	receipt, err := s.alice.txTracker.WaitHash(s.ctx, ethcommon.HexToHash(txHash))
+	if err != nil {
+		return "", fmt.Errorf("failed to check Claim transaction: %w", err)
 	}
 
-	if len(receipt.Logs) == 0 {
-		return "", errors.New("claim transaction has no logs")
+	if len(receipt.Logs) == 0 || receipt.Logs[0].Address != s.contractAddr {
+		return "", errors.New("claim transaction has no logs from the swap contract")
 	}
 
 	abi, err := abi.JSON(strings.NewReader(swap.SwapABI))
@@ -325,6 +552,12 @@ func (s *swapState) handleNotifyClaimed(txHash string) (monero.Address, error) {
 
 	// got Bob's secret
 	sb := res[0].([32]byte)
+	s.claimTxHash = txHash
+	return s.claimMonero(sb)
+}
+
+// claimMonero creates Alice's monero wallet using the secret Bob revealed when calling Claim().
+func (s *swapState) claimMonero(sb [32]byte) (monero.Address, error) {
 	log.Debug("got Bob's secret: ", hex.EncodeToString(sb[:]))
 
 	skB, err := monero.NewPrivateSpendKey(common.Reverse(sb[:]))
@@ -338,8 +571,8 @@ func (s *swapState) handleNotifyClaimed(txHash string) (monero.Address, error) {
 	kpAB := monero.NewPrivateKeyPair(skAB, vkAB)
 
 	// write keys to file in case something goes wrong
-	fp := fmt.Sprintf("%s/%d/swap-secret", s.alice.basepath, s.id)
-	if err = monero.WriteKeysToFile(fp, kpAB, s.alice.env); err != nil {
+	fp := fmt.Sprintf("%s/%s/swap-secret", s.alice.basepath, s.id)
+	if err = monero.WriteKeysToFile(fp, kpAB, s.alice.env, s.alice.ks); err != nil {
 		return "", err
 	}
 
@@ -347,5 +580,14 @@ func (s *swapState) handleNotifyClaimed(txHash string) (monero.Address, error) {
 	// log.Info("public spend keys: ", pkAB.SpendKey().Hex())
 	// log.Info("public view keys: ", pkAB.ViewKey().Hex())
 
-	return s.createMoneroWallet(kpAB)
+	address, err := s.createMoneroWallet(kpAB)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.setStatus(common.CompletedSuccess); err != nil {
+		log.Errorf("failed to update swap status: id=%s err=%s", s.id, err)
+	}
+
+	return address, nil
 }
@@ -0,0 +1,384 @@
+package alice
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/monero"
+	"github.com/noot/atomic-swap/swap-contract"
+)
+
+// persist writes the current state of the swap to the database.
+func (s *swapState) persist() error {
+	info := &db.SwapInfo{
+		ID:            s.id,
+		Provides:      common.ProvidesETH,
+		ETHAmount:     s.providesAmount.BigInt(),
+		XMRAmount:     s.desiredAmount,
+		Status:        s.status.Status(),
+		StatusHistory: s.status.History(),
+		CreatedAt:     s.createdAt,
+		UpdatedAt:     time.Now(),
+	}
+
+	if s.privkeys != nil {
+		info.PrivateSpendKey = s.privkeys.SpendKey().Hex()
+		info.PrivateViewKey = s.privkeys.ViewKey().Hex()
+	}
+
+	if s.bobPublicSpendKey != nil && s.bobPrivateViewKey != nil {
+		info.CounterpartyPublicSpendKey = s.bobPublicSpendKey.Hex()
+		info.CounterpartyPrivateViewKey = s.bobPrivateViewKey.Hex()
+		info.CounterpartyEthAddress = s.bobAddress.String()
+	}
+
+	if s.counterparty != "" {
+		info.CounterpartyPeerID = s.counterparty.Pretty()
+	}
+
+	if s.contract != nil {
+		info.ContractAddress = s.contractAddr.String()
+		info.Timeout0 = s.t0
+		info.Timeout1 = s.t1
+		info.DeployTxHash = s.deployTxHash
+		info.ReadyTxHash = s.readyTxHash
+		info.ClaimTxHash = s.claimTxHash
+		info.RefundTxHash = s.refundTxHash
+	}
+
+	if err := s.alice.db.PutSwap(info); err != nil {
+		return fmt.Errorf("failed to write swap to database: %w", err)
+	}
+
+	return nil
+}
+
+// setStatus moves the swap to the given status and writes it to the database.
+func (s *swapState) setStatus(status common.Status) error {
+	if err := s.status.Transition(status); err != nil {
+		return err
+	}
+
+	log.Infof("swap status updated: id=%s status=%s", s.id, status)
+	s.events.Publish(common.NewStatusEvent(s.id, status))
+	return s.persist()
+}
+
+// GetSwap returns the swap with the given ID from the database.
+func (a *alice) GetSwap(id common.SwapID) (*db.SwapInfo, error) {
+	return a.db.GetSwap(id)
+}
+
+// GetSwaps returns every swap in the database, ordered by the time they were created.
+func (a *alice) GetSwaps() ([]*db.SwapInfo, error) {
+	return a.db.GetAllSwaps()
+}
+
+// ResumeSwaps loads all unfinished swaps from the database and re-arms their refund timers.
+// It should be called once on startup.
+func (a *alice) ResumeSwaps() error {
+	swaps, err := a.db.GetOngoingSwaps()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range swaps {
+		s, err := newSwapStateFromInfo(a, info)
+		if err != nil {
+			log.Errorf("failed to load swap from database: id=%s err=%s", info.ID, err)
+			continue
+		}
+
+		log.Infof("resuming swap: id=%s contract=%s", s.id, info.ContractAddress)
+
+		a.swapMu.Lock()
+		a.swapStates[s.id] = s
+		a.swapMu.Unlock()
+
+		go s.finish()
+	}
+
+	return nil
+}
+
+// finish drives the swap to completion from the contract, then stops it.
+func (s *swapState) finish() {
+	defer func() {
+		s.cancel()
+		s.alice.removeSwap(s.id)
+	}()
+
+	if err := s.resume(); err != nil {
+		log.Errorf("failed to finish swap: id=%s err=%s", s.id, err)
+	}
+}
+
+func newSwapStateFromInfo(a *alice, info *db.SwapInfo) (*swapState, error) {
+	if info.ETHAmount == nil {
+		return nil, errors.New("swap has no ETH amount")
+	}
+
+	s := newSwapState(a, info.ID, common.EtherAmount(*info.ETHAmount), info.XMRAmount)
+	s.createdAt = info.CreatedAt
+	s.t0, s.t1 = info.Timeout0, info.Timeout1
+
+	s.deployTxHash, s.readyTxHash = info.DeployTxHash, info.ReadyTxHash
+	s.claimTxHash, s.refundTxHash = info.ClaimTxHash, info.RefundTxHash
+
+	var err error
+	s.status, err = common.NewStatusTrackerFromHistory(info.StatusHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.CounterpartyPeerID != "" {
+		s.counterparty, err = peer.Decode(info.CounterpartyPeerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if info.PrivateSpendKey != "" {
+		sk, err := hex.DecodeString(info.PrivateSpendKey)
+		if err != nil {
+			return nil, err
+		}
+
+		vk, err := hex.DecodeString(info.PrivateViewKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.privkeys, err = monero.NewPrivateKeyPairFromBytes(sk, vk)
+		if err != nil {
+			return nil, err
+		}
+
+		s.pubkeys = s.privkeys.PublicKeyPair()
+	}
+
+	if info.CounterpartyPublicSpendKey != "" {
+		sk, err := monero.NewPublicKeyFromHex(info.CounterpartyPublicSpendKey)
+		if err != nil {
+			return nil, err
+		}
+
+		vk, err := monero.NewPrivateViewKeyFromHex(info.CounterpartyPrivateViewKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.setBobKeys(sk, vk)
+		s.bobAddress = ethcommon.HexToAddress(info.CounterpartyEthAddress)
+	}
+
+	if info.ContractAddress != "" {
+		s.contractAddr = ethcommon.HexToAddress(info.ContractAddress)
+		s.contract, err = swap.NewSwap(s.contractAddr, a.ethClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// resume drives a swap to completion, based on the state of the contract. It's used both for swaps
+// loaded from the database and for swaps whose stream with Bob closed early. If Bob has claimed, we
+// create the XMR wallet. Otherwise, we refund as soon as the contract allows it.
+func (s *swapState) resume() error {
+	s.Lock()
+	if s.contract == nil {
+		// we never locked any ETH, so there's nothing to do.
+		defer s.Unlock()
+		return s.setStatus(common.CompletedAbort)
+	}
+
+	if s.privkeys == nil {
+		s.Unlock()
+		return errors.New("contract was deployed, but our keys are missing")
+	}
+
+	err := s.setTimeouts()
+	if err == nil {
+		// the watcher is started before checking the contract, so that nothing is missed in between
+		err = s.watchContract(nil)
+	}
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for {
+		done, err := s.step()
+		if err != nil || done {
+			return err
+		}
+
+		log.Infof("waiting until time %s to refund: id=%s", s.t1, s.id)
+
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-s.status.Done():
+			// the contract watcher completed the swap
+			return nil
+		case <-time.After(time.Until(s.t1)):
+		}
+	}
+}
+
+// step checks the contract, claiming our XMR if Bob has claimed and refunding if the contract allows
+// it. It returns true once the swap has completed.
+func (s *swapState) step() (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.status.Status().IsComplete() {
+		return true, nil
+	}
+
+	// eg. a refund started by the user; we check again once it's done
+	if s.txInFlight != "" {
+		return false, nil
+	}
+
+	refunded, err := s.isRefunded()
+	if err != nil {
+		return false, err
+	}
+
+	if refunded {
+		log.Infof("swap was already refunded: id=%s", s.id)
+		return true, s.setStatus(common.CompletedRefund)
+	}
+
+	sb, err := s.getClaimedSecret()
+	if err != nil {
+		return false, err
+	}
+
+	if sb != nil {
+		address, err := s.claimMonero(*sb)
+		if err != nil {
+			return false, err
+		}
+
+		log.Info("successfully created monero wallet from our secrets: address=", address)
+		return true, nil
+	}
+
+	ready, err := s.isReady()
+	if err != nil {
+		return false, err
+	}
+
+	// we can refund before t0 if we haven't set ready, or any time after t1.
+	now := time.Now()
+	if (!ready && now.Before(s.t0)) || !now.Before(s.t1) {
+		txHash, err := s.refund()
+		if err != nil {
+			return false, err
+		}
+
+		log.Infof("got our ETH back: tx hash=%s", txHash)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// setTimeouts fetches t0 and t1 from the contract, if they weren't set before the daemon exited.
+func (s *swapState) setTimeouts() error {
+	if !s.t0.IsZero() && !s.t1.IsZero() {
+		return nil
+	}
+
+	t0, t1, err := s.getTimeouts()
+	if err != nil {
+		return err
+	}
+
+	s.t0, s.t1 = t0, t1
+	return nil
+}
+
+// getTimeouts fetches t0 and t1 from the contract.
+func (s *swapState) getTimeouts() (time.Time, time.Time, error) {
+	st0, err := s.contract.Timeout0(s.alice.callOpts)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to get timeout0 from contract: err=%w", err)
+	}
+
+	st1, err := s.contract.Timeout1(s.alice.callOpts)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to get timeout1 from contract: err=%w", err)
+	}
+
+	return time.Unix(st0.Int64(), 0), time.Unix(st1.Int64(), 0), nil
+}
+
+func (s *swapState) filterOpts() *bind.FilterOpts {
+	return &bind.FilterOpts{
+		Context: s.ctx,
+	}
+}
+
+// getClaimedSecret returns Bob's secret if he has called Claim(), or nil otherwise.
+func (s *swapState) getClaimedSecret() (*[32]byte, error) {
+	iter, err := s.contract.FilterClaimed(s.filterOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = iter.Close()
+	}()
+
+	if !iter.Next() {
+		return nil, iter.Error()
+	}
+
+	sb := iter.Event.S
+	s.claimTxHash = iter.Event.Raw.TxHash.String()
+	return &sb, nil
+}
+
+func (s *swapState) isRefunded() (bool, error) {
+	iter, err := s.contract.FilterRefunded(s.filterOpts())
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = iter.Close()
+	}()
+
+	if !iter.Next() {
+		return false, iter.Error()
+	}
+
+	s.refundTxHash = iter.Event.Raw.TxHash.String()
+	return true, nil
+}
+
+func (s *swapState) isReady() (bool, error) {
+	iter, err := s.contract.FilterIsReady(s.filterOpts())
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = iter.Close()
+	}()
+
+	return iter.Next(), iter.Error()
+}
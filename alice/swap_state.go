@@ -14,9 +14,12 @@ import (
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p-core/peer"
 )
 
-var nextID uint64 = 0
+// if Bob hasn't locked his XMR by this long before t0, we refund. If our transactions are signed
+// offline, we start earlier, to leave time for the refund to be signed.
+const refundBuffer = time.Minute * 5
 
 var (
 	errMissingKeys    = errors.New("did not receive Bob's public spend or private view key")
@@ -31,10 +34,14 @@ type swapState struct {
 	cancel context.CancelFunc
 	sync.Mutex
 
-	id uint64
+	id           common.SwapID
+	createdAt    time.Time
+	counterparty peer.ID
 	// amount of ETH we are providing this swap, and the amount of XMR we should receive.
 	providesAmount common.EtherAmount
 	desiredAmount  common.MoneroAmount
+	// duration of each of the contract's timelocks, as agreed with Bob
+	timeout time.Duration
 
 	// our keys for this session
 	privkeys *monero.PrivateKeyPair
@@ -46,8 +53,12 @@ type swapState struct {
 	bobAddress        ethcommon.Address
 
 	// swap contract and timeouts in it; set once contract is deployed
-	contract *swap.Swap
-	t0, t1   time.Time
+	contract     *swap.Swap
+	contractAddr ethcommon.Address
+	t0, t1       time.Time
+
+	// hashes of the transactions sent for this swap, set once they're known
+	deployTxHash, readyTxHash, claimTxHash, refundTxHash string
 
 	// next expected network message
 	nextExpectedMessage net.Message // TODO: change to type?
@@ -56,27 +67,64 @@ type swapState struct {
 	xmrLockedCh chan struct{}
 	claimedCh   chan struct{}
 
-	// set to true upon creating of the XMR wallet
-	success bool
+	// true once we're watching the contract's events
+	watching bool
+
+	// label of the transaction being sent, if any; the swap's lock is released while it's sent.
+	// streamClosed is set if the stream with Bob closed meanwhile.
+	txInFlight   string
+	streamClosed bool
+	// when we last finished sending a transaction
+	lastTx time.Time
+
+	// current status of the swap
+	status *common.StatusTracker
 }
 
-func newSwapState(a *alice, providesAmount common.EtherAmount, desiredAmount common.MoneroAmount) *swapState {
+func newSwapState(a *alice, id common.SwapID, providesAmount common.EtherAmount, desiredAmount common.MoneroAmount) *swapState {
 	ctx, cancel := context.WithCancel(a.ctx)
 
-	s := &swapState{
+	return &swapState{
 		ctx:                 ctx,
 		cancel:              cancel,
 		alice:               a,
-		id:                  nextID,
+		id:                  id,
+		createdAt:           time.Now(),
 		providesAmount:      providesAmount,
 		desiredAmount:       desiredAmount,
 		nextExpectedMessage: &net.SendKeysMessage{},
 		xmrLockedCh:         make(chan struct{}),
 		claimedCh:           make(chan struct{}),
+		status:              common.NewStatusTracker(),
 	}
+}
 
-	nextID++
-	return s
+// ID returns the ID of the swap
+func (s *swapState) ID() common.SwapID {
+	return s.id
+}
+
+// Status returns the swap's current status.
+func (s *swapState) Status() common.Status {
+	return s.status.Status()
+}
+
+// Done returns a channel that's closed once the swap is complete.
+func (s *swapState) Done() <-chan struct{} {
+	return s.status.Done()
+}
+
+// LastTransaction returns when we last finished sending one of the swap's transactions, or the
+// current time while one is being sent.
+func (s *swapState) LastTransaction() time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.txInFlight != "" {
+		return time.Now()
+	}
+
+	return s.lastTx
 }
 
 func (s *swapState) SendKeysMessage() (*net.SendKeysMessage, error) {
@@ -97,53 +145,49 @@ func (s *swapState) ProtocolComplete() {
 	s.Lock()
 	defer s.Unlock()
 
-	defer func() {
-		// stop all running goroutines
-		s.cancel()
-		s.alice.swapState = nil
-	}()
-
-	if s.success {
-		str := color.New(color.Bold).Sprintf("**swap completed successfully! id=%d**", s.id)
-		log.Info(str)
+	// how the swap continues depends on the transaction's outcome, so wait for it
+	if s.txInFlight != "" {
+		s.streamClosed = true
 		return
 	}
 
-	switch s.nextExpectedMessage.(type) {
-	case *net.SendKeysMessage:
-		// we are fine, as we only just initiated the protocol.
-	case *net.NotifyXMRLock:
-		// we already deployed the contract, so we should call Refund().
-		if err := s.tryRefund(); err != nil {
-			log.Errorf("failed to refund: err=%s", err)
-			return
-		}
-	case *net.NotifyClaimed:
-		// the XMR has been locked, but the ETH hasn't been claimed.
-		// we should also refund in this case.
-		if err := s.tryRefund(); err != nil {
-			log.Errorf("failed to refund: err=%s", err)
-			return
-		}
-	default:
-		log.Errorf("unexpected nextExpectedMessage in ProtocolComplete: type=%T", s.nextExpectedMessage)
-	}
+	s.protocolComplete()
 }
 
-func (s *swapState) tryRefund() error {
-	untilT0 := time.Until(s.t0)
-	untilT1 := time.Until(s.t1)
-
-	// TODO: also check if IsReady == true
+// completeIfStreamClosed handles the stream with Bob closing while a transaction was being sent.
+// It must be called with the swap's lock held.
+func (s *swapState) completeIfStreamClosed() {
+	if s.streamClosed && s.txInFlight == "" {
+		s.streamClosed = false
+		s.protocolComplete()
+	}
+}
 
-	if untilT0 > 0 && untilT1 < 0 {
-		// we've passed t0 but aren't past t1 yet, so we need to wait until t1
-		log.Infof("waiting until time %s to refund", s.t1)
-		<-time.After(untilT1)
+func (s *swapState) protocolComplete() {
+	switch s.status.Status() {
+	case common.CompletedSuccess:
+		str := color.New(color.Bold).Sprintf("**swap completed successfully! id=%s**", s.id)
+		log.Info(str)
+	case common.CompletedRefund:
+		log.Infof("swap completed, our ETH was refunded: id=%s", s.id)
+	case common.CompletedAbort:
+		log.Infof("swap was aborted: id=%s", s.id)
+	case common.ExpectingKeys, common.KeysExchanged:
+		// we are fine, as we haven't deployed the contract yet.
+		if err := s.setStatus(common.CompletedAbort); err != nil {
+			log.Errorf("failed to abort swap: err=%s", err)
+		}
+	case common.ETHLocked, common.XMRLocked, common.ContractReady:
+		// we already deployed the contract, but the ETH hasn't been claimed. the contract decides
+		// how the swap ends, so we keep watching it until Bob claims, or until we can refund.
+		log.Infof("stream closed before the swap completed, continuing from the contract: id=%s", s.id)
+		go s.finish()
+		return
 	}
 
-	_, err := s.refund()
-	return err
+	// stop all running goroutines
+	s.cancel()
+	s.alice.removeSwap(s.id)
 }
 
 // HandleProtocolMessage is called by the network to handle an incoming message.
@@ -152,6 +196,21 @@ func (s *swapState) tryRefund() error {
 func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, error) {
 	s.Lock()
 	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	resp, done, err := s.handleProtocolMessage(msg)
+	if err != nil {
+		s.events.Publish(common.NewFailedEvent(s.id, s.status.Status(), err))
+	}
+
+	return resp, done, err
+}
+
+func (s *swapState) handleProtocolMessage(msg net.Message) (net.Message, bool, error) {
+	// the contract's events may have completed the swap before Bob's message arrived
+	if s.status.Status().IsComplete() {
+		return nil, true, nil
+	}
 
 	if err := s.checkMessageType(msg); err != nil {
 		return nil, true, err
@@ -178,53 +237,34 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 			return nil, true, fmt.Errorf("address received in message does not match expected address")
 		}
 
-		t := time.Now().Format("2006-Jan-2-15:04:05")
-		walletName := fmt.Sprintf("alice-viewonly-wallet-%s", t)
-		if err := s.alice.client.GenerateViewOnlyWalletFromKeys(vk, kp.Address(s.alice.env), walletName, ""); err != nil {
-			return nil, true, fmt.Errorf("failed to generate view-only wallet to verify locked XMR: %w", err)
-		}
-
-		if s.alice.env != common.Development {
-			// wait for 2 new blocks, otherwise balance might be 0
-			// TODO: check transaction hash
-			if err := monero.WaitForBlocks(s.alice.client); err != nil {
-				return nil, true, err
-			}
-
-			if err := monero.WaitForBlocks(s.alice.client); err != nil {
-				return nil, true, err
-			}
+		if err := s.checkLockedXMR(vk, kp.Address(s.alice.env)); err != nil {
+			return nil, true, err
 		}
 
-		if err := s.alice.client.Refresh(); err != nil {
-			return nil, true, fmt.Errorf("failed to refresh client: %w", err)
-		}
+		s.nextExpectedMessage = &net.NotifyClaimed{}
+		close(s.xmrLockedCh)
 
-		balance, err := s.alice.client.GetBalance(0)
-		if err != nil {
-			return nil, true, fmt.Errorf("failed to get balance: %w", err)
+		if err := s.setStatus(common.XMRLocked); err != nil {
+			return nil, true, err
 		}
 
-		log.Debugf("checking locked wallet, address=%s balance=%v", kp.Address(s.alice.env), balance.Balance)
-
-		// TODO: also check that the balance isn't unlocked only after an unreasonable amount of blocks
-		if balance.Balance < float64(s.desiredAmount) {
-			return nil, true, fmt.Errorf("locked XMR amount is less than expected: got %v, expected %v", balance.Balance, float64(s.desiredAmount))
-		}
+		err := s.ready()
 
-		if err := s.alice.client.CloseWallet(); err != nil {
-			return nil, true, fmt.Errorf("failed to close wallet: %w", err)
+		// the contract watcher may have completed the swap while Ready() was being sent
+		if s.status.Status().IsComplete() {
+			return nil, true, nil
 		}
 
-		s.nextExpectedMessage = &net.NotifyClaimed{}
-		close(s.xmrLockedCh)
-
-		if err := s.ready(); err != nil {
+		if err != nil {
 			return nil, true, fmt.Errorf("failed to call Ready: %w", err)
 		}
 
 		log.Debug("set swap.IsReady == true")
 
+		if err := s.setStatus(common.ContractReady); err != nil {
+			return nil, true, err
+		}
+
 		go func() {
 			until := time.Until(s.t1)
 
@@ -233,7 +273,7 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 				return
 			case <-time.After(until):
 				// Bob hasn't claimed, and we're after t_1. let's call Refund
-				txhash, err := s.refund()
+				txhash, err := s.refundIfOngoing()
 				if err != nil {
 					log.Errorf("failed to refund: err=%s", err)
 					return
@@ -242,7 +282,7 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 				log.Infof("got our ETH back: tx hash=%s", txhash)
 
 				// send NotifyRefund msg
-				if err = s.net.SendSwapMessage(&net.NotifyRefund{
+				if err = s.net.SendSwapMessage(s.id, &net.NotifyRefund{
 					TxHash: txhash,
 				}); err != nil {
 					log.Errorf("failed to send refund message: err=%s", err)
@@ -294,6 +334,11 @@ func (s *swapState) handleSendKeysMessage(msg *net.SendKeysMessage) (net.Message
 		return nil, fmt.Errorf("failed to generate Bob's public spend key: %w", err)
 	}
 	s.setBobKeys(sk, vk)
+
+	if err := s.setStatus(common.KeysExchanged); err != nil {
+		return nil, err
+	}
+
 	address, err := s.deployAndLockETH(s.providesAmount)
 	if err != nil {
 		return nil, fmt.Errorf("failed to deploy contract: %w", err)
@@ -329,17 +374,20 @@ func (s *swapState) handleSendKeysMessage(msg *net.SendKeysMessage) (net.Message
 		break
 	}
 
+	if err := s.persist(); err != nil {
+		return nil, err
+	}
+
 	// start goroutine to check that Bob locks before t_0
 	go func() {
-		const timeoutBuffer = time.Minute * 5
-		until := time.Until(s.t0)
+		until := time.Until(s.t0) - refundBuffer - s.alice.signingLatency()
 
 		select {
 		case <-s.ctx.Done():
 			return
-		case <-time.After(until - timeoutBuffer):
+		case <-time.After(until):
 			// Bob hasn't locked yet, let's call refund
-			txhash, err := s.refund()
+			txhash, err := s.refundIfOngoing()
 			if err != nil {
 				log.Errorf("failed to refund: err=%s", err)
 				return
@@ -348,7 +396,7 @@ func (s *swapState) handleSendKeysMessage(msg *net.SendKeysMessage) (net.Message
 			log.Infof("got our ETH back: tx hash=%s", txhash)
 
 			// send NotifyRefund msg
-			if err := s.net.SendSwapMessage(&net.NotifyRefund{
+			if err := s.net.SendSwapMessage(s.id, &net.NotifyRefund{
 				TxHash: txhash,
 			}); err != nil {
 				log.Errorf("failed to send refund message: err=%s", err)
@@ -6,7 +6,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/noot/atomic-swap/chain"
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
 	"github.com/noot/atomic-swap/monero"
 	"github.com/noot/atomic-swap/net"
 
@@ -20,25 +22,38 @@ type mockNet struct {
 	msg net.Message
 }
 
-func (n *mockNet) SendSwapMessage(msg net.Message) error {
+func (n *mockNet) SendSwapMessage(_ common.SwapID, msg net.Message) error {
 	n.msg = msg
 	return nil
 }
 
+func (n *mockNet) CloseSwap(_ common.SwapID) error {
+	return nil
+}
+
 func newTestAlice(t *testing.T) (*alice, *swapState) {
+	ks, err := keystore.NewKeystore([]byte(""))
+	require.NoError(t, err)
+
+	signer, err := chain.NewKeySignerFromHex(common.DefaultPrivKeyAlice)
+	require.NoError(t, err)
+
 	cfg := &Config{
 		Ctx:                  context.Background(),
 		Basepath:             "/tmp/alice",
 		MoneroWalletEndpoint: common.DefaultAliceMoneroEndpoint,
 		EthereumEndpoint:     common.DefaultEthEndpoint,
-		EthereumPrivateKey:   common.DefaultPrivKeyAlice,
+		EthereumSigner:       signer,
 		Environment:          common.Development,
 		ChainID:              common.MainnetConfig.EthereumChainID,
+		Keystore:             ks,
 	}
 
 	alice, err := NewAlice(cfg)
 	require.NoError(t, err)
-	swapState := newSwapState(alice, common.NewEtherAmount(1), common.MoneroAmount(1))
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+	swapState := newSwapState(alice, id, common.NewEtherAmount(1), common.MoneroAmount(1))
 	return alice, swapState
 }
 
@@ -69,6 +84,7 @@ func TestSwapState_HandleProtocolMessage_SendKeysMessage(t *testing.T) {
 	require.Equal(t, time.Second*time.Duration(defaultTimeoutDuration.Int64()), s.t1.Sub(s.t0))
 	require.Equal(t, bobPrivKeys.SpendKey().Public().Hex(), s.bobPublicSpendKey.Hex())
 	require.Equal(t, bobPrivKeys.ViewKey().Hex(), s.bobPrivateViewKey.Hex())
+	require.Equal(t, common.ETHLocked, s.status.Status())
 }
 
 func TestSwapState_HandleProtocolMessage_SendKeysMessage_Refund(t *testing.T) {
@@ -124,9 +140,12 @@ func TestSwapState_NotifyXMRLock(t *testing.T) {
 	require.NoError(t, err)
 
 	s.setBobKeys(bobPrivKeys.SpendKey().Public(), bobPrivKeys.ViewKey())
+	err = s.status.Transition(common.KeysExchanged)
+	require.NoError(t, err)
 
 	_, err = s.deployAndLockETH(common.NewEtherAmount(1))
 	require.NoError(t, err)
+	require.Equal(t, common.ETHLocked, s.status.Status())
 
 	s.desiredAmount = 0
 	kp := monero.SumSpendAndViewKeys(bobPrivKeys.PublicKeyPair(), s.pubkeys)
@@ -141,6 +160,7 @@ func TestSwapState_NotifyXMRLock(t *testing.T) {
 	require.False(t, done)
 	require.NotNil(t, resp)
 	require.Equal(t, net.NotifyReadyType, resp.Type())
+	require.Equal(t, common.ContractReady, s.status.Status())
 
 	// TODO: test refund case
 }
@@ -0,0 +1,150 @@
+package bob
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/net"
+)
+
+var (
+	errNoOngoingSwap = errors.New("no ongoing swap with given ID")
+	errCannotAbort   = errors.New("cannot abort swap after our XMR may have been locked")
+	errCannotRefund  = errors.New("only Alice can refund, we regain our XMR once she does")
+	errTimelock      = errors.New("not allowed by the swap contract's timelock")
+)
+
+// getOngoingSwap returns the ongoing swap with the given ID.
+func (b *bob) getOngoingSwap(id common.SwapID) (*swapState, error) {
+	b.swapMu.Lock()
+	defer b.swapMu.Unlock()
+
+	s, has := b.swapStates[id]
+	if !has {
+		return nil, errNoOngoingSwap
+	}
+
+	return s, nil
+}
+
+// Cancel aborts the swap with the given ID. It's only possible before we start locking our XMR.
+func (b *bob) Cancel(id common.SwapID) error {
+	s, err := b.getOngoingSwap(id)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	err = checkCanAbort(s.status.Status())
+	if err == nil {
+		err = s.setStatus(common.CompletedAbort)
+	}
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("swap aborted by user: id=%s", id)
+	s.cancel()
+	b.removeSwap(id)
+
+	if err = b.net.CloseSwap(id); err != nil {
+		log.Debugf("failed to close swap stream: id=%s err=%s", id, err)
+	}
+
+	return nil
+}
+
+func checkCanAbort(status common.Status) error {
+	switch status {
+	case common.ExpectingKeys, common.KeysExchanged:
+		return nil
+	default:
+		if status.IsComplete() {
+			return fmt.Errorf("swap has already completed with status %s", status)
+		}
+
+		return errCannotAbort
+	}
+}
+
+// Claim calls Claim() on the contract of the swap with the given ID, returning the hash of the transaction.
+// It returns an error if the swap's timelocks don't allow a claim yet.
+func (b *bob) Claim(id common.SwapID) (string, error) {
+	s, err := b.getOngoingSwap(id)
+	if err != nil {
+		return "", err
+	}
+
+	// we can only claim once our XMR is locked, or Alice could keep both
+	switch status := s.status.Status(); status {
+	case common.XMRLocked, common.ContractReady:
+	default:
+		return "", fmt.Errorf("cannot claim swap with status %s", status)
+	}
+
+	if err = s.checkClaimAllowed(); err != nil {
+		return "", err
+	}
+
+	txHash, err := s.claimIfOngoing()
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("claimed by user: id=%s tx hash=%s", id, txHash)
+
+	// let Alice know, so that she can create the XMR wallet
+	if err = b.net.SendSwapMessage(id, &net.NotifyClaimed{
+		TxHash: txHash,
+	}); err != nil {
+		log.Warnf("failed to send NotifyClaimed message: id=%s err=%s", id, err)
+	}
+
+	s.cancel()
+	return txHash, nil
+}
+
+// checkClaimAllowed returns an error if the contract doesn't allow us to claim now.
+// We can claim after Alice calls Ready() or after t0, but only before t1.
+func (s *swapState) checkClaimAllowed() error {
+	t0, t1, err := s.getTimeouts()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !now.Before(t1) {
+		return fmt.Errorf("%w: claim is only possible before t1 (%s), wait for Alice to refund",
+			errTimelock, t1.Format(time.RFC3339))
+	}
+
+	if !now.Before(t0) {
+		return nil
+	}
+
+	ready, err := s.isReady()
+	if err != nil {
+		return err
+	}
+
+	if !ready {
+		return fmt.Errorf("%w: claim is possible once Alice calls Ready() or after t0 (%s)",
+			errTimelock, t0.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Refund isn't possible for Bob; Alice refunds the ETH.
+func (b *bob) Refund(_ common.SwapID) (string, error) {
+	return "", errCannotRefund
+}
+
+// FeeSettings returns the fees paid by our transactions, and the network's current fees.
+func (b *bob) FeeSettings() (*chain.FeeSettings, error) {
+	return b.fees.Settings(b.ctx)
+}
@@ -0,0 +1,73 @@
+package bob
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/swap-contract"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBob_Cancel(t *testing.T) {
+	b, s := newTestBob(t)
+	b.net = new(mockNet)
+	b.swapStates[s.id] = s
+
+	err := b.Cancel(s.id)
+	require.NoError(t, err)
+	require.Equal(t, common.CompletedAbort, s.status.Status())
+
+	_, err = b.getOngoingSwap(s.id)
+	require.Equal(t, errNoOngoingSwap, err)
+}
+
+func TestBob_Cancel_AfterLock(t *testing.T) {
+	b, s := newTestBob(t)
+	defer s.cancel()
+	b.net = new(mockNet)
+	b.swapStates[s.id] = s
+
+	advanceStatus(t, s, common.KeysExchanged, common.ETHLocked)
+
+	err := b.Cancel(s.id)
+	require.Equal(t, errCannotAbort, err)
+	require.Equal(t, common.ETHLocked, s.status.Status())
+}
+
+func TestBob_Claim(t *testing.T) {
+	b, s := newTestBob(t)
+	defer s.cancel()
+	b.net = new(mockNet)
+	b.swapStates[s.id] = s
+
+	_, _, err := s.generateKeys()
+	require.NoError(t, err)
+
+	pkBob, err := crypto.HexToECDSA(common.DefaultPrivKeyBob)
+	require.NoError(t, err)
+
+	b.auth, err = bind.NewKeyedTransactorWithChainID(pkBob, big.NewInt(common.GanacheChainID))
+	require.NoError(t, err)
+
+	var claimKey [32]byte
+	copy(claimKey[:], common.Reverse(s.privkeys.SpendKey().Public().Bytes()))
+	s.contractAddr, _, s.contract, err = swap.DeploySwap(b.auth, b.ethClient, claimKey, [32]byte{}, b.ethAddress, defaultTimeoutDuration)
+	require.NoError(t, err)
+
+	// we can't claim before our XMR is locked
+	advanceStatus(t, s, common.KeysExchanged, common.ETHLocked)
+	_, err = b.Claim(s.id)
+	require.Error(t, err)
+
+	// or before Alice calls Ready() or t0 passes
+	advanceStatus(t, s, common.XMRLocked)
+	_, err = b.Claim(s.id)
+	require.True(t, errors.Is(err, errTimelock))
+	require.Equal(t, common.XMRLocked, s.status.Status())
+}
@@ -2,72 +2,153 @@ package bob
 
 import (
 	"errors"
+	"time"
 
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
 	"github.com/noot/atomic-swap/net"
 
 	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	errNoSwapID    = errors.New("swap ID must be set")
+	errSwapIDInUse = errors.New("swap ID has already been used")
 )
 
 func (b *bob) Provides() common.ProvidesCoin {
 	return common.ProvidesXMR
 }
 
-// InitiateProtocol is called when an RPC call is made from the user to initiate a swap.
-func (b *bob) InitiateProtocol(providesAmount, desiredAmount float64) (net.SwapState, error) {
-	if err := b.initiate(common.MoneroToPiconero(providesAmount), common.EtherToWei(desiredAmount)); err != nil {
+// InitiateProtocol is called once a peer has agreed to the terms of a swap that the user initiated with it.
+func (b *bob) InitiateProtocol(who peer.ID, terms *net.Terms) (net.SwapState, error) {
+	s, err := b.initiate(who, terms.SwapID, common.MoneroToPiconero(terms.ProvidesAmount),
+		common.EtherToWei(terms.DesiredAmount), terms.Timeout)
+	if err != nil {
 		return nil, err
 	}
 
-	return b.swapState, nil
+	return s, nil
 }
 
-func (b *bob) initiate(providesAmount common.MoneroAmount, desiredAmount common.EtherAmount) error {
+func (b *bob) initiate(who peer.ID, id common.SwapID, providesAmount common.MoneroAmount, desiredAmount common.EtherAmount,
+	timeout time.Duration) (*swapState, error) {
 	b.swapMu.Lock()
 	defer b.swapMu.Unlock()
 
-	if b.swapState != nil {
-		return errors.New("protocol already in progress")
+	if err := b.checkSwapID(id); err != nil {
+		return nil, err
 	}
 
+	b.walletMu.Lock()
 	balance, err := b.client.GetBalance(0)
+	b.walletMu.Unlock()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// check user's balance and that they actualy have what they will provide
 	if balance.UnlockedBalance <= float64(providesAmount) {
-		return errors.New("balance lower than amount to be provided")
+		return nil, errors.New("balance lower than amount to be provided")
 	}
 
-	b.swapState = newSwapState(b, providesAmount, desiredAmount)
-	log.Info(color.New(color.Bold).Sprintf("**initiated swap with ID=%d**", b.swapState.id))
+	s := newSwapState(b, id, providesAmount, desiredAmount)
+	s.counterparty = who
+	s.timeout = timeout
+	b.swapStates[s.id] = s
+
+	log.Info(color.New(color.Bold).Sprintf("**initiated swap with ID=%s**", s.id))
 	log.Info(color.New(color.Bold).Sprint("DO NOT EXIT THIS PROCESS OR FUNDS MAY BE LOST!"))
+	return s, nil
+}
+
+// checkSwapID returns an error if the given ID is unset or has already been used by another swap.
+// It must be called with swapMu held.
+func (b *bob) checkSwapID(id common.SwapID) error {
+	if id.IsZero() {
+		return errNoSwapID
+	}
+
+	if _, has := b.swapStates[id]; has {
+		return errSwapIDInUse
+	}
+
+	_, err := b.db.GetSwap(id)
+	if err == nil {
+		return errSwapIDInUse
+	}
+
+	if !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
 	return nil
 }
 
+// removeSwap removes the swap with the given ID from the set of ongoing swaps.
+func (b *bob) removeSwap(id common.SwapID) {
+	b.swapMu.Lock()
+	defer b.swapMu.Unlock()
+	delete(b.swapStates, id)
+}
+
 // HandleInitiateMessage is called when we receive a network message from a peer that they wish to initiate a swap.
-func (b *bob) HandleInitiateMessage(msg *net.InitiateMessage) (net.SwapState, net.Message, error) {
+func (b *bob) HandleInitiateMessage(who peer.ID, msg *net.InitiateMessage) (net.SwapState, net.Message, error) {
 	if msg.Provides != common.ProvidesETH {
 		return nil, nil, errors.New("peer does not provide ETH")
 	}
 
-	// TODO: allow user to accept/reject this via RPC
 	str := color.New(color.Bold).Sprintf("**incoming swap with want amount %v**", msg.DesiredAmount)
 	log.Info(str)
 
-	if err := b.initiate(common.MoneroToPiconero(msg.DesiredAmount), common.EtherToWei(msg.ProvidesAmount)); err != nil {
+	// nothing is generated or locked until the swap is accepted
+	if err := b.waitForAcceptance(who, msg); err != nil {
 		return nil, nil, err
 	}
 
-	if err := b.swapState.handleSendKeysMessage(msg.SendKeysMessage); err != nil {
+	s, err := b.initiate(who, msg.SwapID, common.MoneroToPiconero(msg.DesiredAmount),
+		common.EtherToWei(msg.ProvidesAmount), msg.Timeout)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	resp, err := b.swapState.SendKeysMessage()
+	// return the swap state on error, so that the network calls ProtocolComplete() on it
+	if err := s.handleSendKeysMessage(msg.SendKeysMessage); err != nil {
+		return s, nil, err
+	}
+
+	resp, err := s.SendKeysMessage()
 	if err != nil {
-		return nil, nil, err
+		return s, nil, err
 	}
 
-	return b.swapState, resp, nil
+	return s, resp, nil
+}
+
+// waitForAcceptance queues an incoming swap until it's accepted, either by the user or by the request policy.
+func (b *bob) waitForAcceptance(who peer.ID, msg *net.InitiateMessage) error {
+	b.swapMu.Lock()
+	err := b.checkSwapID(msg.SwapID)
+	b.swapMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	req := &common.SwapRequest{
+		ID:             msg.SwapID,
+		Peer:           who.Pretty(),
+		Provides:       msg.Provides,
+		ProvidesAmount: msg.ProvidesAmount,
+		DesiredAmount:  msg.DesiredAmount,
+	}
+
+	b.events.Publish(common.NewRequestEvent(req))
+	if err = b.requests.Wait(b.ctx, req); err != nil {
+		log.Infof("swap request wasn't accepted: id=%s err=%s", req.ID, err)
+		b.events.Publish(common.NewRequestDeclinedEvent(req.ID, err))
+		return err
+	}
+
+	return nil
 }
@@ -2,19 +2,21 @@ package bob
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
-	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 
+	"github.com/noot/atomic-swap/chain"
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/keystore"
 	"github.com/noot/atomic-swap/monero"
 	"github.com/noot/atomic-swap/net"
 	"github.com/noot/atomic-swap/swap-contract"
@@ -24,8 +26,25 @@ import (
 
 var (
 	log = logging.Logger("bob")
+
+	errNoKeystore = errors.New("must provide keystore")
+	errNoSigner   = errors.New("must provide ethereum signer")
+	errTxInFlight = errors.New("a transaction is already being sent for this swap")
 )
 
+// ethBackend is the subset of *ethclient.Client that Bob uses.
+type ethBackend interface {
+	bind.ContractBackend
+	chain.Backend
+	BalanceAt(ctx context.Context, account ethcommon.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// feeManager sends our transactions; it's implemented by *chain.FeeManager.
+type feeManager interface {
+	Transact(ctx context.Context, auth *bind.TransactOpts, deadline time.Time, send chain.TransactFunc) (*ethtypes.Receipt, error)
+	Settings(ctx context.Context) (*chain.FeeSettings, error)
+}
+
 // bob implements the functions that will be called by a user who owns XMR
 // and wishes to swap for ETH.
 type bob struct {
@@ -37,16 +56,29 @@ type bob struct {
 	daemonClient               monero.DaemonClient
 	walletFile, walletPassword string
 
-	ethClient  *ethclient.Client
-	ethPrivKey *ecdsa.PrivateKey
+	ethClient  ethBackend
 	auth       *bind.TransactOpts
 	callOpts   *bind.CallOpts
 	ethAddress ethcommon.Address
+	txTracker  *chain.TxTracker
+	fees       feeManager
 
 	net net.MessageSender
+	ks  *keystore.Keystore
+	db  *db.Database
 
-	swapMu    sync.Mutex
-	swapState *swapState
+	// ensures concurrent swaps don't switch the wallet open in monero-wallet-rpc from under each other
+	walletMu sync.Mutex
+
+	// ongoing swaps, keyed by swap ID
+	swapMu     sync.Mutex
+	swapStates map[common.SwapID]*swapState
+
+	// receives events for every swap
+	events *common.EventFeed
+
+	// incoming swaps waiting to be accepted
+	requests *common.RequestQueue
 }
 
 type Config struct {
@@ -56,9 +88,14 @@ type Config struct {
 	MoneroDaemonEndpoint       string // only needed for development
 	WalletFile, WalletPassword string
 	EthereumEndpoint           string
-	EthereumPrivateKey         string
 	Environment                common.Environment
 	ChainID                    int64
+	EthereumSigner             chain.Signer         // signs our transactions
+	Confirmations              uint64               // blocks a transaction must be included under to be final
+	Fees                       *chain.FeeConfig     // fees paid by our transactions; optional
+	Keystore                   *keystore.Keystore   // used to encrypt swap secrets
+	Events                     *common.EventFeed    // receives swap events; optional
+	Requests                   *common.RequestQueue // holds incoming swaps until they are accepted; optional
 }
 
 // NewBob returns a new instance of Bob.
@@ -68,9 +105,8 @@ func NewBob(cfg *Config) (*bob, error) {
 		return nil, errors.New("environment is development, must provide monero daemon endpoint")
 	}
 
-	pk, err := crypto.HexToECDSA(cfg.EthereumPrivateKey)
-	if err != nil {
-		return nil, err
+	if cfg.EthereumSigner == nil {
+		return nil, errNoSigner
 	}
 
 	ec, err := ethclient.Dial(cfg.EthereumEndpoint)
@@ -78,13 +114,7 @@ func NewBob(cfg *Config) (*bob, error) {
 		return nil, err
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(pk, big.NewInt(cfg.ChainID)) // ganache chainID
-	if err != nil {
-		return nil, err
-	}
-
-	pub := pk.Public().(*ecdsa.PublicKey)
-	addr := crypto.PubkeyToAddress(*pub)
+	addr := cfg.EthereumSigner.Address()
 
 	// monero-wallet-rpc client
 	walletClient := monero.NewClient(cfg.MoneroWalletEndpoint)
@@ -99,6 +129,30 @@ func NewBob(cfg *Config) (*bob, error) {
 		daemonClient = monero.NewClient(cfg.MoneroDaemonEndpoint)
 	}
 
+	if cfg.Keystore == nil {
+		return nil, errNoKeystore
+	}
+
+	database, err := db.NewDatabase(cfg.Basepath, cfg.Keystore)
+	if err != nil {
+		return nil, err
+	}
+
+	events := cfg.Events
+	if events == nil {
+		events = common.NewEventFeed()
+	}
+
+	// by default, every incoming swap must be accepted by the user
+	requests := cfg.Requests
+	if requests == nil {
+		requests = common.NewRequestQueue(common.RequestPolicy{})
+	}
+
+	tracker := chain.NewTxTracker(ec, &chain.TrackerConfig{
+		Confirmations: cfg.Confirmations,
+	})
+
 	return &bob{
 		ctx:            cfg.Ctx,
 		basepath:       cfg.Basepath,
@@ -108,13 +162,19 @@ func NewBob(cfg *Config) (*bob, error) {
 		walletFile:     cfg.WalletFile,
 		walletPassword: cfg.WalletPassword,
 		ethClient:      ec,
-		ethPrivKey:     pk,
-		auth:           auth,
+		auth:           chain.NewTransactOpts(cfg.Ctx, cfg.EthereumSigner, big.NewInt(cfg.ChainID)),
+		ks:             cfg.Keystore,
+		db:             database,
+		events:         events,
+		requests:       requests,
+		swapStates:     make(map[common.SwapID]*swapState),
 		callOpts: &bind.CallOpts{
 			From:    addr,
 			Context: cfg.Ctx,
 		},
 		ethAddress: addr,
+		txTracker:  tracker,
+		fees:       chain.NewFeeManager(ec, tracker, cfg.Fees),
 	}, nil
 }
 
@@ -122,7 +182,7 @@ func (b *bob) SetMessageSender(n net.MessageSender) {
 	b.net = n
 }
 
-func (b *bob) openWallet() error { //nolint
+func (b *bob) openWallet() error {
 	return b.client.OpenWallet(b.walletFile, b.walletPassword)
 }
 
@@ -140,12 +200,16 @@ func (s *swapState) generateKeys() (*monero.PublicKey, *monero.PrivateViewKey, e
 		return nil, nil, err
 	}
 
-	fp := fmt.Sprintf("%s/%d/bob-secret", s.bob.basepath, s.id)
-	if err := monero.WriteKeysToFile(fp, s.privkeys, s.bob.env); err != nil {
+	fp := fmt.Sprintf("%s/%s/bob-secret", s.bob.basepath, s.id)
+	if err := monero.WriteKeysToFile(fp, s.privkeys, s.bob.env, s.bob.ks); err != nil {
 		return nil, nil, err
 	}
 
 	s.pubkeys = s.privkeys.PublicKeyPair()
+	if err := s.persist(); err != nil {
+		return nil, nil, err
+	}
+
 	return s.pubkeys.SpendKey(), s.privkeys.ViewKey(), nil
 }
 
@@ -162,102 +226,106 @@ func (s *swapState) setContract(address ethcommon.Address) error {
 	return err
 }
 
-// watchForReady watches for Alice to call Ready() on the swap contract, allowing
-// Bob to call Claim().
-func (s *swapState) watchForReady() (<-chan struct{}, error) { //nolint:unused
-	watchOpts := &bind.WatchOpts{
-		Context: s.ctx,
+// watchContract starts watching the swap contract's events. The events drive the swap even if the
+// stream with Alice is closed: when Alice sets ready we claim, and when she refunds we regain
+// control of our XMR using the secret she revealed. It's only started once.
+func (s *swapState) watchContract() error {
+	if s.watching {
+		return nil
 	}
 
-	done := make(chan struct{})
-	ch := make(chan *swap.SwapIsReady)
-	defer close(done)
-
-	// watch for Refund() event on chain, calculate unlock key as result
-	sub, err := s.contract.WatchIsReady(watchOpts, ch)
+	w, err := chain.NewWatcher(s.ctx, s.bob.ethClient, s.contractAddr, &chain.WatcherConfig{
+		Confirmations: s.bob.txTracker.Confirmations(),
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to watch swap contract: %w", err)
 	}
 
-	defer sub.Unsubscribe()
-
+	s.watching = true
 	go func() {
-		for {
-			select {
-			case event := <-ch:
-				if !event.B {
-					continue
-				}
-
-				// contract is ready!!
-				close(done)
-			case <-s.ctx.Done():
-				return
-			}
+		for ev := range w.Events() {
+			s.handleContractEvent(ev)
 		}
 	}()
 
-	return done, nil
+	return nil
 }
 
-// watchForRefund watches for the Refund event in the contract.
-// This should be called before LockFunds.
-// If a keypair is sent over this channel, the rest of the protocol should be aborted.
-//
-// If Alice chooses to refund and thus reveals s_a,
-// the private spend and view keys that contain the previously locked monero
-// ((s_a + s_b), (v_a + v_b)) are sent over the channel.
-// Bob can then use these keys to move his funds if he wishes.
-func (s *swapState) watchForRefund() (<-chan *monero.PrivateKeyPair, error) { //nolint:unused
-	watchOpts := &bind.WatchOpts{
-		Context: s.ctx,
-	}
-
-	out := make(chan *monero.PrivateKeyPair)
-	ch := make(chan *swap.SwapRefunded)
-	defer close(out)
-
-	// watch for Refund() event on chain, calculate unlock key as result
-	sub, err := s.contract.WatchRefunded(watchOpts, ch)
-	if err != nil {
-		return nil, err
+// handleContractEvent acts on an event emitted by the swap contract, whether or not Alice told us about it.
+func (s *swapState) handleContractEvent(ev *chain.Event) {
+	s.Lock()
+	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	if s.status.Status().IsComplete() {
+		return
 	}
 
-	defer sub.Unsubscribe()
+	switch ev.Type {
+	case chain.Ready:
+		// if we're not at XMRLocked, we're either already claiming, or we haven't locked our XMR yet
+		if s.status.Status() != common.XMRLocked {
+			return
+		}
 
-	go func() {
-		for {
-			select {
-			case refund := <-ch:
-				if refund == nil {
-					continue
-				}
-
-				// got Alice's secret
-				sa := refund.S
-				skA, err := monero.NewPrivateSpendKey(sa[:])
-				if err != nil {
-					log.Info("failed to convert Alice's secret into a key: %w", err)
-					return
-				}
-
-				vkA, err := skA.View()
-				if err != nil {
-					log.Info("failed to get view key from Alice's secret spend key: %w", err)
-					return
-				}
-
-				skAB := monero.SumPrivateSpendKeys(skA, s.privkeys.SpendKey())
-				vkAB := monero.SumPrivateViewKeys(vkA, s.privkeys.ViewKey())
-				kpAB := monero.NewPrivateKeyPair(skAB, vkAB)
-				out <- kpAB
-			case <-s.ctx.Done():
-				return
-			}
+		txHash, err := s.handleReady()
+		if err != nil {
+			log.Errorf("failed to claim: id=%s err=%s", s.id, err)
+			s.events.Publish(common.NewFailedEvent(s.id, s.status.Status(), err))
+			return
 		}
-	}()
 
-	return out, nil
+		log.Debug("funds claimed!")
+
+		// Alice will also see the claim on-chain, so this is just a courtesy
+		if err := s.net.SendSwapMessage(s.id, &net.NotifyClaimed{
+			TxHash: txHash,
+		}); err != nil {
+			log.Debugf("failed to send NotifyClaimed message: id=%s err=%s", s.id, err)
+		}
+	case chain.Claimed:
+		s.claimTxHash = ev.TxHash.String()
+		if err := s.setStatus(common.CompletedSuccess); err != nil {
+			log.Errorf("failed to update swap status: id=%s err=%s", s.id, err)
+			return
+		}
+	case chain.Refunded:
+		s.refundTxHash = ev.TxHash.String()
+		addr, err := s.reclaimMonero(ev.Secret)
+		if err != nil {
+			log.Errorf("failed to regain control of our XMR: id=%s err=%s", s.id, err)
+			s.events.Publish(common.NewFailedEvent(s.id, s.status.Status(), err))
+			return
+		}
+
+		log.Infof("regained control over monero account %s", addr)
+	}
+
+	// the contract has settled the swap, so there's nothing left to exchange with Alice
+	if err := s.bob.net.CloseSwap(s.id); err != nil {
+		log.Debugf("failed to close swap stream: id=%s err=%s", s.id, err)
+	}
+}
+
+// handleReady claims our ETH once Alice has set the contract as ready, whether we learnt of it from
+// her NotifyReady message or from the contract's IsReady event.
+func (s *swapState) handleReady() (string, error) {
+	select {
+	case <-s.readyCh:
+	default:
+		close(s.readyCh)
+	}
+
+	if err := s.setStatus(common.ContractReady); err != nil {
+		return "", err
+	}
+
+	txHash, err := s.claimFunds()
+	if err != nil {
+		return "", fmt.Errorf("failed to redeem ether: %w", err)
+	}
+
+	return txHash, nil
 }
 
 // lockFunds locks Bob's funds in the monero account specified by public key
@@ -265,6 +333,9 @@ func (s *swapState) watchForRefund() (<-chan *monero.PrivateKeyPair, error) { //
 // It accepts the amount to lock as the input
 // TODO: units
 func (s *swapState) lockFunds(amount common.MoneroAmount) (monero.Address, error) {
+	s.bob.walletMu.Lock()
+	defer s.bob.walletMu.Unlock()
+
 	kp := monero.SumSpendAndViewKeys(s.alicePublicKeys, s.pubkeys)
 	log.Infof("going to lock XMR funds, amount(piconero)=%d", amount)
 
@@ -311,10 +382,11 @@ func (s *swapState) lockFunds(amount common.MoneroAmount) (monero.Address, error
 
 // claimFunds redeems Bob's ETH funds by calling Claim() on the contract
 func (s *swapState) claimFunds() (string, error) {
-	pub := s.ethPrivKey.Public().(*ecdsa.PublicKey)
-	addr := ethcrypto.PubkeyToAddress(*pub)
+	if s.txInFlight != "" {
+		return "", fmt.Errorf("%w: %s", errTxInFlight, s.txInFlight)
+	}
 
-	balance, err := s.ethClient.BalanceAt(s.ctx, addr, nil)
+	balance, err := s.ethClient.BalanceAt(s.ctx, s.bob.ethAddress, nil)
 	if err != nil {
 		return "", err
 	}
@@ -326,23 +398,70 @@ func (s *swapState) claimFunds() (string, error) {
 	var sc [32]byte
 	copy(sc[:], common.Reverse(secret))
 
-	tx, err := s.contract.Claim(s.bob.auth, sc)
-	if err != nil {
-		return "", err
-	}
+	// we can only claim until t1
+	contract := s.contract
+	receipt, err := s.transact(fmt.Sprintf("claim swap %s", s.id), s.t1, func(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+		tx, err := contract.Claim(opts, sc)
+		if err == nil {
+			log.Infof("sent Claim tx, tx hash=%s", tx.Hash())
+		}
 
-	log.Infof("sent Claim tx, tx hash=%s", tx.Hash())
+		return tx, err
+	})
 
-	if _, ok := common.WaitForReceipt(s.ctx, s.bob.ethClient, tx.Hash()); !ok {
-		return "", errors.New("failed to check Claim transaction receipt")
+	// the contract watcher may have seen the claim first
+	if err != nil && s.status.Status() == common.CompletedSuccess {
+		return s.claimTxHash, nil
 	}
 
-	balance, err = s.bob.ethClient.BalanceAt(s.ctx, addr, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to call Claim in Swap.sol: %w", err)
+	}
+
+	balance, err = s.bob.ethClient.BalanceAt(s.ctx, s.bob.ethAddress, nil)
 	if err != nil {
 		return "", err
 	}
 
 	log.Info("Bob's balance after claim: ", balance)
-	s.success = true
-	return tx.Hash().String(), nil
+	s.claimTxHash = receipt.TxHash.String()
+
+	// the contract watcher may have seen the claim first
+	if s.status.Status() != common.CompletedSuccess {
+		if err = s.setStatus(common.CompletedSuccess); err != nil {
+			log.Errorf("failed to update swap status: id=%s err=%s", s.id, err)
+		}
+	}
+
+	return s.claimTxHash, nil
+}
+
+// transact sends one of the swap's transactions, and waits for it to be confirmed. It must be called
+// with the swap's lock held, which is released meanwhile: with fee bumping, getting the transaction
+// confirmed can take until its deadline, and Alice's messages, the contract watcher and RPC calls
+// shouldn't have to wait for it. As the swap may have changed by the time it returns, callers must
+// check its status again.
+func (s *swapState) transact(label string, deadline time.Time, send chain.TransactFunc) (*ethtypes.Receipt, error) {
+	s.txInFlight = label
+	s.Unlock()
+	receipt, err := s.bob.fees.Transact(s.ctx, s.bob.auth, deadline, send)
+	s.Lock()
+	s.txInFlight = ""
+	s.lastTx = time.Now()
+
+	return receipt, err
+}
+
+// claimIfOngoing calls claimFunds(), unless the swap has already been claimed or refunded.
+// It's called by the claim timer and by the user, which race with the contract watcher.
+func (s *swapState) claimIfOngoing() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	if status := s.status.Status(); status.IsComplete() || s.refundTxHash != "" {
+		return "", fmt.Errorf("swap has already been settled with status %s", status)
+	}
+
+	return s.claimFunds()
 }
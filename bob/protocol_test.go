@@ -0,0 +1,164 @@
+package bob
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/monero"
+	"github.com/noot/atomic-swap/net"
+	"github.com/noot/atomic-swap/swap-contract"
+)
+
+// stubFees sends transactions straight to a simulated chain, and checks that the swap's lock
+// isn't held while they're sent.
+type stubFees struct {
+	b       *bob
+	backend *backends.SimulatedBackend
+}
+
+func (f *stubFees) Transact(ctx context.Context, auth *bind.TransactOpts, _ time.Time, send chain.TransactFunc) (*ethtypes.Receipt, error) {
+	f.b.swapMu.Lock()
+	var swaps []*swapState
+	for _, s := range f.b.swapStates {
+		swaps = append(swaps, s)
+	}
+	f.b.swapMu.Unlock()
+
+	unlocked := make(chan struct{})
+	go func() {
+		for _, s := range swaps {
+			s.Lock()
+			s.Unlock() //nolint:staticcheck
+		}
+		close(unlocked)
+	}()
+
+	select {
+	case <-unlocked:
+	case <-time.After(time.Second * 5):
+		return nil, errors.New("swap lock is held while sending a transaction")
+	}
+
+	tx, err := send(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	f.backend.Commit()
+	return f.backend.TransactionReceipt(ctx, tx.Hash())
+}
+
+func (f *stubFees) Settings(context.Context) (*chain.FeeSettings, error) {
+	return &chain.FeeSettings{}, nil
+}
+
+// newSimulatedBob returns a bob whose transactions go to a simulated chain, so that claims can be
+// tested without ganache or monero-wallet-rpc. It also returns Alice's transactor on that chain.
+func newSimulatedBob(t *testing.T) (*bob, *bind.TransactOpts) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	signer, err := chain.NewKeySignerFromHex(common.DefaultPrivKeyBob)
+	require.NoError(t, err)
+
+	chainID := big.NewInt(1337)
+	aliceKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	alice, err := bind.NewKeyedTransactorWithChainID(aliceKey, chainID)
+	require.NoError(t, err)
+
+	funds := new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		signer.Address(): {Balance: funds},
+		alice.From:       {Balance: funds},
+	}, 30000000)
+	t.Cleanup(func() {
+		_ = backend.Close()
+	})
+
+	ks, err := keystore.NewKeystore([]byte(""))
+	require.NoError(t, err)
+
+	basepath := t.TempDir()
+	database, err := db.NewDatabase(basepath, ks)
+	require.NoError(t, err)
+
+	b := &bob{
+		ctx:        ctx,
+		basepath:   basepath,
+		env:        common.Development,
+		ethClient:  backend,
+		auth:       chain.NewTransactOpts(ctx, signer, chainID),
+		callOpts:   &bind.CallOpts{From: signer.Address(), Context: ctx},
+		ethAddress: signer.Address(),
+		txTracker:  chain.NewTxTracker(backend, nil),
+		net:        new(mockNet),
+		ks:         ks,
+		db:         database,
+		events:     common.NewEventFeed(),
+		swapStates: make(map[common.SwapID]*swapState),
+	}
+
+	b.fees = &stubFees{b: b, backend: backend}
+	return b, alice
+}
+
+func TestSwapState_HandleContractEvent_Ready(t *testing.T) {
+	b, alice := newSimulatedBob(t)
+	backend := b.fees.(*stubFees).backend
+
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+	s := newSwapState(b, id, common.MoneroAmount(1), common.NewEtherAmount(1))
+	defer s.cancel()
+	b.swapStates[id] = s
+
+	_, _, err = s.generateKeys()
+	require.NoError(t, err)
+	aliceKeys, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	var pkAlice, pkBob [32]byte
+	copy(pkAlice[:], common.Reverse(aliceKeys.PublicKeyPair().SpendKey().Bytes()))
+	copy(pkBob[:], common.Reverse(s.pubkeys.SpendKey().Bytes()))
+
+	alice.Value = big.NewInt(1000000000000)
+	address, _, contract, err := swap.DeploySwap(alice, backend, pkBob, pkAlice, b.ethAddress, big.NewInt(3600))
+	require.NoError(t, err)
+	alice.Value = nil
+	backend.Commit()
+
+	_, err = contract.SetReady(alice)
+	require.NoError(t, err)
+	backend.Commit()
+
+	require.NoError(t, s.setContract(address))
+	require.NoError(t, s.setTimeouts())
+	for _, status := range []common.Status{common.KeysExchanged, common.ETHLocked, common.XMRLocked} {
+		require.NoError(t, s.status.Transition(status))
+	}
+
+	s.handleContractEvent(&chain.Event{Type: chain.Ready})
+	require.Equal(t, common.CompletedSuccess, s.Status())
+	require.Empty(t, s.txInFlight)
+	require.NotEmpty(t, s.claimTxHash)
+	require.Equal(t, net.NotifyClaimedType, b.net.(*mockNet).msg.Type())
+
+	balance, err := backend.BalanceAt(context.Background(), address, nil)
+	require.NoError(t, err)
+	require.Zero(t, balance.Sign())
+}
@@ -0,0 +1,392 @@
+package bob
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/monero"
+)
+
+// resumePollInterval is how often a resumed swap checks the contract while waiting to claim.
+var resumePollInterval = time.Minute
+
+// persist writes the current state of the swap to the database.
+func (s *swapState) persist() error {
+	info := &db.SwapInfo{
+		ID:            s.id,
+		Provides:      common.ProvidesXMR,
+		ETHAmount:     s.desiredAmount.BigInt(),
+		XMRAmount:     s.providesAmount,
+		Status:        s.status.Status(),
+		StatusHistory: s.status.History(),
+		CreatedAt:     s.createdAt,
+		UpdatedAt:     time.Now(),
+	}
+
+	if s.privkeys != nil {
+		info.PrivateSpendKey = s.privkeys.SpendKey().Hex()
+		info.PrivateViewKey = s.privkeys.ViewKey().Hex()
+	}
+
+	if s.alicePublicKeys != nil {
+		info.CounterpartyPublicSpendKey = s.alicePublicKeys.SpendKey().Hex()
+		info.CounterpartyPublicViewKey = s.alicePublicKeys.ViewKey().Hex()
+	}
+
+	if s.counterparty != "" {
+		info.CounterpartyPeerID = s.counterparty.Pretty()
+	}
+
+	if s.contract != nil {
+		info.ContractAddress = s.contractAddr.String()
+		info.Timeout0 = s.t0
+		info.Timeout1 = s.t1
+		info.ClaimTxHash = s.claimTxHash
+		info.RefundTxHash = s.refundTxHash
+	}
+
+	if err := s.bob.db.PutSwap(info); err != nil {
+		return fmt.Errorf("failed to write swap to database: %w", err)
+	}
+
+	return nil
+}
+
+// setStatus moves the swap to the given status and writes it to the database.
+func (s *swapState) setStatus(status common.Status) error {
+	if err := s.status.Transition(status); err != nil {
+		return err
+	}
+
+	log.Infof("swap status updated: id=%s status=%s", s.id, status)
+	s.events.Publish(common.NewStatusEvent(s.id, status))
+	return s.persist()
+}
+
+// GetSwap returns the swap with the given ID from the database.
+func (b *bob) GetSwap(id common.SwapID) (*db.SwapInfo, error) {
+	return b.db.GetSwap(id)
+}
+
+// GetSwaps returns every swap in the database, ordered by the time they were created.
+func (b *bob) GetSwaps() ([]*db.SwapInfo, error) {
+	return b.db.GetAllSwaps()
+}
+
+// ResumeSwaps loads all unfinished swaps from the database and re-arms their claim timers.
+// It should be called once on startup.
+func (b *bob) ResumeSwaps() error {
+	swaps, err := b.db.GetOngoingSwaps()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range swaps {
+		s, err := newSwapStateFromInfo(b, info)
+		if err != nil {
+			log.Errorf("failed to load swap from database: id=%s err=%s", info.ID, err)
+			continue
+		}
+
+		log.Infof("resuming swap: id=%s contract=%s", s.id, info.ContractAddress)
+
+		b.swapMu.Lock()
+		b.swapStates[s.id] = s
+		b.swapMu.Unlock()
+
+		go s.finish()
+	}
+
+	return nil
+}
+
+// finish drives the swap to completion from the contract, then stops it.
+func (s *swapState) finish() {
+	defer func() {
+		s.cancel()
+		s.bob.removeSwap(s.id)
+	}()
+
+	if err := s.resume(); err != nil {
+		log.Errorf("failed to finish swap: id=%s err=%s", s.id, err)
+	}
+}
+
+func newSwapStateFromInfo(b *bob, info *db.SwapInfo) (*swapState, error) {
+	if info.ETHAmount == nil {
+		return nil, errors.New("swap has no ETH amount")
+	}
+
+	s := newSwapState(b, info.ID, info.XMRAmount, common.EtherAmount(*info.ETHAmount))
+	s.createdAt = info.CreatedAt
+	s.t0, s.t1 = info.Timeout0, info.Timeout1
+
+	s.claimTxHash, s.refundTxHash = info.ClaimTxHash, info.RefundTxHash
+
+	var err error
+	s.status, err = common.NewStatusTrackerFromHistory(info.StatusHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.CounterpartyPeerID != "" {
+		s.counterparty, err = peer.Decode(info.CounterpartyPeerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if info.PrivateSpendKey != "" {
+		sk, err := hex.DecodeString(info.PrivateSpendKey)
+		if err != nil {
+			return nil, err
+		}
+
+		vk, err := hex.DecodeString(info.PrivateViewKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.privkeys, err = monero.NewPrivateKeyPairFromBytes(sk, vk)
+		if err != nil {
+			return nil, err
+		}
+
+		s.pubkeys = s.privkeys.PublicKeyPair()
+	}
+
+	if info.CounterpartyPublicSpendKey != "" {
+		kp, err := monero.NewPublicKeyPairFromHex(info.CounterpartyPublicSpendKey, info.CounterpartyPublicViewKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.setAlicePublicKeys(kp)
+	}
+
+	if info.ContractAddress != "" {
+		if err := s.setContract(ethcommon.HexToAddress(info.ContractAddress)); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// resume drives a swap to completion, based on the state of the contract. It's used both for swaps
+// loaded from the database and for swaps whose stream with Alice closed early. If Alice has refunded,
+// we regain control of our XMR. Otherwise, we claim as soon as the contract allows it.
+func (s *swapState) resume() error {
+	s.Lock()
+	switch s.status.Status() {
+	case common.ExpectingKeys, common.KeysExchanged:
+		// we never locked any XMR, so there's nothing to do.
+		defer s.Unlock()
+		return s.setStatus(common.CompletedAbort)
+	}
+
+	err := s.checkResumable()
+	if err == nil {
+		// the watcher is started before checking the contract, so that nothing is missed in between
+		err = s.watchContract()
+	}
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for {
+		done, err := s.step()
+		if err != nil || done {
+			return err
+		}
+
+		now := time.Now()
+		until := resumePollInterval
+		if now.Before(s.t0) && time.Until(s.t0) < until {
+			until = time.Until(s.t0)
+		}
+
+		if !now.Before(s.t1) {
+			log.Warnf("past t1, waiting for Alice to refund so we can regain our XMR: id=%s", s.id)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-s.status.Done():
+			// the contract watcher completed the swap
+			return nil
+		case <-time.After(until):
+		}
+	}
+}
+
+// checkResumable returns an error if the swap can't be driven from the contract.
+func (s *swapState) checkResumable() error {
+	if s.contract == nil {
+		return errors.New("swap has no contract")
+	}
+
+	if s.privkeys == nil {
+		return errors.New("XMR may have been locked, but our keys are missing")
+	}
+
+	return s.setTimeouts()
+}
+
+// step checks the contract, regaining our XMR if Alice has refunded and claiming if the contract
+// allows it. It returns true once the swap has completed.
+func (s *swapState) step() (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	if s.status.Status().IsComplete() {
+		return true, nil
+	}
+
+	// eg. a claim started by the user; we check again once it's done
+	if s.txInFlight != "" {
+		return false, nil
+	}
+
+	sa, err := s.getRefundedSecret()
+	if err != nil {
+		return false, err
+	}
+
+	if sa != nil {
+		address, err := s.reclaimMonero(*sa)
+		if err != nil {
+			return false, err
+		}
+
+		log.Infof("regained control over monero account %s", address)
+		return true, nil
+	}
+
+	claimed, err := s.isClaimed()
+	if err != nil {
+		return false, err
+	}
+
+	if claimed {
+		log.Infof("swap was already claimed: id=%s", s.id)
+		return true, s.setStatus(common.CompletedSuccess)
+	}
+
+	ready, err := s.isReady()
+	if err != nil {
+		return false, err
+	}
+
+	// we can claim after Alice sets ready or after t0, but only until t1.
+	now := time.Now()
+	if now.Before(s.t1) && (ready || !now.Before(s.t0)) {
+		txHash, err := s.claimFunds()
+		if err != nil {
+			return false, err
+		}
+
+		log.Infof("claimed our ETH: tx hash=%s", txHash)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// setTimeouts fetches t0 and t1 from the contract, if they weren't set before the daemon exited.
+func (s *swapState) setTimeouts() error {
+	if !s.t0.IsZero() && !s.t1.IsZero() {
+		return nil
+	}
+
+	t0, t1, err := s.getTimeouts()
+	if err != nil {
+		return err
+	}
+
+	s.t0, s.t1 = t0, t1
+	return nil
+}
+
+// getTimeouts fetches t0 and t1 from the contract.
+func (s *swapState) getTimeouts() (time.Time, time.Time, error) {
+	st0, err := s.contract.Timeout0(s.bob.callOpts)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to get timeout0 from contract: err=%w", err)
+	}
+
+	st1, err := s.contract.Timeout1(s.bob.callOpts)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to get timeout1 from contract: err=%w", err)
+	}
+
+	return time.Unix(st0.Int64(), 0), time.Unix(st1.Int64(), 0), nil
+}
+
+func (s *swapState) filterOpts() *bind.FilterOpts {
+	return &bind.FilterOpts{
+		Context: s.ctx,
+	}
+}
+
+// getRefundedSecret returns Alice's secret if she has called Refund(), or nil otherwise.
+func (s *swapState) getRefundedSecret() (*[32]byte, error) {
+	iter, err := s.contract.FilterRefunded(s.filterOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = iter.Close()
+	}()
+
+	if !iter.Next() {
+		return nil, iter.Error()
+	}
+
+	sa := iter.Event.S
+	s.refundTxHash = iter.Event.Raw.TxHash.String()
+	return &sa, nil
+}
+
+func (s *swapState) isClaimed() (bool, error) {
+	iter, err := s.contract.FilterClaimed(s.filterOpts())
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = iter.Close()
+	}()
+
+	if !iter.Next() {
+		return false, iter.Error()
+	}
+
+	s.claimTxHash = iter.Event.Raw.TxHash.String()
+	return true, nil
+}
+
+func (s *swapState) isReady() (bool, error) {
+	iter, err := s.contract.FilterIsReady(s.filterOpts())
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = iter.Close()
+	}()
+
+	return iter.Next(), iter.Error()
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/noot/atomic-swap/common"
 	"github.com/noot/atomic-swap/monero"
@@ -19,11 +20,10 @@ import (
 	"github.com/noot/atomic-swap/swap-contract"
 )
 
-var nextID uint64 = 0
-
 var (
-	errMissingKeys    = errors.New("did not receive Alice's public spend or view key")
-	errMissingAddress = errors.New("got empty contract address")
+	errMissingKeys     = errors.New("did not receive Alice's public spend or view key")
+	errMissingAddress  = errors.New("got empty contract address")
+	errTimeoutMismatch = errors.New("contract's timeout isn't the one that was agreed")
 )
 
 type swapState struct {
@@ -32,9 +32,13 @@ type swapState struct {
 	cancel context.CancelFunc
 	sync.Mutex
 
-	id             uint64
+	id             common.SwapID
+	createdAt      time.Time
+	counterparty   peer.ID
 	providesAmount common.MoneroAmount
 	desiredAmount  common.EtherAmount
+	// duration of each of the contract's timelocks, as agreed with Alice
+	timeout time.Duration
 
 	// our keys for this session
 	privkeys *monero.PrivateKeyPair
@@ -45,6 +49,9 @@ type swapState struct {
 	contractAddr ethcommon.Address
 	t0, t1       time.Time
 
+	// hashes of the transactions sent for this swap, set once they're known
+	claimTxHash, refundTxHash string
+
 	// Alice's keys for this session
 	alicePublicKeys *monero.PublicKeyPair
 	//alicePrivateViewKey *monero.PrivateViewKey
@@ -55,26 +62,63 @@ type swapState struct {
 	// channels
 	readyCh chan struct{}
 
-	// set to true on claiming the ETH
-	success bool
+	// true once we're watching the contract's events
+	watching bool
+
+	// label of the transaction being sent, if any; the swap's lock is released while it's sent.
+	// streamClosed is set if the stream with Alice closed meanwhile.
+	txInFlight   string
+	streamClosed bool
+	// when we last finished sending a transaction
+	lastTx time.Time
+
+	// current status of the swap
+	status *common.StatusTracker
 }
 
-func newSwapState(b *bob, providesAmount common.MoneroAmount, desiredAmount common.EtherAmount) *swapState {
+func newSwapState(b *bob, id common.SwapID, providesAmount common.MoneroAmount, desiredAmount common.EtherAmount) *swapState {
 	ctx, cancel := context.WithCancel(b.ctx)
 
-	s := &swapState{
+	return &swapState{
 		ctx:                 ctx,
 		cancel:              cancel,
 		bob:                 b,
-		id:                  nextID,
+		id:                  id,
+		createdAt:           time.Now(),
 		providesAmount:      providesAmount,
 		desiredAmount:       desiredAmount,
 		nextExpectedMessage: &net.SendKeysMessage{},
 		readyCh:             make(chan struct{}),
+		status:              common.NewStatusTracker(),
+	}
+}
+
+// ID returns the ID of the swap
+func (s *swapState) ID() common.SwapID {
+	return s.id
+}
+
+// Status returns the swap's current status.
+func (s *swapState) Status() common.Status {
+	return s.status.Status()
+}
+
+// Done returns a channel that's closed once the swap is complete.
+func (s *swapState) Done() <-chan struct{} {
+	return s.status.Done()
+}
+
+// LastTransaction returns when we last finished sending one of the swap's transactions, or the
+// current time while one is being sent.
+func (s *swapState) LastTransaction() time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.txInFlight != "" {
+		return time.Now()
 	}
 
-	nextID++
-	return s
+	return s.lastTx
 }
 
 func (s *swapState) SendKeysMessage() (*net.SendKeysMessage, error) {
@@ -96,55 +140,49 @@ func (s *swapState) ProtocolComplete() {
 	s.Lock()
 	defer s.Unlock()
 
-	defer func() {
-		// stop all running goroutines
-		s.cancel()
-		s.bob.swapState = nil
-	}()
-
-	if s.success {
-		str := color.New(color.Bold).Sprintf("**swap completed successfully! id=%d**", s.id)
-		log.Info(str)
+	// how the swap continues depends on the claim's outcome, so wait for it
+	if s.txInFlight != "" {
+		s.streamClosed = true
 		return
 	}
 
-	switch s.nextExpectedMessage.(type) {
-	case *net.SendKeysMessage:
-		// we are fine, as we only just initiated the protocol.
-	case *net.NotifyContractDeployed:
-		// we were waiting for the contract to be deployed, but haven't
-		// locked out funds yet, so we're fine.
-	case *net.NotifyReady:
-		// we already locked our funds - need to wait until we can claim
-		// the funds (ie. wait until after t0)
-		if err := s.tryClaim(); err != nil {
-			log.Errorf("failed to claim funds: err=%s", err)
-		}
-
-		// TODO: we should check if Alice refunded, if so then check contract for secret
-	default:
-		log.Errorf("unexpected nextExpectedMessage in ProtocolComplete: type=%T", s.nextExpectedMessage)
-	}
+	s.protocolComplete()
 }
 
-func (s *swapState) tryClaim() error {
-	untilT0 := time.Until(s.t0)
-	untilT1 := time.Until(s.t1)
-
-	if untilT0 < 0 {
-		// we need to wait until t0 to claim
-		log.Infof("waiting until time %s to refund", s.t0)
-		<-time.After(untilT0)
+// completeIfStreamClosed handles the stream with Alice closing while a transaction was being sent.
+// It must be called with the swap's lock held.
+func (s *swapState) completeIfStreamClosed() {
+	if s.streamClosed && s.txInFlight == "" {
+		s.streamClosed = false
+		s.protocolComplete()
 	}
+}
 
-	if untilT1 > 0 { //nolint
-		// we've passed t1, our only option now is for Alice to refund
-		// and we can regain control of the locked XMR.
-		// TODO: watch contract for Refund() to be called.
+func (s *swapState) protocolComplete() {
+	switch s.status.Status() {
+	case common.CompletedSuccess:
+		str := color.New(color.Bold).Sprintf("**swap completed successfully! id=%s**", s.id)
+		log.Info(str)
+	case common.CompletedRefund:
+		log.Infof("swap completed, Alice refunded and we regained control of our XMR: id=%s", s.id)
+	case common.CompletedAbort:
+		log.Infof("swap was aborted: id=%s", s.id)
+	case common.ExpectingKeys, common.KeysExchanged:
+		// we haven't locked our funds yet, so we're fine.
+		if err := s.setStatus(common.CompletedAbort); err != nil {
+			log.Errorf("failed to abort swap: err=%s", err)
+		}
+	case common.ETHLocked, common.XMRLocked, common.ContractReady:
+		// we may have already locked our funds. the contract decides how the swap ends, so we
+		// keep watching it until we can claim, or until Alice refunds.
+		log.Infof("stream closed before the swap completed, continuing from the contract: id=%s", s.id)
+		go s.finish()
+		return
 	}
 
-	_, err := s.claimFunds()
-	return err
+	// stop all running goroutines
+	s.cancel()
+	s.bob.removeSwap(s.id)
 }
 
 // HandleProtocolMessage is called by the network to handle an incoming message.
@@ -153,6 +191,21 @@ func (s *swapState) tryClaim() error {
 func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, error) {
 	s.Lock()
 	defer s.Unlock()
+	defer s.completeIfStreamClosed()
+
+	resp, done, err := s.handleProtocolMessage(msg)
+	if err != nil {
+		s.events.Publish(common.NewFailedEvent(s.id, s.status.Status(), err))
+	}
+
+	return resp, done, err
+}
+
+func (s *swapState) handleProtocolMessage(msg net.Message) (net.Message, bool, error) {
+	// the contract's events may have completed the swap before Alice's message arrived
+	if s.status.Status().IsComplete() {
+		return nil, true, nil
+	}
 
 	if err := s.checkMessageType(msg); err != nil {
 		return nil, true, err
@@ -180,16 +233,11 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 			return nil, true, fmt.Errorf("failed to instantiate contract instance: %w", err)
 		}
 
-		addrAB, err := s.lockFunds(s.providesAmount)
-		if err != nil {
-			return nil, true, fmt.Errorf("failed to lock funds: %w", err)
-		}
-
-		out := &net.NotifyXMRLock{
-			Address: string(addrAB),
+		if err := s.watchContract(); err != nil {
+			return nil, true, err
 		}
 
-		// set t0 and t1
+		// set t0 and t1, and check that they're what we agreed to before locking
 		st0, err := s.contract.Timeout0(s.bob.callOpts)
 		if err != nil {
 			return nil, true, fmt.Errorf("failed to get timeout0 from contract: err=%w", err)
@@ -204,6 +252,32 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 
 		s.t1 = time.Unix(st1.Int64(), 0)
 
+		if s.timeout != 0 && s.t1.Sub(s.t0) != s.timeout {
+			return nil, true, fmt.Errorf("%w: contract has timeout %s, expected %s", errTimeoutMismatch, s.t1.Sub(s.t0), s.timeout)
+		}
+
+		// persist before locking, so that we can recover our XMR if we exit during the transfer
+		if err := s.setStatus(common.ETHLocked); err != nil {
+			return nil, true, err
+		}
+
+		addrAB, err := s.lockFunds(s.providesAmount)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to lock funds: %w", err)
+		}
+
+		if err = s.setStatus(common.XMRLocked); err != nil {
+			return nil, true, err
+		}
+
+		out := &net.NotifyXMRLock{
+			Address: string(addrAB),
+		}
+
+		if err := s.persist(); err != nil {
+			return nil, true, err
+		}
+
 		go func() {
 			until := time.Until(s.t0)
 
@@ -214,7 +288,7 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 				return
 			case <-time.After(until):
 				// we can now call Claim()
-				txHash, err := s.claimFunds()
+				txHash, err := s.claimIfOngoing()
 				if err != nil {
 					log.Errorf("failed to claim: err=%s", err)
 					return
@@ -223,7 +297,7 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 				log.Debug("funds claimed!")
 
 				// send *net.NotifyClaimed
-				if err := s.net.SendSwapMessage(&net.NotifyClaimed{
+				if err := s.net.SendSwapMessage(s.id, &net.NotifyClaimed{
 					TxHash: txHash,
 				}); err != nil {
 					log.Errorf("failed to send NotifyClaimed message: err=%s", err)
@@ -236,12 +310,17 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 		return out, false, nil
 	case *net.NotifyReady:
 		log.Debug("Alice called Ready(), attempting to claim funds...")
-		close(s.readyCh)
+
+		// the contract's IsReady event may have got here first, in which case we're already
+		// claiming, and Alice is told once the claim is done
+		if s.txInFlight != "" {
+			return nil, false, nil
+		}
 
 		// contract ready, let's claim our ether
-		txHash, err := s.claimFunds()
+		txHash, err := s.handleReady()
 		if err != nil {
-			return nil, true, fmt.Errorf("failed to redeem ether: %w", err)
+			return nil, true, err
 		}
 
 		log.Debug("funds claimed!!")
@@ -249,7 +328,6 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 			TxHash: txHash,
 		}
 
-		s.success = true
 		return out, true, nil
 	case *net.NotifyRefund:
 		// generate monero wallet, regaining control over locked funds
@@ -258,7 +336,6 @@ func (s *swapState) HandleProtocolMessage(msg net.Message) (net.Message, bool, e
 			return nil, true, err
 		}
 
-		s.success = true
 		log.Infof("regained control over monero account %s", addr)
 		return nil, true, nil
 	default:
@@ -280,17 +357,17 @@ func (s *swapState) handleSendKeysMessage(msg *net.SendKeysMessage) error {
 	}
 
 	s.setAlicePublicKeys(kp)
-	return nil
+	return s.setStatus(common.KeysExchanged)
 }
 
 func (s *swapState) handleRefund(txHash string) (monero.Address, error) {
-	receipt, err := s.bob.ethClient.TransactionReceipt(s.ctx, ethcommon.HexToHash(txHash))
+	receipt, err := s.bob.txTracker.WaitHash(s.ctx, ethcommon.HexToHash(txHash))
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to check Refund transaction: %w", err)
 	}
 
-	if len(receipt.Logs) == 0 {
-		return "", errors.New("claim transaction has no logs")
+	if len(receipt.Logs) == 0 || receipt.Logs[0].Address != s.contractAddr {
+		return "", errors.New("refund transaction has no logs from the swap contract")
 	}
 
 	abi, err := abi.JSON(strings.NewReader(swap.SwapABI))
@@ -305,6 +382,12 @@ func (s *swapState) handleRefund(txHash string) (monero.Address, error) {
 	}
 
 	sa := res[0].([32]byte)
+	s.refundTxHash = txHash
+	return s.reclaimMonero(sa)
+}
+
+// reclaimMonero regains control over the locked XMR using the secret Alice revealed when calling Refund().
+func (s *swapState) reclaimMonero(sa [32]byte) (monero.Address, error) {
 	log.Debug("got Alice's secret: ", hex.EncodeToString(sa[:]))
 
 	// got Alice's secret
@@ -325,8 +408,8 @@ func (s *swapState) handleRefund(txHash string) (monero.Address, error) {
 	kpAB := monero.NewPrivateKeyPair(skAB, vkAB)
 
 	// write keys to file in case something goes wrong
-	fp := fmt.Sprintf("%s/%d/swap-secret", s.bob.basepath, s.id)
-	if err = monero.WriteKeysToFile(fp, kpAB, s.bob.env); err != nil {
+	fp := fmt.Sprintf("%s/%s/swap-secret", s.bob.basepath, s.id)
+	if err = monero.WriteKeysToFile(fp, kpAB, s.bob.env, s.bob.ks); err != nil {
 		return "", err
 	}
 
@@ -334,11 +417,30 @@ func (s *swapState) handleRefund(txHash string) (monero.Address, error) {
 	log.Info("public spend keys: ", pkAB.SpendKey().Hex())
 	log.Info("public view keys: ", pkAB.ViewKey().Hex())
 
-	return s.createMoneroWallet(kpAB)
+	address, err := s.createMoneroWallet(kpAB)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.setStatus(common.CompletedRefund); err != nil {
+		log.Errorf("failed to update swap status: id=%s err=%s", s.id, err)
+	}
+
+	return address, nil
 }
 
-// createMoneroWallet creates Alice's monero wallet after Bob calls Claim().
+// createMoneroWallet creates a monero wallet from the swap keys after Alice calls Refund().
+// Once done, Bob's original wallet is re-opened, so that other swaps can continue to use it.
 func (s *swapState) createMoneroWallet(kpAB *monero.PrivateKeyPair) (monero.Address, error) {
+	s.bob.walletMu.Lock()
+	defer s.bob.walletMu.Unlock()
+
+	defer func() {
+		if err := s.bob.openWallet(); err != nil {
+			log.Errorf("failed to re-open wallet: err=%s", err)
+		}
+	}()
+
 	t := time.Now().Format("2006-Jan-2-15:04:05")
 	walletName := fmt.Sprintf("bob-swap-wallet-%s", t)
 	if err := s.bob.client.GenerateFromKeys(kpAB, walletName, "", s.bob.env); err != nil {
@@ -357,7 +459,6 @@ func (s *swapState) createMoneroWallet(kpAB *monero.PrivateKeyPair) (monero.Addr
 	}
 
 	log.Info("wallet balance: ", balance.Balance)
-	s.success = true
 	return kpAB.Address(s.bob.env), nil
 }
 
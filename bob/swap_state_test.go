@@ -6,7 +6,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/noot/atomic-swap/chain"
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
 	"github.com/noot/atomic-swap/monero"
 	"github.com/noot/atomic-swap/net"
 	"github.com/noot/atomic-swap/swap-contract"
@@ -28,14 +30,24 @@ type mockNet struct {
 	msg net.Message
 }
 
-func (n *mockNet) SendSwapMessage(msg net.Message) error {
+func (n *mockNet) SendSwapMessage(_ common.SwapID, msg net.Message) error {
 	n.msg = msg
 	return nil
 }
 
+func (n *mockNet) CloseSwap(_ common.SwapID) error {
+	return nil
+}
+
 var defaultTimeoutDuration = big.NewInt(60 * 60 * 24) // 1 day = 60s * 60min * 24hr
 
 func newTestBob(t *testing.T) (*bob, *swapState) {
+	ks, err := keystore.NewKeystore([]byte(""))
+	require.NoError(t, err)
+
+	signer, err := chain.NewKeySignerFromHex(common.DefaultPrivKeyBob)
+	require.NoError(t, err)
+
 	cfg := &Config{
 		Ctx:                  context.Background(),
 		Basepath:             "/tmp/bob",
@@ -44,9 +56,10 @@ func newTestBob(t *testing.T) (*bob, *swapState) {
 		WalletFile:           testWallet,
 		WalletPassword:       "",
 		EthereumEndpoint:     common.DefaultEthEndpoint,
-		EthereumPrivateKey:   common.DefaultPrivKeyBob,
+		EthereumSigner:       signer,
 		Environment:          common.Development,
 		ChainID:              common.MainnetConfig.EthereumChainID,
+		Keystore:             ks,
 	}
 
 	bob, err := NewBob(cfg)
@@ -57,10 +70,19 @@ func newTestBob(t *testing.T) (*bob, *swapState) {
 
 	_ = bob.daemonClient.GenerateBlocks(bobAddr.Address, 61)
 
-	swapState := newSwapState(bob, common.MoneroAmount(33), common.NewEtherAmount(33))
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+	swapState := newSwapState(bob, id, common.MoneroAmount(33), common.NewEtherAmount(33))
 	return bob, swapState
 }
 
+// advanceStatus moves the swap through the given statuses, as if the protocol had reached them.
+func advanceStatus(t *testing.T, s *swapState, statuses ...common.Status) {
+	for _, status := range statuses {
+		require.NoError(t, s.status.Transition(status))
+	}
+}
+
 func TestSwapState_GenerateKeys(t *testing.T) {
 	_, swapState := newTestBob(t)
 
@@ -90,6 +112,7 @@ func TestSwapState_ClaimFunds(t *testing.T) {
 	copy(claimKey[:], common.Reverse(swapState.privkeys.SpendKey().Public().Bytes()))
 	swapState.contractAddr, _, swapState.contract, err = swap.DeploySwap(bob.auth, conn, claimKey, [32]byte{}, bob.ethAddress, defaultTimeoutDuration)
 	require.NoError(t, err)
+	advanceStatus(t, swapState, common.KeysExchanged, common.ETHLocked, common.XMRLocked)
 
 	_, err = swapState.contract.SetReady(bob.auth)
 	require.NoError(t, err)
@@ -97,6 +120,7 @@ func TestSwapState_ClaimFunds(t *testing.T) {
 	txHash, err := swapState.claimFunds()
 	require.NoError(t, err)
 	require.NotEqual(t, "", txHash)
+	require.Equal(t, common.CompletedSuccess, swapState.status.Status())
 }
 
 func TestSwapState_handleSendKeysMessage(t *testing.T) {
@@ -118,6 +142,7 @@ func TestSwapState_handleSendKeysMessage(t *testing.T) {
 	err = s.handleSendKeysMessage(msg)
 	require.NoError(t, err)
 	require.Equal(t, &net.NotifyContractDeployed{}, s.nextExpectedMessage)
+	require.Equal(t, common.KeysExchanged, s.status.Status())
 	require.Equal(t, alicePubKeys.SpendKey().Hex(), s.alicePublicKeys.SpendKey().Hex())
 	require.Equal(t, alicePubKeys.ViewKey().Hex(), s.alicePublicKeys.ViewKey().Hex())
 }
@@ -139,6 +164,7 @@ func TestSwapState_HandleProtocolMessage_NotifyContractDeployed_ok(t *testing.T)
 	bob, s := newTestBob(t)
 	defer s.cancel()
 	s.nextExpectedMessage = &net.NotifyContractDeployed{}
+	advanceStatus(t, s, common.KeysExchanged)
 	_, _, err := s.generateKeys()
 	require.NoError(t, err)
 
@@ -170,6 +196,7 @@ func TestSwapState_HandleProtocolMessage_NotifyContractDeployed_ok(t *testing.T)
 	require.Equal(t, addr, s.contractAddr)
 	require.Equal(t, duration, s.t1.Sub(s.t0))
 	require.Equal(t, &net.NotifyReady{}, s.nextExpectedMessage)
+	require.Equal(t, common.XMRLocked, s.status.Status())
 }
 
 func TestSwapState_HandleProtocolMessage_NotifyContractDeployed_timeout(t *testing.T) {
@@ -177,6 +204,7 @@ func TestSwapState_HandleProtocolMessage_NotifyContractDeployed_timeout(t *testi
 	defer s.cancel()
 	s.net = new(mockNet)
 	s.nextExpectedMessage = &net.NotifyContractDeployed{}
+	advanceStatus(t, s, common.KeysExchanged)
 	_, _, err := s.generateKeys()
 	require.NoError(t, err)
 
@@ -207,6 +235,7 @@ func TestSwapState_HandleProtocolMessage_NotifyContractDeployed_timeout(t *testi
 	require.Equal(t, addr, s.contractAddr)
 	require.Equal(t, duration, s.t1.Sub(s.t0))
 	require.Equal(t, &net.NotifyReady{}, s.nextExpectedMessage)
+	require.Equal(t, common.XMRLocked, s.status.Status())
 
 	// time.Sleep(duration)
 	// require.NotNil(t, s.net.(*mockNet).msg) // TODO: fix this, sometimes fails?
@@ -216,6 +245,7 @@ func TestSwapState_HandleProtocolMessage_NotifyReady(t *testing.T) {
 	bob, s := newTestBob(t)
 
 	s.nextExpectedMessage = &net.NotifyReady{}
+	advanceStatus(t, s, common.KeysExchanged, common.ETHLocked, common.XMRLocked)
 	_, _, err := s.generateKeys()
 	require.NoError(t, err)
 
@@ -233,6 +263,7 @@ func TestSwapState_HandleProtocolMessage_NotifyReady(t *testing.T) {
 	require.True(t, done)
 	require.NotNil(t, resp)
 	require.Equal(t, net.NotifyClaimedType, resp.Type())
+	require.Equal(t, common.CompletedSuccess, s.status.Status())
 }
 
 func TestSwapState_handleRefund(t *testing.T) {
@@ -255,6 +286,7 @@ func TestSwapState_handleRefund(t *testing.T) {
 	// lock XMR
 	addrAB, err := s.lockFunds(s.providesAmount)
 	require.NoError(t, err)
+	advanceStatus(t, s, common.KeysExchanged, common.ETHLocked, common.XMRLocked)
 
 	// call refund w/ Alice's spend key
 	secret := aliceKeys.SpendKeyBytes()
@@ -267,6 +299,7 @@ func TestSwapState_handleRefund(t *testing.T) {
 	addr, err := s.handleRefund(tx.Hash().String())
 	require.NoError(t, err)
 	require.Equal(t, addrAB, addr)
+	require.Equal(t, common.CompletedRefund, s.status.Status())
 }
 
 func TestSwapState_HandleProtocolMessage_NotifyRefund(t *testing.T) {
@@ -289,6 +322,7 @@ func TestSwapState_HandleProtocolMessage_NotifyRefund(t *testing.T) {
 	// lock XMR
 	_, err = s.lockFunds(s.providesAmount)
 	require.NoError(t, err)
+	advanceStatus(t, s, common.KeysExchanged, common.ETHLocked, common.XMRLocked)
 
 	// call refund w/ Alice's spend key
 	secret := aliceKeys.SpendKeyBytes()
@@ -0,0 +1,441 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// DefaultBumpInterval is how long a transaction can be pending before it's replaced with one
+	// paying higher fees, when it isn't close to its deadline.
+	DefaultBumpInterval = time.Minute * 3
+
+	// transactions are never bumped more often than this, however close their deadline is
+	minBumpInterval = time.Second * 15
+
+	// fees are raised by this percentage when bumping, or by urgentBumpPercent close to the deadline.
+	// geth's mempool only accepts a replacement that raises both fees by at least 10%.
+	bumpPercent       = 25
+	urgentBumpPercent = 50
+	minBumpPercent    = 10
+)
+
+var errFeeCapReached = errors.New("can't raise fees any further without exceeding the maximum fee per gas")
+
+// FeeBackend is the subset of *ethclient.Client that the fee manager uses.
+type FeeBackend interface {
+	TxBackend
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	PendingNonceAt(ctx context.Context, account ethcommon.Address) (uint64, error)
+}
+
+// FeeConfig configures a FeeManager.
+type FeeConfig struct {
+	// MaxFeePerGas is the most we'll pay per gas, including the base fee, even when bumping a
+	// stuck transaction. If it's nil, there's no limit.
+	MaxFeePerGas *big.Int
+	// PriorityFee is the tip per gas paid to the block producer. If it's nil, the node's
+	// suggestion is used.
+	PriorityFee  *big.Int
+	BumpInterval time.Duration // defaults to DefaultBumpInterval
+	// NoReplacements stops pending transactions from being replaced, eg. because they're signed
+	// offline, and every replacement would have to be signed too.
+	NoReplacements bool
+}
+
+// FeeSettings describes the fees our transactions pay.
+type FeeSettings struct {
+	MaxFeePerGas *big.Int      // nil if there's no limit
+	PriorityFee  *big.Int      // nil if the node's suggestion is used
+	BumpInterval time.Duration // zero if pending transactions aren't replaced
+
+	// current network conditions. BaseFee is nil if the chain doesn't support EIP-1559.
+	BaseFee              *big.Int
+	SuggestedPriorityFee *big.Int
+}
+
+// FeeManager sets the fees of our transactions. Transactions that are stuck in the mempool are
+// replaced with ones that pay higher fees, more often and by more as their deadline approaches,
+// so that a claim or refund isn't missed because of congestion.
+type FeeManager struct {
+	backend      FeeBackend
+	tracker      *TxTracker
+	maxFee       *big.Int
+	priorityFee  *big.Int
+	bumpInterval time.Duration
+
+	// set if pending transactions are never replaced
+	noReplacements bool
+
+	// guards nonces, so that concurrent swaps don't re-use nonces. It's only held while a nonce is
+	// picked, not while the transaction is signed and sent, which can take a while.
+	sendMu sync.Mutex
+	nonces map[ethcommon.Address]*nonceState
+}
+
+// nonceState tracks the nonces handed out for an account, as the node's pending nonce doesn't
+// include transactions that are still being signed.
+type nonceState struct {
+	next  uint64   // the nonce after the highest one handed out
+	freed []uint64 // nonces below next whose transaction was never sent
+}
+
+// NewFeeManager returns a new *FeeManager, which waits for transactions with the given tracker.
+func NewFeeManager(backend FeeBackend, tracker *TxTracker, cfg *FeeConfig) *FeeManager {
+	if cfg == nil {
+		cfg = &FeeConfig{}
+	}
+
+	bumpInterval := cfg.BumpInterval
+	if bumpInterval == 0 {
+		bumpInterval = DefaultBumpInterval
+	}
+
+	return &FeeManager{
+		backend:        backend,
+		tracker:        tracker,
+		maxFee:         cfg.MaxFeePerGas,
+		priorityFee:    cfg.PriorityFee,
+		bumpInterval:   bumpInterval,
+		noReplacements: cfg.NoReplacements,
+		nonces:         make(map[ethcommon.Address]*nonceState),
+	}
+}
+
+// Settings returns the configured fees, along with the current base fee and suggested priority fee.
+func (m *FeeManager) Settings(ctx context.Context) (*FeeSettings, error) {
+	head, err := m.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current block: %w", err)
+	}
+
+	settings := &FeeSettings{
+		MaxFeePerGas: m.maxFee,
+		PriorityFee:  m.priorityFee,
+		BumpInterval: m.bumpInterval,
+		BaseFee:      head.BaseFee,
+	}
+
+	if m.noReplacements {
+		settings.BumpInterval = 0
+	}
+
+	if head.BaseFee != nil {
+		settings.SuggestedPriorityFee, err = m.backend.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get suggested priority fee: %w", err)
+		}
+	}
+
+	return settings, nil
+}
+
+// TransactFunc sends a transaction with the given options, eg. by calling a contract binding.
+type TransactFunc func(opts *bind.TransactOpts) (*ethtypes.Transaction, error)
+
+// Transact sends a transaction with send, and waits for it to be confirmed. While it's pending, it's
+// replaced with one paying higher fees every bump interval. The deadline is when the transaction
+// stops being useful, eg. a timelock in the swap contract; the closer it is, the more often and the
+// more fees are bumped. It's ignored if it's zero.
+// The receipt of whichever transaction was confirmed is returned.
+func (m *FeeManager) Transact(ctx context.Context, auth *bind.TransactOpts, deadline time.Time, send TransactFunc) (*ethtypes.Receipt, error) {
+	fees, err := m.initialFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := *auth
+	opts.Context = ctx
+	fees.apply(&opts)
+
+	if opts.Nonce == nil {
+		nonce, err := m.reserveNonce(ctx, opts.From)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Nonce = new(big.Int).SetUint64(nonce)
+	}
+
+	tx, err := send(&opts)
+	if err != nil {
+		if auth.Nonce == nil {
+			m.releaseNonce(opts.From, opts.Nonce.Uint64())
+		}
+
+		return nil, err
+	}
+
+	if !deadline.IsZero() && time.Until(deadline) < m.bumpInterval {
+		log.Warnf("transaction was sent close to its deadline: tx=%s deadline=%s", tx.Hash(), deadline.Format(time.RFC3339))
+	}
+
+	// replacements must use the same nonce, and the same gas limit, as estimating it again would
+	// be done against a state that may include the pending transaction
+	opts.Nonce = new(big.Int).SetUint64(tx.Nonce())
+	opts.GasLimit = tx.Gas()
+
+	tracked := &trackedTx{
+		txs: []*ethtypes.Transaction{tx},
+	}
+
+	if !m.noReplacements {
+		tracked.replacer = &feeBumper{
+			m:        m,
+			opts:     opts,
+			send:     send,
+			fees:     fees,
+			deadline: deadline,
+			sentAt:   time.Now(),
+		}
+	}
+
+	return m.tracker.wait(ctx, tracked)
+}
+
+// reserveNonce returns the nonce of the next transaction sent from the account, which is then
+// signed and sent without holding sendMu. Nonces whose transaction was never sent are re-used
+// first, so that they don't leave a gap that holds up later transactions.
+func (m *FeeManager) reserveNonce(ctx context.Context, from ethcommon.Address) (uint64, error) {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	pending, err := m.backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get account nonce: %w", err)
+	}
+
+	state, has := m.nonces[from]
+	if !has {
+		state = &nonceState{}
+		m.nonces[from] = state
+	}
+
+	// freed nonces may have been used since, eg. by a transaction sent by another program
+	freed := state.freed[:0]
+	for _, nonce := range state.freed {
+		if nonce >= pending {
+			freed = append(freed, nonce)
+		}
+	}
+
+	sort.Slice(freed, func(i, j int) bool {
+		return freed[i] < freed[j]
+	})
+
+	if len(freed) > 0 {
+		state.freed = freed[1:]
+		return freed[0], nil
+	}
+
+	state.freed = freed
+	nonce := pending
+	if state.next > nonce {
+		nonce = state.next
+	}
+
+	state.next = nonce + 1
+	return nonce, nil
+}
+
+// releaseNonce returns a nonce whose transaction couldn't be signed or sent, so that it's re-used.
+func (m *FeeManager) releaseNonce(from ethcommon.Address, nonce uint64) {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	state, has := m.nonces[from]
+	if !has {
+		return
+	}
+
+	if nonce+1 == state.next {
+		state.next = nonce
+		return
+	}
+
+	state.freed = append(state.freed, nonce)
+}
+
+// txFees are the fees paid by a transaction. gasPrice is only set on chains without EIP-1559.
+type txFees struct {
+	gasPrice       *big.Int
+	tipCap, feeCap *big.Int
+}
+
+func (f *txFees) apply(opts *bind.TransactOpts) {
+	opts.GasPrice, opts.GasTipCap, opts.GasFeeCap = f.gasPrice, f.tipCap, f.feeCap
+}
+
+func (m *FeeManager) initialFees(ctx context.Context) (*txFees, error) {
+	head, err := m.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current block: %w", err)
+	}
+
+	if head.BaseFee == nil {
+		price, err := m.backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get suggested gas price: %w", err)
+		}
+
+		return m.capped(&txFees{gasPrice: price}), nil
+	}
+
+	tip := m.priorityFee
+	if tip == nil {
+		tip, err = m.backend.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get suggested priority fee: %w", err)
+		}
+	}
+
+	return m.capped(&txFees{
+		tipCap: tip,
+		feeCap: minFeeCap(head.BaseFee, tip),
+	}), nil
+}
+
+// bumpFees returns the fees of a transaction replacing one with the given fees.
+func (m *FeeManager) bumpFees(ctx context.Context, fees *txFees, percent int64) (*txFees, error) {
+	var next *txFees
+	if fees.gasPrice != nil {
+		next = m.capped(&txFees{gasPrice: addPercent(fees.gasPrice, percent)})
+		if next.gasPrice.Cmp(addPercent(fees.gasPrice, minBumpPercent)) < 0 {
+			return nil, errFeeCapReached
+		}
+
+		return next, nil
+	}
+
+	next = &txFees{
+		tipCap: addPercent(fees.tipCap, percent),
+		feeCap: addPercent(fees.feeCap, percent),
+	}
+
+	// the base fee may have risen faster than our fees
+	if head, err := m.backend.HeaderByNumber(ctx, nil); err == nil && head.BaseFee != nil {
+		if floor := minFeeCap(head.BaseFee, next.tipCap); next.feeCap.Cmp(floor) < 0 {
+			next.feeCap = floor
+		}
+	}
+
+	next = m.capped(next)
+	if next.feeCap.Cmp(addPercent(fees.feeCap, minBumpPercent)) < 0 ||
+		next.tipCap.Cmp(addPercent(fees.tipCap, minBumpPercent)) < 0 {
+		return nil, errFeeCapReached
+	}
+
+	return next, nil
+}
+
+// capped limits the fees to the maximum fee per gas, if there is one.
+func (m *FeeManager) capped(fees *txFees) *txFees {
+	if m.maxFee == nil {
+		return fees
+	}
+
+	if fees.gasPrice != nil {
+		fees.gasPrice = bigMin(fees.gasPrice, m.maxFee)
+		return fees
+	}
+
+	fees.feeCap = bigMin(fees.feeCap, m.maxFee)
+	fees.tipCap = bigMin(fees.tipCap, fees.feeCap)
+	return fees
+}
+
+// feeBumper replaces a pending transaction with one paying higher fees.
+type feeBumper struct {
+	m        *FeeManager
+	opts     bind.TransactOpts
+	send     TransactFunc
+	fees     *txFees
+	deadline time.Time
+	sentAt   time.Time
+}
+
+// interval returns how long a transaction can be pending before it's replaced. It's shortened as
+// the deadline approaches, so that there's time for several replacements.
+func (b *feeBumper) interval() time.Duration {
+	interval := b.m.bumpInterval
+	if b.deadline.IsZero() {
+		return interval
+	}
+
+	if quarter := time.Until(b.deadline) / 4; quarter < interval {
+		interval = quarter
+	}
+
+	if interval < minBumpInterval {
+		interval = minBumpInterval
+	}
+
+	return interval
+}
+
+func (b *feeBumper) urgent() bool {
+	return !b.deadline.IsZero() && time.Until(b.deadline) < b.m.bumpInterval*2
+}
+
+func (b *feeBumper) due() bool {
+	return time.Since(b.sentAt) >= b.interval()
+}
+
+func (b *feeBumper) replace(ctx context.Context) (*ethtypes.Transaction, error) {
+	// wait a full interval before trying again, even if this fails
+	b.sentAt = time.Now()
+
+	percent := int64(bumpPercent)
+	if b.urgent() {
+		percent = urgentBumpPercent
+	}
+
+	fees, err := b.m.bumpFees(ctx, b.fees, percent)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := b.opts
+	opts.Context = ctx
+	fees.apply(&opts)
+
+	tx, err := b.send(&opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b.fees = fees
+	return tx, nil
+}
+
+// minFeeCap returns the fee cap go-ethereum uses by default, which allows for the base fee to
+// double before the transaction is included.
+func minFeeCap(baseFee, tip *big.Int) *big.Int {
+	feeCap := new(big.Int).Mul(baseFee, big.NewInt(2))
+	return feeCap.Add(feeCap, tip)
+}
+
+// addPercent returns n increased by the given percentage, rounded up.
+func addPercent(n *big.Int, percent int64) *big.Int {
+	res := new(big.Int).Mul(n, big.NewInt(100+percent))
+	res.Add(res, big.NewInt(99))
+	return res.Div(res, big.NewInt(100))
+}
+
+func bigMin(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+
+	return b
+}
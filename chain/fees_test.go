@@ -0,0 +1,199 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/swap-contract"
+)
+
+// stuckBackend holds on to the first transactions sent to it, as if they were stuck in the mempool.
+type stuckBackend struct {
+	*backends.SimulatedBackend
+
+	sync.Mutex
+	stuck int
+	held  map[ethcommon.Hash]*ethtypes.Transaction
+	sent  []*ethtypes.Transaction
+}
+
+func newStuckBackend(backend *backends.SimulatedBackend, stuck int) *stuckBackend {
+	return &stuckBackend{
+		SimulatedBackend: backend,
+		stuck:            stuck,
+		held:             make(map[ethcommon.Hash]*ethtypes.Transaction),
+	}
+}
+
+func (b *stuckBackend) SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.sent = append(b.sent, tx)
+	if len(b.held) < b.stuck {
+		b.held[tx.Hash()] = tx
+		return nil
+	}
+
+	return b.SimulatedBackend.SendTransaction(ctx, tx)
+}
+
+func (b *stuckBackend) TransactionByHash(ctx context.Context, hash ethcommon.Hash) (*ethtypes.Transaction, bool, error) {
+	b.Lock()
+	tx, has := b.held[hash]
+	b.Unlock()
+	if has {
+		return tx, true, nil
+	}
+
+	return b.SimulatedBackend.TransactionByHash(ctx, hash)
+}
+
+func (b *stuckBackend) sentTxs() []*ethtypes.Transaction {
+	b.Lock()
+	defer b.Unlock()
+	return append([]*ethtypes.Transaction{}, b.sent...)
+}
+
+func newTestFeeManager(backend FeeBackend, cfg *FeeConfig) *FeeManager {
+	return NewFeeManager(backend, NewTxTracker(backend, &TrackerConfig{
+		PollInterval: testPollInterval,
+	}), cfg)
+}
+
+func TestFeeManager_MaxFee(t *testing.T) {
+	s := newTestSwap(t)
+
+	head, err := s.backend.HeaderByNumber(context.Background(), nil)
+	require.NoError(t, err)
+	require.NotNil(t, head.BaseFee)
+
+	// lower than the fee cap go-ethereum would use, but enough to be included
+	maxFee := new(big.Int).Add(head.BaseFee, big.NewInt(1))
+	backend := newStuckBackend(s.backend, 0)
+	contract, err := swap.NewSwap(s.address, backend)
+	require.NoError(t, err)
+
+	m := newTestFeeManager(backend, &FeeConfig{
+		MaxFeePerGas: maxFee,
+	})
+
+	settings, err := m.Settings(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, maxFee, settings.MaxFeePerGas)
+	require.Nil(t, settings.PriorityFee)
+	require.Equal(t, DefaultBumpInterval, settings.BumpInterval)
+	require.Equal(t, head.BaseFee, settings.BaseFee)
+
+	ch := make(chan *waitResult, 1)
+	go func() {
+		receipt, err := m.Transact(context.Background(), s.alice, time.Time{}, contract.SetReady)
+		ch <- &waitResult{receipt, err}
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(backend.sentTxs()) == 1
+	}, time.Second*5, testPollInterval)
+
+	s.backend.Commit()
+	res := requireResult(t, ch)
+	require.NoError(t, res.err)
+
+	tx, _, err := s.backend.TransactionByHash(context.Background(), res.receipt.TxHash)
+	require.NoError(t, err)
+	require.Equal(t, maxFee, tx.GasFeeCap())
+	require.Equal(t, big.NewInt(1), tx.GasTipCap())
+}
+
+func TestFeeManager_Bump(t *testing.T) {
+	s := newTestSwap(t)
+	backend := newStuckBackend(s.backend, 1)
+	contract, err := swap.NewSwap(s.address, backend)
+	require.NoError(t, err)
+
+	m := newTestFeeManager(backend, &FeeConfig{
+		PriorityFee:  big.NewInt(100),
+		BumpInterval: testPollInterval * 2,
+	})
+
+	ch := make(chan *waitResult, 1)
+	go func() {
+		receipt, err := m.Transact(context.Background(), s.alice, time.Time{}, contract.SetReady)
+		ch <- &waitResult{receipt, err}
+	}()
+
+	// the first transaction is stuck, so it should be replaced
+	require.Eventually(t, func() bool {
+		return len(backend.sentTxs()) == 2
+	}, time.Second*5, testPollInterval)
+
+	s.backend.Commit()
+	res := requireResult(t, ch)
+	require.NoError(t, res.err)
+
+	sent := backend.sentTxs()
+	require.Equal(t, sent[1].Hash(), res.receipt.TxHash)
+	require.Equal(t, sent[0].Nonce(), sent[1].Nonce())
+	require.Equal(t, sent[0].Gas(), sent[1].Gas())
+	require.Equal(t, big.NewInt(125), sent[1].GasTipCap())
+	require.Equal(t, addPercent(sent[0].GasFeeCap(), bumpPercent), sent[1].GasFeeCap())
+
+	require.Len(t, res.receipt.Logs, 1)
+	_, err = s.contract.ParseIsReady(*res.receipt.Logs[0])
+	require.NoError(t, err)
+}
+
+func TestFeeManager_BumpFees(t *testing.T) {
+	s := newTestSwap(t)
+	m := newTestFeeManager(s.backend, &FeeConfig{
+		MaxFeePerGas: big.NewInt(1000),
+	})
+
+	ctx := context.Background()
+	fees, err := m.bumpFees(ctx, &txFees{gasPrice: big.NewInt(500)}, bumpPercent)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(625), fees.gasPrice)
+
+	// capped, but still enough of an increase to replace the transaction
+	fees, err = m.bumpFees(ctx, &txFees{gasPrice: big.NewInt(900)}, bumpPercent)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(1000), fees.gasPrice)
+
+	_, err = m.bumpFees(ctx, &txFees{gasPrice: big.NewInt(950)}, bumpPercent)
+	require.ErrorIs(t, err, errFeeCapReached)
+
+	// the fee cap is raised to keep up with the base fee, which is far higher than the maximum
+	m.maxFee = nil
+	fees, err = m.bumpFees(ctx, &txFees{tipCap: big.NewInt(100), feeCap: big.NewInt(1000)}, urgentBumpPercent)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(150), fees.tipCap)
+	require.Equal(t, 1, fees.feeCap.Cmp(big.NewInt(1500)))
+}
+
+func TestFeeBumper_Interval(t *testing.T) {
+	m := NewFeeManager(nil, nil, nil)
+
+	b := &feeBumper{m: m}
+	require.Equal(t, DefaultBumpInterval, b.interval())
+	require.False(t, b.urgent())
+
+	// bumps are more frequent, and larger, close to the deadline
+	b.deadline = time.Now().Add(time.Minute * 4)
+	require.InDelta(t, time.Minute, b.interval(), float64(time.Second))
+	require.True(t, b.urgent())
+
+	b.deadline = time.Now().Add(time.Second * 10)
+	require.Equal(t, minBumpInterval, b.interval())
+
+	b.deadline = time.Now().Add(time.Hour)
+	require.Equal(t, DefaultBumpInterval, b.interval())
+	require.False(t, b.urgent())
+}
@@ -0,0 +1,241 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// DefaultSigningLatency is how long we expect it takes to get a transaction signed offline, if no
+// other value is configured.
+const DefaultSigningLatency = time.Minute * 10
+
+// a transaction without a deadline is given this many times the signing latency to be signed
+const signingTimeoutFactor = 3
+
+var (
+	// ErrNoUnsignedTx is returned when importing a signed transaction that doesn't match any of the
+	// transactions waiting to be signed.
+	ErrNoUnsignedTx = errors.New("signed transaction doesn't match any transaction waiting to be signed")
+	// ErrSigningTimeout is returned when a transaction isn't signed before its deadline, or within a
+	// few times the signing latency if it doesn't have one.
+	ErrSigningTimeout = errors.New("transaction wasn't signed offline in time")
+
+	errSigningCancelled = errors.New("stopped waiting for transaction to be signed offline")
+)
+
+// UnsignedTx is a transaction waiting to be signed offline.
+type UnsignedTx struct {
+	ID       uint64
+	Label    string // what the transaction does
+	Tx       *ethtypes.Transaction
+	ChainID  *big.Int
+	Deadline time.Time // when the transaction must be included by; zero if there's no deadline
+	Created  time.Time
+}
+
+type signingRequest struct {
+	*UnsignedTx
+	signed chan *ethtypes.Transaction
+}
+
+// OfflineSigner is a Signer for an account whose key is kept offline, eg. on an air-gapped machine.
+// Transactions are held until a signed version is imported, so SignTx blocks until then.
+type OfflineSigner struct {
+	address ethcommon.Address
+	latency time.Duration
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]*signingRequest
+}
+
+// NewOfflineSigner returns a new *OfflineSigner for the given account. The latency is how long we
+// expect it takes to get a transaction signed, and is used to start time-sensitive transactions early
+// enough; if it's zero, DefaultSigningLatency is used.
+func NewOfflineSigner(address ethcommon.Address, latency time.Duration) *OfflineSigner {
+	if latency == 0 {
+		latency = DefaultSigningLatency
+	}
+
+	return &OfflineSigner{
+		address: address,
+		latency: latency,
+		nextID:  1,
+		pending: make(map[uint64]*signingRequest),
+	}
+}
+
+// Address returns the address of the offline account.
+func (s *OfflineSigner) Address() ethcommon.Address {
+	return s.address
+}
+
+// Latency returns how long we expect it takes to get a transaction signed.
+func (s *OfflineSigner) Latency() time.Duration {
+	return s.latency
+}
+
+// SignTx waits for the transaction to be signed offline.
+func (s *OfflineSigner) SignTx(tx *ethtypes.Transaction, chainID *big.Int) (*ethtypes.Transaction, error) {
+	return s.Sign(context.Background(), "", time.Time{}, tx, chainID)
+}
+
+// WithLabel returns a Signer that describes the transactions it's given with the label and deadline,
+// and stops waiting for them to be signed once the context is cancelled.
+func (s *OfflineSigner) WithLabel(ctx context.Context, label string, deadline time.Time) Signer {
+	return &labelledSigner{
+		OfflineSigner: s,
+		ctx:           ctx,
+		label:         label,
+		deadline:      deadline,
+	}
+}
+
+// Sign holds the transaction until a signed version of it is imported, and returns it. It gives up
+// once the deadline passes, or after signingTimeoutFactor times the signing latency if there's no
+// deadline, so that the transaction's nonce can be re-used.
+func (s *OfflineSigner) Sign(ctx context.Context, label string, deadline time.Time, tx *ethtypes.Transaction,
+	chainID *big.Int) (*ethtypes.Transaction, error) {
+	req := &signingRequest{
+		UnsignedTx: &UnsignedTx{
+			Label:    label,
+			Tx:       withChainID(tx, chainID),
+			ChainID:  chainID,
+			Deadline: deadline,
+			Created:  time.Now(),
+		},
+		signed: make(chan *ethtypes.Transaction, 1),
+	}
+
+	s.mu.Lock()
+	req.ID = s.nextID
+	s.nextID++
+	s.pending[req.ID] = req
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, req.ID)
+		s.mu.Unlock()
+	}()
+
+	log.Infof("transaction is waiting to be signed offline: id=%d label=%q nonce=%d", req.ID, label, tx.Nonce())
+	if !deadline.IsZero() && time.Until(deadline) < s.latency {
+		log.Warnf("transaction must be signed and sent by %s, sooner than signing usually takes: id=%d label=%q",
+			deadline.Format(time.RFC3339), req.ID, label)
+	}
+
+	timeout := time.Until(deadline)
+	if deadline.IsZero() {
+		timeout = s.latency * signingTimeoutFactor
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case signed := <-req.signed:
+		return signed, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: id=%d label=%q", ErrSigningTimeout, req.ID, label)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: id=%d label=%q: %s", errSigningCancelled, req.ID, label, ctx.Err())
+	}
+}
+
+// Pending returns the transactions waiting to be signed, oldest first.
+func (s *OfflineSigner) Pending() []*UnsignedTx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txs := make([]*UnsignedTx, 0, len(s.pending))
+	for _, req := range s.pending {
+		txs = append(txs, req.UnsignedTx)
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].ID < txs[j].ID
+	})
+	return txs
+}
+
+// Import hands a signed transaction to whoever is waiting for it, who then sends it. It must be a
+// signed version of one of the pending transactions, signed by our account.
+func (s *OfflineSigner) Import(signed *ethtypes.Transaction) (*UnsignedTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, req := range s.pending {
+		txSigner := ethtypes.LatestSignerForChainID(req.ChainID)
+		if txSigner.Hash(signed) != txSigner.Hash(req.Tx) {
+			continue
+		}
+
+		sender, err := ethtypes.Sender(txSigner, signed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature: %w", err)
+		}
+
+		if sender != s.address {
+			return nil, fmt.Errorf("%w: expected=%s actual=%s", errWrongSigner, s.address, sender)
+		}
+
+		req.signed <- signed
+		delete(s.pending, req.ID)
+		return req.UnsignedTx, nil
+	}
+
+	return nil, ErrNoUnsignedTx
+}
+
+// labelledSigner is a view of an OfflineSigner that labels the transactions it's given.
+type labelledSigner struct {
+	*OfflineSigner
+	ctx      context.Context
+	label    string
+	deadline time.Time
+}
+
+func (s *labelledSigner) SignTx(tx *ethtypes.Transaction, chainID *big.Int) (*ethtypes.Transaction, error) {
+	return s.Sign(s.ctx, s.label, s.deadline, tx, chainID)
+}
+
+// withChainID returns the transaction with its chain ID set, as go-ethereum leaves it unset until the
+// transaction is signed, but it's needed to sign the transaction offline.
+func withChainID(tx *ethtypes.Transaction, chainID *big.Int) *ethtypes.Transaction {
+	switch tx.Type() {
+	case ethtypes.DynamicFeeTxType:
+		return ethtypes.NewTx(&ethtypes.DynamicFeeTx{
+			ChainID:    chainID,
+			Nonce:      tx.Nonce(),
+			GasTipCap:  tx.GasTipCap(),
+			GasFeeCap:  tx.GasFeeCap(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	case ethtypes.AccessListTxType:
+		return ethtypes.NewTx(&ethtypes.AccessListTx{
+			ChainID:    chainID,
+			Nonce:      tx.Nonce(),
+			GasPrice:   tx.GasPrice(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	default:
+		return tx
+	}
+}
@@ -0,0 +1,171 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func requirePending(t *testing.T, signer *OfflineSigner) *UnsignedTx {
+	require.Eventually(t, func() bool {
+		return len(signer.Pending()) == 1
+	}, time.Second*5, testPollInterval)
+
+	return signer.Pending()[0]
+}
+
+func TestOfflineSigner(t *testing.T) {
+	s := newTestSwap(t)
+	chainID := big.NewInt(1337)
+	offline := NewOfflineSigner(s.alice.From, time.Hour)
+	m := NewFeeManager(s.backend, NewTxTracker(s.backend, &TrackerConfig{
+		PollInterval: testPollInterval,
+	}), &FeeConfig{NoReplacements: true})
+
+	ctx := context.Background()
+	deadline := time.Now().Add(time.Minute)
+	ch := make(chan *waitResult, 1)
+	go func() {
+		opts := NewTransactOpts(ctx, offline.WithLabel(ctx, "set ready", deadline), chainID)
+		receipt, err := m.Transact(ctx, opts, deadline, s.contract.SetReady)
+		ch <- &waitResult{receipt, err}
+	}()
+
+	unsigned := requirePending(t, offline)
+	require.Equal(t, "set ready", unsigned.Label)
+	require.Equal(t, deadline, unsigned.Deadline)
+	require.Equal(t, chainID, unsigned.Tx.ChainId())
+	require.Equal(t, s.address, *unsigned.Tx.To())
+
+	// the transaction survives being exported and imported
+	raw, err := unsigned.Tx.MarshalBinary()
+	require.NoError(t, err)
+	exported := new(ethtypes.Transaction)
+	require.NoError(t, exported.UnmarshalBinary(raw))
+
+	// signed by the wrong key
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signed, err := NewKeySigner(other).SignTx(exported, chainID)
+	require.NoError(t, err)
+	_, err = offline.Import(signed)
+	require.ErrorIs(t, err, errWrongSigner)
+
+	// not one of ours
+	signed, err = NewKeySigner(s.aliceKey).SignTx(ethtypes.NewTx(&ethtypes.DynamicFeeTx{
+		ChainID: chainID,
+		Nonce:   unsigned.Tx.Nonce(),
+	}), chainID)
+	require.NoError(t, err)
+	_, err = offline.Import(signed)
+	require.ErrorIs(t, err, ErrNoUnsignedTx)
+
+	signed, err = NewKeySigner(s.aliceKey).SignTx(exported, chainID)
+	require.NoError(t, err)
+	imported, err := offline.Import(signed)
+	require.NoError(t, err)
+	require.Equal(t, unsigned.ID, imported.ID)
+	require.Empty(t, offline.Pending())
+
+	require.Eventually(t, func() bool {
+		_, pending, err := s.backend.TransactionByHash(ctx, signed.Hash())
+		return err == nil && pending
+	}, time.Second*5, testPollInterval)
+
+	s.backend.Commit()
+	res := requireResult(t, ch)
+	require.NoError(t, res.err)
+	require.Equal(t, signed.Hash(), res.receipt.TxHash)
+}
+
+func TestOfflineSigner_Cancel(t *testing.T) {
+	s := newTestSwap(t)
+	offline := NewOfflineSigner(s.alice.From, 0)
+	require.Equal(t, DefaultSigningLatency, offline.Latency())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.contract.SetReady(NewTransactOpts(ctx, offline.WithLabel(ctx, "set ready", time.Time{}), big.NewInt(1337)))
+		errCh <- err
+	}()
+
+	requirePending(t, offline)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, errSigningCancelled)
+	case <-time.After(time.Second * 5):
+		t.Fatal("didn't stop waiting for the transaction to be signed")
+	}
+
+	require.Empty(t, offline.Pending())
+}
+
+func TestOfflineSigner_Timeout(t *testing.T) {
+	s := newTestSwap(t)
+	offline := NewOfflineSigner(s.alice.From, time.Hour)
+
+	ctx := context.Background()
+	deadline := time.Now().Add(time.Millisecond * 200)
+	_, err := s.contract.SetReady(NewTransactOpts(ctx, offline.WithLabel(ctx, "set ready", deadline), big.NewInt(1337)))
+	require.ErrorIs(t, err, ErrSigningTimeout)
+	require.Empty(t, offline.Pending())
+}
+
+func TestFeeManager_ConcurrentSigning(t *testing.T) {
+	s := newTestSwap(t)
+	chainID := big.NewInt(1337)
+	offline := NewOfflineSigner(s.alice.From, time.Hour)
+	m := newTestFeeManager(s.backend, &FeeConfig{NoReplacements: true})
+
+	nonce, err := s.backend.PendingNonceAt(context.Background(), s.alice.From)
+	require.NoError(t, err)
+
+	transact := func(ctx context.Context, label string) chan *waitResult {
+		ch := make(chan *waitResult, 1)
+		go func() {
+			opts := NewTransactOpts(ctx, offline.WithLabel(ctx, label, time.Time{}), chainID)
+			receipt, err := m.Transact(ctx, opts, time.Time{}, s.contract.SetReady)
+			ch <- &waitResult{receipt, err}
+		}()
+		return ch
+	}
+
+	requirePendingCount := func(count int) []*UnsignedTx {
+		require.Eventually(t, func() bool {
+			return len(offline.Pending()) == count
+		}, time.Second*5, testPollInterval)
+		return offline.Pending()
+	}
+
+	// the second transaction is waiting to be signed alongside the first, with the next nonce
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := transact(firstCtx, "first")
+	requirePendingCount(1)
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	transact(secondCtx, "second")
+	pending := requirePendingCount(2)
+	require.Equal(t, nonce, pending[0].Tx.Nonce())
+	require.Equal(t, nonce+1, pending[1].Tx.Nonce())
+
+	// the first is never signed, so its nonce is given to the next transaction
+	cancelFirst()
+	res := requireResult(t, first)
+	require.ErrorIs(t, res.err, errSigningCancelled)
+	requirePendingCount(1)
+
+	thirdCtx, cancelThird := context.WithCancel(context.Background())
+	defer cancelThird()
+	transact(thirdCtx, "third")
+	pending = requirePendingCount(2)
+	require.Equal(t, "third", pending[1].Label)
+	require.Equal(t, nonce, pending[1].Tx.Nonce())
+}
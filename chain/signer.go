@@ -0,0 +1,155 @@
+package chain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/external"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	errNoSignerAccounts = errors.New("external signer doesn't have any accounts")
+	errWrongSigner      = errors.New("transaction was signed by the wrong account")
+	errSignedTxChanged  = errors.New("external signer changed the transaction it signed")
+)
+
+// Signer signs our transactions. The swap protocol only ever uses a Signer, so the key itself
+// can be kept out of the daemon entirely, eg. by an external signer such as Clef.
+type Signer interface {
+	// Address returns the address of the account that signs transactions.
+	Address() ethcommon.Address
+	// SignTx signs the transaction for the given chain.
+	SignTx(tx *ethtypes.Transaction, chainID *big.Int) (*ethtypes.Transaction, error)
+}
+
+// NewTransactOpts returns options for sending transactions signed by the given signer.
+func NewTransactOpts(ctx context.Context, signer Signer, chainID *big.Int) *bind.TransactOpts {
+	from := signer.Address()
+	return &bind.TransactOpts{
+		From: from,
+		Signer: func(address ethcommon.Address, tx *ethtypes.Transaction) (*ethtypes.Transaction, error) {
+			if address != from {
+				return nil, bind.ErrNotAuthorized
+			}
+
+			return signer.SignTx(tx, chainID)
+		},
+		Context: ctx,
+	}
+}
+
+// keySigner signs transactions with a private key held in memory.
+type keySigner struct {
+	key     *ecdsa.PrivateKey
+	address ethcommon.Address
+}
+
+// NewKeySigner returns a Signer that signs with the given private key.
+func NewKeySigner(key *ecdsa.PrivateKey) Signer {
+	return &keySigner{
+		key:     key,
+		address: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// NewKeySignerFromHex returns a Signer that signs with the given hex-encoded private key.
+func NewKeySignerFromHex(key string) (Signer, error) {
+	pk, err := crypto.HexToECDSA(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeySigner(pk), nil
+}
+
+// NewKeystoreSigner returns a Signer that signs with the key in a go-ethereum keystore file,
+// which is decrypted with the given password.
+func NewKeystoreSigner(keyJSON []byte, password string) (Signer, error) {
+	key, err := keystore.DecryptKey(keyJSON, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore file: %w", err)
+	}
+
+	return NewKeySigner(key.PrivateKey), nil
+}
+
+func (s *keySigner) Address() ethcommon.Address {
+	return s.address
+}
+
+func (s *keySigner) SignTx(tx *ethtypes.Transaction, chainID *big.Int) (*ethtypes.Transaction, error) {
+	return ethtypes.SignTx(tx, ethtypes.LatestSignerForChainID(chainID), s.key)
+}
+
+// externalSigner signs transactions with an external signer, which keeps the key in its own process
+// and may ask the user to approve every transaction.
+type externalSigner struct {
+	signer  *external.ExternalSigner
+	account accounts.Account
+}
+
+// NewExternalSigner returns a Signer that uses the Clef-compatible JSON-RPC signer at the given endpoint,
+// which may be a HTTP URL or an IPC path. Transactions are signed by the given account, or by the
+// signer's first account if it's the zero address.
+func NewExternalSigner(endpoint string, account ethcommon.Address) (Signer, error) {
+	signer, err := external.NewExternalSigner(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to external signer: %w", err)
+	}
+
+	accs := signer.Accounts()
+	if len(accs) == 0 {
+		return nil, errNoSignerAccounts
+	}
+
+	if account == (ethcommon.Address{}) {
+		account = accs[0].Address
+	}
+
+	acc := accounts.Account{Address: account}
+	if !signer.Contains(acc) {
+		return nil, fmt.Errorf("external signer doesn't have account %s", account)
+	}
+
+	return &externalSigner{
+		signer:  signer,
+		account: acc,
+	}, nil
+}
+
+func (s *externalSigner) Address() ethcommon.Address {
+	return s.account.Address
+}
+
+func (s *externalSigner) SignTx(tx *ethtypes.Transaction, chainID *big.Int) (*ethtypes.Transaction, error) {
+	signed, err := s.signer.SignTx(s.account, tx, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("external signer failed to sign transaction: %w", err)
+	}
+
+	// the signer may have changed the transaction, or signed it with another account
+	txSigner := ethtypes.LatestSignerForChainID(chainID)
+	if txSigner.Hash(signed) != txSigner.Hash(tx) {
+		return nil, errSignedTxChanged
+	}
+
+	sender, err := ethtypes.Sender(txSigner, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	if sender != s.account.Address {
+		return nil, fmt.Errorf("%w: expected=%s actual=%s", errWrongSigner, s.account.Address, sender)
+	}
+
+	return signed, nil
+}
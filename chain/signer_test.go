@@ -0,0 +1,136 @@
+package chain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+// clefStandIn serves the parts of Clef's external API that the external signer uses, signing
+// every transaction without asking for approval.
+type clefStandIn struct {
+	account ethcommon.Address
+	key     *ecdsa.PrivateKey
+}
+
+type signTransactionResult struct {
+	Raw hexutil.Bytes         `json:"raw"`
+	Tx  *ethtypes.Transaction `json:"tx"`
+}
+
+func (c *clefStandIn) Version() string {
+	return "6.1.0"
+}
+
+func (c *clefStandIn) List() []ethcommon.Address {
+	return []ethcommon.Address{c.account}
+}
+
+func (c *clefStandIn) SignTransaction(args apitypes.SendTxArgs, _ *string) (*signTransactionResult, error) {
+	tx, err := ethtypes.SignTx(args.ToTransaction(), ethtypes.LatestSignerForChainID(args.ChainID.ToInt()), c.key)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &signTransactionResult{Raw: raw, Tx: tx}, nil
+}
+
+// newClefStandIn starts a stand-in for Clef that lists the given account, but signs with the given key,
+// and returns its endpoint.
+func newClefStandIn(t *testing.T, account ethcommon.Address, key *ecdsa.PrivateKey) string {
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("account", &clefStandIn{
+		account: account,
+		key:     key,
+	}))
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+
+	return httpServer.URL
+}
+
+func TestExternalSigner(t *testing.T) {
+	s := newTestSwap(t)
+	address := crypto.PubkeyToAddress(s.aliceKey.PublicKey)
+	endpoint := newClefStandIn(t, address, s.aliceKey)
+
+	signer, err := NewExternalSigner(endpoint, ethcommon.Address{})
+	require.NoError(t, err)
+	require.Equal(t, address, signer.Address())
+
+	tx, err := s.contract.SetReady(NewTransactOpts(context.Background(), signer, big.NewInt(1337)))
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	receipt, err := s.backend.TransactionReceipt(context.Background(), tx.Hash())
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.ReceiptStatusSuccessful, receipt.Status)
+
+	_, err = NewExternalSigner(endpoint, ethcommon.Address{1})
+	require.Error(t, err)
+}
+
+func TestExternalSigner_WrongKey(t *testing.T) {
+	s := newTestSwap(t)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	address := crypto.PubkeyToAddress(s.aliceKey.PublicKey)
+	signer, err := NewExternalSigner(newClefStandIn(t, address, other), address)
+	require.NoError(t, err)
+
+	_, err = s.contract.SetReady(NewTransactOpts(context.Background(), signer, big.NewInt(1337)))
+	require.ErrorIs(t, err, errWrongSigner)
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	keyJSON, err := keystore.EncryptKey(&keystore.Key{
+		Address:    address,
+		PrivateKey: key,
+	}, "password", keystore.LightScryptN, keystore.LightScryptP)
+	require.NoError(t, err)
+
+	_, err = NewKeystoreSigner(keyJSON, "wrong password")
+	require.ErrorIs(t, err, keystore.ErrDecrypt)
+
+	signer, err := NewKeystoreSigner(keyJSON, "password")
+	require.NoError(t, err)
+	require.Equal(t, address, signer.Address())
+
+	chainID := big.NewInt(1337)
+	tx, err := signer.SignTx(ethtypes.NewTx(&ethtypes.DynamicFeeTx{
+		ChainID:   chainID,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(2),
+		Gas:       21000,
+	}), chainID)
+	require.NoError(t, err)
+
+	sender, err := ethtypes.Sender(ethtypes.LatestSignerForChainID(chainID), tx)
+	require.NoError(t, err)
+	require.Equal(t, address, sender)
+}
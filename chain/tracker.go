@@ -0,0 +1,302 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// DefaultConfirmations is the number of blocks, including its own, that a transaction must be
+	// included under before it's considered final, if no other value is configured.
+	DefaultConfirmations = 1
+
+	// DefaultTxTimeout is how long we wait for a transaction to be confirmed.
+	DefaultTxTimeout = time.Hour
+
+	defaultTxPollInterval = time.Second * 10
+)
+
+var (
+	// ErrTxReverted is returned when a transaction was confirmed, but failed.
+	ErrTxReverted = errors.New("transaction reverted")
+	// ErrTxReplaced is returned when a transaction was dropped, and another transaction with
+	// the same nonce was included instead.
+	ErrTxReplaced = errors.New("transaction was replaced by another with the same nonce")
+	// ErrTxTimeout is returned when a transaction isn't confirmed in time.
+	ErrTxTimeout = errors.New("timed out waiting for transaction to be confirmed")
+)
+
+// TxBackend is the subset of *ethclient.Client that the tracker uses.
+type TxBackend interface {
+	TransactionReceipt(ctx context.Context, txHash ethcommon.Hash) (*ethtypes.Receipt, error)
+	TransactionByHash(ctx context.Context, txHash ethcommon.Hash) (*ethtypes.Transaction, bool, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error)
+	SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error
+}
+
+// TrackerConfig configures a TxTracker.
+type TrackerConfig struct {
+	Confirmations uint64        // defaults to DefaultConfirmations
+	PollInterval  time.Duration // defaults to 10s
+	Timeout       time.Duration // defaults to DefaultTxTimeout
+}
+
+// TxTracker waits for transactions to be confirmed. A transaction is only confirmed once its
+// block is buried under enough others and is still part of the canonical chain, so that a reorg
+// is unlikely to undo it. If a reorg drops one of our transactions, it's resubmitted.
+type TxTracker struct {
+	backend       TxBackend
+	confirmations uint64
+	pollInterval  time.Duration
+	timeout       time.Duration
+}
+
+// NewTxTracker returns a new *TxTracker.
+func NewTxTracker(backend TxBackend, cfg *TrackerConfig) *TxTracker {
+	if cfg == nil {
+		cfg = &TrackerConfig{}
+	}
+
+	t := &TxTracker{
+		backend:       backend,
+		confirmations: cfg.Confirmations,
+		pollInterval:  cfg.PollInterval,
+		timeout:       cfg.Timeout,
+	}
+
+	if t.confirmations == 0 {
+		t.confirmations = DefaultConfirmations
+	}
+
+	if t.pollInterval == 0 {
+		t.pollInterval = defaultTxPollInterval
+	}
+
+	if t.timeout == 0 {
+		t.timeout = DefaultTxTimeout
+	}
+
+	return t
+}
+
+// Confirmations returns the number of confirmations the tracker waits for.
+func (t *TxTracker) Confirmations() uint64 {
+	return t.confirmations
+}
+
+// Wait waits for our signed transaction to be confirmed, resubmitting it if it's dropped.
+// It returns ErrTxReverted if the transaction failed, and ErrTxReplaced if its nonce was used
+// by another transaction.
+func (t *TxTracker) Wait(ctx context.Context, tx *ethtypes.Transaction) (*ethtypes.Receipt, error) {
+	return t.wait(ctx, &trackedTx{txs: []*ethtypes.Transaction{tx}})
+}
+
+// WaitHash waits for the transaction with the given hash to be confirmed. It's used for transactions
+// sent by someone else, which can't be resubmitted if they're dropped.
+func (t *TxTracker) WaitHash(ctx context.Context, txHash ethcommon.Hash) (*ethtypes.Receipt, error) {
+	return t.wait(ctx, &trackedTx{hash: txHash})
+}
+
+// replacer replaces a transaction that's stuck in the mempool with one that uses the same nonce.
+type replacer interface {
+	due() bool
+	replace(ctx context.Context) (*ethtypes.Transaction, error)
+}
+
+type trackedTx struct {
+	// the hash of a transaction that isn't ours, or our transactions, which all use the same
+	// nonce; each replaces the one before it
+	hash ethcommon.Hash
+	txs  []*ethtypes.Transaction
+
+	// replaces our latest transaction if it isn't included in time; optional
+	replacer replacer
+
+	// the last receipt seen, used to notice reorgs
+	receipt *ethtypes.Receipt
+}
+
+func (tx *trackedTx) String() string {
+	if tx.receipt != nil {
+		return tx.receipt.TxHash.String()
+	}
+
+	if len(tx.txs) != 0 {
+		return tx.txs[len(tx.txs)-1].Hash().String()
+	}
+
+	return tx.hash.String()
+}
+
+// hashes returns the hashes of the transactions that may be included, the latest first.
+func (tx *trackedTx) hashes() []ethcommon.Hash {
+	if len(tx.txs) == 0 {
+		return []ethcommon.Hash{tx.hash}
+	}
+
+	hashes := make([]ethcommon.Hash, len(tx.txs))
+	for i, tx := range tx.txs {
+		hashes[len(hashes)-1-i] = tx.Hash()
+	}
+
+	return hashes
+}
+
+func (t *TxTracker) wait(ctx context.Context, tx *trackedTx) (*ethtypes.Receipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	for {
+		receipt, err := t.check(ctx, tx)
+		if err != nil || receipt != nil {
+			return receipt, err
+		}
+
+		// once the transaction is included, there's no point in replacing it
+		if tx.receipt == nil && tx.replacer != nil && tx.replacer.due() {
+			replacement, err := tx.replacer.replace(ctx)
+			if err != nil {
+				log.Warnf("failed to replace pending transaction: tx=%s err=%s", tx, err)
+			} else {
+				log.Infof("replaced pending transaction with one paying higher fees: tx=%s replaced=%s", replacement.Hash(), tx)
+				tx.txs = append(tx.txs, replacement)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: tx=%s", ErrTxTimeout, tx)
+			}
+
+			return nil, ctx.Err()
+		case <-time.After(t.pollInterval):
+		}
+	}
+}
+
+// findReceipt returns the receipt of whichever of the transactions was included, or nil if none were.
+func (t *TxTracker) findReceipt(ctx context.Context, tx *trackedTx) (*ethtypes.Receipt, error) {
+	for _, hash := range tx.hashes() {
+		receipt, err := t.backend.TransactionReceipt(ctx, hash)
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+
+		if err != nil || receipt != nil {
+			return receipt, err
+		}
+	}
+
+	return nil, nil
+}
+
+// check returns the transaction's receipt once it's confirmed, or nil if it isn't yet.
+// Errors from the node are logged and retried, as they're usually temporary.
+func (t *TxTracker) check(ctx context.Context, tx *trackedTx) (*ethtypes.Receipt, error) {
+	receipt, err := t.findReceipt(ctx, tx)
+	if err != nil {
+		log.Debugf("failed to get transaction receipt: tx=%s err=%s", tx, err)
+		return nil, nil
+	}
+
+	if receipt == nil {
+		if tx.receipt != nil {
+			log.Warnf("transaction was removed from the chain by a reorg: tx=%s block=%d", tx, tx.receipt.BlockNumber)
+			tx.receipt = nil
+		}
+
+		log.Infof("waiting for transaction to be included in chain: tx=%s", tx)
+		return nil, t.ensurePending(ctx, tx)
+	}
+
+	if tx.receipt != nil && tx.receipt.BlockHash != receipt.BlockHash {
+		log.Warnf("transaction was moved to block %d by a reorg: tx=%s", receipt.BlockNumber, receipt.TxHash)
+	}
+	tx.receipt = receipt
+
+	head, err := t.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		log.Debugf("failed to get current block: err=%s", err)
+		return nil, nil
+	}
+
+	if head.Number.Cmp(receipt.BlockNumber) < 0 {
+		// the node's view of the chain is lagging behind its receipt
+		return nil, nil
+	}
+
+	confirmations := new(big.Int).Sub(head.Number, receipt.BlockNumber).Uint64() + 1
+	if confirmations < t.confirmations {
+		log.Debugf("waiting for transaction to be confirmed: tx=%s confirmations=%d/%d",
+			receipt.TxHash, confirmations, t.confirmations)
+		return nil, nil
+	}
+
+	// make sure the receipt's block is still part of the canonical chain
+	header, err := t.backend.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		log.Debugf("failed to get block %d: err=%s", receipt.BlockNumber, err)
+		return nil, nil
+	}
+
+	if header.Hash() != receipt.BlockHash {
+		log.Warnf("transaction's block was replaced by a reorg: tx=%s block=%d", receipt.TxHash, receipt.BlockNumber)
+		return nil, nil
+	}
+
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		return receipt, fmt.Errorf("%w: tx=%s block=%d", ErrTxReverted, receipt.TxHash, receipt.BlockNumber)
+	}
+
+	log.Debugf("transaction confirmed: tx=%s block hash=%s block number=%d gas used=%d",
+		receipt.TxHash,
+		receipt.BlockHash,
+		receipt.BlockNumber,
+		receipt.GasUsed,
+	)
+	return receipt, nil
+}
+
+// ensurePending resubmits our latest transaction if the node no longer knows about any of them,
+// eg. because a reorg dropped them, or they were evicted from the mempool.
+func (t *TxTracker) ensurePending(ctx context.Context, tx *trackedTx) error {
+	if len(tx.txs) == 0 {
+		return nil
+	}
+
+	for _, hash := range tx.hashes() {
+		if _, _, err := t.backend.TransactionByHash(ctx, hash); !errors.Is(err, ethereum.NotFound) {
+			return nil
+		}
+	}
+
+	latest := tx.txs[len(tx.txs)-1]
+	log.Warnf("transaction isn't known to the node, resubmitting: tx=%s", latest.Hash())
+	err := t.backend.SendTransaction(ctx, latest)
+	if err == nil || strings.Contains(err.Error(), "already known") {
+		return nil
+	}
+
+	if !strings.Contains(err.Error(), "nonce too low") {
+		log.Warnf("failed to resubmit transaction: tx=%s err=%s", latest.Hash(), err)
+		return nil
+	}
+
+	// the nonce has been used, but it may have been by one of our transactions, if it was
+	// included after we last checked
+	if receipt, _ := t.findReceipt(ctx, tx); receipt != nil {
+		return nil
+	}
+
+	log.Errorf("transaction can't be resubmitted, as its nonce was used by another transaction: tx=%s", latest.Hash())
+	return fmt.Errorf("%w: tx=%s", ErrTxReplaced, latest.Hash())
+}
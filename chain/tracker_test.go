@@ -0,0 +1,163 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+type waitResult struct {
+	receipt *ethtypes.Receipt
+	err     error
+}
+
+func waitAsync(t *testing.T, tracker *TxTracker, tx *ethtypes.Transaction) <-chan *waitResult {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	ch := make(chan *waitResult, 1)
+	go func() {
+		receipt, err := tracker.Wait(ctx, tx)
+		ch <- &waitResult{receipt, err}
+	}()
+
+	return ch
+}
+
+func requireWaiting(t *testing.T, ch <-chan *waitResult) {
+	select {
+	case res := <-ch:
+		t.Fatalf("stopped waiting too early: err=%v", res.err)
+	case <-time.After(testPollInterval * 4):
+	}
+}
+
+func requireResult(t *testing.T, ch <-chan *waitResult) *waitResult {
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for transaction")
+		return nil
+	}
+}
+
+func TestTxTracker_Confirmations(t *testing.T) {
+	s := newTestSwap(t)
+	tracker := NewTxTracker(s.backend, &TrackerConfig{
+		Confirmations: 3,
+		PollInterval:  testPollInterval,
+	})
+
+	tx, err := s.contract.SetReady(s.alice)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	ch := waitAsync(t, tracker, tx)
+	requireWaiting(t, ch)
+
+	s.backend.Commit()
+	requireWaiting(t, ch)
+
+	s.backend.Commit()
+	res := requireResult(t, ch)
+	require.NoError(t, res.err)
+	require.Equal(t, tx.Hash(), res.receipt.TxHash)
+}
+
+func TestTxTracker_Reverted(t *testing.T) {
+	s := newTestSwap(t)
+	tracker := NewTxTracker(s.backend, &TrackerConfig{
+		PollInterval: testPollInterval,
+	})
+
+	// claiming before the contract is ready fails; setting the gas limit skips estimation,
+	// which would catch the failure before the transaction is sent
+	opts := *s.bob
+	opts.GasLimit = 1000000
+	tx, err := s.contract.Claim(&opts, s.secretB)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	res := requireResult(t, waitAsync(t, tracker, tx))
+	require.ErrorIs(t, res.err, ErrTxReverted)
+	require.Equal(t, ethtypes.ReceiptStatusFailed, res.receipt.Status)
+}
+
+func TestTxTracker_Reorg(t *testing.T) {
+	s := newTestSwap(t)
+	tracker := NewTxTracker(s.backend, &TrackerConfig{
+		PollInterval: testPollInterval,
+	})
+
+	ctx := context.Background()
+	parent, err := s.backend.HeaderByNumber(ctx, nil)
+	require.NoError(t, err)
+
+	tx, err := s.contract.SetReady(s.alice)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	// replace the transaction's block with a longer chain that doesn't include it
+	require.NoError(t, s.backend.Fork(ctx, parent.Hash()))
+	s.backend.Commit()
+	s.backend.Commit()
+
+	receipt, err := s.backend.TransactionReceipt(ctx, tx.Hash())
+	require.NoError(t, err)
+	require.Nil(t, receipt)
+
+	// the tracker should resubmit the transaction
+	ch := waitAsync(t, tracker, tx)
+	require.Eventually(t, func() bool {
+		_, pending, err := s.backend.TransactionByHash(ctx, tx.Hash())
+		return err == nil && pending
+	}, time.Second*5, testPollInterval)
+
+	s.backend.Commit()
+	res := requireResult(t, ch)
+	require.NoError(t, res.err)
+	require.Equal(t, new(big.Int).Add(parent.Number, big.NewInt(3)), res.receipt.BlockNumber)
+}
+
+// replacedBackend rejects resubmitted transactions, as a node does once their nonce has been used.
+type replacedBackend struct {
+	TxBackend
+}
+
+func (replacedBackend) SendTransaction(context.Context, *ethtypes.Transaction) error {
+	return errors.New("nonce too low")
+}
+
+func TestTxTracker_Replaced(t *testing.T) {
+	s := newTestSwap(t)
+	tracker := NewTxTracker(replacedBackend{s.backend}, &TrackerConfig{
+		PollInterval: testPollInterval,
+	})
+
+	// the transaction is signed, but never reaches the node
+	opts := *s.alice
+	opts.NoSend = true
+	tx, err := s.contract.SetReady(&opts)
+	require.NoError(t, err)
+
+	res := requireResult(t, waitAsync(t, tracker, tx))
+	require.ErrorIs(t, res.err, ErrTxReplaced)
+}
+
+func TestTxTracker_Timeout(t *testing.T) {
+	s := newTestSwap(t)
+	tracker := NewTxTracker(s.backend, &TrackerConfig{
+		PollInterval: testPollInterval,
+		Timeout:      testPollInterval * 4,
+	})
+
+	_, err := tracker.WaitHash(context.Background(), ethcommon.Hash{1})
+	require.ErrorIs(t, err, ErrTxTimeout)
+}
@@ -0,0 +1,410 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	logging "github.com/ipfs/go-log"
+
+	"github.com/noot/atomic-swap/swap-contract"
+)
+
+// DefaultPollInterval is how often the watcher asks the node for new logs. Logs pushed over a
+// subscription are handled straight away; polling catches anything the subscription missed.
+const DefaultPollInterval = time.Second * 12
+
+var log = logging.Logger("chain")
+
+// EventType is the kind of swap contract event.
+type EventType byte
+
+const (
+	// Ready means Alice called set_ready(), so Bob can claim.
+	Ready EventType = iota
+	// Claimed means Bob called claim(), revealing his secret.
+	Claimed
+	// Refunded means Alice called refund(), revealing her secret.
+	Refunded
+)
+
+var eventNames = map[EventType]string{
+	Ready:    "IsReady",
+	Claimed:  "Claimed",
+	Refunded: "Refunded",
+}
+
+func (t EventType) String() string {
+	if name, has := eventNames[t]; has {
+		return name
+	}
+
+	return fmt.Sprintf("EventType(%d)", byte(t))
+}
+
+// Event is an event emitted by a swap contract.
+type Event struct {
+	Type EventType
+	// Secret is the secret revealed by claim() or refund(), as passed to the contract. It's empty
+	// for Ready events.
+	Secret      [32]byte
+	TxHash      ethcommon.Hash
+	BlockNumber uint64
+}
+
+// Backend is the subset of *ethclient.Client that the watcher uses. Subscriptions are only
+// supported by WebSocket and IPC clients; over HTTP, the watcher falls back to polling.
+type Backend interface {
+	ethereum.LogFilterer
+	HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error)
+}
+
+// WatcherConfig configures a Watcher.
+type WatcherConfig struct {
+	// FromBlock is the first block that's searched for events. If it's nil, events are only
+	// searched for from the current block on.
+	FromBlock     *big.Int
+	PollInterval  time.Duration // defaults to DefaultPollInterval
+	Confirmations uint64        // defaults to DefaultConfirmations
+}
+
+// Watcher watches a swap contract for its IsReady, Claimed and Refunded events. Each event is
+// sent on the channel returned by Events once, after its block has the configured number of
+// confirmations; events whose block is reorged out before then are dropped, and searched for
+// again in the new chain. As either claim() or refund() ends the swap, the watcher stops and
+// closes the channel after either is seen.
+type Watcher struct {
+	ctx           context.Context
+	backend       Backend
+	contract      ethcommon.Address
+	filterer      *swap.SwapFilterer
+	pollInterval  time.Duration
+	confirmations uint64
+
+	// topics of the events we watch for, and the next block to poll
+	topics map[ethcommon.Hash]EventType
+	next   *big.Int
+
+	// logs waiting for confirmations, and logs already sent, so that a log seen by both the
+	// subscription and a poll is sent once
+	pending map[logID]ethtypes.Log
+	seen    map[logID]struct{}
+	out     chan *Event
+}
+
+type logID struct {
+	txHash ethcommon.Hash
+	index  uint
+}
+
+// NewWatcher starts watching the swap contract at the given address, until the context is cancelled.
+func NewWatcher(ctx context.Context, backend Backend, contract ethcommon.Address, cfg *WatcherConfig) (*Watcher, error) {
+	if cfg == nil {
+		cfg = &WatcherConfig{}
+	}
+
+	pollInterval := cfg.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	confirmations := cfg.Confirmations
+	if confirmations == 0 {
+		confirmations = DefaultConfirmations
+	}
+
+	swapABI, err := abi.JSON(strings.NewReader(swap.SwapABI))
+	if err != nil {
+		return nil, err
+	}
+
+	filterer, err := swap.NewSwapFilterer(contract, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	next := cfg.FromBlock
+	if next == nil {
+		head, err := backend.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current block: %w", err)
+		}
+
+		next = head.Number
+	}
+
+	w := &Watcher{
+		ctx:           ctx,
+		backend:       backend,
+		contract:      contract,
+		filterer:      filterer,
+		pollInterval:  pollInterval,
+		confirmations: confirmations,
+		next:          new(big.Int).Set(next),
+		topics:        make(map[ethcommon.Hash]EventType),
+		pending:       make(map[logID]ethtypes.Log),
+		seen:          make(map[logID]struct{}),
+		out:           make(chan *Event, len(eventNames)),
+	}
+
+	for typ, name := range eventNames {
+		w.topics[swapABI.Events[name].ID] = typ
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Events returns the channel that the contract's events are sent on.
+func (w *Watcher) Events() <-chan *Event {
+	return w.out
+}
+
+func (w *Watcher) query() ethereum.FilterQuery {
+	topics := make([]ethcommon.Hash, 0, len(w.topics))
+	for topic := range w.topics {
+		topics = append(topics, topic)
+	}
+
+	return ethereum.FilterQuery{
+		Addresses: []ethcommon.Address{w.contract},
+		Topics:    [][]ethcommon.Hash{topics},
+	}
+}
+
+func (w *Watcher) run() {
+	defer close(w.out)
+
+	logs := make(chan ethtypes.Log)
+	sub := w.subscribe(logs)
+
+	// if the backend supports subscriptions, we resubscribe after a subscription fails
+	resubscribe := sub != nil
+	defer func() {
+		if sub != nil {
+			sub.Unsubscribe()
+		}
+	}()
+
+	// catch up with the logs emitted before we subscribed
+	if w.poll() {
+		return
+	}
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		var subErr <-chan error
+		if sub != nil {
+			subErr = sub.Err()
+		}
+
+		select {
+		case l := <-logs:
+			w.handle(l)
+			if w.deliver() {
+				return
+			}
+		case err := <-subErr:
+			log.Warnf("log subscription failed, polling instead: contract=%s err=%s", w.contract, err)
+			sub.Unsubscribe()
+			sub = nil
+		case <-ticker.C:
+			if sub == nil && resubscribe {
+				sub = w.subscribe(logs)
+			}
+
+			if w.poll() {
+				return
+			}
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// subscribe returns a subscription to the contract's logs, or nil if the backend doesn't support them.
+func (w *Watcher) subscribe(logs chan ethtypes.Log) ethereum.Subscription {
+	sub, err := w.backend.SubscribeFilterLogs(w.ctx, w.query(), logs)
+	if err != nil {
+		log.Debugf("failed to subscribe to logs, polling instead: contract=%s err=%s", w.contract, err)
+		return nil
+	}
+
+	return sub
+}
+
+// poll handles the logs emitted since the last poll, and sends the events that are now confirmed.
+// It returns true once the swap has ended.
+func (w *Watcher) poll() bool {
+	head, err := w.backend.HeaderByNumber(w.ctx, nil)
+	if err != nil {
+		log.Warnf("failed to get current block: err=%s", err)
+		return false
+	}
+
+	if head.Number.Cmp(w.next) >= 0 {
+		q := w.query()
+		q.FromBlock, q.ToBlock = w.next, head.Number
+		logs, err := w.backend.FilterLogs(w.ctx, q)
+		if err != nil {
+			log.Warnf("failed to get logs: contract=%s err=%s", w.contract, err)
+			return false
+		}
+
+		w.next = new(big.Int).Add(head.Number, big.NewInt(1))
+		for _, l := range logs {
+			w.handle(l)
+		}
+	}
+
+	return w.deliver()
+}
+
+// handle adds the log to the ones waiting for confirmations, unless its event was already sent.
+// A removed log, which the subscription sends when the log's block is reorged out, is dropped.
+func (w *Watcher) handle(l ethtypes.Log) {
+	if len(l.Topics) == 0 {
+		return
+	}
+
+	id := logID{txHash: l.TxHash, index: l.Index}
+	if _, has := w.seen[id]; has {
+		if l.Removed {
+			log.Warnf("the block of a contract event that was already handled was reorged out: contract=%s tx=%s block=%d",
+				w.contract, l.TxHash, l.BlockNumber)
+		}
+
+		return
+	}
+
+	if !l.Removed {
+		w.pending[id] = l
+		return
+	}
+
+	// the log may already have been found again in the new chain
+	if pending, has := w.pending[id]; has && pending.BlockHash == l.BlockHash {
+		delete(w.pending, id)
+	}
+}
+
+// deliver sends the events of the pending logs whose block has enough confirmations, in the order
+// they were emitted. A log whose block is no longer in the chain is dropped, and the chain is
+// searched again from that block on. It returns true once the swap has ended.
+func (w *Watcher) deliver() bool {
+	if len(w.pending) == 0 {
+		return false
+	}
+
+	head, err := w.backend.HeaderByNumber(w.ctx, nil)
+	if err != nil {
+		log.Warnf("failed to get current block: err=%s", err)
+		return false
+	}
+
+	logs := make([]ethtypes.Log, 0, len(w.pending))
+	for _, l := range w.pending {
+		logs = append(logs, l)
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+
+		return logs[i].Index < logs[j].Index
+	})
+
+	for _, l := range logs {
+		// the logs are in block order, so none of the rest are confirmed either
+		if head.Number.Uint64()+1 < l.BlockNumber+w.confirmations {
+			return false
+		}
+
+		header, err := w.backend.HeaderByNumber(w.ctx, new(big.Int).SetUint64(l.BlockNumber))
+		if err != nil {
+			log.Warnf("failed to get block: number=%d err=%s", l.BlockNumber, err)
+			return false
+		}
+
+		delete(w.pending, logID{txHash: l.TxHash, index: l.Index})
+		if header.Hash() != l.BlockHash {
+			log.Debugf("block of contract event was reorged out: contract=%s tx=%s block=%d",
+				w.contract, l.TxHash, l.BlockNumber)
+			if w.next.Uint64() > l.BlockNumber {
+				w.next = new(big.Int).SetUint64(l.BlockNumber)
+			}
+
+			continue
+		}
+
+		if w.send(l) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send sends the event in the log. It returns true if the log ends the swap.
+func (w *Watcher) send(l ethtypes.Log) bool {
+	id := logID{txHash: l.TxHash, index: l.Index}
+	ev, err := w.parse(l)
+	if err != nil {
+		log.Warnf("failed to parse log: contract=%s tx=%s err=%s", w.contract, l.TxHash, err)
+		return false
+	}
+
+	w.seen[id] = struct{}{}
+	log.Debugf("got contract event: contract=%s event=%s tx=%s", w.contract, ev.Type, ev.TxHash)
+
+	select {
+	case w.out <- ev:
+	case <-w.ctx.Done():
+		return true
+	}
+
+	return ev.Type != Ready
+}
+
+func (w *Watcher) parse(l ethtypes.Log) (*Event, error) {
+	typ, has := w.topics[l.Topics[0]]
+	if !has {
+		return nil, errors.New("log isn't a swap contract event")
+	}
+
+	ev := &Event{
+		Type:        typ,
+		TxHash:      l.TxHash,
+		BlockNumber: l.BlockNumber,
+	}
+
+	switch typ {
+	case Claimed:
+		claimed, err := w.filterer.ParseClaimed(l)
+		if err != nil {
+			return nil, err
+		}
+
+		ev.Secret = claimed.S
+	case Refunded:
+		refunded, err := w.filterer.ParseRefunded(l)
+		if err != nil {
+			return nil, err
+		}
+
+		ev.Secret = refunded.S
+	}
+
+	return ev, nil
+}
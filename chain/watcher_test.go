@@ -0,0 +1,301 @@
+package chain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/monero"
+	"github.com/noot/atomic-swap/swap-contract"
+)
+
+const testPollInterval = time.Millisecond * 50
+
+type testSwap struct {
+	backend          *backends.SimulatedBackend
+	address          ethcommon.Address
+	contract         *swap.Swap
+	alice, bob       *bind.TransactOpts
+	aliceKey         *ecdsa.PrivateKey
+	secretA, secretB [32]byte
+}
+
+func newTestSwap(t *testing.T) *testSwap {
+	pkA, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	pkB, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	chainID := big.NewInt(1337)
+	alice, err := bind.NewKeyedTransactorWithChainID(pkA, chainID)
+	require.NoError(t, err)
+	bob, err := bind.NewKeyedTransactorWithChainID(pkB, chainID)
+	require.NoError(t, err)
+
+	funds := new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		alice.From: {Balance: funds},
+		bob.From:   {Balance: funds},
+	}, 30000000)
+	t.Cleanup(func() {
+		_ = backend.Close()
+	})
+
+	kpA, err := monero.GenerateKeys()
+	require.NoError(t, err)
+	kpB, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	var pkAlice, pkBob [32]byte
+	copy(pkAlice[:], common.Reverse(kpA.PublicKeyPair().SpendKey().Bytes()))
+	copy(pkBob[:], common.Reverse(kpB.PublicKeyPair().SpendKey().Bytes()))
+
+	alice.Value = big.NewInt(1000000000000)
+	address, _, contract, err := swap.DeploySwap(alice, backend, pkBob, pkAlice, bob.From, big.NewInt(3600))
+	require.NoError(t, err)
+	alice.Value = nil
+	backend.Commit()
+
+	s := &testSwap{
+		backend:  backend,
+		address:  address,
+		contract: contract,
+		alice:    alice,
+		bob:      bob,
+		aliceKey: pkA,
+	}
+
+	copy(s.secretA[:], common.Reverse(kpA.SpendKeyBytes()))
+	copy(s.secretB[:], common.Reverse(kpB.SpendKeyBytes()))
+	return s
+}
+
+func (s *testSwap) newWatcher(t *testing.T, backend Backend) *Watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	w, err := NewWatcher(ctx, backend, s.address, &WatcherConfig{
+		FromBlock:    big.NewInt(0),
+		PollInterval: testPollInterval,
+	})
+	require.NoError(t, err)
+	return w
+}
+
+func nextEvent(t *testing.T, w *Watcher) *Event {
+	select {
+	case ev, ok := <-w.Events():
+		require.True(t, ok, "watcher stopped")
+		return ev
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func requireStopped(t *testing.T, w *Watcher) {
+	select {
+	case _, ok := <-w.Events():
+		require.False(t, ok)
+	case <-time.After(time.Second * 5):
+		t.Fatal("watcher didn't stop")
+	}
+}
+
+// pollingBackend doesn't support subscriptions, like a node connected to over HTTP.
+type pollingBackend struct {
+	*backends.SimulatedBackend
+}
+
+func (pollingBackend) SubscribeFilterLogs(context.Context, ethereum.FilterQuery, chan<- ethtypes.Log) (ethereum.Subscription, error) {
+	return nil, errors.New("notifications not supported")
+}
+
+func testWatcherClaim(t *testing.T, polling bool) {
+	s := newTestSwap(t)
+
+	var backend Backend = s.backend
+	if polling {
+		backend = pollingBackend{s.backend}
+	}
+
+	w := s.newWatcher(t, backend)
+
+	tx, err := s.contract.SetReady(s.alice)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	ev := nextEvent(t, w)
+	require.Equal(t, Ready, ev.Type)
+	require.Equal(t, tx.Hash(), ev.TxHash)
+
+	tx, err = s.contract.Claim(s.bob, s.secretB)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	ev = nextEvent(t, w)
+	require.Equal(t, Claimed, ev.Type)
+	require.Equal(t, tx.Hash(), ev.TxHash)
+	require.Equal(t, s.secretB, ev.Secret)
+
+	// the swap is over once it's claimed
+	requireStopped(t, w)
+}
+
+func TestWatcher_Claim(t *testing.T) {
+	testWatcherClaim(t, false)
+}
+
+func TestWatcher_Claim_Polling(t *testing.T) {
+	testWatcherClaim(t, true)
+}
+
+func TestWatcher_Refund(t *testing.T) {
+	s := newTestSwap(t)
+
+	// the refund happens before the watcher starts, so it's found when catching up
+	tx, err := s.contract.Refund(s.alice, s.secretA)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	w := s.newWatcher(t, s.backend)
+	ev := nextEvent(t, w)
+	require.Equal(t, Refunded, ev.Type)
+	require.Equal(t, tx.Hash(), ev.TxHash)
+	require.Equal(t, s.secretA, ev.Secret)
+	requireStopped(t, w)
+}
+
+func TestWatcher_FromCurrentBlock(t *testing.T) {
+	s := newTestSwap(t)
+
+	_, err := s.contract.SetReady(s.alice)
+	require.NoError(t, err)
+	s.backend.Commit()
+	s.backend.Commit()
+
+	// events before the current block are ignored
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWatcher(ctx, pollingBackend{s.backend}, s.address, &WatcherConfig{
+		PollInterval: testPollInterval,
+	})
+	require.NoError(t, err)
+
+	tx, err := s.contract.Claim(s.bob, s.secretB)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	ev := nextEvent(t, w)
+	require.Equal(t, Claimed, ev.Type)
+	require.Equal(t, tx.Hash(), ev.TxHash)
+}
+
+func testWatcherConfirmations(t *testing.T, polling bool) {
+	s := newTestSwap(t)
+
+	var backend Backend = s.backend
+	if polling {
+		backend = pollingBackend{s.backend}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWatcher(ctx, backend, s.address, &WatcherConfig{
+		FromBlock:     big.NewInt(0),
+		PollInterval:  testPollInterval,
+		Confirmations: 3,
+	})
+	require.NoError(t, err)
+
+	tx, err := s.contract.SetReady(s.alice)
+	require.NoError(t, err)
+	s.backend.Commit()
+	s.backend.Commit()
+
+	// the event's block only has 2 of the 3 confirmations
+	select {
+	case ev := <-w.Events():
+		t.Fatalf("got unconfirmed event: %v", ev)
+	case <-time.After(testPollInterval * 4):
+	}
+
+	s.backend.Commit()
+	ev := nextEvent(t, w)
+	require.Equal(t, Ready, ev.Type)
+	require.Equal(t, tx.Hash(), ev.TxHash)
+}
+
+func TestWatcher_Confirmations(t *testing.T) {
+	testWatcherConfirmations(t, false)
+}
+
+func TestWatcher_Confirmations_Polling(t *testing.T) {
+	testWatcherConfirmations(t, true)
+}
+
+func testWatcherReorg(t *testing.T, polling bool) {
+	s := newTestSwap(t)
+
+	var backend Backend = s.backend
+	if polling {
+		backend = pollingBackend{s.backend}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWatcher(ctx, backend, s.address, &WatcherConfig{
+		FromBlock:     big.NewInt(0),
+		PollInterval:  testPollInterval,
+		Confirmations: 2,
+	})
+	require.NoError(t, err)
+
+	parent, err := s.backend.HeaderByNumber(ctx, nil)
+	require.NoError(t, err)
+
+	_, err = s.contract.SetReady(s.alice)
+	require.NoError(t, err)
+	s.backend.Commit()
+
+	// let the watcher see the unconfirmed event before its block is replaced
+	time.Sleep(testPollInterval * 4)
+
+	// replace the block with a longer chain in which Alice refunds instead, so that the
+	// Ready event is never sent
+	require.NoError(t, s.backend.Fork(ctx, parent.Hash()))
+	tx, err := s.contract.Refund(s.alice, s.secretA)
+	require.NoError(t, err)
+	s.backend.Commit()
+	s.backend.Commit()
+
+	ev := nextEvent(t, w)
+	require.Equal(t, Refunded, ev.Type)
+	require.Equal(t, tx.Hash(), ev.TxHash)
+	require.Equal(t, s.secretA, ev.Secret)
+	requireStopped(t, w)
+}
+
+func TestWatcher_Reorg(t *testing.T) {
+	testWatcherReorg(t, false)
+}
+
+func TestWatcher_Reorg_Polling(t *testing.T) {
+	testWatcherReorg(t, true)
+}
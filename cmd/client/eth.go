@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/noot/atomic-swap/rpc"
+)
+
+func (c *Client) getFeeSettings() (*rpc.GetFeeSettingsResponse, error) {
+	const (
+		method = "eth_getFeeSettings"
+	)
+
+	var res *rpc.GetFeeSettingsResponse
+	if err := c.post(method, &rpc.GetFeeSettingsRequest{}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) getUnsignedTxs() (*rpc.GetUnsignedTxsResponse, error) {
+	const (
+		method = "eth_getUnsignedTxs"
+	)
+
+	var res *rpc.GetUnsignedTxsResponse
+	if err := c.post(method, &rpc.GetUnsignedTxsRequest{}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) importSignedTx(tx string) (*rpc.ImportSignedTxResponse, error) {
+	const (
+		method = "eth_importSignedTx"
+	)
+
+	var res *rpc.ImportSignedTxResponse
+	if err := c.post(method, &rpc.ImportSignedTxRequest{Tx: tx}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
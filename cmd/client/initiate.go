@@ -1,43 +1,18 @@
 package main
 
 import (
-	"encoding/json"
-
-	"github.com/noot/atomic-swap/common"
 	"github.com/noot/atomic-swap/rpc"
-	"github.com/noot/atomic-swap/rpcclient"
 )
 
-func (c *Client) initiate(maddr string, provides common.ProvidesCoin, providesAmount, desiredAmount float64) (bool, error) {
+func (c *Client) initiate(req *rpc.InitiateRequest) (*rpc.InitiateResponse, error) {
 	const (
 		method = "net_initiate"
 	)
 
-	req := &rpc.InitiateRequest{
-		Multiaddr:      maddr,
-		ProvidesCoin:   provides,
-		ProvidesAmount: providesAmount,
-		DesiredAmount:  desiredAmount,
-	}
-
-	params, err := json.Marshal(req)
-	if err != nil {
-		return false, err
-	}
-
-	resp, err := rpcclient.PostRPC(c.endpoint, method, string(params))
-	if err != nil {
-		return false, err
-	}
-
-	if resp.Error != nil {
-		return false, resp.Error
-	}
-
 	var res *rpc.InitiateResponse
-	if err = json.Unmarshal(resp.Result, &res); err != nil {
-		return false, err
+	if err := c.post(method, req, &res); err != nil {
+		return nil, err
 	}
 
-	return res.Success, nil
+	return res, nil
 }
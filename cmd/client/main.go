@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/net"
+	"github.com/noot/atomic-swap/rpc"
 
 	logging "github.com/ipfs/go-log"
 	"github.com/urfave/cli"
@@ -18,6 +21,16 @@ const (
 var log = logging.Logger("cmd")
 
 var (
+	swapIDFlag = &cli.StringFlag{
+		Name:  "id",
+		Usage: "ID of the swap, as returned by initiate, requests, ongoing or past",
+	}
+
+	peerIDFlag = &cli.StringFlag{
+		Name:  "peer-id",
+		Usage: "ID of the peer, as listed by peers",
+	}
+
 	app = &cli.App{
 		Name:  "swapcli",
 		Usage: "Client for swapd",
@@ -41,7 +54,7 @@ var (
 			{
 				Name:    "query",
 				Aliases: []string{"q"},
-				Usage:   "query a peer for details on what they provide",
+				Usage:   "query a peer for their offers",
 				Action:  runQuery,
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -50,6 +63,17 @@ var (
 					},
 				},
 			},
+			{
+				Name:   "offers",
+				Usage:  "list the offers broadcast by makers on the network, best exchange rate first",
+				Action: runOffers,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "provides",
+						Usage: "only list offers that provide this coin: one of [ETH, XMR]",
+					},
+				},
+			},
 			{
 				Name:    "initiate",
 				Aliases: []string{"i"},
@@ -60,17 +84,160 @@ var (
 						Name:  "multiaddr",
 						Usage: "peer's multiaddress, as provided by discover",
 					},
+					&cli.StringFlag{
+						Name:  "offer-id",
+						Usage: "ID of the peer's offer to take, as provided by query or offers",
+					},
 					&cli.StringFlag{
 						Name:  "provides",
 						Usage: "coin to provide in the swap: one of [ETH, XMR]",
 					},
 					&cli.Float64Flag{
 						Name:  "provides-amount",
-						Usage: "amount of coin to send in the swap",
+						Usage: "most amount of coin to send in the swap",
 					},
 					&cli.Float64Flag{
 						Name:  "desired-amount",
-						Usage: "amount of coin to receive in the swap",
+						Usage: "amount of coin to receive in the swap; the peer may counter with less, at the same price",
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "duration of each of the swap contract's timelocks to propose; default 24h",
+					},
+				},
+			},
+			{
+				Name:   "make-offer",
+				Usage:  "make a signed offer to swap our coin, which is broadcast to the network",
+				Action: runMakeOffer,
+				Flags: []cli.Flag{
+					&cli.Float64Flag{
+						Name:  "min-amount",
+						Usage: "minimum amount of our coin to swap",
+					},
+					&cli.Float64Flag{
+						Name:  "max-amount",
+						Usage: "maximum amount of our coin to swap",
+					},
+					&cli.Float64Flag{
+						Name:  "exchange-rate",
+						Usage: "exchange rate of the offer, in ETH/XMR",
+					},
+					&cli.DurationFlag{
+						Name:  "ttl",
+						Usage: "how long the offer is live for, up to a week; default 24h",
+					},
+				},
+			},
+			{
+				Name:   "ongoing",
+				Usage:  "list swaps that haven't completed",
+				Action: runOngoing,
+			},
+			{
+				Name:   "past",
+				Usage:  "list swaps that have completed",
+				Action: runPast,
+			},
+			{
+				Name:   "details",
+				Usage:  "show the details of a swap",
+				Action: runDetails,
+				Flags:  []cli.Flag{swapIDFlag},
+			},
+			{
+				Name:   "cancel",
+				Usage:  "abort a swap before our funds are locked",
+				Action: runCancel,
+				Flags:  []cli.Flag{swapIDFlag},
+			},
+			{
+				Name:   "refund",
+				Usage:  "refund the ETH locked in a swap; only possible if we provide ETH",
+				Action: runRefund,
+				Flags:  []cli.Flag{swapIDFlag},
+			},
+			{
+				Name:   "claim",
+				Usage:  "claim the ETH locked in a swap; only possible if we provide XMR",
+				Action: runClaim,
+				Flags:  []cli.Flag{swapIDFlag},
+			},
+			{
+				Name:   "requests",
+				Usage:  "list incoming swap requests that are waiting to be accepted",
+				Action: runRequests,
+			},
+			{
+				Name:   "accept",
+				Usage:  "accept an incoming swap request",
+				Action: runAccept,
+				Flags:  []cli.Flag{swapIDFlag},
+			},
+			{
+				Name:   "decline",
+				Usage:  "decline an incoming swap request",
+				Action: runDecline,
+				Flags:  []cli.Flag{swapIDFlag},
+			},
+			{
+				Name:   "peers",
+				Usage:  "list the peers we know about and their scores, highest score first",
+				Action: runPeers,
+			},
+			{
+				Name:   "ban",
+				Usage:  "ban a peer, which disconnects it and stops it from connecting to us",
+				Action: runBan,
+				Flags:  []cli.Flag{peerIDFlag},
+			},
+			{
+				Name:   "unban",
+				Usage:  "unban a peer, and reset its score",
+				Action: runUnban,
+				Flags:  []cli.Flag{peerIDFlag},
+			},
+			{
+				Name:   "fees",
+				Usage:  "show the fees paid by our Ethereum transactions, and the network's current fees",
+				Action: runFees,
+			},
+			{
+				Name:   "unsigned-txs",
+				Usage:  "list the transactions waiting to be signed offline, in the order they must be signed",
+				Action: runUnsignedTxs,
+			},
+			{
+				Name:   "sign-tx",
+				Usage:  "sign a transaction listed by unsigned-txs with a keystore file; doesn't need the daemon, so it can be run offline",
+				Action: runSignTx,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tx",
+						Usage: "hex-encoded unsigned transaction",
+					},
+					&cli.UintFlag{
+						Name:  "chain-id",
+						Usage: "chain ID the transaction is signed for, as listed by unsigned-txs",
+					},
+					&cli.StringFlag{
+						Name:  "keystore",
+						Usage: "go-ethereum keystore file containing the key of the signing account",
+					},
+					&cli.StringFlag{
+						Name:  "password-file",
+						Usage: "file containing the password of the keystore file; prompted for if unset",
+					},
+				},
+			},
+			{
+				Name:   "import-tx",
+				Usage:  "import a transaction signed offline, which the daemon then sends",
+				Action: runImportTx,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tx",
+						Usage: "hex-encoded signed transaction",
 					},
 				},
 			},
@@ -107,14 +274,9 @@ func runDiscover(ctx *cli.Context) error {
 		return err
 	}
 
-	endpoint := ctx.String("daemon-addr")
-	if endpoint == "" {
-		endpoint = defaultSwapdAddress
-	}
-
 	searchTime := ctx.Uint("search-time")
 
-	c := NewClient(endpoint)
+	c := NewClient(getEndpoint(ctx))
 	peers, err := c.discover(provides, uint64(searchTime))
 	if err != nil {
 		return err
@@ -133,30 +295,77 @@ func runQuery(ctx *cli.Context) error {
 		return errors.New("must provide peer's multiaddress with --multiaddr")
 	}
 
-	endpoint := ctx.String("daemon-addr")
-	if endpoint == "" {
-		endpoint = defaultSwapdAddress
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.query(maddr)
+	if err != nil {
+		return err
 	}
 
-	c := NewClient(endpoint)
-	res, err := c.query(maddr)
+	if len(res.Offers) == 0 {
+		fmt.Println("Peer has no offers")
+		return nil
+	}
+
+	for _, o := range res.Offers {
+		printOffer(o)
+	}
+
+	return nil
+}
+
+func runOffers(ctx *cli.Context) error {
+	var provides common.ProvidesCoin
+	if ctx.String("provides") != "" {
+		var err error
+		provides, err = common.NewProvidesCoin(ctx.String("provides"))
+		if err != nil {
+			return err
+		}
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getOffers(provides)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Provides: %v\n", res.Provides)
-	fmt.Printf("MaximumAmount: %v\n", res.MaximumAmount)
-	fmt.Printf("ExchangeRate (ETH/XMR): %v\n", res.ExchangeRate)
+	if len(res.Offers) == 0 {
+		fmt.Println("No offers")
+		return nil
+	}
+
+	for _, o := range res.Offers {
+		printOffer(o)
+	}
 
 	return nil
 }
 
+func printOffer(o *net.Offer) {
+	fmt.Printf("Offer %s\n", o.ID)
+	fmt.Printf("  Maker: %s\n", o.Maker)
+	for _, addr := range o.Addrs {
+		fmt.Printf("    %s/p2p/%s\n", addr, o.Maker)
+	}
+	fmt.Printf("  Provides: %s, desires: %s\n", o.Provides, o.Desires)
+	fmt.Printf("  Amount: %v to %v %s\n", o.MinimumAmount, o.MaximumAmount, o.Provides)
+	fmt.Printf("  ExchangeRate (ETH/XMR): %v\n", o.ExchangeRate)
+	fmt.Printf("  Timeouts: %v\n", o.Timeouts)
+	fmt.Printf("  Contract version: %d\n", o.ContractVersion)
+	fmt.Printf("  Expires: %s\n", o.ExpiresAt.Format(time.RFC3339))
+}
+
 func runInitiate(ctx *cli.Context) error {
 	maddr := ctx.String("multiaddr")
 	if maddr == "" {
 		return errors.New("must provide peer's multiaddress with --multiaddr")
 	}
 
+	offerID, err := net.NewOfferIDFromString(ctx.String("offer-id"))
+	if err != nil {
+		return fmt.Errorf("must provide the ID of the peer's offer with --offer-id: %w", err)
+	}
+
 	provides, err := common.NewProvidesCoin(ctx.String("provides"))
 	if err != nil {
 		return err
@@ -172,22 +381,405 @@ func runInitiate(ctx *cli.Context) error {
 		return errors.New("must provide --desired-amount")
 	}
 
-	endpoint := ctx.String("daemon-addr")
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.initiate(&rpc.InitiateRequest{
+		Multiaddr:      maddr,
+		OfferID:        offerID,
+		ProvidesCoin:   provides,
+		ProvidesAmount: providesAmount,
+		DesiredAmount:  desiredAmount,
+		Timeout:        uint64(ctx.Duration("timeout") / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.Success {
+		fmt.Printf("Swap %s successful, provided %v %s and received %v %s\n",
+			res.ID, res.ProvidesAmount, provides, res.DesiredAmount, provides.Counterpart())
+	} else {
+		fmt.Printf("Swap failed! Please check swapd logs for additional information.")
+	}
+
+	return nil
+}
+
+func runMakeOffer(ctx *cli.Context) error {
+	maxAmount := ctx.Float64("max-amount")
+	if maxAmount == 0 {
+		return errors.New("must provide --max-amount")
+	}
+
+	exchangeRate := ctx.Float64("exchange-rate")
+	if exchangeRate == 0 {
+		return errors.New("must provide --exchange-rate")
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.makeOffer(&rpc.MakeOfferRequest{
+		MinimumAmount: ctx.Float64("min-amount"),
+		MaximumAmount: maxAmount,
+		ExchangeRate:  common.ExchangeRate(exchangeRate),
+		TTL:           uint64(ctx.Duration("ttl") / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	printOffer(res.Offer)
+	return nil
+}
+
+func runOngoing(ctx *cli.Context) error {
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getOngoingSwaps()
+	if err != nil {
+		return err
+	}
+
+	printSwaps(res.Swaps)
+	return nil
+}
+
+func runPast(ctx *cli.Context) error {
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getPastSwaps()
+	if err != nil {
+		return err
+	}
+
+	printSwaps(res.Swaps)
+	return nil
+}
+
+func printSwaps(swaps []*rpc.SwapSummary) {
+	if len(swaps) == 0 {
+		fmt.Println("No swaps found")
+		return
+	}
+
+	for _, s := range swaps {
+		fmt.Printf("%s provides=%s eth=%v xmr=%v status=%s created=%s\n",
+			s.ID, s.Provides, s.ETHAmount, s.XMRAmount, s.Status, s.CreatedAt.Format(time.RFC3339))
+	}
+}
+
+func runDetails(ctx *cli.Context) error {
+	id, err := getSwapID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getSwapDetails(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ID: %s\n", res.ID)
+	fmt.Printf("Provides: %s\n", res.Provides)
+	fmt.Printf("ETH amount: %v\n", res.ETHAmount)
+	fmt.Printf("XMR amount: %v\n", res.XMRAmount)
+	fmt.Printf("Counterparty peer ID: %s\n", res.CounterpartyPeerID)
+	fmt.Printf("Status: %s\n", res.Status)
+	printIfSet("Contract address", res.ContractAddress)
+	if res.Timeout0 != nil && res.Timeout1 != nil {
+		fmt.Printf("t0: %s\n", res.Timeout0.Format(time.RFC3339))
+		fmt.Printf("t1: %s\n", res.Timeout1.Format(time.RFC3339))
+	}
+	printIfSet("Deploy tx", res.DeployTxHash)
+	printIfSet("Ready tx", res.ReadyTxHash)
+	printIfSet("Claim tx", res.ClaimTxHash)
+	printIfSet("Refund tx", res.RefundTxHash)
+
+	fmt.Println("History:")
+	for _, t := range res.StatusHistory {
+		fmt.Printf("  %s %s\n", t.Time.Format(time.RFC3339), t.Status)
+	}
+
+	return nil
+}
+
+func runCancel(ctx *cli.Context) error {
+	id, err := getSwapID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	if err = c.cancel(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Swap %s aborted\n", id)
+	return nil
+}
+
+func runRefund(ctx *cli.Context) error {
+	id, err := getSwapID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.refund(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Refunded swap %s, tx hash: %s\n", id, res.TxHash)
+	return nil
+}
+
+func runClaim(ctx *cli.Context) error {
+	id, err := getSwapID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.claim(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Claimed swap %s, tx hash: %s\n", id, res.TxHash)
+	return nil
+}
+
+func runRequests(ctx *cli.Context) error {
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getRequests()
+	if err != nil {
+		return err
+	}
+
+	if len(res.Requests) == 0 {
+		fmt.Println("No pending requests")
+		return nil
+	}
+
+	for _, r := range res.Requests {
+		fmt.Printf("%s peer=%s provides=%v %s desired=%v expires=%s\n",
+			r.ID, r.Peer, r.ProvidesAmount, r.Provides, r.DesiredAmount, r.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func runAccept(ctx *cli.Context) error {
+	id, err := getSwapID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	if err = c.acceptRequest(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Accepted swap %s\n", id)
+	return nil
+}
+
+func runDecline(ctx *cli.Context) error {
+	id, err := getSwapID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	if err = c.declineRequest(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Declined swap %s\n", id)
+	return nil
+}
+
+func runPeers(ctx *cli.Context) error {
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getPeers()
+	if err != nil {
+		return err
+	}
+
+	if len(res.Peers) == 0 {
+		fmt.Println("No known peers")
+		return nil
+	}
+
+	for _, p := range res.Peers {
+		fmt.Printf("%s score=%d completed=%d aborted=%d aborted-after-lock=%d timeouts=%d violations=%d banned=%t allowed=%t\n",
+			p.ID, p.Score, p.CompletedSwaps, p.AbortedSwaps, p.AbortsAfterLock, p.Timeouts, p.ProtocolViolations,
+			p.Banned, p.Allowed)
+	}
+
+	return nil
+}
+
+func runBan(ctx *cli.Context) error {
+	id, err := getPeerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	if err = c.banPeer(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Banned peer %s\n", id)
+	return nil
+}
+
+func runUnban(ctx *cli.Context) error {
+	id, err := getPeerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	if err = c.unbanPeer(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Unbanned peer %s\n", id)
+	return nil
+}
+
+func getPeerID(ctx *cli.Context) (string, error) {
+	id := ctx.String("peer-id")
+	if id == "" {
+		return "", errors.New("must provide a peer ID with --peer-id")
+	}
+
+	return id, nil
+}
+
+func getSwapID(ctx *cli.Context) (common.SwapID, error) {
+	id, err := common.NewSwapIDFromString(ctx.String("id"))
+	if err != nil {
+		return common.SwapID{}, fmt.Errorf("must provide a valid swap ID with --id: %w", err)
+	}
+
+	return id, nil
+}
+
+func printIfSet(name, value string) {
+	if value != "" {
+		fmt.Printf("%s: %s\n", name, value)
+	}
+}
+
+func getEndpoint(ctx *cli.Context) string {
+	endpoint := ctx.GlobalString("daemon-addr")
 	if endpoint == "" {
 		endpoint = defaultSwapdAddress
 	}
 
-	c := NewClient(endpoint)
-	ok, err := c.initiate(maddr, provides, providesAmount, desiredAmount)
+	return endpoint
+}
+
+func runFees(ctx *cli.Context) error {
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getFeeSettings()
 	if err != nil {
 		return err
 	}
 
-	if ok {
-		fmt.Printf("Swap successful, received %v %s\n", desiredAmount, provides)
+	maxFee, priorityFee := "none", "node's suggestion"
+	if res.MaxFeePerGas != 0 {
+		maxFee = fmt.Sprintf("%v gwei", res.MaxFeePerGas)
+	}
+
+	if res.PriorityFee != 0 {
+		priorityFee = fmt.Sprintf("%v gwei", res.PriorityFee)
+	}
+
+	fmt.Printf("Max fee per gas: %s\n", maxFee)
+	fmt.Printf("Priority fee: %s\n", priorityFee)
+	if res.BumpInterval != 0 {
+		fmt.Printf("Bump interval: %ds\n", res.BumpInterval)
 	} else {
-		fmt.Printf("Swap failed! Please check swapd logs for additional information.")
+		fmt.Println("Bump interval: none, pending transactions aren't replaced")
+	}
+	if res.BaseFee != 0 {
+		fmt.Printf("Current base fee: %v gwei\n", res.BaseFee)
+		fmt.Printf("Suggested priority fee: %v gwei\n", res.SuggestedPriorityFee)
+	}
+
+	return nil
+}
+
+func runUnsignedTxs(ctx *cli.Context) error {
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.getUnsignedTxs()
+	if err != nil {
+		return err
+	}
+
+	if len(res.Txs) == 0 {
+		fmt.Println("No transactions waiting to be signed")
+		return nil
+	}
+
+	for _, tx := range res.Txs {
+		fmt.Printf("ID: %d\n", tx.ID)
+		fmt.Printf("Label: %s\n", tx.Label)
+		fmt.Printf("From: %s\n", tx.From)
+		fmt.Printf("Nonce: %d\n", tx.Nonce)
+		fmt.Printf("Chain ID: %d\n", tx.ChainID)
+		if tx.Deadline != 0 {
+			fmt.Printf("Deadline: %s (%s left)\n", time.Unix(int64(tx.Deadline), 0).Format(time.RFC3339),
+				time.Duration(tx.TimeLeft)*time.Second)
+		}
+
+		printIfSet("WARNING", tx.Warning)
+		fmt.Printf("Transaction: %s\n\n", tx.Tx)
+	}
+
+	return nil
+}
+
+func runSignTx(ctx *cli.Context) error {
+	tx := ctx.String("tx")
+	if tx == "" {
+		return errors.New("must provide --tx")
+	}
+
+	chainID := ctx.Uint("chain-id")
+	if chainID == 0 {
+		return errors.New("must provide --chain-id")
+	}
+
+	keystoreFile := ctx.String("keystore")
+	if keystoreFile == "" {
+		return errors.New("must provide --keystore")
+	}
+
+	signed, err := signTx(keystoreFile, ctx.String("password-file"), tx, uint64(chainID))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(signed)
+	return nil
+}
+
+func runImportTx(ctx *cli.Context) error {
+	tx := ctx.String("tx")
+	if tx == "" {
+		return errors.New("must provide --tx")
+	}
+
+	c := NewClient(getEndpoint(ctx))
+	res, err := c.importSignedTx(tx)
+	if err != nil {
+		return err
 	}
 
+	fmt.Printf("Imported signed transaction %d, it's being sent: tx hash=%s\n", res.ID, res.TxHash)
+	printIfSet("WARNING", res.Warning)
 	return nil
 }
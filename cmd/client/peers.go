@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/noot/atomic-swap/rpc"
+)
+
+func (c *Client) getPeers() (*rpc.GetPeersResponse, error) {
+	const (
+		method = "net_getPeers"
+	)
+
+	var res *rpc.GetPeersResponse
+	if err := c.post(method, &rpc.GetPeersRequest{}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) banPeer(id string) error {
+	const (
+		method = "net_banPeer"
+	)
+
+	var res *rpc.BanPeerResponse
+	return c.post(method, &rpc.PeerIDRequest{PeerID: id}, &res)
+}
+
+func (c *Client) unbanPeer(id string) error {
+	const (
+		method = "net_unbanPeer"
+	)
+
+	var res *rpc.BanPeerResponse
+	return c.post(method, &rpc.PeerIDRequest{PeerID: id}, &res)
+}
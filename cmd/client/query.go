@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 
+	"github.com/noot/atomic-swap/common"
 	"github.com/noot/atomic-swap/rpc"
 	"github.com/noot/atomic-swap/rpcclient"
 )
@@ -37,3 +38,33 @@ func (c *Client) query(maddr string) (*rpc.QueryPeerResponse, error) {
 
 	return res, nil
 }
+
+func (c *Client) makeOffer(req *rpc.MakeOfferRequest) (*rpc.MakeOfferResponse, error) {
+	const (
+		method = "net_makeOffer"
+	)
+
+	var res *rpc.MakeOfferResponse
+	if err := c.post(method, req, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) getOffers(provides common.ProvidesCoin) (*rpc.GetOffersResponse, error) {
+	const (
+		method = "net_getOffers"
+	)
+
+	req := &rpc.GetOffersRequest{
+		Provides: provides,
+	}
+
+	var res *rpc.GetOffersResponse
+	if err := c.post(method, req, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
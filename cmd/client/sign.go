@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"golang.org/x/term"
+
+	"github.com/noot/atomic-swap/chain"
+)
+
+// signTx signs a hex-encoded unsigned transaction, as exported by unsigned-txs, with the key in the
+// given go-ethereum keystore file. It doesn't need the daemon, so it can be run on an offline machine.
+func signTx(keystoreFile, passwordFile, unsigned string, chainID uint64) (string, error) {
+	raw, err := hexutil.Decode(unsigned)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode transaction: %w", err)
+	}
+
+	tx := new(ethtypes.Transaction)
+	if err = tx.UnmarshalBinary(raw); err != nil {
+		return "", fmt.Errorf("failed to decode transaction: %w", err)
+	}
+
+	keyJSON, err := os.ReadFile(filepath.Clean(keystoreFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to read keystore file: %w", err)
+	}
+
+	var password string
+	if passwordFile != "" {
+		bz, err := os.ReadFile(filepath.Clean(passwordFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+
+		password = strings.TrimRight(string(bz), "\r\n")
+	} else {
+		password, err = promptPassword(fmt.Sprintf("Password for %s: ", keystoreFile))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	signer, err := chain.NewKeystoreSigner(keyJSON, password)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.SignTx(tx, new(big.Int).SetUint64(chainID))
+	if err != nil {
+		return "", err
+	}
+
+	raw, err = signed.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+
+	return hexutil.Encode(raw), nil
+}
+
+// promptPassword reads a password from the terminal, without echoing it.
+func promptPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("can't prompt for the password without a terminal, use --password-file")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	return string(password), nil
+}
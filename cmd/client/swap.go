@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/rpc"
+	"github.com/noot/atomic-swap/rpcclient"
+)
+
+func (c *Client) getOngoingSwaps() (*rpc.GetSwapsResponse, error) {
+	const (
+		method = "swap_getOngoing"
+	)
+
+	var res *rpc.GetSwapsResponse
+	if err := c.post(method, &rpc.GetSwapsRequest{}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) getPastSwaps() (*rpc.GetSwapsResponse, error) {
+	const (
+		method = "swap_getPast"
+	)
+
+	var res *rpc.GetSwapsResponse
+	if err := c.post(method, &rpc.GetSwapsRequest{}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) getSwapDetails(id common.SwapID) (*rpc.GetDetailsResponse, error) {
+	const (
+		method = "swap_getDetails"
+	)
+
+	req := &rpc.GetDetailsRequest{
+		ID: id,
+	}
+
+	var res *rpc.GetDetailsResponse
+	if err := c.post(method, req, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) cancel(id common.SwapID) error {
+	const (
+		method = "swap_cancel"
+	)
+
+	var res *rpc.CancelResponse
+	return c.post(method, &rpc.SwapIDRequest{ID: id}, &res)
+}
+
+func (c *Client) refund(id common.SwapID) (*rpc.TxHashResponse, error) {
+	const (
+		method = "swap_refund"
+	)
+
+	var res *rpc.TxHashResponse
+	if err := c.post(method, &rpc.SwapIDRequest{ID: id}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) claim(id common.SwapID) (*rpc.TxHashResponse, error) {
+	const (
+		method = "swap_claim"
+	)
+
+	var res *rpc.TxHashResponse
+	if err := c.post(method, &rpc.SwapIDRequest{ID: id}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) getRequests() (*rpc.GetRequestsResponse, error) {
+	const (
+		method = "swap_getRequests"
+	)
+
+	var res *rpc.GetRequestsResponse
+	if err := c.post(method, &rpc.GetRequestsRequest{}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) acceptRequest(id common.SwapID) error {
+	const (
+		method = "swap_acceptRequest"
+	)
+
+	var res *rpc.RequestDecisionResponse
+	return c.post(method, &rpc.SwapIDRequest{ID: id}, &res)
+}
+
+func (c *Client) declineRequest(id common.SwapID) error {
+	const (
+		method = "swap_declineRequest"
+	)
+
+	var res *rpc.RequestDecisionResponse
+	return c.post(method, &rpc.SwapIDRequest{ID: id}, &res)
+}
+
+// post calls the given method with the given request, and decodes the result into res.
+func (c *Client) post(method string, req, res interface{}) error {
+	params, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := rpcclient.PostRPC(c.endpoint, method, string(params))
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	return json.Unmarshal(resp.Result, res)
+}
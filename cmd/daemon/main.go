@@ -12,7 +12,9 @@ import (
 
 	"github.com/noot/atomic-swap/alice"
 	"github.com/noot/atomic-swap/bob"
+	"github.com/noot/atomic-swap/chain"
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
 	"github.com/noot/atomic-swap/net"
 	"github.com/noot/atomic-swap/rpc"
 
@@ -32,6 +34,10 @@ const (
 	defaultAliceLibp2pKey = "alice.key"
 	defaultBobLibp2pKey   = "bob.key"
 
+	// default peer record files, in the basepath
+	defaultAlicePeersFile = "alice-peers.json"
+	defaultBobPeersFile   = "bob-peers.json"
+
 	// default RPC port
 	defaultRPCPort = 5001
 
@@ -43,6 +49,8 @@ var (
 	_   = logging.SetLogLevel("alice", "debug")
 	_   = logging.SetLogLevel("bob", "debug")
 	_   = logging.SetLogLevel("common", "info")
+	_   = logging.SetLogLevel("db", "info")
+	_   = logging.SetLogLevel("recovery", "debug")
 	_   = logging.SetLogLevel("cmd", "debug")
 	_   = logging.SetLogLevel("net", "debug")
 	_   = logging.SetLogLevel("rpc", "debug")
@@ -53,6 +61,9 @@ var (
 		Name:   "atomic-swap",
 		Usage:  "A program for doing atomic swaps between ETH and XMR",
 		Action: runDaemon,
+		Commands: []cli.Command{
+			recoverCommand,
+		},
 		Flags: []cli.Flag{
 			&cli.UintFlag{
 				Name:  "rpc-port",
@@ -60,7 +71,7 @@ var (
 			},
 			&cli.StringFlag{
 				Name:  "basepath",
-				Usage: "path to store swap artifacts",
+				Usage: "path to store swap artifacts and the swap database",
 			},
 			&cli.BoolFlag{
 				Name:  "alice",
@@ -83,9 +94,14 @@ var (
 				Usage: "environment to use: one of mainnet, stagenet, or dev",
 			},
 			&cli.Float64Flag{
-				Name:  "amount", // TODO: remove this and pass it via RPC
+				Name:  "amount",
 				Value: 0,
-				Usage: "maximum amount to swap (in smallest units of coin)",
+				Usage: "if set, make an offer at startup to swap up to this amount of our coin; offers can also be made with swapcli make-offer",
+			},
+			&cli.Float64Flag{
+				Name:  "exchange-rate",
+				Value: defaultExchangeRate,
+				Usage: "exchange rate (ETH/XMR) of the offer made at startup",
 			},
 			&cli.StringFlag{
 				Name:  "monero-endpoint",
@@ -99,20 +115,119 @@ var (
 				Name:  "ethereum-endpoint",
 				Usage: "ethereum client endpoint",
 			},
-			&cli.StringFlag{
-				Name:  "ethereum-privkey",
-				Usage: "file containing a private key hex string",
-			},
+			ethPrivKeyFlag,
+			ethKeystoreFlag,
+			ethKeystorePasswordFileFlag,
+			externalSignerFlag,
+			externalSignerAccountFlag,
+			offlineSignerFlag,
+			offlineSigningLatencyFlag,
 			&cli.UintFlag{
 				Name:  "ethereum-chain-id",
 				Usage: "ethereum chain ID; eg. mainnet=1, ropsten=3, rinkeby=4, goerli=5, ganache=1337",
 			},
+			ethConfirmationsFlag,
+			maxFeeFlag,
+			priorityFeeFlag,
 			&cli.StringFlag{
 				Name:  "bootnodes",
 				Usage: "comma-separated string of libp2p bootnodes",
 			},
+			&cli.UintFlag{
+				Name:  "max-swaps",
+				Usage: "maximum number of swaps to perform concurrently; default 10",
+			},
+			&cli.BoolFlag{
+				Name:  "no-advertise",
+				Usage: "don't advertise in the DHT or broadcast our offers; for private or OTC-only nodes",
+			},
+			&cli.StringFlag{
+				Name:  "relays",
+				Usage: "comma-separated string of circuit relays to be reached through, for nodes behind a NAT",
+			},
+			&cli.BoolFlag{
+				Name:  "relay-service",
+				Usage: "relay connections for peers behind a NAT; the node must be publicly reachable",
+			},
+			&cli.BoolFlag{
+				Name:  "no-hole-punching",
+				Usage: "don't try to upgrade relayed connections to direct ones",
+			},
+			&cli.DurationFlag{
+				Name:  "message-timeout",
+				Usage: "how long to wait for each message of a swap before giving up on it; default 30m",
+			},
+			&cli.UintFlag{
+				Name:  "max-queries-per-minute",
+				Usage: "how many times each peer can query us per minute; default 30",
+			},
+			&cli.UintFlag{
+				Name:  "max-swap-streams-per-minute",
+				Usage: "how many swap streams each peer can open with us per minute; default 5",
+			},
+			&cli.UintFlag{
+				Name:  "max-swaps-per-peer",
+				Usage: "how many swaps, including those still negotiating, each peer can have with us at once; default 2",
+			},
+			&cli.UintFlag{
+				Name:  "max-conns",
+				Usage: "number of connections above which the least useful are closed; default 200",
+			},
+			&cli.IntFlag{
+				Name:  "ban-threshold",
+				Usage: "score at or below which a misbehaving peer is banned; default -20",
+			},
+			&cli.StringFlag{
+				Name:  "allow-list",
+				Usage: "comma-separated string of peer IDs; if set, only these peers can query us or start swaps with us",
+			},
+			&cli.BoolFlag{
+				Name:  "mdns",
+				Usage: "discover peers on the local network that use the same environment and chain ID",
+			},
+			&cli.UintFlag{
+				Name:  "max-negotiation-rounds",
+				Usage: "number of proposals and counter-proposals after which a swap's negotiation is given up; default 4",
+			},
+			&cli.BoolFlag{
+				Name:  "auto-accept",
+				Usage: "accept incoming swap requests without asking, if they're within --auto-accept-max-amount",
+			},
+			&cli.Float64Flag{
+				Name:  "auto-accept-max-amount",
+				Usage: "maximum amount of our coin an automatically accepted swap can use; default no limit",
+			},
+			&cli.DurationFlag{
+				Name:  "request-timeout",
+				Usage: "how long an incoming swap request waits to be accepted before it expires; default 5m",
+			},
+			keystorePasswordFlag,
+			keystorePasswordFileFlag,
 		},
 	}
+
+	ethConfirmationsFlag = &cli.UintFlag{
+		Name:  "ethereum-confirmations",
+		Usage: "number of blocks a transaction must be included under before it's considered final; default 12 on mainnet, 3 on stagenet, 1 on dev",
+	}
+	maxFeeFlag = &cli.Float64Flag{
+		Name:  "max-fee-per-gas",
+		Usage: "most our transactions pay per gas in gwei, including when replacing stuck ones; default unlimited",
+	}
+	priorityFeeFlag = &cli.Float64Flag{
+		Name:  "priority-fee",
+		Usage: "priority fee (tip) per gas in gwei paid by our transactions; default is the node's suggestion",
+	}
+
+	keystorePasswordFlag = &cli.StringFlag{
+		Name:   "keystore-password",
+		Usage:  "password used to encrypt swap secrets",
+		EnvVar: "SWAPD_KEYSTORE_PASSWORD",
+	}
+	keystorePasswordFileFlag = &cli.StringFlag{
+		Name:  "keystore-password-file",
+		Usage: "file containing the password used to encrypt swap secrets",
+	}
 )
 
 func main() {
@@ -124,9 +239,7 @@ func main() {
 
 func runDaemon(c *cli.Context) error {
 	var (
-		moneroEndpoint, daemonEndpoint, ethEndpoint, ethPrivKeyFile, ethPrivKey string
-		env                                                                     common.Environment
-		cfg                                                                     common.Config
+		moneroEndpoint, daemonEndpoint, ethEndpoint string
 	)
 
 	isAlice := c.Bool("alice")
@@ -140,21 +253,9 @@ func runDaemon(c *cli.Context) error {
 		return errors.New("must specify only one of --alice or --bob")
 	}
 
-	switch c.String("env") {
-	case "mainnet":
-		env = common.Mainnet
-		cfg = common.MainnetConfig
-	case "stagenet":
-		env = common.Stagenet
-		cfg = common.StagenetConfig
-	case "dev":
-		env = common.Development
-		cfg = common.DevelopmentConfig
-	case "":
-		env = defaultEnvironment
-		cfg = common.DevelopmentConfig
-	default:
-		return errors.New("--env must be one of mainnet, stagenet, or dev")
+	env, cfg, err := getEnvironment(c)
+	if err != nil {
+		return err
 	}
 
 	if c.String("monero-endpoint") != "" {
@@ -173,30 +274,14 @@ func runDaemon(c *cli.Context) error {
 		ethEndpoint = common.DefaultEthEndpoint
 	}
 
-	// TODO: if env isn't development, require a private key
-	if c.String("ethereum-privkey") != "" {
-		ethPrivKeyFile = c.String("ethereum-privkey")
-		key, err := os.ReadFile(filepath.Clean(ethPrivKeyFile))
-		if err != nil {
-			return fmt.Errorf("failed to read ethereum-privkey file: %w", err)
-		}
-
-		if key[len(key)-1] == '\n' {
-			key = key[:len(key)-1]
-		}
-
-		ethPrivKey = string(key)
-	} else {
-		if env != common.Development {
-			return errors.New("must provide --ethereum-privkey file for non-development environment")
-		}
+	ethSigner, err := getEthereumSigner(c, env, isAlice)
+	if err != nil {
+		return err
+	}
 
-		log.Warn("no ethereum private key file provided, using ganache deterministic key")
-		if isAlice {
-			ethPrivKey = common.DefaultPrivKeyAlice
-		} else {
-			ethPrivKey = common.DefaultPrivKeyBob
-		}
+	offlineSigner, isOffline := ethSigner.(*chain.OfflineSigner)
+	if isOffline && isBob {
+		return errors.New("--offline-signer is only supported with --alice")
 	}
 
 	chainID := int64(c.Uint("ethereum-chain-id"))
@@ -204,25 +289,41 @@ func runDaemon(c *cli.Context) error {
 		chainID = cfg.EthereumChainID
 	}
 
+	confirmations := getEthConfirmations(c, cfg)
+	fees := getFeeConfig(c)
+
 	if c.String("monero-daemon-endpoint") != "" {
 		daemonEndpoint = c.String("monero-daemon-endpoint")
 	} else {
 		daemonEndpoint = cfg.MoneroDaemonEndpoint
 	}
 
+	ks, err := getKeystore(c, env)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// swap events are published by the protocol handler, and sent to RPC subscribers
+	events := common.NewEventFeed()
+
+	// incoming swaps wait here until they're accepted via RPC, or by the auto-accept policy
+	requests := common.NewRequestQueue(common.RequestPolicy{
+		AutoAccept: c.Bool("auto-accept"),
+		MaxAmount:  c.Float64("auto-accept-max-amount"),
+		Timeout:    c.Duration("request-timeout"),
+	})
+
 	type Handler interface {
 		net.Handler
 		rpc.Protocol
 		SetMessageSender(net.MessageSender)
+		ResumeSwaps() error
 	}
 
-	var (
-		handler Handler
-		err     error
-	)
+	var handler Handler
 	switch {
 	case isAlice:
 		aliceCfg := &alice.Config{
@@ -230,9 +331,14 @@ func runDaemon(c *cli.Context) error {
 			Basepath:             cfg.Basepath,
 			MoneroWalletEndpoint: moneroEndpoint,
 			EthereumEndpoint:     ethEndpoint,
-			EthereumPrivateKey:   ethPrivKey,
+			EthereumSigner:       ethSigner,
 			Environment:          env,
 			ChainID:              chainID,
+			Confirmations:        confirmations,
+			Fees:                 fees,
+			Keystore:             ks,
+			Events:               events,
+			Requests:             requests,
 		}
 
 		handler, err = alice.NewAlice(aliceCfg)
@@ -256,9 +362,14 @@ func runDaemon(c *cli.Context) error {
 			WalletFile:           walletFile,
 			WalletPassword:       walletPassword,
 			EthereumEndpoint:     ethEndpoint,
-			EthereumPrivateKey:   ethPrivKey,
+			EthereumSigner:       ethSigner,
 			Environment:          env,
 			ChainID:              chainID,
+			Confirmations:        confirmations,
+			Fees:                 fees,
+			Keystore:             ks,
+			Events:               events,
+			Requests:             requests,
 		}
 
 		handler, err = bob.NewBob(bobCfg)
@@ -281,17 +392,41 @@ func runDaemon(c *cli.Context) error {
 		bootnodes = strings.Split(c.String("bootnodes"), ",")
 	}
 
+	var relays []string
+	if c.String("relays") != "" {
+		relays = strings.Split(c.String("relays"), ",")
+	}
+
+	var allowList []string
+	if c.String("allow-list") != "" {
+		allowList = strings.Split(c.String("allow-list"), ",")
+	}
+
 	netCfg := &net.Config{
-		Ctx:           ctx,
-		Environment:   env,
-		ChainID:       chainID,
-		Port:          defaultAlicePort,                          // TODO: make flag
-		Provides:      []common.ProvidesCoin{common.ProvidesETH}, // TODO: make flag
-		MaximumAmount: []float64{amount},
-		ExchangeRate:  defaultExchangeRate,
-		KeyFile:       defaultAliceLibp2pKey, // TODO: make flag
-		Bootnodes:     bootnodes,
-		Handler:       handler,
+		Ctx:         ctx,
+		Environment: env,
+		ChainID:     chainID,
+		Port:        defaultAlicePort,                          // TODO: make flag
+		Provides:    []common.ProvidesCoin{common.ProvidesETH}, // TODO: make flag
+		KeyFile:     defaultAliceLibp2pKey,                     // TODO: make flag
+		Bootnodes:   bootnodes,
+		Handler:     handler,
+		MaxSwaps:    int(c.Uint("max-swaps")),
+
+		MaxNegotiationRounds:    uint32(c.Uint("max-negotiation-rounds")),
+		NoAdvertise:             c.Bool("no-advertise"),
+		MDNS:                    c.Bool("mdns"),
+		Relays:                  relays,
+		RelayService:            c.Bool("relay-service"),
+		NoHolePunching:          c.Bool("no-hole-punching"),
+		PeersFile:               filepath.Join(cfg.Basepath, defaultAlicePeersFile),
+		BanThreshold:            c.Int("ban-threshold"),
+		AllowList:               allowList,
+		MessageTimeout:          c.Duration("message-timeout"),
+		MaxQueriesPerMinute:     int(c.Uint("max-queries-per-minute")),
+		MaxSwapStreamsPerMinute: int(c.Uint("max-swap-streams-per-minute")),
+		MaxSwapsPerPeer:         int(c.Uint("max-swaps-per-peer")),
+		MaxConns:                int(c.Uint("max-conns")),
 	}
 
 	// TODO: this is ugly
@@ -299,6 +434,7 @@ func runDaemon(c *cli.Context) error {
 		netCfg.Port = defaultBobPort
 		netCfg.Provides = []common.ProvidesCoin{common.ProvidesXMR}
 		netCfg.KeyFile = defaultBobLibp2pKey
+		netCfg.PeersFile = filepath.Join(cfg.Basepath, defaultBobPeersFile)
 		port = defaultRPCPort + 1
 	}
 
@@ -310,14 +446,34 @@ func runDaemon(c *cli.Context) error {
 	// connect network to protocol handler
 	handler.SetMessageSender(host)
 
+	// pick up any swaps that were in progress when we last exited
+	if err = handler.ResumeSwaps(); err != nil {
+		return err
+	}
+
 	if err = host.Start(); err != nil {
 		return err
 	}
 
+	if amount != 0 {
+		provides := handler.Provides()
+		if _, err = host.MakeOffer(&net.Offer{
+			Provides:      provides,
+			Desires:       provides.Counterpart(),
+			MaximumAmount: amount,
+			ExchangeRate:  common.ExchangeRate(c.Float64("exchange-rate")),
+		}, 0); err != nil {
+			return err
+		}
+	}
+
 	rpcCfg := &rpc.Config{
-		Port:     port,
-		Net:      host,
-		Protocol: handler,
+		Port:          port,
+		Net:           host,
+		Protocol:      handler,
+		Events:        events,
+		Requests:      requests,
+		OfflineSigner: offlineSigner, // nil unless our transactions are signed offline
 	}
 
 	s, err := rpc.NewServer(rpcCfg)
@@ -330,3 +486,84 @@ func runDaemon(c *cli.Context) error {
 	wait(ctx)
 	return nil
 }
+
+// getEnvironment returns the environment specified by --env, along with its default config.
+// If --basepath is set, it overrides the default basepath.
+func getEnvironment(c *cli.Context) (common.Environment, common.Config, error) {
+	var (
+		env common.Environment
+		cfg common.Config
+	)
+
+	switch c.String("env") {
+	case "mainnet":
+		env = common.Mainnet
+		cfg = common.MainnetConfig
+	case "stagenet":
+		env = common.Stagenet
+		cfg = common.StagenetConfig
+	case "dev":
+		env = common.Development
+		cfg = common.DevelopmentConfig
+	case "":
+		env = defaultEnvironment
+		cfg = common.DevelopmentConfig
+	default:
+		return 0, common.Config{}, errors.New("--env must be one of mainnet, stagenet, or dev")
+	}
+
+	if c.String("basepath") != "" {
+		cfg.Basepath = c.String("basepath")
+	}
+
+	return env, cfg, nil
+}
+
+// getEthConfirmations returns the number of confirmations to wait for, from --ethereum-confirmations or
+// the environment's default.
+func getEthConfirmations(c *cli.Context, cfg common.Config) uint64 {
+	if confirmations := c.Uint("ethereum-confirmations"); confirmations != 0 {
+		return uint64(confirmations)
+	}
+
+	return cfg.EthereumConfirmations
+}
+
+// getFeeConfig returns the fees our transactions pay, from --max-fee-per-gas and --priority-fee.
+// Unset values are left nil, so that the node's suggestions are used without a limit.
+func getFeeConfig(c *cli.Context) *chain.FeeConfig {
+	cfg := &chain.FeeConfig{}
+	if maxFee := c.Float64("max-fee-per-gas"); maxFee != 0 {
+		cfg.MaxFeePerGas = common.GweiToWei(maxFee)
+	}
+
+	if tip := c.Float64("priority-fee"); tip != 0 {
+		cfg.PriorityFee = common.GweiToWei(tip)
+	}
+
+	return cfg
+}
+
+// getKeystore returns a keystore that encrypts swap secrets with the password given by
+// --keystore-password or --keystore-password-file.
+// In the development environment, an empty password is used if neither is set.
+func getKeystore(c *cli.Context, env common.Environment) (*keystore.Keystore, error) {
+	var password string
+	switch {
+	case c.String("keystore-password-file") != "":
+		bz, err := os.ReadFile(filepath.Clean(c.String("keystore-password-file")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keystore-password-file: %w", err)
+		}
+
+		password = strings.TrimRight(string(bz), "\r\n")
+	case c.String("keystore-password") != "":
+		password = c.String("keystore-password")
+	case env != common.Development:
+		return nil, errors.New("must provide --keystore-password or --keystore-password-file for non-development environment")
+	default:
+		log.Warn("no keystore password provided, swap secrets will be encrypted with an empty password")
+	}
+
+	return keystore.NewKeystore([]byte(password))
+}
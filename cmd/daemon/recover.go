@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/recovery"
+)
+
+var recoverCommand = cli.Command{
+	Name:  "recover",
+	Usage: "claim or refund an interrupted swap using the secrets saved in its swap directory",
+	Description: "Determines the state of the swap contract and does the appropriate action: " +
+		"refunds if we are Alice, claims if we are Bob, or creates the swap's XMR wallet " +
+		"if the counterparty's secret was revealed in the contract. This does not require swapd to be running.",
+	Action: runRecover,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "swap-dir",
+			Usage: "swap directory containing the saved secrets, ie. <basepath>/<swap ID>",
+		},
+		&cli.StringFlag{
+			Name:  "contract-address",
+			Usage: "address of the swap contract; if not set, it's read from the swap database",
+		},
+		&cli.StringFlag{
+			Name:  "env",
+			Usage: "environment to use: one of mainnet, stagenet, or dev",
+		},
+		&cli.StringFlag{
+			Name:  "monero-endpoint",
+			Usage: "monero-wallet-rpc endpoint",
+		},
+		&cli.StringFlag{
+			Name:  "ethereum-endpoint",
+			Usage: "ethereum client endpoint",
+		},
+		ethPrivKeyFlag,
+		ethKeystoreFlag,
+		ethKeystorePasswordFileFlag,
+		externalSignerFlag,
+		externalSignerAccountFlag,
+		&cli.UintFlag{
+			Name:  "ethereum-chain-id",
+			Usage: "ethereum chain ID; eg. mainnet=1, ropsten=3, rinkeby=4, goerli=5, ganache=1337",
+		},
+		ethConfirmationsFlag,
+		maxFeeFlag,
+		priorityFeeFlag,
+		keystorePasswordFlag,
+		keystorePasswordFileFlag,
+	},
+}
+
+func runRecover(c *cli.Context) error {
+	dir := c.String("swap-dir")
+	if dir == "" {
+		return errors.New("must provide --swap-dir")
+	}
+
+	env, cfg, err := getEnvironment(c)
+	if err != nil {
+		return err
+	}
+
+	ks, err := getKeystore(c, env)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := recovery.LoadSwapSecrets(dir, ks)
+	if err != nil {
+		return err
+	}
+
+	isAlice := secrets.Provides == common.ProvidesETH
+	if isAlice {
+		log.Info("found Alice's secret in swap directory")
+	} else {
+		log.Info("found Bob's secret in swap directory")
+	}
+
+	contractAddr, err := getContractAddress(c, dir, ks)
+	if err != nil && secrets.SwapKeys == nil {
+		return err
+	}
+
+	moneroEndpoint := c.String("monero-endpoint")
+	if moneroEndpoint == "" {
+		if isAlice {
+			moneroEndpoint = common.DefaultAliceMoneroEndpoint
+		} else {
+			moneroEndpoint = common.DefaultBobMoneroEndpoint
+		}
+	}
+
+	ethEndpoint := c.String("ethereum-endpoint")
+	if ethEndpoint == "" {
+		ethEndpoint = common.DefaultEthEndpoint
+	}
+
+	ethSigner, err := getEthereumSigner(c, env, isAlice)
+	if err != nil {
+		return err
+	}
+
+	chainID := int64(c.Uint("ethereum-chain-id"))
+	if chainID == 0 {
+		chainID = cfg.EthereumChainID
+	}
+
+	r, err := recovery.NewRecoverer(&recovery.Config{
+		Ctx:                  context.Background(),
+		Environment:          env,
+		MoneroWalletEndpoint: moneroEndpoint,
+		EthereumEndpoint:     ethEndpoint,
+		EthereumSigner:       ethSigner,
+		ChainID:              chainID,
+		Confirmations:        getEthConfirmations(c, cfg),
+		Fees:                 getFeeConfig(c),
+		Keystore:             ks,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := r.Recover(dir, secrets, contractAddr)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.Refunded:
+		fmt.Printf("Refunded ETH from contract, tx hash=%s\n", res.TxHash)
+	case res.Claimed:
+		fmt.Printf("Claimed ETH from contract, tx hash=%s\n", res.TxHash)
+	default:
+		fmt.Printf("Created XMR wallet %s with address %s\n", res.WalletName, res.MoneroAddress)
+	}
+
+	return nil
+}
+
+// getContractAddress returns the address passed with --contract-address, or if it's not set,
+// the address stored in the swap database, which is located in the parent of the swap directory.
+func getContractAddress(c *cli.Context, dir string, ks *keystore.Keystore) (ethcommon.Address, error) {
+	if c.String("contract-address") != "" {
+		return ethcommon.HexToAddress(c.String("contract-address")), nil
+	}
+
+	id, err := common.NewSwapIDFromString(filepath.Base(filepath.Clean(dir)))
+	if err != nil {
+		return ethcommon.Address{}, errors.New("must provide --contract-address")
+	}
+
+	database, err := db.NewDatabase(filepath.Dir(filepath.Clean(dir)), ks)
+	if err != nil {
+		return ethcommon.Address{}, err
+	}
+
+	info, err := database.GetSwap(id)
+	if err != nil {
+		return ethcommon.Address{}, fmt.Errorf("failed to get contract address from swap database: %w", err)
+	}
+
+	if info.ContractAddress == "" {
+		return ethcommon.Address{}, errors.New("swap database has no contract address for this swap")
+	}
+
+	return ethcommon.HexToAddress(info.ContractAddress), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli"
+	"golang.org/x/term"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+)
+
+var (
+	errMultipleSigners  = errors.New("must provide only one of --ethereum-privkey, --ethereum-keystore, --external-signer or --offline-signer")
+	errNoPasswordPrompt = errors.New("can't prompt for the keystore password without a terminal, use --ethereum-keystore-password-file")
+)
+
+// flags that choose how our Ethereum transactions are signed, shared by the daemon and recover commands
+var (
+	ethPrivKeyFlag = &cli.StringFlag{
+		Name:  "ethereum-privkey",
+		Usage: "file containing a private key hex string",
+	}
+	ethKeystoreFlag = &cli.StringFlag{
+		Name:  "ethereum-keystore",
+		Usage: "go-ethereum keystore file containing the encrypted private key; the password is prompted for unless --ethereum-keystore-password-file is set",
+	}
+	ethKeystorePasswordFileFlag = &cli.StringFlag{
+		Name:  "ethereum-keystore-password-file",
+		Usage: "file containing the password of --ethereum-keystore",
+	}
+	externalSignerFlag = &cli.StringFlag{
+		Name:  "external-signer",
+		Usage: "endpoint of a Clef-compatible external signer that signs our transactions, eg. http://localhost:8550 or a path to clef.ipc",
+	}
+	externalSignerAccountFlag = &cli.StringFlag{
+		Name:  "external-signer-account",
+		Usage: "address of the --external-signer account to use; default is its first account",
+	}
+	offlineSignerFlag = &cli.StringFlag{
+		Name:  "offline-signer",
+		Usage: "address of an account whose key is kept offline; its transactions are exported for signing with swapcli unsigned-txs, and sent once imported with swapcli import-tx (alice only)",
+	}
+	offlineSigningLatencyFlag = &cli.DurationFlag{
+		Name:  "offline-signing-latency",
+		Usage: "how long it usually takes to get a transaction signed offline; time-sensitive transactions are started this much earlier. default 10m",
+	}
+)
+
+// getEthereumSigner returns the signer of our transactions, given by --ethereum-privkey, --ethereum-keystore,
+// --external-signer or --offline-signer. In the development environment, it falls back to the ganache
+// deterministic keys.
+func getEthereumSigner(c *cli.Context, env common.Environment, isAlice bool) (chain.Signer, error) {
+	var set int
+	for _, name := range []string{"ethereum-privkey", "ethereum-keystore", "external-signer", "offline-signer"} {
+		if c.String(name) != "" {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return nil, errMultipleSigners
+	}
+
+	switch {
+	case c.String("ethereum-privkey") != "":
+		key, err := os.ReadFile(filepath.Clean(c.String("ethereum-privkey")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ethereum-privkey file: %w", err)
+		}
+
+		return chain.NewKeySignerFromHex(strings.TrimSpace(string(key)))
+	case c.String("ethereum-keystore") != "":
+		return getKeystoreSigner(c)
+	case c.String("external-signer") != "":
+		var account ethcommon.Address
+		if addr := c.String("external-signer-account"); addr != "" {
+			if !ethcommon.IsHexAddress(addr) {
+				return nil, fmt.Errorf("invalid external-signer-account: %s", addr)
+			}
+
+			account = ethcommon.HexToAddress(addr)
+		}
+
+		signer, err := chain.NewExternalSigner(c.String("external-signer"), account)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("using external signer: account=%s", signer.Address())
+		return signer, nil
+	case c.String("offline-signer") != "":
+		addr := c.String("offline-signer")
+		if !ethcommon.IsHexAddress(addr) {
+			return nil, fmt.Errorf("invalid offline-signer: %s", addr)
+		}
+
+		signer := chain.NewOfflineSigner(ethcommon.HexToAddress(addr), c.Duration("offline-signing-latency"))
+		log.Infof("transactions will be signed offline: account=%s latency=%s", signer.Address(), signer.Latency())
+		return signer, nil
+	}
+
+	if env != common.Development {
+		return nil, errors.New("must provide --ethereum-privkey, --ethereum-keystore or --external-signer for non-development environment")
+	}
+
+	log.Warn("no ethereum private key provided, using ganache deterministic key")
+	if isAlice {
+		return chain.NewKeySignerFromHex(common.DefaultPrivKeyAlice)
+	}
+
+	return chain.NewKeySignerFromHex(common.DefaultPrivKeyBob)
+}
+
+// getKeystoreSigner decrypts the --ethereum-keystore file with the password in
+// --ethereum-keystore-password-file, or one entered at the terminal.
+func getKeystoreSigner(c *cli.Context) (chain.Signer, error) {
+	keyJSON, err := os.ReadFile(filepath.Clean(c.String("ethereum-keystore")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ethereum-keystore file: %w", err)
+	}
+
+	var password string
+	if file := c.String("ethereum-keystore-password-file"); file != "" {
+		bz, err := os.ReadFile(filepath.Clean(file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ethereum-keystore-password-file: %w", err)
+		}
+
+		password = strings.TrimRight(string(bz), "\r\n")
+	} else {
+		password, err = promptPassword(fmt.Sprintf("Password for %s: ", c.String("ethereum-keystore")))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chain.NewKeystoreSigner(keyJSON, password)
+}
+
+// promptPassword reads a password from the terminal, without echoing it.
+func promptPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errNoPasswordPrompt
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	return string(password), nil
+}
@@ -9,26 +9,31 @@ var homeDir, _ = os.UserHomeDir()
 
 // Config contains constants that are defaults for various environments
 type Config struct {
-	Basepath             string
-	MoneroDaemonEndpoint string
-	EthereumChainID      int64
-	Bootnodes            []string // TODO: when it's ready for users to test, add some bootnodes
+	Basepath              string
+	MoneroDaemonEndpoint  string
+	EthereumChainID       int64
+	EthereumConfirmations uint64   // blocks a transaction must be included under to be final
+	Bootnodes             []string // TODO: when it's ready for users to test, add some bootnodes
 }
 
 var MainnetConfig = Config{
-	Basepath:             fmt.Sprintf("%s/.atomicswap/mainnet", homeDir),
-	MoneroDaemonEndpoint: "http://127.0.0.1:18081/json_rpc",
-	EthereumChainID:      MainnetChainID,
+	Basepath:              fmt.Sprintf("%s/.atomicswap/mainnet", homeDir),
+	MoneroDaemonEndpoint:  "http://127.0.0.1:18081/json_rpc",
+	EthereumChainID:       MainnetChainID,
+	EthereumConfirmations: 12,
 }
 
 var StagenetConfig = Config{
-	Basepath:             fmt.Sprintf("%s/.atomicswap/stagenet", homeDir),
-	MoneroDaemonEndpoint: "http://127.0.0.1:38081/json_rpc",
-	EthereumChainID:      RopstenChainID,
+	Basepath:              fmt.Sprintf("%s/.atomicswap/stagenet", homeDir),
+	MoneroDaemonEndpoint:  "http://127.0.0.1:38081/json_rpc",
+	EthereumChainID:       RopstenChainID,
+	EthereumConfirmations: 3,
 }
 
 var DevelopmentConfig = Config{
 	Basepath:             fmt.Sprintf("%s/.atomicswap/dev", homeDir),
 	MoneroDaemonEndpoint: "http://127.0.0.1:18081/json_rpc",
 	EthereumChainID:      GanacheChainID,
+	// ganache only mines blocks when it receives transactions
+	EthereumConfirmations: 1,
 }
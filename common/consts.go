@@ -3,6 +3,7 @@ package common
 import (
 	"math"
 	"math/big"
+	"time"
 )
 
 const (
@@ -22,8 +23,22 @@ const (
 	DefaultPrivKeyBob = "6cbed15c793ce57650b9877cf6fa156fbef513c4e6134f022a85b1ffdd59b2a1"
 )
 
+const (
+	// SwapContractVersion is the version of the swap contract (swap-contract/Swap.sol) that's deployed
+	// for swaps. It's advertised in offers, and must be increased whenever the contract changes.
+	SwapContractVersion uint32 = 1
+
+	// SwapTimeout is the default duration of each of the swap contract's timelocks.
+	SwapTimeout = time.Hour * 24
+
+	// MinSwapTimeout and MaxSwapTimeout bound the timelock durations we agree to when negotiating a swap.
+	MinSwapTimeout = time.Hour
+	MaxSwapTimeout = time.Hour * 24 * 7
+)
+
 var (
 	numEtherUnits  = math.Pow(10, 18)
+	numGweiUnits   = math.Pow(10, 9)
 	numMoneroUnits = math.Pow(10, 12)
 )
 
@@ -37,6 +52,11 @@ func (a MoneroAmount) Uint64() uint64 {
 	return uint64(a)
 }
 
+// AsMonero returns the amount in monero.
+func (a MoneroAmount) AsMonero() float64 {
+	return float64(a) / numMoneroUnits
+}
+
 type EtherAmount big.Int
 
 func NewEtherAmount(amount int64) EtherAmount {
@@ -56,6 +76,24 @@ func (a EtherAmount) BigInt() *big.Int {
 	return &i
 }
 
+// AsEther returns the amount in ether.
+func (a EtherAmount) AsEther() float64 {
+	res, _ := new(big.Float).Quo(new(big.Float).SetInt(a.BigInt()), big.NewFloat(numEtherUnits)).Float64()
+	return res
+}
+
 func (a EtherAmount) String() string {
 	return a.BigInt().String()
 }
+
+// GweiToWei converts an amount in gwei, as gas prices are usually given, to wei.
+func GweiToWei(amount float64) *big.Int {
+	res, _ := new(big.Float).Mul(big.NewFloat(amount), big.NewFloat(numGweiUnits)).Int(nil)
+	return res
+}
+
+// WeiToGwei converts an amount in wei to gwei.
+func WeiToGwei(amount *big.Int) float64 {
+	res, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), big.NewFloat(numGweiUnits)).Float64()
+	return res
+}
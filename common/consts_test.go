@@ -0,0 +1,14 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAmounts(t *testing.T) {
+	require.Equal(t, 1.5, EtherToWei(1.5).AsEther())
+	require.Equal(t, 0.25, MoneroToPiconero(0.25).AsMonero())
+	require.Equal(t, "1500000000", GweiToWei(1.5).String())
+	require.Equal(t, 1.5, WeiToGwei(GweiToWei(1.5)))
+}
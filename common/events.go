@@ -0,0 +1,159 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// SwapEventType is the kind of a SwapEvent.
+type SwapEventType string
+
+const (
+	// EventSwapRequest is emitted when a peer initiates a swap with us.
+	EventSwapRequest SwapEventType = "SwapRequest"
+	// EventRequestDeclined is emitted when a swap request is declined or expires.
+	EventRequestDeclined SwapEventType = "RequestDeclined"
+	// EventKeysExchanged is emitted when both parties have each other's keys.
+	EventKeysExchanged SwapEventType = "KeysExchanged"
+	// EventContractDeployed is emitted when Alice has deployed the swap contract and locked her ETH in it.
+	EventContractDeployed SwapEventType = "ContractDeployed"
+	// EventXMRLocked is emitted when Bob has locked his XMR.
+	EventXMRLocked SwapEventType = "XMRLocked"
+	// EventReady is emitted when Alice has called Ready() on the swap contract.
+	EventReady SwapEventType = "Ready"
+	// EventClaimed is emitted when the swap completes successfully.
+	EventClaimed SwapEventType = "Claimed"
+	// EventRefunded is emitted when Alice has refunded her ETH.
+	EventRefunded SwapEventType = "Refunded"
+	// EventAborted is emitted when the swap ends before we locked any funds.
+	EventAborted SwapEventType = "Aborted"
+	// EventFailed is emitted when a step of the swap fails; the swap's status doesn't change.
+	EventFailed SwapEventType = "Failed"
+)
+
+var statusEventTypes = map[Status]SwapEventType{
+	KeysExchanged:    EventKeysExchanged,
+	ETHLocked:        EventContractDeployed,
+	XMRLocked:        EventXMRLocked,
+	ContractReady:    EventReady,
+	CompletedSuccess: EventClaimed,
+	CompletedRefund:  EventRefunded,
+	CompletedAbort:   EventAborted,
+}
+
+// eventBufferSize is the number of events buffered for each subscriber.
+const eventBufferSize = 64
+
+// SwapEvent describes something that happened to a swap.
+type SwapEvent struct {
+	Type   SwapEventType `json:"type"`
+	ID     SwapID        `json:"id"`
+	Status Status        `json:"status"`
+	Time   time.Time     `json:"time"`
+
+	// set for EventFailed and EventRequestDeclined
+	Error string `json:"error,omitempty"`
+
+	// set for EventSwapRequest
+	Peer           string       `json:"peer,omitempty"`
+	Provides       ProvidesCoin `json:"provides,omitempty"`
+	ProvidesAmount float64      `json:"providesAmount,omitempty"`
+	DesiredAmount  float64      `json:"desiredAmount,omitempty"`
+}
+
+// NewStatusEvent returns the event for a swap that's entered the given status.
+func NewStatusEvent(id SwapID, status Status) *SwapEvent {
+	return &SwapEvent{
+		Type:   statusEventTypes[status],
+		ID:     id,
+		Status: status,
+		Time:   time.Now(),
+	}
+}
+
+// NewRequestEvent returns the event for a swap request received from a peer.
+func NewRequestEvent(req *SwapRequest) *SwapEvent {
+	return &SwapEvent{
+		Type:           EventSwapRequest,
+		ID:             req.ID,
+		Status:         ExpectingKeys,
+		Time:           time.Now(),
+		Peer:           req.Peer,
+		Provides:       req.Provides,
+		ProvidesAmount: req.ProvidesAmount,
+		DesiredAmount:  req.DesiredAmount,
+	}
+}
+
+// NewRequestDeclinedEvent returns the event for a swap request that was declined or expired.
+func NewRequestDeclinedEvent(id SwapID, err error) *SwapEvent {
+	return &SwapEvent{
+		Type:   EventRequestDeclined,
+		ID:     id,
+		Status: ExpectingKeys,
+		Time:   time.Now(),
+		Error:  err.Error(),
+	}
+}
+
+// NewFailedEvent returns the event for a swap that's failed with the given error while at the given status.
+func NewFailedEvent(id SwapID, status Status, err error) *SwapEvent {
+	return &SwapEvent{
+		Type:   EventFailed,
+		ID:     id,
+		Status: status,
+		Time:   time.Now(),
+		Error:  err.Error(),
+	}
+}
+
+// EventFeed sends the events published to it to each of its subscribers.
+// It's safe for concurrent use.
+type EventFeed struct {
+	sync.RWMutex
+	subs map[chan *SwapEvent]struct{}
+}
+
+// NewEventFeed returns a new *EventFeed with no subscribers.
+func NewEventFeed() *EventFeed {
+	return &EventFeed{
+		subs: make(map[chan *SwapEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives every event published after the call,
+// and a function that unsubscribes and closes the channel.
+// If the subscriber falls too far behind, events are dropped rather than blocking the swap.
+func (f *EventFeed) Subscribe() (<-chan *SwapEvent, func()) {
+	ch := make(chan *SwapEvent, eventBufferSize)
+
+	f.Lock()
+	f.subs[ch] = struct{}{}
+	f.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			f.Lock()
+			delete(f.subs, ch)
+			f.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish sends the event to every subscriber.
+func (f *EventFeed) Publish(event *SwapEvent) {
+	f.RLock()
+	defer f.RUnlock()
+
+	for ch := range f.subs {
+		select {
+		case ch <- event:
+		default:
+			log.Warnf("dropped swap event for slow subscriber: type=%s id=%s", event.Type, event.ID)
+		}
+	}
+}
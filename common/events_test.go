@@ -0,0 +1,51 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFeed(t *testing.T) {
+	feed := NewEventFeed()
+
+	id, err := NewSwapID()
+	require.NoError(t, err)
+
+	ch1, unsub1 := feed.Subscribe()
+	ch2, unsub2 := feed.Subscribe()
+	defer unsub2()
+
+	feed.Publish(NewStatusEvent(id, ETHLocked))
+
+	for _, ch := range []<-chan *SwapEvent{ch1, ch2} {
+		event := <-ch
+		require.Equal(t, EventContractDeployed, event.Type)
+		require.Equal(t, id, event.ID)
+		require.Equal(t, ETHLocked, event.Status)
+	}
+
+	unsub1()
+	unsub1()
+	_, ok := <-ch1
+	require.False(t, ok)
+
+	feed.Publish(NewFailedEvent(id, ETHLocked, errors.New("oops")))
+	event := <-ch2
+	require.Equal(t, EventFailed, event.Type)
+	require.Equal(t, "oops", event.Error)
+}
+
+func TestEventFeed_SlowSubscriber(t *testing.T) {
+	feed := NewEventFeed()
+	ch, unsub := feed.Subscribe()
+	defer unsub()
+
+	// publishing never blocks, even if the subscriber isn't reading
+	for i := 0; i < eventBufferSize*2; i++ {
+		feed.Publish(NewStatusEvent(SwapID{}, KeysExchanged))
+	}
+
+	require.Equal(t, eventBufferSize, len(ch))
+}
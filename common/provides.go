@@ -19,3 +19,12 @@ func NewProvidesCoin(s string) (ProvidesCoin, error) {
 		return "", errors.New("invalid ProvidesCoin")
 	}
 }
+
+// Counterpart returns the coin that's swapped for the given coin.
+func (c ProvidesCoin) Counterpart() ProvidesCoin {
+	if c == ProvidesXMR {
+		return ProvidesETH
+	}
+
+	return ProvidesXMR
+}
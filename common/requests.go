@@ -0,0 +1,166 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRequestTimeout is how long an incoming swap request waits to be accepted before it expires.
+const DefaultRequestTimeout = time.Minute * 5
+
+var (
+	errRequestDeclined = errors.New("swap request was declined")
+	errRequestExpired  = errors.New("swap request expired before it was accepted")
+	errRequestPending  = errors.New("swap request with given ID is already pending")
+	errNoRequest       = errors.New("no pending swap request with given ID")
+)
+
+// SwapRequest is a swap initiated by a peer, which we haven't yet accepted.
+// Amounts are from the peer's point of view: they provide ProvidesAmount and want DesiredAmount.
+type SwapRequest struct {
+	ID             SwapID       `json:"id"`
+	Peer           string       `json:"peer"`
+	Provides       ProvidesCoin `json:"provides"`
+	ProvidesAmount float64      `json:"providesAmount"`
+	DesiredAmount  float64      `json:"desiredAmount"`
+	ReceivedAt     time.Time    `json:"receivedAt"`
+	ExpiresAt      time.Time    `json:"expiresAt"`
+}
+
+// RequestPolicy decides how incoming swap requests are handled.
+type RequestPolicy struct {
+	// AutoAccept accepts requests without asking the user, if they're within MaxAmount.
+	AutoAccept bool
+	// MaxAmount is the most of our coin that an automatically accepted swap can use; 0 means no limit.
+	MaxAmount float64
+	// Timeout is how long a request waits to be accepted before it expires; defaults to DefaultRequestTimeout.
+	Timeout time.Duration
+}
+
+// accepts returns true if the request should be accepted without asking the user.
+func (p *RequestPolicy) accepts(req *SwapRequest) bool {
+	if !p.AutoAccept {
+		return false
+	}
+
+	return p.MaxAmount == 0 || req.DesiredAmount <= p.MaxAmount
+}
+
+// RequestQueue holds incoming swap requests until they're accepted, declined or expire.
+// It's safe for concurrent use.
+type RequestQueue struct {
+	policy RequestPolicy
+
+	sync.Mutex
+	pending map[SwapID]*pendingRequest
+}
+
+type pendingRequest struct {
+	*SwapRequest
+	decision chan bool
+}
+
+// NewRequestQueue returns a new *RequestQueue that handles requests according to the given policy.
+func NewRequestQueue(policy RequestPolicy) *RequestQueue {
+	if policy.Timeout == 0 {
+		policy.Timeout = DefaultRequestTimeout
+	}
+
+	return &RequestQueue{
+		policy:  policy,
+		pending: make(map[SwapID]*pendingRequest),
+	}
+}
+
+// Wait blocks until the request is accepted, in which case it returns nil.
+// It returns an error if the request is declined, expires, or the context is cancelled.
+// The request's ReceivedAt and ExpiresAt are set by Wait.
+func (q *RequestQueue) Wait(ctx context.Context, req *SwapRequest) error {
+	req.ReceivedAt = time.Now()
+	req.ExpiresAt = req.ReceivedAt.Add(q.policy.Timeout)
+
+	if q.policy.accepts(req) {
+		log.Infof("automatically accepted swap request: id=%s", req.ID)
+		return nil
+	}
+
+	p := &pendingRequest{
+		SwapRequest: req,
+		decision:    make(chan bool, 1),
+	}
+
+	q.Lock()
+	if _, has := q.pending[req.ID]; has {
+		q.Unlock()
+		return errRequestPending
+	}
+
+	q.pending[req.ID] = p
+	q.Unlock()
+
+	defer func() {
+		q.Lock()
+		delete(q.pending, req.ID)
+		q.Unlock()
+	}()
+
+	log.Infof("waiting for swap request to be accepted: id=%s expires=%s", req.ID, req.ExpiresAt.Format(time.RFC3339))
+
+	select {
+	case accepted := <-p.decision:
+		if !accepted {
+			return errRequestDeclined
+		}
+
+		return nil
+	case <-time.After(q.policy.Timeout):
+		return errRequestExpired
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Accept accepts the pending request with the given ID.
+func (q *RequestQueue) Accept(id SwapID) error {
+	return q.decide(id, true)
+}
+
+// Decline declines the pending request with the given ID.
+func (q *RequestQueue) Decline(id SwapID) error {
+	return q.decide(id, false)
+}
+
+func (q *RequestQueue) decide(id SwapID, accept bool) error {
+	q.Lock()
+	defer q.Unlock()
+
+	p, has := q.pending[id]
+	if !has {
+		return errNoRequest
+	}
+
+	// the request leaves the queue once Wait() returns, so it can only be decided once
+	delete(q.pending, id)
+	p.decision <- accept
+	return nil
+}
+
+// Pending returns the requests that are waiting to be accepted, oldest first.
+func (q *RequestQueue) Pending() []*SwapRequest {
+	q.Lock()
+	defer q.Unlock()
+
+	reqs := make([]*SwapRequest, 0, len(q.pending))
+	for _, p := range q.pending {
+		reqs = append(reqs, p.SwapRequest)
+	}
+
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].ReceivedAt.Before(reqs[j].ReceivedAt)
+	})
+
+	return reqs
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(t *testing.T, desiredAmount float64) *SwapRequest {
+	id, err := NewSwapID()
+	require.NoError(t, err)
+
+	return &SwapRequest{
+		ID:             id,
+		Provides:       ProvidesXMR,
+		ProvidesAmount: 1,
+		DesiredAmount:  desiredAmount,
+	}
+}
+
+// waitPending waits until the queue has n pending requests.
+func waitPending(t *testing.T, q *RequestQueue, n int) []*SwapRequest {
+	for i := 0; i < 100; i++ {
+		if reqs := q.Pending(); len(reqs) == n {
+			return reqs
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatalf("expected %d pending requests", n)
+	return nil
+}
+
+func TestRequestQueue_Accept(t *testing.T) {
+	q := NewRequestQueue(RequestPolicy{})
+	req := newTestRequest(t, 1)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- q.Wait(context.Background(), req)
+	}()
+
+	reqs := waitPending(t, q, 1)
+	require.Equal(t, req.ID, reqs[0].ID)
+	require.Equal(t, DefaultRequestTimeout, reqs[0].ExpiresAt.Sub(reqs[0].ReceivedAt))
+
+	require.NoError(t, q.Accept(req.ID))
+	require.NoError(t, <-errCh)
+	require.Empty(t, q.Pending())
+
+	// it can't be decided twice
+	require.Equal(t, errNoRequest, q.Decline(req.ID))
+}
+
+func TestRequestQueue_Decline(t *testing.T) {
+	q := NewRequestQueue(RequestPolicy{})
+	req := newTestRequest(t, 1)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- q.Wait(context.Background(), req)
+	}()
+
+	waitPending(t, q, 1)
+
+	// a request with the same ID can't be queued while it's pending
+	require.Equal(t, errRequestPending, q.Wait(context.Background(), req))
+
+	require.NoError(t, q.Decline(req.ID))
+	require.Equal(t, errRequestDeclined, <-errCh)
+}
+
+func TestRequestQueue_Expire(t *testing.T) {
+	q := NewRequestQueue(RequestPolicy{
+		Timeout: time.Millisecond * 50,
+	})
+
+	err := q.Wait(context.Background(), newTestRequest(t, 1))
+	require.Equal(t, errRequestExpired, err)
+	require.Empty(t, q.Pending())
+}
+
+func TestRequestQueue_AutoAccept(t *testing.T) {
+	q := NewRequestQueue(RequestPolicy{
+		AutoAccept: true,
+		MaxAmount:  2,
+		Timeout:    time.Millisecond * 50,
+	})
+
+	err := q.Wait(context.Background(), newTestRequest(t, 2))
+	require.NoError(t, err)
+
+	// requests over the maximum amount still need to be accepted by the user
+	err = q.Wait(context.Background(), newTestRequest(t, 3))
+	require.Equal(t, errRequestExpired, err)
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status is the stage a swap is at. Alice and Bob move through the same statuses,
+// in the order allowed by validTransitions.
+type Status byte
+
+const (
+	// ExpectingKeys means we're waiting for the counterparty's keys.
+	ExpectingKeys Status = iota
+	// KeysExchanged means both parties have each other's keys, and Alice can deploy the swap contract.
+	KeysExchanged
+	// ETHLocked means Alice has deployed the swap contract and locked her ETH in it.
+	ETHLocked
+	// XMRLocked means Bob has locked his XMR in the account shared by both parties.
+	XMRLocked
+	// ContractReady means Alice has called Ready() on the swap contract, so Bob can claim the ETH.
+	ContractReady
+	// CompletedSuccess means the swap succeeded: Bob has claimed the ETH,
+	// and, if we are Alice, we've created the wallet containing the XMR.
+	CompletedSuccess
+	// CompletedRefund means Alice has refunded her ETH, and, if we are Bob and locked our XMR,
+	// we've regained control over it.
+	CompletedRefund
+	// CompletedAbort means the swap ended before we locked any funds.
+	CompletedAbort
+)
+
+var statusNames = map[Status]string{
+	ExpectingKeys:    "ExpectingKeys",
+	KeysExchanged:    "KeysExchanged",
+	ETHLocked:        "ETHLocked",
+	XMRLocked:        "XMRLocked",
+	ContractReady:    "ContractReady",
+	CompletedSuccess: "CompletedSuccess",
+	CompletedRefund:  "CompletedRefund",
+	CompletedAbort:   "CompletedAbort",
+}
+
+// validTransitions maps each status to the statuses that can follow it.
+// Alice can deploy the contract before learning that Bob locked his XMR, and Bob can claim
+// after t0 without Alice calling Ready(), so the contract may be claimed or refunded
+// from any status after ETHLocked. Once the ETH is locked, the swap can't be aborted: it ends
+// with the ETH being claimed or refunded.
+var validTransitions = map[Status][]Status{
+	ExpectingKeys: {KeysExchanged, CompletedAbort},
+	KeysExchanged: {ETHLocked, CompletedAbort},
+	ETHLocked:     {XMRLocked, CompletedSuccess, CompletedRefund},
+	XMRLocked:     {ContractReady, CompletedSuccess, CompletedRefund},
+	ContractReady: {CompletedSuccess, CompletedRefund},
+}
+
+// ErrInvalidTransition is returned when moving a swap to a status that can't follow its current status.
+var ErrInvalidTransition = errors.New("invalid swap status transition")
+
+// NewStatus returns the Status with the given name.
+func NewStatus(s string) (Status, error) {
+	for status, name := range statusNames {
+		if name == s {
+			return status, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid swap status %q", s)
+}
+
+func (s Status) String() string {
+	if name, has := statusNames[s]; has {
+		return name
+	}
+
+	return fmt.Sprintf("Status(%d)", byte(s))
+}
+
+// IsComplete returns true if the swap has finished, and no further action is needed.
+func (s Status) IsComplete() bool {
+	switch s {
+	case CompletedSuccess, CompletedRefund, CompletedAbort:
+		return true
+	default:
+		return false
+	}
+}
+
+// CanTransitionTo returns true if a swap with this status can move to the given status.
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, status := range validTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarshalText encodes the status as its name; it's used for JSON encoding.
+func (s Status) MarshalText() ([]byte, error) {
+	if _, has := statusNames[s]; !has {
+		return nil, fmt.Errorf("invalid swap status %d", byte(s))
+	}
+
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a status from its name; it's used for JSON decoding.
+func (s *Status) UnmarshalText(text []byte) error {
+	status, err := NewStatus(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = status
+	return nil
+}
+
+// StatusTransition records when a swap entered a status.
+type StatusTransition struct {
+	Status Status    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// StatusTracker tracks the status of a swap, and the time it entered each status.
+// It's safe for concurrent use.
+type StatusTracker struct {
+	sync.RWMutex
+	history []StatusTransition
+	done    chan struct{} // closed once the swap is complete
+}
+
+// NewStatusTracker returns a new *StatusTracker for a swap that's just been initiated.
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{
+		history: []StatusTransition{{
+			Status: ExpectingKeys,
+			Time:   time.Now(),
+		}},
+		done: make(chan struct{}),
+	}
+}
+
+// NewStatusTrackerFromHistory returns a *StatusTracker for a swap with the given history,
+// as returned by History().
+func NewStatusTrackerFromHistory(history []StatusTransition) (*StatusTracker, error) {
+	if len(history) == 0 {
+		return NewStatusTracker(), nil
+	}
+
+	if history[0].Status != ExpectingKeys {
+		return nil, fmt.Errorf("swap history must start with %s, got %s", ExpectingKeys, history[0].Status)
+	}
+
+	for i := 1; i < len(history); i++ {
+		if !history[i-1].Status.CanTransitionTo(history[i].Status) {
+			return nil, fmt.Errorf("%w: from %s to %s", ErrInvalidTransition, history[i-1].Status, history[i].Status)
+		}
+	}
+
+	t := &StatusTracker{
+		history: append([]StatusTransition{}, history...),
+		done:    make(chan struct{}),
+	}
+
+	if t.history[len(t.history)-1].Status.IsComplete() {
+		close(t.done)
+	}
+
+	return t, nil
+}
+
+// Status returns the current status of the swap.
+func (t *StatusTracker) Status() Status {
+	t.RLock()
+	defer t.RUnlock()
+	return t.history[len(t.history)-1].Status
+}
+
+// History returns every status the swap has entered, in order.
+func (t *StatusTracker) History() []StatusTransition {
+	t.RLock()
+	defer t.RUnlock()
+	return append([]StatusTransition{}, t.history...)
+}
+
+// Transition moves the swap to the given status.
+// It returns ErrInvalidTransition if the status can't follow the current status.
+func (t *StatusTracker) Transition(next Status) error {
+	t.Lock()
+	defer t.Unlock()
+
+	current := t.history[len(t.history)-1].Status
+	if !current.CanTransitionTo(next) {
+		return fmt.Errorf("%w: from %s to %s", ErrInvalidTransition, current, next)
+	}
+
+	t.history = append(t.history, StatusTransition{
+		Status: next,
+		Time:   time.Now(),
+	})
+
+	// complete statuses can't be left, so this only happens once
+	if next.IsComplete() {
+		close(t.done)
+	}
+
+	return nil
+}
+
+// Done returns a channel that's closed once the swap is complete.
+func (t *StatusTracker) Done() <-chan struct{} {
+	return t.done
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusTracker_Transition(t *testing.T) {
+	tracker := NewStatusTracker()
+	require.Equal(t, ExpectingKeys, tracker.Status())
+
+	for _, status := range []Status{KeysExchanged, ETHLocked, XMRLocked, ContractReady, CompletedSuccess} {
+		err := tracker.Transition(status)
+		require.NoError(t, err)
+		require.Equal(t, status, tracker.Status())
+	}
+
+	require.True(t, tracker.Status().IsComplete())
+	require.Equal(t, 6, len(tracker.History()))
+
+	// can't leave a completed status
+	err := tracker.Transition(CompletedRefund)
+	require.True(t, errors.Is(err, ErrInvalidTransition))
+	require.Equal(t, CompletedSuccess, tracker.Status())
+}
+
+func TestStatusTracker_InvalidTransition(t *testing.T) {
+	tracker := NewStatusTracker()
+
+	// funds can't be claimed or refunded before they're locked
+	err := tracker.Transition(CompletedSuccess)
+	require.True(t, errors.Is(err, ErrInvalidTransition))
+	err = tracker.Transition(CompletedRefund)
+	require.True(t, errors.Is(err, ErrInvalidTransition))
+
+	// the swap can't be aborted once the ETH is locked
+	require.NoError(t, tracker.Transition(KeysExchanged))
+	for _, status := range []Status{ETHLocked, XMRLocked} {
+		require.NoError(t, tracker.Transition(status))
+		err = tracker.Transition(CompletedAbort)
+		require.True(t, errors.Is(err, ErrInvalidTransition))
+	}
+}
+
+func TestNewStatusTrackerFromHistory(t *testing.T) {
+	tracker := NewStatusTracker()
+	require.NoError(t, tracker.Transition(KeysExchanged))
+	require.NoError(t, tracker.Transition(CompletedAbort))
+
+	bz, err := json.Marshal(tracker.History())
+	require.NoError(t, err)
+	require.Contains(t, string(bz), `"status":"CompletedAbort"`)
+
+	var history []StatusTransition
+	err = json.Unmarshal(bz, &history)
+	require.NoError(t, err)
+
+	res, err := NewStatusTrackerFromHistory(history)
+	require.NoError(t, err)
+	require.Equal(t, CompletedAbort, res.Status())
+	require.Equal(t, 3, len(res.History()))
+
+	_, err = NewStatusTrackerFromHistory(history[1:])
+	require.Error(t, err)
+
+	_, err = NewStatusTrackerFromHistory([]StatusTransition{history[0], history[2], history[1]})
+	require.True(t, errors.Is(err, ErrInvalidTransition))
+}
+
+func TestStatusTracker_Done(t *testing.T) {
+	tracker := NewStatusTracker()
+	require.NoError(t, tracker.Transition(KeysExchanged))
+
+	select {
+	case <-tracker.Done():
+		t.Fatal("done before the swap completed")
+	default:
+	}
+
+	require.NoError(t, tracker.Transition(CompletedAbort))
+	<-tracker.Done()
+
+	// a swap loaded from a completed history is already done
+	loaded, err := NewStatusTrackerFromHistory(tracker.History())
+	require.NoError(t, err)
+	<-loaded.Done()
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// SwapID is a globally unique identifier for a swap.
+// It's chosen at random by the initiator and sent in the InitiateMessage, so both parties
+// refer to a swap by the same ID.
+type SwapID [32]byte
+
+var errInvalidSwapID = errors.New("swap ID must be a 32-byte hex string")
+
+// NewSwapID returns a new random swap ID.
+func NewSwapID() (SwapID, error) {
+	var id SwapID
+	if _, err := rand.Read(id[:]); err != nil {
+		return SwapID{}, fmt.Errorf("failed to generate swap ID: %w", err)
+	}
+
+	return id, nil
+}
+
+// NewSwapIDFromString parses a hex-encoded swap ID.
+func NewSwapIDFromString(s string) (SwapID, error) {
+	var id SwapID
+	if err := id.UnmarshalText([]byte(s)); err != nil {
+		return SwapID{}, err
+	}
+
+	return id, nil
+}
+
+// IsZero returns true if the ID is unset.
+func (id SwapID) IsZero() bool {
+	return id == SwapID{}
+}
+
+func (id SwapID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes the ID as a hex string; it's used for JSON encoding.
+func (id SwapID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes a hex string into the ID; it's used for JSON decoding.
+func (id *SwapID) UnmarshalText(text []byte) error {
+	if len(text) != hex.EncodedLen(len(id)) {
+		return errInvalidSwapID
+	}
+
+	if _, err := hex.Decode(id[:], text); err != nil {
+		return errInvalidSwapID
+	}
+
+	return nil
+}
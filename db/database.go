@@ -0,0 +1,191 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	logging "github.com/ipfs/go-log"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+)
+
+const (
+	swapsDir      = "swaps"
+	fileExtension = ".json"
+	filePerms     = keystore.FilePerms
+	dirPerms      = keystore.DirPerms
+)
+
+var (
+	log = logging.Logger("db")
+
+	// ErrNotFound is returned when the requested swap does not exist in the database.
+	ErrNotFound = errors.New("swap not found in database")
+)
+
+// Database is an on-disk store of swaps, located under the node's basepath.
+// Each swap is stored as its own JSON file, which is atomically replaced upon
+// every state transition.
+type Database struct {
+	sync.Mutex
+	dir string
+	ks  *keystore.Keystore
+}
+
+// NewDatabase returns a new *Database that stores swaps in <basepath>/swaps.
+// The private keys of each swap are encrypted with the given keystore.
+func NewDatabase(basepath string, ks *keystore.Keystore) (*Database, error) {
+	dir := filepath.Join(basepath, swapsDir)
+	if err := os.MkdirAll(dir, dirPerms); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	return &Database{
+		dir: dir,
+		ks:  ks,
+	}, nil
+}
+
+// PutSwap writes the given swap to the database, overwriting any previous entry with the same ID.
+func (db *Database) PutSwap(info *SwapInfo) error {
+	db.Lock()
+	defer db.Unlock()
+
+	secrets, err := json.Marshal(&swapSecrets{
+		PrivateSpendKey:            info.PrivateSpendKey,
+		PrivateViewKey:             info.PrivateViewKey,
+		CounterpartyPrivateViewKey: info.CounterpartyPrivateViewKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	encSecrets, err := db.ks.Encrypt(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt swap secrets: %w", err)
+	}
+
+	bz, err := json.MarshalIndent(&storedSwap{
+		SwapInfo: info,
+		Secrets:  encSecrets,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that a crash never leaves a partially written entry
+	path := db.path(info.ID)
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bz, filePerms); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// GetSwap returns the swap with the given ID.
+func (db *Database) GetSwap(id common.SwapID) (*SwapInfo, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.getSwap(db.path(id))
+}
+
+// GetAllSwaps returns every swap in the database, ordered by the time they were created.
+func (db *Database) GetAllSwaps() ([]*SwapInfo, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	files, err := ioutil.ReadDir(db.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	swaps := []*SwapInfo{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExtension) {
+			continue
+		}
+
+		info, err := db.getSwap(filepath.Join(db.dir, f.Name()))
+		if err != nil {
+			log.Warnf("failed to read swap from database: file=%s err=%s", f.Name(), err)
+			continue
+		}
+
+		swaps = append(swaps, info)
+	}
+
+	sort.Slice(swaps, func(i, j int) bool {
+		return swaps[i].CreatedAt.Before(swaps[j].CreatedAt)
+	})
+
+	return swaps, nil
+}
+
+// GetOngoingSwaps returns every swap in the database that has not yet completed.
+func (db *Database) GetOngoingSwaps() ([]*SwapInfo, error) {
+	swaps, err := db.GetAllSwaps()
+	if err != nil {
+		return nil, err
+	}
+
+	ongoing := []*SwapInfo{}
+	for _, info := range swaps {
+		if !info.Status.IsComplete() {
+			ongoing = append(ongoing, info)
+		}
+	}
+
+	return ongoing, nil
+}
+
+func (db *Database) getSwap(path string) (*SwapInfo, error) {
+	bz, err := ioutil.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored *storedSwap
+	if err = json.Unmarshal(bz, &stored); err != nil {
+		return nil, err
+	}
+
+	if stored == nil || stored.SwapInfo == nil {
+		return nil, errors.New("empty swap entry")
+	}
+
+	// entries written before secrets were encrypted have them in plaintext
+	secretsBz := bz
+	if len(stored.Secrets) != 0 {
+		secretsBz, err = db.ks.Decrypt(stored.Secrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt swap secrets: %w", err)
+		}
+	}
+
+	var secrets swapSecrets
+	if err = json.Unmarshal(secretsBz, &secrets); err != nil {
+		return nil, err
+	}
+
+	info := stored.SwapInfo
+	info.PrivateSpendKey = secrets.PrivateSpendKey
+	info.PrivateViewKey = secrets.PrivateViewKey
+	info.CounterpartyPrivateViewKey = secrets.CounterpartyPrivateViewKey
+	return info, nil
+}
+
+func (db *Database) path(id common.SwapID) string {
+	return filepath.Join(db.dir, id.String()+fileExtension)
+}
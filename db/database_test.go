@@ -0,0 +1,100 @@
+package db
+
+import (
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeystore(t *testing.T) *keystore.Keystore {
+	ks, err := keystore.NewKeystore([]byte("password"))
+	require.NoError(t, err)
+	return ks
+}
+
+func TestDatabase_PutGetSwap(t *testing.T) {
+	db, err := NewDatabase(t.TempDir(), newTestKeystore(t))
+	require.NoError(t, err)
+
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+
+	_, err = db.GetSwap(id)
+	require.Equal(t, ErrNotFound, err)
+
+	info := &SwapInfo{
+		ID:              id,
+		Provides:        common.ProvidesETH,
+		ETHAmount:       big.NewInt(1000),
+		XMRAmount:       common.MoneroAmount(33),
+		PrivateSpendKey: "deadbeef",
+		PrivateViewKey:  "beefdead",
+		ContractAddress: "0xabcd",
+		Timeout0:        time.Unix(100, 0).UTC(),
+		Timeout1:        time.Unix(200, 0).UTC(),
+		DeployTxHash:    "0x1234",
+	}
+
+	err = db.PutSwap(info)
+	require.NoError(t, err)
+
+	res, err := db.GetSwap(id)
+	require.NoError(t, err)
+	require.Equal(t, info, res)
+
+	// private keys aren't stored in plaintext
+	bz, err := ioutil.ReadFile(db.path(id))
+	require.NoError(t, err)
+	require.NotContains(t, string(bz), info.PrivateSpendKey)
+	require.NotContains(t, string(bz), info.PrivateViewKey)
+
+	info.Status = common.CompletedSuccess
+	err = db.PutSwap(info)
+	require.NoError(t, err)
+
+	res, err = db.GetSwap(id)
+	require.NoError(t, err)
+	require.Equal(t, common.CompletedSuccess, res.Status)
+}
+
+func TestDatabase_GetOngoingSwaps(t *testing.T) {
+	db, err := NewDatabase(t.TempDir(), newTestKeystore(t))
+	require.NoError(t, err)
+
+	ids := make([]common.SwapID, 4)
+	for i := range ids {
+		ids[i], err = common.NewSwapID()
+		require.NoError(t, err)
+
+		swap := &SwapInfo{
+			ID:        ids[i],
+			Status:    common.ETHLocked,
+			CreatedAt: time.Unix(int64(i), 0),
+		}
+		if i%2 == 0 {
+			swap.Status = common.CompletedRefund
+		}
+
+		err = db.PutSwap(swap)
+		require.NoError(t, err)
+	}
+
+	all, err := db.GetAllSwaps()
+	require.NoError(t, err)
+	require.Equal(t, 4, len(all))
+	for i, info := range all {
+		require.Equal(t, ids[i], info.ID)
+	}
+
+	ongoing, err := db.GetOngoingSwaps()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ongoing))
+	require.Equal(t, ids[1], ongoing[0].ID)
+	require.Equal(t, ids[3], ongoing[1].ID)
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+// SwapInfo contains everything needed to resume a swap after the daemon restarts.
+// Keys are hex-encoded; amounts are in the smallest units of each coin (wei and piconero).
+// Private keys are encrypted with the database's keystore before being written to disk.
+type SwapInfo struct {
+	ID       common.SwapID
+	Provides common.ProvidesCoin
+
+	ETHAmount *big.Int
+	XMRAmount common.MoneroAmount
+
+	// our keys for this swap
+	PrivateSpendKey string `json:"-"`
+	PrivateViewKey  string `json:"-"`
+
+	// counterparty's keys and address for this swap
+	CounterpartyPublicSpendKey string
+	CounterpartyPublicViewKey  string
+	CounterpartyPrivateViewKey string `json:"-"`
+	CounterpartyEthAddress     string
+	CounterpartyPeerID         string
+
+	// swap contract and timeouts in it; set once contract is deployed
+	ContractAddress    string
+	Timeout0, Timeout1 time.Time
+
+	// hashes of the transactions sent for this swap; empty if not sent, or not known to us
+	DeployTxHash string
+	ReadyTxHash  string
+	ClaimTxHash  string
+	RefundTxHash string
+
+	// current status of the swap, and the time it entered each status
+	Status        common.Status
+	StatusHistory []common.StatusTransition
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// swapSecrets are the fields of SwapInfo that are encrypted at rest.
+type swapSecrets struct {
+	PrivateSpendKey            string
+	PrivateViewKey             string
+	CounterpartyPrivateViewKey string
+}
+
+// storedSwap is the on-disk format of a swap.
+type storedSwap struct {
+	*SwapInfo
+	Secrets json.RawMessage `json:",omitempty"`
+}
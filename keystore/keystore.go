@@ -0,0 +1,238 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	version = 1
+	kdfName = "scrypt"
+
+	// the scrypt parameters used by go-ethereum's keystore
+	standardScryptN = 1 << 18
+	standardScryptR = 8
+	standardScryptP = 1
+
+	keyLen  = 32
+	saltLen = 32
+
+	// FilePerms are the permissions used for files written by the keystore.
+	FilePerms = 0600
+	// DirPerms are the permissions used for directories created by the keystore.
+	DirPerms = 0700
+)
+
+var (
+	// ErrInvalidPassword is returned when data can't be decrypted with the keystore's password.
+	ErrInvalidPassword = errors.New("failed to decrypt: invalid password or corrupted data")
+
+	errNotEncrypted = errors.New("data was not encrypted by the keystore")
+)
+
+type scryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// encryptedData is the on-disk format of an encrypted secret. It contains everything
+// needed to decrypt the secret given the password, so files can be moved between machines.
+type encryptedData struct {
+	Version    int          `json:"version"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdfparams"`
+	Salt       []byte       `json:"salt"`
+	Nonce      []byte       `json:"nonce"`
+	Ciphertext []byte       `json:"ciphertext"`
+}
+
+// Keystore encrypts and decrypts secrets with a key derived from a password using scrypt.
+// Secrets are encrypted with AES-256-GCM.
+//
+// Deriving a key is deliberately slow, so each Keystore derives the key for its own salt once,
+// and caches keys derived for data written with other salts (ie. by previous runs).
+type Keystore struct {
+	password []byte
+	params   scryptParams
+	salt     []byte
+
+	sync.Mutex
+	keys map[string][]byte // salt -> key
+}
+
+// NewKeystore returns a new *Keystore that encrypts secrets with the given password.
+func NewKeystore(password []byte) (*Keystore, error) {
+	return newKeystore(password, scryptParams{
+		N: standardScryptN,
+		R: standardScryptR,
+		P: standardScryptP,
+	})
+}
+
+func newKeystore(password []byte, params scryptParams) (*Keystore, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	ks := &Keystore{
+		password: password,
+		params:   params,
+		salt:     salt,
+		keys:     make(map[string][]byte),
+	}
+
+	if _, err := ks.deriveKey(salt, params); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+func (ks *Keystore) deriveKey(salt []byte, params scryptParams) ([]byte, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if key, has := ks.keys[string(salt)]; has {
+		return key, nil
+	}
+
+	key, err := scrypt.Key(ks.password, salt, params.N, params.R, params.P, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	ks.keys[string(salt)] = key
+	return key, nil
+}
+
+// Encrypt encrypts the given data. The result is JSON-encoded.
+func (ks *Keystore) Encrypt(plaintext []byte) ([]byte, error) {
+	key, err := ks.deriveKey(ks.salt, ks.params)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&encryptedData{
+		Version:    version,
+		KDF:        kdfName,
+		KDFParams:  ks.params,
+		Salt:       ks.salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	})
+}
+
+// Decrypt decrypts data that was returned by Encrypt.
+func (ks *Keystore) Decrypt(data []byte) ([]byte, error) {
+	var enc *encryptedData
+	if err := json.Unmarshal(data, &enc); err != nil || enc == nil || enc.KDF == "" {
+		return nil, errNotEncrypted
+	}
+
+	if enc.Version != version || enc.KDF != kdfName {
+		return nil, fmt.Errorf("unsupported encryption: version=%d kdf=%s", enc.Version, enc.KDF)
+	}
+
+	// don't let a crafted file make us use an arbitrary amount of memory
+	if enc.KDFParams.N > standardScryptN || enc.KDFParams.R > standardScryptR || enc.KDFParams.P > standardScryptP {
+		return nil, errors.New("unsupported scrypt parameters")
+	}
+
+	key, err := ks.deriveKey(enc.Salt, enc.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, ErrInvalidPassword
+	}
+
+	plaintext, err := aead.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidPassword
+	}
+
+	return plaintext, nil
+}
+
+// IsEncrypted returns true if the given data is in the format returned by Encrypt.
+func IsEncrypted(data []byte) bool {
+	var enc *encryptedData
+	if err := json.Unmarshal(data, &enc); err != nil || enc == nil {
+		return false
+	}
+
+	return enc.KDF != "" && len(enc.Ciphertext) != 0
+}
+
+// WriteFile encrypts the given data and writes it to the given path, creating any missing directories.
+// The file is only readable by the current user, and is atomically replaced if it already exists.
+func (ks *Keystore) WriteFile(path string, plaintext []byte) error {
+	bz, err := ks.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), DirPerms); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(filepath.Clean(tmp), bz, FilePerms); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ReadFile reads and decrypts a file written by WriteFile.
+// Files that were written in plaintext, before the keystore existed, are returned as-is.
+func (ks *Keystore) ReadFile(path string) ([]byte, error) {
+	bz, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsEncrypted(bz) {
+		return bytes.TrimSpace(bz), nil
+	}
+
+	return ks.Decrypt(bz)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,72 @@
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// light parameters, so that tests run quickly
+var testParams = scryptParams{
+	N: 1 << 12,
+	R: 8,
+	P: 1,
+}
+
+func newTestKeystore(t *testing.T, password string) *Keystore {
+	ks, err := newKeystore([]byte(password), testParams)
+	require.NoError(t, err)
+	return ks
+}
+
+func TestKeystore_EncryptDecrypt(t *testing.T) {
+	ks := newTestKeystore(t, "password")
+
+	secret := []byte("noot")
+	enc, err := ks.Encrypt(secret)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(enc))
+	require.NotContains(t, string(enc), string(secret))
+
+	res, err := ks.Decrypt(enc)
+	require.NoError(t, err)
+	require.Equal(t, secret, res)
+
+	// a different keystore with the same password can decrypt it
+	res, err = newTestKeystore(t, "password").Decrypt(enc)
+	require.NoError(t, err)
+	require.Equal(t, secret, res)
+
+	_, err = newTestKeystore(t, "wrong").Decrypt(enc)
+	require.Equal(t, ErrInvalidPassword, err)
+}
+
+func TestKeystore_WriteReadFile(t *testing.T) {
+	ks := newTestKeystore(t, "password")
+
+	path := filepath.Join(t.TempDir(), "dir", "secret.key")
+	err := ks.WriteFile(path, []byte("noot"))
+	require.NoError(t, err)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(FilePerms), fi.Mode().Perm())
+
+	fi, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(DirPerms), fi.Mode().Perm())
+
+	res, err := ks.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("noot"), res)
+
+	// plaintext files are read as-is
+	err = ioutil.WriteFile(path, []byte("{\"a\":\"b\"}\n"), FilePerms)
+	require.NoError(t, err)
+	res, err = ks.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("{\"a\":\"b\"}"), res)
+}
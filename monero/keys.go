@@ -1,34 +1,54 @@
 package monero
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
 )
 
-// WriteKeysToFile writes the given private key pair to a file within the given path.
-func WriteKeysToFile(basepath string, keys *PrivateKeyPair, env common.Environment) error {
+// WriteKeysToFile encrypts the given private key pair with the keystore and writes it to a file within the given path.
+func WriteKeysToFile(basepath string, keys *PrivateKeyPair, env common.Environment, ks *keystore.Keystore) error {
 	t := time.Now().Format("2006-Jan-2-15:04:05")
 	path := fmt.Sprintf("%s-%s.key", basepath, t)
 
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+	bz, err := keys.Marshal(env)
+	if err != nil {
 		return err
 	}
 
-	file, err := os.Create(filepath.Clean(path))
+	return ks.WriteFile(path, bz)
+}
+
+// ReadKeysFromFile reads and decrypts a private key pair that was written by WriteKeysToFile.
+func ReadKeysFromFile(path string, ks *keystore.Keystore) (*PrivateKeyPair, error) {
+	bz, err := ks.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	bz, err := keys.Marshal(env)
+	m := make(map[string]string)
+	if err = json.Unmarshal(bz, &m); err != nil {
+		return nil, err
+	}
+
+	if m["PrivateSpendKey"] == "" || m["PrivateViewKey"] == "" {
+		return nil, errors.New("key file is missing private spend or view key")
+	}
+
+	sk, err := hex.DecodeString(m["PrivateSpendKey"])
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	vk, err := hex.DecodeString(m["PrivateViewKey"])
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = file.Write(bz)
-	return err
+	return NewPrivateKeyPairFromBytes(sk, vk)
 }
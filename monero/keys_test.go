@@ -0,0 +1,38 @@
+package monero
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteKeysToFile_ReadKeysFromFile(t *testing.T) {
+	kp, err := GenerateKeys()
+	require.NoError(t, err)
+
+	ks, err := keystore.NewKeystore([]byte("password"))
+	require.NoError(t, err)
+
+	basepath := filepath.Join(t.TempDir(), "test-secret")
+	err = WriteKeysToFile(basepath, kp, common.Development, ks)
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(basepath + "-*.key")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	bz, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	require.True(t, keystore.IsEncrypted(bz))
+	require.NotContains(t, string(bz), kp.SpendKey().Hex())
+
+	res, err := ReadKeysFromFile(files[0], ks)
+	require.NoError(t, err)
+	require.Equal(t, kp.SpendKey().Hex(), res.SpendKey().Hex())
+	require.Equal(t, kp.ViewKey().Hex(), res.ViewKey().Hex())
+}
@@ -25,10 +25,19 @@ type discovery struct {
 	dht      *dual.DHT
 	h        libp2phost.Host
 	rd       *libp2pdiscovery.RoutingDiscovery
+	prefix   string
 	provides []common.ProvidesCoin
+
+	// signals the advertise loop to advertise again without waiting for the TTL, eg. when a new
+	// peer joins the local DHT
+	readvertise chan struct{}
 }
 
-func newDiscovery(ctx context.Context, h libp2phost.Host, bnsFunc func() []peer.AddrInfo, provides ...common.ProvidesCoin) (*discovery, error) {
+// newDiscovery returns a DHT discovery service. Coins are advertised and looked up under the given
+// prefix, so that only peers that speak the same protocol on the same network find each other.
+// If provides is empty, we don't advertise at all.
+func newDiscovery(ctx context.Context, h libp2phost.Host, bnsFunc func() []peer.AddrInfo, prefix string,
+	provides ...common.ProvidesCoin) (*discovery, error) {
 	dhtOpts := []dual.Option{
 		dual.DHTOption(kaddht.BootstrapPeersFunc(bnsFunc)),
 		dual.DHTOption(kaddht.Mode(kaddht.ModeAutoServer)),
@@ -46,7 +55,10 @@ func newDiscovery(ctx context.Context, h libp2phost.Host, bnsFunc func() []peer.
 		dht:      dht,
 		h:        h,
 		rd:       rd,
+		prefix:   prefix,
 		provides: provides,
+
+		readvertise: make(chan struct{}, 1),
 	}, nil
 }
 
@@ -56,9 +68,11 @@ func (d *discovery) start() error {
 		return fmt.Errorf("failed to bootstrap DHT: %w", err)
 	}
 
-	// wait to connect to bootstrap peers
-	time.Sleep(time.Second)
-	go d.advertise()
+	if len(d.provides) != 0 {
+		// wait to connect to bootstrap peers
+		time.Sleep(time.Second)
+		go d.advertise()
+	}
 
 	log.Debug("discovery started!")
 	return nil
@@ -74,30 +88,45 @@ func (d *discovery) advertise() {
 	for {
 		select {
 		case <-time.After(ttl):
-			log.Debug("advertising in the DHT...")
-			err := d.dht.Bootstrap(d.ctx)
-			if err != nil {
-				log.Warnf("failed to bootstrap DHT: err=%s", err)
-				continue
-			}
-
-			for _, provides := range d.provides {
-				ttl, err = d.rd.Advertise(d.ctx, string(provides))
-				if err != nil {
-					log.Debugf("failed to advertise in the DHT: err=%s", err)
-					ttl = tryAdvertiseTimeout
-				}
-			}
+		case <-d.readvertise:
 		case <-d.ctx.Done():
 			return
 		}
+
+		log.Debug("advertising in the DHT...")
+		err := d.dht.Bootstrap(d.ctx)
+		if err != nil {
+			log.Warnf("failed to bootstrap DHT: err=%s", err)
+			continue
+		}
+
+		for _, provides := range d.provides {
+			ttl, err = d.rd.Advertise(d.ctx, d.namespace(provides))
+			if err != nil {
+				log.Debugf("failed to advertise in the DHT: err=%s", err)
+				ttl = tryAdvertiseTimeout
+			}
+		}
 	}
 }
 
+// advertiseNow makes us advertise again as soon as possible, if we advertise at all.
+func (d *discovery) advertiseNow() {
+	select {
+	case d.readvertise <- struct{}{}:
+	default:
+	}
+}
+
+// namespace returns the DHT namespace that providers of the given coin advertise under.
+func (d *discovery) namespace(provides common.ProvidesCoin) string {
+	return fmt.Sprintf("%s/%s", d.prefix, provides)
+}
+
 func (d *discovery) discover(provides common.ProvidesCoin, searchTime time.Duration) ([]peer.AddrInfo, error) { //nolint:unused
 	log.Debugf("attempting to find DHT peers that provide %s for %s...", provides, searchTime)
 
-	peerCh, err := d.rd.FindPeers(d.ctx, string(provides))
+	peerCh, err := d.rd.FindPeers(d.ctx, d.namespace(provides))
 	if err != nil {
 		return nil, err
 	}
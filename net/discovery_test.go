@@ -0,0 +1,18 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func TestDiscovery_Namespace(t *testing.T) {
+	dev := &discovery{prefix: "/atomic-swap/dev/1337" + swapID}
+	stagenet := &discovery{prefix: "/atomic-swap/stagenet/3" + swapID}
+
+	require.Equal(t, "/atomic-swap/dev/1337/swap/0/XMR", dev.namespace(common.ProvidesXMR))
+	require.NotEqual(t, dev.namespace(common.ProvidesXMR), stagenet.namespace(common.ProvidesXMR))
+	require.NotEqual(t, dev.namespace(common.ProvidesXMR), dev.namespace(common.ProvidesETH))
+}
@@ -4,27 +4,46 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/noot/atomic-swap/common"
 
-	"github.com/libp2p/go-libp2p"
+	connmgr "github.com/libp2p/go-libp2p-connmgr"
+	"github.com/libp2p/go-libp2p-core/crypto"
 	libp2phost "github.com/libp2p/go-libp2p-core/host"
 	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	ma "github.com/multiformats/go-multiaddr"
 
 	logging "github.com/ipfs/go-log"
 )
 
 const (
-	protocolID     = "/atomic-swap"
-	maxReads       = 128
-	defaultKeyFile = "net.key"
+	protocolID            = "/atomic-swap"
+	defaultKeyFile        = "net.key"
+	defaultMaxSwaps       = 10
+	defaultMaxMessageSize = 1 << 20 // 1 MiB
+	defaultMaxConns       = 200
+
+	// defaultMessageTimeout is how long we wait for each message of a swap. It's longer than the
+	// time a swap request waits to be accepted, and than locking either coin takes.
+	defaultMessageTimeout = time.Minute * 30
+
+	// writeTimeout is how long writing a message to a stream can take
+	writeTimeout = time.Second * 30
+
+	// connGracePeriod is how long new connections are kept before they can be trimmed
+	connGracePeriod = time.Minute
 )
 
+var errMessageTooLarge = errors.New("message is larger than the maximum message size")
+
 var log = logging.Logger("net")
 var _ Host = &host{}
 
@@ -34,12 +53,19 @@ type Host interface {
 
 	Discover(provides common.ProvidesCoin, searchTime time.Duration) ([]peer.AddrInfo, error)
 	Query(who peer.AddrInfo) (*QueryResponse, error)
-	Initiate(who peer.AddrInfo, msg *InitiateMessage, s SwapState) error
+	MakeOffer(terms *Offer, ttl time.Duration) (*Offer, error)
+	Offers() []*Offer
+	OrderBook(provides common.ProvidesCoin) []*Offer
+	Initiate(who peer.AddrInfo, terms *Terms, initiate InitiateFunc) error
+	Peers() []*PeerRecord
+	BanPeer(who peer.ID) error
+	UnbanPeer(who peer.ID) error
 	MessageSender
 }
 
 type MessageSender interface {
-	SendSwapMessage(Message) error
+	SendSwapMessage(id common.SwapID, msg Message) error
+	CloseSwap(id common.SwapID) error
 }
 
 type host struct {
@@ -47,33 +73,107 @@ type host struct {
 	cancel     context.CancelFunc
 	protocolID string
 
-	h             libp2phost.Host
-	bootnodes     []peer.AddrInfo
-	queryResponse *QueryResponse
-	discovery     *discovery
-	handler       Handler
-
-	// swap instance info
-	swapMu     sync.Mutex
-	swapState  SwapState
-	swapStream libp2pnetwork.Stream
-
-	queryMu  sync.Mutex
-	queryBuf []byte
+	h         libp2phost.Host
+	key       crypto.PrivKey
+	bootnodes []peer.AddrInfo
+	discovery *discovery
+	handler   Handler
+
+	// finds peers on the local network, if enabled
+	useMDNS bool
+	mdns    mdns.Service
+
+	// relays that we reserve a slot with, our relay service if we run one, and whether we try to
+	// upgrade relayed connections to direct ones
+	relays       []peer.AddrInfo
+	relayService bool
+	relay        *relayv2.Relay
+	holePunching bool
+
+	// scores peers, and decides who can connect to us, query us and start swaps with us
+	reputation *reputation
+
+	// limits how often, and how much, each peer can use our query and swap streams
+	limiter *peerLimiter
+
+	// our live offers, keyed by offer ID, and the amount of each that's been taken by swaps that
+	// weren't aborted or refunded
+	offerMu   sync.Mutex
+	offers    map[OfferID]*Offer
+	committed map[OfferID]float64
+
+	// offers broadcast by makers on the network
+	book        *orderBook
+	offersTopic *pubsub.Topic
+	noAdvertise bool
+
+	// swap instance info, keyed by swap ID, and the number of slots reserved for swaps whose stream
+	// is open but that haven't been added yet
+	swapMu        sync.Mutex
+	swaps         map[common.SwapID]*swap
+	maxSwaps      int
+	reservedSwaps int
+
+	// largest message we accept from peers
+	maxMessageSize uint64
+
+	// how long we wait for each message of a swap before giving up on it
+	messageTimeout time.Duration
+
+	// number of proposals after which we give up negotiating a swap
+	maxNegotiationRounds uint32
 }
 
 // Config is used to configure the network Host.
 type Config struct {
-	Ctx           context.Context
-	Environment   common.Environment
-	ChainID       int64
-	Port          uint64
-	Provides      []common.ProvidesCoin
-	MaximumAmount []float64
-	ExchangeRate  common.ExchangeRate
-	KeyFile       string
-	Bootnodes     []string
-	Handler       Handler
+	Ctx            context.Context
+	Environment    common.Environment
+	ChainID        int64
+	Port           uint64
+	Provides       []common.ProvidesCoin // coins we advertise in the DHT
+	KeyFile        string
+	Bootnodes      []string
+	Handler        Handler
+	MaxSwaps       int    // maximum number of concurrent swaps; defaults to 10
+	MaxMessageSize uint64 // largest message accepted from peers, in bytes; defaults to 1 MiB
+	// MaxNegotiationRounds is the number of proposals, including counter-proposals, after which
+	// we give up negotiating a swap; defaults to 4
+	MaxNegotiationRounds uint32
+	// NoAdvertise stops us from advertising the coins we provide in the DHT, and from broadcasting our
+	// offers; only peers that already know our address can query us for our offers.
+	NoAdvertise bool
+	// MDNS enables discovery of peers on the local network that use the same protocol ID, for LAN
+	// and test networks where there are no bootnodes.
+	MDNS bool
+	// Relays are the multiaddrs of circuit relays to reserve a slot with, for when we can't be
+	// reached directly. We then advertise addresses through them, instead of our public addresses.
+	Relays []string
+	// RelayService makes us run a circuit relay for peers that can't be reached directly. We must
+	// be publicly reachable to be useful as a relay.
+	RelayService bool
+	// NoHolePunching stops us from trying to upgrade relayed connections to direct ones.
+	NoHolePunching bool
+	// PeersFile is where our records of peers' behaviour are saved; if it's empty, they're only
+	// kept in memory.
+	PeersFile string
+	// BanThreshold is the score at or below which peers are banned; defaults to -20.
+	BanThreshold int
+	// AllowList holds the only peer IDs that can query us or start swaps with us, if it's not empty.
+	AllowList []string
+	// MessageTimeout is how long we wait for each message of a swap before giving up on it, so that
+	// a silent peer can't hold a swap open until its timelocks expire; defaults to 30 minutes.
+	MessageTimeout time.Duration
+	// MaxQueriesPerMinute is how many times each peer can query us per minute; defaults to 30.
+	MaxQueriesPerMinute int
+	// MaxSwapStreamsPerMinute is how many swap streams each peer can open with us per minute;
+	// defaults to 5.
+	MaxSwapStreamsPerMinute int
+	// MaxSwapsPerPeer is how many swap streams each peer can have open with us at once, including
+	// those still negotiating; defaults to 2.
+	MaxSwapsPerPeer int
+	// MaxConns is the number of connections above which the connection manager closes the least
+	// useful ones, down to three quarters of it; defaults to 200.
+	MaxConns int
 }
 
 func NewHost(cfg *Config) (*host, error) {
@@ -81,6 +181,42 @@ func NewHost(cfg *Config) (*host, error) {
 		cfg.KeyFile = defaultKeyFile
 	}
 
+	if cfg.MaxSwaps == 0 {
+		cfg.MaxSwaps = defaultMaxSwaps
+	}
+
+	if cfg.MaxMessageSize == 0 {
+		cfg.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	if cfg.MaxNegotiationRounds == 0 {
+		cfg.MaxNegotiationRounds = defaultMaxNegotiationRounds
+	}
+
+	if cfg.BanThreshold == 0 {
+		cfg.BanThreshold = defaultBanThreshold
+	}
+
+	if cfg.MessageTimeout == 0 {
+		cfg.MessageTimeout = defaultMessageTimeout
+	}
+
+	if cfg.MaxQueriesPerMinute == 0 {
+		cfg.MaxQueriesPerMinute = defaultMaxQueriesPerMinute
+	}
+
+	if cfg.MaxSwapStreamsPerMinute == 0 {
+		cfg.MaxSwapStreamsPerMinute = defaultMaxSwapStreamsPerMinute
+	}
+
+	if cfg.MaxSwapsPerPeer == 0 {
+		cfg.MaxSwapsPerPeer = defaultMaxSwapsPerPeer
+	}
+
+	if cfg.MaxConns == 0 {
+		cfg.MaxConns = defaultMaxConns
+	}
+
 	key, err := loadKey(cfg.KeyFile)
 	if err != nil {
 		fmt.Println("failed to load libp2p key, generating key...", cfg.KeyFile)
@@ -95,43 +231,82 @@ func NewHost(cfg *Config) (*host, error) {
 		return nil, err
 	}
 
-	// set libp2p host options
-	opts := []libp2p.Option{
-		libp2p.ListenAddrs(addr),
-		libp2p.DisableRelay(),
-		libp2p.Identity(key),
-		libp2p.NATPortMap(),
-	}
-
 	// format bootnodes
 	bns, err := stringsToAddrInfos(cfg.Bootnodes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to format bootnodes: %w", err)
 	}
 
+	relays, err := stringsToAddrInfos(cfg.Relays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format relays: %w", err)
+	}
+
+	allowList := make([]peer.ID, len(cfg.AllowList))
+	for i, s := range cfg.AllowList {
+		if allowList[i], err = peer.Decode(s); err != nil {
+			return nil, fmt.Errorf("invalid peer ID %q in allow-list: %w", s, err)
+		}
+	}
+
+	rep, err := newReputation(cfg.PeersFile, cfg.BanThreshold, allowList)
+	if err != nil {
+		return nil, err
+	}
+
 	// create libp2p host instance
-	h, err := libp2p.New(context.Background(), opts...)
+	h, err := newLibp2pHost(&libp2pConfig{
+		key:          key,
+		listenAddrs:  []ma.Multiaddr{addr},
+		natPortMap:   true,
+		relays:       relays,
+		holePunching: !cfg.NoHolePunching,
+		gater:        rep,
+		connMgr:      connmgr.NewConnManager(cfg.MaxConns*3/4, cfg.MaxConns, connGracePeriod),
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// the gater stops banned peers from connecting, but they may already be connected
+	rep.onBan = func(who peer.ID) {
+		_ = h.Network().ClosePeer(who)
+	}
+
 	ourCtx, cancel := context.WithCancel(cfg.Ctx)
 	hst := &host{
-		ctx:        ourCtx,
-		cancel:     cancel,
-		protocolID: fmt.Sprintf("%s/%s/%d", protocolID, cfg.Environment, cfg.ChainID),
-		h:          h,
-		queryResponse: &QueryResponse{
-			Provides:      cfg.Provides,
-			MaximumAmount: cfg.MaximumAmount,
-			ExchangeRate:  cfg.ExchangeRate,
-		},
-		handler:   cfg.Handler,
-		bootnodes: bns,
-		queryBuf:  make([]byte, 2048),
-	}
-
-	hst.discovery, err = newDiscovery(ourCtx, h, hst.getBootnodes, cfg.Provides...)
+		ctx:            ourCtx,
+		cancel:         cancel,
+		protocolID:     fmt.Sprintf("%s/%s/%d", protocolID, cfg.Environment, cfg.ChainID),
+		h:              h,
+		key:            key,
+		offers:         make(map[OfferID]*Offer),
+		committed:      make(map[OfferID]float64),
+		book:           newOrderBook(maxOrderBookSize),
+		noAdvertise:    cfg.NoAdvertise,
+		useMDNS:        cfg.MDNS,
+		relays:         relays,
+		relayService:   cfg.RelayService,
+		holePunching:   !cfg.NoHolePunching,
+		reputation:     rep,
+		limiter:        newPeerLimiter(cfg.MaxQueriesPerMinute, cfg.MaxSwapStreamsPerMinute, cfg.MaxSwapsPerPeer),
+		handler:        cfg.Handler,
+		bootnodes:      bns,
+		swaps:          make(map[common.SwapID]*swap),
+		maxSwaps:       cfg.MaxSwaps,
+		maxMessageSize: cfg.MaxMessageSize,
+		messageTimeout: cfg.MessageTimeout,
+
+		maxNegotiationRounds: cfg.MaxNegotiationRounds,
+	}
+
+	provides := cfg.Provides
+	if cfg.NoAdvertise {
+		provides = nil
+	}
+
+	// advertisements are scoped to the network and the version of the swap protocol
+	hst.discovery, err = newDiscovery(ourCtx, h, hst.getBootnodes, hst.protocolID+swapID, provides...)
 	if err != nil {
 		return nil, err
 	}
@@ -143,19 +318,38 @@ func (h *host) Start() error {
 	h.h.SetStreamHandler(protocol.ID(h.protocolID+queryID), h.handleQueryStream)
 	h.h.SetStreamHandler(protocol.ID(h.protocolID+swapID), h.handleProtocolStream)
 
-	h.h.Network().SetConnHandler(h.handleConn)
+	h.h.Network().Notify(&libp2pnetwork.NotifyBundle{ConnectedF: h.handleConn})
 	for _, addr := range h.multiaddrs() {
 		log.Info("Started listening: address=", addr)
 	}
 
+	if h.relayService {
+		if err := h.startRelayService(); err != nil {
+			return err
+		}
+	}
+
 	if err := h.bootstrap(); err != nil {
 		return err
 	}
 
+	h.connectRelays()
+
+	if h.useMDNS {
+		if err := h.startMDNS(); err != nil {
+			return err
+		}
+	}
+
 	if err := h.discovery.start(); err != nil {
 		return err
 	}
 
+	if err := h.startOrderBook(); err != nil {
+		return err
+	}
+
+	go h.reputation.flushLoop(h.ctx)
 	return nil
 }
 
@@ -163,10 +357,26 @@ func (h *host) Start() error {
 func (h *host) Stop() error {
 	h.cancel()
 
+	if h.mdns != nil {
+		if err := h.mdns.Close(); err != nil {
+			log.Warnf("failed to close mDNS service: err=%s", err)
+		}
+	}
+
 	if err := h.discovery.stop(); err != nil {
 		return err
 	}
 
+	if h.relay != nil {
+		if err := h.relay.Close(); err != nil {
+			log.Warnf("failed to close relay service: err=%s", err)
+		}
+	}
+
+	if err := h.reputation.flush(); err != nil {
+		log.Warnf("failed to save peer records: err=%s", err)
+	}
+
 	// close libp2p host
 	if err := h.h.Close(); err != nil {
 		log.Error("Failed to close libp2p host", "error", err)
@@ -182,16 +392,37 @@ func (h *host) Discover(provides common.ProvidesCoin, searchTime time.Duration)
 	return h.discovery.discover(provides, searchTime)
 }
 
-// SendSwapMessage sends a message to the peer who we're currently doing a swap with.
-func (h *host) SendSwapMessage(msg Message) error {
+// SendSwapMessage sends a message to the peer who we're doing the swap with the given ID with.
+func (h *host) SendSwapMessage(id common.SwapID, msg Message) error {
 	h.swapMu.Lock()
-	defer h.swapMu.Unlock()
+	swap, has := h.swaps[id]
+	h.swapMu.Unlock()
 
-	if h.swapStream == nil {
-		return errors.New("no swap currently happening")
+	if !has {
+		return fmt.Errorf("no swap with id %s currently happening", id)
 	}
 
-	return h.writeToStream(h.swapStream, msg)
+	if sm, ok := msg.(SwapMessage); ok {
+		sm.SetSwapID(id)
+	}
+
+	return h.writeToStream(swap.stream, msg)
+}
+
+// CloseSwap closes the stream of the swap with the given ID, ending the protocol with the peer.
+func (h *host) CloseSwap(id common.SwapID) error {
+	h.swapMu.Lock()
+	swap, has := h.swaps[id]
+	if has {
+		swap.closed = true
+	}
+	h.swapMu.Unlock()
+
+	if !has {
+		return fmt.Errorf("no swap with id %s currently happening", id)
+	}
+
+	return swap.stream.Close()
 }
 
 func (h *host) getBootnodes() []peer.AddrInfo {
@@ -225,6 +456,14 @@ func (h *host) writeToStream(s libp2pnetwork.Stream, msg Message) error {
 	lenBytes := uint64ToLEB128(msgLen)
 	encMsg = append(lenBytes, encMsg...)
 
+	if err = s.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = s.SetWriteDeadline(time.Time{})
+	}()
+
 	_, err = s.Write(encMsg)
 	if err != nil {
 		return err
@@ -237,52 +476,32 @@ func (h *host) writeToStream(s libp2pnetwork.Stream, msg Message) error {
 	return nil
 }
 
-func (h *host) handleConn(conn libp2pnetwork.Conn) {
-	log.Debug("incoming connection, peer=", conn.RemotePeer())
+func (h *host) handleConn(_ libp2pnetwork.Network, conn libp2pnetwork.Conn) {
+	log.Debug("new connection, peer=", conn.RemotePeer(), " transient=", conn.Stat().Transient)
 }
 
-// readStream reads from the stream into the given buffer, returning the number of bytes read
-func readStream(stream libp2pnetwork.Stream, buf []byte) (int, error) {
+// readStream reads a length-prefixed message from the stream. Messages larger than maxSize are
+// rejected before they're read.
+func readStream(stream io.Reader, maxSize uint64) ([]byte, error) {
 	if stream == nil {
-		return 0, errors.New("stream is nil")
+		return nil, errors.New("stream is nil")
 	}
 
-	var (
-		tot int
-	)
-
-	length, bytesRead, err := readLEB128ToUint64(stream, buf[:1])
+	length, err := readLEB128ToUint64(stream)
 	if err != nil {
-		return bytesRead, fmt.Errorf("failed to read length: %w", err)
-	}
-
-	if length == 0 {
-		return 0, nil
-	}
-
-	if length > uint64(len(buf)) {
-		log.Warn("received message with size greater than allocated message buffer", "length", length, "buffer size", len(buf))
-		return 0, fmt.Errorf("message size greater than allocated message buffer: got %d", length)
+		return nil, fmt.Errorf("failed to read length: %w", err)
 	}
 
-	tot = 0
-	for i := 0; i < maxReads; i++ {
-		n, err := stream.Read(buf[tot:])
-		if err != nil {
-			return n + tot, err
-		}
-
-		tot += n
-		if tot == int(length) {
-			break
-		}
+	if length > maxSize {
+		return nil, fmt.Errorf("%w: got %d bytes, maximum is %d", errMessageTooLarge, length, maxSize)
 	}
 
-	if tot != int(length) {
-		return tot, fmt.Errorf("failed to read entire message: expected %d bytes, received %d bytes", length, tot)
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(stream, buf); err != nil {
+		return nil, fmt.Errorf("failed to read entire message of %d bytes: %w", length, err)
 	}
 
-	return tot, nil
+	return buf, nil
 }
 
 // bootstrap connects the host to the configured bootnodes
@@ -1,113 +1,360 @@
 package net
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/protocol"
+
+	"github.com/noot/atomic-swap/common"
 )
 
 // Handler handles incoming protocol messages.
 // It is implemented by *alice.alice and *bob.bob
 type Handler interface {
-	HandleInitiateMessage(msg *InitiateMessage) (s SwapState, resp Message, err error)
+	// HandleInitiateMessage is called with the peer that sent the InitiateMessage.
+	HandleInitiateMessage(who peer.ID, msg *InitiateMessage) (s SwapState, resp Message, err error)
 }
 
 // SwapState handles incoming protocol messages for an initiated protocol.
 // It is implemented by *alice.swapState and *bob.swapState
 type SwapState interface {
+	ID() common.SwapID
+	Status() common.Status
 	HandleProtocolMessage(msg Message) (resp Message, done bool, err error)
 	ProtocolComplete()
+	// Done returns a channel that's closed once the swap is complete.
+	Done() <-chan struct{}
+	// LastTransaction returns when we last finished sending one of the swap's transactions, or the
+	// current time while one is being sent. It's zero if we haven't sent any.
+	LastTransaction() time.Time
 
-	// used by RPC
+	// used by the initiator to send its keys in the InitiateMessage
 	SendKeysMessage() (*SendKeysMessage, error)
 }
 
+// InitiateFunc is called by the initiator once the terms of a swap have been agreed, to create
+// the swap's state. Nothing should be generated or written for a swap before then.
+type InitiateFunc func(terms *Terms) (SwapState, error)
+
 const (
 	swapID          = "/swap/0"
 	protocolTimeout = time.Second * 5
 )
 
-func (h *host) Initiate(who peer.AddrInfo, msg *InitiateMessage, s SwapState) error {
-	h.swapMu.Lock()
-	defer h.swapMu.Unlock()
+// swap is an ongoing swap and the stream it's being performed over.
+type swap struct {
+	swapState SwapState
+	stream    libp2pnetwork.Stream
+	closed    bool // we closed the stream, rather than the peer
+}
 
-	// TODO: need a lock for this, otherwise two streams can enter this func
-	if h.swapState != nil {
-		return errors.New("already have ongoing swap")
+var (
+	errTooManySwaps  = errors.New("maximum number of concurrent swaps reached")
+	errNoSwapID      = errors.New("InitiateMessage has no swap ID")
+	errTermsMismatch = errors.New("InitiateMessage doesn't have the agreed terms")
+)
+
+// Initiate opens a swap stream with the given peer and proposes the given terms, with the ID of
+// the swap that the peer will use for it. Once the terms have been agreed, the swap state is
+// created and the swap protocol is performed over the stream.
+func (h *host) Initiate(who peer.AddrInfo, terms *Terms, initiate InitiateFunc) error {
+	// the stream has a swap slot reserved, which is taken over once the swap is added
+	stream, err := h.openProtocolStream(who, terms.SwapID)
+	if err != nil {
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(h.ctx, protocolTimeout)
-	defer cancel()
+	n := newNegotiation(h.maxNegotiationRounds, func(theirs *Terms) (*Terms, error) {
+		return counterAsInitiator(terms, theirs)
+	})
 
-	if err := h.h.Connect(ctx, who); err != nil {
+	agreed, err := h.negotiate(stream, n, n.propose(terms))
+	if err != nil {
+		h.recordError(who.ID, err)
+		h.releaseSwapSlot()
+		_ = stream.Close()
+		return fmt.Errorf("failed to negotiate swap with peer: %w", err)
+	}
+
+	s, err := initiate(agreed)
+	if err != nil {
+		h.releaseSwapSlot()
+		_ = stream.Close()
+		return err
+	}
+
+	if err = h.sendInitiateMessage(stream, agreed, s); err != nil {
+		_ = stream.Close()
+		// the protocol never started, but the swap state still needs to be cleaned up
+		s.ProtocolComplete()
 		return err
 	}
 
-	stream, err := h.h.NewStream(ctx, who.ID, protocol.ID(h.protocolID+swapID))
+	h.handleProtocolStreamInner(stream, s, nil)
+	return nil
+}
+
+// openProtocolStream opens a swap stream with the given peer, and negotiates the wire format.
+func (h *host) openProtocolStream(who peer.AddrInfo, id common.SwapID) (libp2pnetwork.Stream, error) {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	if _, has := h.swaps[id]; has {
+		return nil, fmt.Errorf("already have ongoing swap with id %s", id)
+	}
+
+	if err := h.reserveSwapSlot(); err != nil {
+		return nil, err
+	}
+
+	stream, err := h.newStream(who, swapID, protocolTimeout, true)
 	if err != nil {
-		return fmt.Errorf("failed to open stream with peer: err=%w", err)
+		h.reservedSwaps--
+		return nil, fmt.Errorf("failed to open stream with peer: err=%w", err)
 	}
 
 	log.Debug(
 		"opened protocol stream, peer=", who.ID,
 	)
 
-	if err := h.writeToStream(stream, msg); err != nil {
+	hs, err := h.initiateHandshake(stream)
+	if err != nil {
+		h.recordError(who.ID, err)
+		h.reservedSwaps--
+		_ = stream.Close()
+		return nil, fmt.Errorf("failed to negotiate wire format with peer: %w", err)
+	}
+
+	log.Debugf("negotiated wire format: peer=%s version=%d capabilities=%v", who.ID, hs.version, hs.capabilities)
+	return stream, nil
+}
+
+// sendInitiateMessage sends the InitiateMessage with the agreed terms and our keys, and registers the swap.
+func (h *host) sendInitiateMessage(stream libp2pnetwork.Stream, terms *Terms, s SwapState) error {
+	skm, err := s.SendKeysMessage()
+	if err != nil {
+		return err
+	}
+
+	msg := &InitiateMessage{
+		SwapID:          terms.SwapID,
+		OfferID:         terms.OfferID,
+		Provides:        terms.Provides,
+		ProvidesAmount:  terms.ProvidesAmount,
+		DesiredAmount:   terms.DesiredAmount,
+		Timeout:         terms.Timeout,
+		SendKeysMessage: skm,
+	}
+
+	if err = h.writeToStream(stream, msg); err != nil {
 		log.Warnf("failed to send InitiateMessage to peer: err=%s", err)
+		h.releaseSwapSlot()
 		return err
 	}
 
-	h.swapState = s
-	h.handleProtocolStreamInner(stream)
-	return nil
+	return h.addSwap(s, stream)
 }
 
 // handleProtocolStream is called when there is an incoming protocol stream.
 func (h *host) handleProtocolStream(stream libp2pnetwork.Stream) {
+	who := stream.Conn().RemotePeer()
+	if !h.reputation.isAllowed(who) {
+		log.Debugf("rejecting swap stream: peer=%s err=%s", who, errPeerNotAllowed)
+		_ = stream.Reset()
+		return
+	}
+
+	if err := h.limiter.acquireSwap(who); err != nil {
+		log.Debugf("rejecting swap stream: peer=%s err=%s", who, err)
+		_ = stream.Reset()
+		return
+	}
+	defer h.limiter.releaseSwap(who)
+
+	// the slot is checked and reserved at once, so that concurrent streams can't exceed maxSwaps
 	h.swapMu.Lock()
-	defer h.swapMu.Unlock()
+	err := h.reserveSwapSlot()
+	h.swapMu.Unlock()
+	if err != nil {
+		log.Debugf("failed to handle incoming swap stream: %s", err)
+		_ = stream.Close()
+		return
+	}
+
+	hs, err := h.acceptHandshake(stream)
+	if err != nil {
+		log.Warnf("failed to negotiate wire format with peer: peer=%s err=%s", who, err)
+		h.recordError(who, err)
+		h.releaseSwapSlot()
+		_ = stream.Close()
+		return
+	}
+
+	log.Debugf("negotiated wire format: peer=%s version=%d capabilities=%v", who, hs.version, hs.capabilities)
+
+	terms, err := h.negotiate(stream, newNegotiation(h.maxNegotiationRounds, h.counterProposal), nil)
+	if err != nil {
+		log.Infof("failed to negotiate swap with peer: peer=%s err=%s", who, err)
+		h.recordError(who, err)
+		h.releaseSwapSlot()
+		_ = stream.Close()
+		return
+	}
+
+	// concurrent swaps may have taken the offer while we were negotiating
+	if err = h.reserveOffer(terms); err != nil {
+		log.Infof("rejecting swap: peer=%s err=%s", who, err)
+		h.releaseSwapSlot()
+		_ = stream.Close()
+		return
+	}
+
+	h.handleProtocolStreamInner(stream, nil, terms)
+}
+
+// initiateHandshake sends our Hello and waits for the peer's; it's called on outgoing swap streams.
+func (h *host) initiateHandshake(stream libp2pnetwork.Stream) (*handshake, error) {
+	if err := h.writeToStream(stream, newHello()); err != nil {
+		return nil, err
+	}
+
+	theirs, err := h.readHello(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHandshake(theirs)
+}
+
+// acceptHandshake waits for the peer's Hello and replies with ours; it's called on incoming swap streams.
+// Our Hello is sent even if our versions are incompatible, so that the peer can tell why the swap failed.
+func (h *host) acceptHandshake(stream libp2pnetwork.Stream) (*handshake, error) {
+	theirs, err := h.readHello(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.writeToStream(stream, newHello()); err != nil {
+		return nil, err
+	}
+
+	return newHandshake(theirs)
+}
+
+func (h *host) readHello(stream libp2pnetwork.Stream) (*Hello, error) {
+	msg, err := h.readMessage(stream)
+	if err != nil {
+		// peers from before the handshake was added don't reply to it
+		return nil, fmt.Errorf("failed to read Hello, peer may use an incompatible wire format version: %w", err)
+	}
+
+	hello, ok := msg.(*Hello)
+	if !ok {
+		return nil, fmt.Errorf("expected Hello, received %T", msg)
+	}
+
+	return hello, nil
+}
 
-	if h.swapState != nil {
-		log.Debug("failed to handling incoming swap stream, already have ongoing swap")
+// readMessage reads the next message from the stream, waiting for up to protocolTimeout for it.
+func (h *host) readMessage(stream libp2pnetwork.Stream) (Message, error) {
+	if err := stream.SetReadDeadline(time.Now().Add(protocolTimeout)); err != nil {
+		return nil, err
 	}
 
-	h.handleProtocolStreamInner(stream)
+	defer func() {
+		_ = stream.SetReadDeadline(time.Time{})
+	}()
+
+	buf, err := readStream(stream, h.maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMessage(buf)
 }
 
 // handleProtocolStreamInner is called to handle a protocol stream, in both ingoing and outgoing cases.
-func (h *host) handleProtocolStreamInner(stream libp2pnetwork.Stream) {
+// If the stream is incoming, the passed SwapState is nil, and is created upon receiving the InitiateMessage,
+// which must have the agreed terms. The amount the terms take from our offer is released if the swap
+// doesn't succeed.
+func (h *host) handleProtocolStreamInner(stream libp2pnetwork.Stream, s SwapState, agreed *Terms) {
+	who := stream.Conn().RemotePeer()
+
+	// the peer closed the stream, or went silent, before the swap was done
+	var aborted bool
+
+	// an incoming stream has a swap slot reserved until the swap is added
+	slotReserved := s == nil
+
 	defer func() {
-		log.Debugf("closing stream: peer=%s protocol=%s", stream.Conn().RemotePeer(), stream.Protocol())
+		log.Debugf("closing stream: peer=%s protocol=%s", who, stream.Protocol())
 		_ = stream.Close()
-		if h.swapState != nil {
-			h.swapState.ProtocolComplete()
-			h.swapState = nil
+		if slotReserved {
+			h.releaseSwapSlot()
+		}
+
+		if s == nil {
+			if agreed != nil {
+				h.releaseOffer(agreed)
+			}
+
+			return
 		}
-	}()
 
-	msgBytes := make([]byte, 2048)
+		// the status is checked before ProtocolComplete(), which may refund the swap
+		status := s.Status()
+		s.ProtocolComplete()
+		if agreed != nil {
+			go h.settleOffer(agreed, s)
+		}
+
+		h.swapMu.Lock()
+		if swap, has := h.swaps[s.ID()]; has && swap.stream == stream {
+			aborted = aborted && !swap.closed
+			delete(h.swaps, s.ID())
+			h.h.ConnManager().Unprotect(who, s.ID().String())
+		}
+		h.swapMu.Unlock()
+
+		h.recordSwapOutcome(who, status, aborted)
+	}()
 
 	for {
-		tot, err := readStream(stream, msgBytes[:])
+		msgBytes, err := h.readSwapMessage(stream, s)
+		if errors.Is(err, errMessageTooLarge) {
+			log.Warnf("failed to read message from peer: peer=%s err=%s", who, err)
+			h.reputation.record(who, ProtocolViolation)
+			return
+		}
+
+		if isTimeout(err) {
+			log.Warnf("peer didn't send the next message in time: peer=%s timeout=%s", who, h.messageTimeout)
+			h.reputation.record(who, PeerTimedOut)
+			aborted = true
+			return
+		}
+
 		if err != nil {
 			log.Debug("peer closed stream with us, protocol exited")
+			aborted = true
 			return
 		}
 
 		// decode message based on message type
-		msg, err := decodeMessage(msgBytes[:tot])
+		msg, err := decodeMessage(msgBytes)
 		if err != nil {
 			log.Debug("failed to decode message from peer, id=", stream.ID(), " protocol=", stream.Protocol(), " err=", err)
+			h.reputation.record(who, ProtocolViolation)
 			continue
 		}
 
 		log.Debug(
-			"received message from peer, peer=", stream.Conn().RemotePeer(), " msg=", msg.String(),
+			"received message from peer, peer=", who, " msg=", msg.String(),
 		)
 
 		var (
@@ -115,23 +362,50 @@ func (h *host) handleProtocolStreamInner(stream libp2pnetwork.Stream) {
 			done bool
 		)
 
-		if h.swapState == nil {
+		if s == nil {
 			im, ok := msg.(*InitiateMessage)
 			if !ok {
 				log.Warnf("failed to handle protocol message: message was not InitiateMessage")
+				h.reputation.record(who, ProtocolViolation)
+				return
+			}
+
+			if im.SwapID.IsZero() {
+				log.Warnf("failed to handle protocol message: %s", errNoSwapID)
+				h.reputation.record(who, ProtocolViolation)
+				return
+			}
+
+			if h.hasSwap(im.SwapID) {
+				log.Warnf("failed to handle protocol message: already have ongoing swap with id %s", im.SwapID)
+				return
+			}
+
+			if im.terms() != *agreed {
+				log.Warnf("rejecting swap: id=%s err=%s", im.SwapID, errTermsMismatch)
+				h.reputation.record(who, ProtocolViolation)
 				return
 			}
 
-			var s SwapState
-			s, resp, err = h.handler.HandleInitiateMessage(im)
+			s, resp, err = h.handler.HandleInitiateMessage(who, im)
 			if err != nil {
 				log.Warnf("failed to handle protocol message: err=%s", err)
 				return
 			}
 
-			h.swapState = s
+			slotReserved = false
+			if err = h.addSwap(s, stream); err != nil {
+				log.Warnf("failed to handle protocol message: err=%s", err)
+				return
+			}
 		} else {
-			resp, done, err = h.swapState.HandleProtocolMessage(msg)
+			if sm, ok := msg.(SwapMessage); !ok || sm.GetSwapID() != s.ID() {
+				log.Warnf("failed to handle protocol message: message does not belong to swap %s", s.ID())
+				h.reputation.record(who, ProtocolViolation)
+				return
+			}
+
+			resp, done, err = s.HandleProtocolMessage(msg)
 			if err != nil {
 				log.Warnf("failed to handle protocol message: err=%s", err)
 				return
@@ -142,14 +416,95 @@ func (h *host) handleProtocolStreamInner(stream libp2pnetwork.Stream) {
 			continue
 		}
 
+		if sm, ok := resp.(SwapMessage); ok {
+			sm.SetSwapID(s.ID())
+		}
+
 		if err := h.writeToStream(stream, resp); err != nil {
 			log.Warnf("failed to send response to peer: err=%s", err)
 			return
 		}
 
 		if done {
-			log.Info("protocol complete!")
+			log.Infof("protocol complete! id=%s", s.ID())
 			return
 		}
 	}
 }
+
+func (h *host) hasSwap(id common.SwapID) bool {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+	_, has := h.swaps[id]
+	return has
+}
+
+// reserveSwapSlot reserves one of the maxSwaps slots for a swap whose stream is being opened or
+// accepted. The slot is taken over by addSwap, or must be given back with releaseSwapSlot if the
+// swap isn't added. It must be called with swapMu held.
+func (h *host) reserveSwapSlot() error {
+	if len(h.swaps)+h.reservedSwaps >= h.maxSwaps {
+		return errTooManySwaps
+	}
+
+	h.reservedSwaps++
+	return nil
+}
+
+func (h *host) releaseSwapSlot() {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+	h.reservedSwaps--
+}
+
+// addSwap registers a swap, so that messages can be sent to it. It takes over the swap slot
+// reserved for its stream, even if it fails.
+func (h *host) addSwap(s SwapState, stream libp2pnetwork.Stream) error {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	h.reservedSwaps--
+
+	if _, has := h.swaps[s.ID()]; has {
+		return fmt.Errorf("already have ongoing swap with id %s", s.ID())
+	}
+
+	h.swaps[s.ID()] = &swap{
+		swapState: s,
+		stream:    stream,
+	}
+
+	// the connection manager mustn't close the connection while the swap is ongoing
+	h.h.ConnManager().Protect(stream.Conn().RemotePeer(), s.ID().String())
+	return nil
+}
+
+// deadlineReader is the part of a stream that swap messages are read from.
+type deadlineReader interface {
+	io.Reader
+	SetReadDeadline(time.Time) error
+}
+
+// readSwapMessage reads the next message of a swap. The peer has messageTimeout to send it, so that
+// it can't hold the swap open by going silent. While we're sending one of the swap's transactions,
+// the peer may be waiting on it, so it has messageTimeout from when the transaction was sent.
+func (h *host) readSwapMessage(stream deadlineReader, s SwapState) ([]byte, error) {
+	deadline := time.Now().Add(h.messageTimeout)
+	for {
+		if err := stream.SetReadDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set read deadline: %w", err)
+		}
+
+		msgBytes, err := readStream(stream, h.maxMessageSize)
+		if !isTimeout(err) || s == nil {
+			return msgBytes, err
+		}
+
+		deadline = s.LastTransaction().Add(h.messageTimeout)
+		if !deadline.After(time.Now()) {
+			return nil, err
+		}
+
+		log.Debugf("waiting longer for the next message, as we were sending a transaction: id=%s", s.ID())
+	}
+}
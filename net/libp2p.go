@@ -0,0 +1,101 @@
+package net
+
+import (
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	libp2phost "github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mplex "github.com/libp2p/go-libp2p-mplex"
+	noise "github.com/libp2p/go-libp2p-noise"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	tls "github.com/libp2p/go-libp2p-tls"
+	yamux "github.com/libp2p/go-libp2p-yamux"
+	"github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/libp2p/go-tcp-transport"
+	ws "github.com/libp2p/go-ws-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// libp2pConfig holds the options of the libp2p host that we use.
+type libp2pConfig struct {
+	key          crypto.PrivKey
+	listenAddrs  []ma.Multiaddr
+	natPortMap   bool
+	relays       []peer.AddrInfo // static relays to reserve a slot with
+	holePunching bool
+	gater        connmgr.ConnectionGater
+	connMgr      connmgr.ConnManager
+}
+
+// newLibp2pHost creates a libp2p host with the TCP and websocket transports. It's built from
+// libp2p's config rather than with libp2p.New, whose default QUIC transport doesn't build with
+// current Go releases.
+func newLibp2pHost(c *libp2pConfig) (libp2phost.Host, error) {
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config.Config{
+		PeerKey:     c.key,
+		ListenAddrs: c.listenAddrs,
+		Peerstore:   ps,
+
+		// the relay transport lets us dial peers through their relays, and be dialled through ours
+		RelayCustom: true,
+		Relay:       true,
+
+		EnableHolePunching: c.holePunching,
+		ConnectionGater:    c.gater,
+		ConnManager:        c.connMgr,
+	}
+
+	for _, tpt := range []interface{}{tcp.NewTCPTransport, ws.New} {
+		tptc, err := config.TransportConstructor(tpt)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transports = append(cfg.Transports, tptc)
+	}
+
+	// security transports and muxers are in order of preference
+	for _, sec := range []struct {
+		id  string
+		tpt interface{}
+	}{{noise.ID, noise.New}, {tls.ID, tls.New}} {
+		secc, err := config.SecurityConstructor(sec.tpt)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SecurityTransports = append(cfg.SecurityTransports, config.MsSecC{SecC: secc, ID: sec.id})
+	}
+
+	for _, mux := range []struct {
+		id  string
+		tpt interface{}
+	}{{"/yamux/1.0.0", yamux.DefaultTransport}, {"/mplex/6.7.0", mplex.DefaultTransport}} {
+		muxc, err := config.MuxerConstructor(mux.tpt)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Muxers = append(cfg.Muxers, config.MsMuxC{MuxC: muxc, ID: mux.id})
+	}
+
+	if c.natPortMap {
+		cfg.NATManager = bhost.NewNATManager
+	}
+
+	if len(c.relays) != 0 {
+		// if we have relays, we can't be reached directly, so we advertise addresses through them
+		// rather than waiting for AutoNAT to find that out
+		private := libp2pnetwork.ReachabilityPrivate
+		cfg.EnableAutoRelay = true
+		cfg.StaticRelayOpt = autorelay.WithStaticRelays(c.relays)
+		cfg.AutoNATConfig.ForceReachability = &private
+	}
+
+	return cfg.NewNode()
+}
@@ -0,0 +1,67 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// mdnsConnectTimeout is how long we try to connect to a peer found on the local network.
+const mdnsConnectTimeout = time.Second * 10
+
+// mdnsServiceName returns the mDNS service name that we announce ourselves under. It's derived
+// from the protocol ID, so that only peers on the same network and chain find each other.
+func mdnsServiceName(protocolID string) string {
+	return fmt.Sprintf("_%s._udp", strings.ReplaceAll(strings.Trim(protocolID, "/"), "/", "-"))
+}
+
+// startMDNS starts announcing ourselves on the local network, and connecting to the peers that
+// we find there. Connected peers join the DHT and the offers topic, so they show up in Discover
+// and the order book.
+func (h *host) startMDNS() error {
+	h.mdns = mdns.NewMdnsService(h.h, mdnsServiceName(h.protocolID), &mdnsNotifee{h: h})
+	if err := h.mdns.Start(); err != nil {
+		return fmt.Errorf("failed to start mDNS service: %w", err)
+	}
+
+	return nil
+}
+
+type mdnsNotifee struct {
+	h *host
+}
+
+// HandlePeerFound is called by the mDNS service for each peer found on the local network.
+func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	h := n.h
+	if pi.ID == h.h.ID() || h.h.Network().Connectedness(pi.ID) == libp2pnetwork.Connected {
+		return
+	}
+
+	log.Debugf("found peer via mDNS: peer=%s", pi.ID)
+
+	ctx, cancel := context.WithTimeout(h.ctx, mdnsConnectTimeout)
+	defer cancel()
+
+	if err := h.h.Connect(ctx, pi); err != nil {
+		log.Debugf("failed to connect to peer found via mDNS: peer=%s err=%s", pi.ID, err)
+		return
+	}
+
+	// the service name only tells us what the peer claims; once connected, it's identified, so
+	// we can check that it really speaks our swap protocol
+	supported, err := h.h.Peerstore().SupportsProtocols(pi.ID, h.protocolID+swapID)
+	if err != nil || len(supported) == 0 {
+		log.Debugf("peer found via mDNS doesn't support our protocol: peer=%s", pi.ID)
+		_ = h.h.Network().ClosePeer(pi.ID)
+		return
+	}
+
+	log.Infof("connected to peer found via mDNS: peer=%s", pi.ID)
+	h.discovery.advertiseNow()
+}
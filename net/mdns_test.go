@@ -0,0 +1,65 @@
+package net
+
+import (
+	"context"
+	"testing"
+
+	libp2phost "github.com/libp2p/go-libp2p-core/host"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLibp2pHost(t *testing.T) libp2phost.Host {
+	key, err := generateKey(0, t.TempDir()+"/net.key")
+	require.NoError(t, err)
+
+	h, err := newLibp2pHost(&libp2pConfig{
+		key:         key,
+		listenAddrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/0")},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+	return h
+}
+
+func TestMDNSServiceName(t *testing.T) {
+	require.Equal(t, "_atomic-swap-development-1337._udp", mdnsServiceName("/atomic-swap/development/1337"))
+}
+
+func TestMDNSNotifee_HandlePeerFound(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := &host{
+		ctx:        ctx,
+		protocolID: "/atomic-swap/development/1337",
+		h:          newTestLibp2pHost(t),
+		discovery:  &discovery{readvertise: make(chan struct{}, 1)},
+	}
+	n := &mdnsNotifee{h: h}
+
+	// a peer that speaks our swap protocol stays connected, and makes us advertise again
+	same := newTestLibp2pHost(t)
+	same.SetStreamHandler(protocol.ID(h.protocolID+swapID), func(s libp2pnetwork.Stream) {
+		_ = s.Close()
+	})
+	n.HandlePeerFound(peer.AddrInfo{ID: same.ID(), Addrs: same.Addrs()})
+	require.Equal(t, libp2pnetwork.Connected, h.h.Network().Connectedness(same.ID()))
+	require.Len(t, h.discovery.readvertise, 1)
+
+	// a peer on another chain is disconnected
+	other := newTestLibp2pHost(t)
+	other.SetStreamHandler(protocol.ID("/atomic-swap/development/1"+swapID), func(s libp2pnetwork.Stream) {
+		_ = s.Close()
+	})
+	n.HandlePeerFound(peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()})
+	require.NotEqual(t, libp2pnetwork.Connected, h.h.Network().Connectedness(other.ID()))
+
+	// and we ignore ourselves
+	n.HandlePeerFound(peer.AddrInfo{ID: h.h.ID(), Addrs: h.h.Addrs()})
+}
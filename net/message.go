@@ -1,9 +1,8 @@
 package net
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
+	"time"
 
 	"github.com/noot/atomic-swap/common"
 )
@@ -17,6 +16,10 @@ const (
 	NotifyReadyType
 	NotifyClaimedType
 	NotifyRefundType
+	HelloType
+	ProposeMessageType
+	AcceptMessageType
+	RejectMessageType
 )
 
 type Message interface {
@@ -25,120 +28,163 @@ type Message interface {
 	Type() byte
 }
 
-func decodeMessage(b []byte) (Message, error) {
-	if len(b) == 0 {
-		return nil, errors.New("invalid message bytes")
-	}
-
-	switch b[0] {
-	case QueryResponseType:
-		var m *QueryResponse
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	case InitiateMessageType:
-		var m *InitiateMessage
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	case SendKeysMessageType:
-		var m *SendKeysMessage
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	case NotifyContractDeployedType:
-		var m *NotifyContractDeployed
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	case NotifyXMRLockType:
-		var m *NotifyXMRLock
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	case NotifyReadyType:
-		var m *NotifyReady
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	case NotifyClaimedType:
-		var m *NotifyClaimed
-		if err := json.Unmarshal(b[1:], &m); err != nil {
-			return nil, err
-		}
-		return m, nil
-	default:
-		return nil, errors.New("invalid message type")
-	}
+// SwapMessage is a message that belongs to a specific swap. The InitiateMessage and every
+// message sent after it carry the ID of the swap they belong to.
+type SwapMessage interface {
+	Message
+	GetSwapID() common.SwapID
+	SetSwapID(id common.SwapID)
 }
 
+// QueryResponse is sent in response to a query, and holds the peer's live offers.
 type QueryResponse struct {
-	Provides      []common.ProvidesCoin
-	MaximumAmount []float64
-	ExchangeRate  common.ExchangeRate
+	Offers []*Offer
 }
 
 func (m *QueryResponse) String() string {
-	return fmt.Sprintf("QueryResponse Provides=%v MaximumAmount=%v ExchangeRate=%v",
-		m.Provides,
-		m.MaximumAmount,
-		m.ExchangeRate,
-	)
+	return fmt.Sprintf("QueryResponse Offers=%v", m.Offers)
 }
 
 func (m *QueryResponse) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{QueryResponseType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *QueryResponse) Type() byte {
 	return QueryResponseType
 }
 
+func (m *QueryResponse) marshal(b []byte) []byte {
+	for _, o := range m.Offers {
+		b = appendMessage(b, 4, o)
+	}
+	return b
+}
+
+func (m *QueryResponse) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) error {
+		if f.num != 4 {
+			return nil
+		}
+
+		enc, err := f.bytes()
+		if err != nil {
+			return err
+		}
+
+		o := new(Offer)
+		if err = o.unmarshal(enc); err != nil {
+			return err
+		}
+
+		m.Offers = append(m.Offers, o)
+		return nil
+	})
+}
+
+// InitiateMessage is sent by the initiator of a swap once both parties have agreed on its terms;
+// the swap ID it contains is chosen by the initiator and used by both parties for the rest of the
+// swap. Its terms must be the ones that were agreed.
 type InitiateMessage struct {
+	SwapID         common.SwapID
+	OfferID        OfferID
 	Provides       common.ProvidesCoin
 	ProvidesAmount float64
 	DesiredAmount  float64
+	Timeout        time.Duration
 	*SendKeysMessage
 }
 
 func (m *InitiateMessage) String() string {
-	return fmt.Sprintf("InitiateMessage Provides=%v ProvidesAmount=%v DesiredAmount=%v Keys=%s",
+	return fmt.Sprintf("InitiateMessage SwapID=%s OfferID=%s Provides=%v ProvidesAmount=%v DesiredAmount=%v Timeout=%s Keys=%s",
+		m.SwapID,
+		m.OfferID,
 		m.Provides,
 		m.ProvidesAmount,
 		m.DesiredAmount,
+		m.Timeout,
 		m.SendKeysMessage,
 	)
 }
 
-func (m *InitiateMessage) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
+// terms returns the terms of the swap that the message initiates.
+func (m *InitiateMessage) terms() Terms {
+	return Terms{
+		SwapID:         m.SwapID,
+		OfferID:        m.OfferID,
+		Provides:       m.Provides,
+		ProvidesAmount: m.ProvidesAmount,
+		DesiredAmount:  m.DesiredAmount,
+		Timeout:        m.Timeout,
 	}
+}
 
-	return append([]byte{InitiateMessageType}, b...), nil
+func (m *InitiateMessage) Encode() ([]byte, error) {
+	return encodeMessage(m)
 }
 
 func (m *InitiateMessage) Type() byte {
 	return InitiateMessageType
 }
 
+func (m *InitiateMessage) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	b = appendString(b, 2, string(m.Provides))
+	b = appendDouble(b, 3, m.ProvidesAmount)
+	b = appendDouble(b, 4, m.DesiredAmount)
+	if m.SendKeysMessage != nil {
+		b = appendMessage(b, 5, m.SendKeysMessage)
+	}
+	if !m.OfferID.IsZero() {
+		b = appendBytes(b, 6, m.OfferID[:])
+	}
+	return appendUint64(b, 7, uint64(m.Timeout/time.Second))
+}
+
+func (m *InitiateMessage) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			var c string
+			c, err = f.string()
+			m.Provides = common.ProvidesCoin(c)
+		case 3:
+			m.ProvidesAmount, err = f.double()
+		case 4:
+			m.DesiredAmount, err = f.double()
+		case 5:
+			var keys []byte
+			if keys, err = f.bytes(); err != nil {
+				return err
+			}
+			m.SendKeysMessage = new(SendKeysMessage)
+			err = m.SendKeysMessage.unmarshal(keys)
+		case 6:
+			m.OfferID, err = f.offerID()
+		case 7:
+			var timeout uint64
+			timeout, err = f.uint64()
+			m.Timeout = time.Duration(timeout) * time.Second
+		}
+		return err
+	})
+}
+
+func (m *InitiateMessage) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *InitiateMessage) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
 // The below messages are sawp protocol messages, exchanged after the swap has been agreed
 // upon by both sides.
 
 // SendKeysMessage is sent by both parties to each other to initiate the protocol
 type SendKeysMessage struct {
+	SwapID         common.SwapID
 	PublicSpendKey string
 	PublicViewKey  string
 	PrivateViewKey string
@@ -155,21 +201,51 @@ func (m *SendKeysMessage) String() string {
 }
 
 func (m *SendKeysMessage) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{SendKeysMessageType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *SendKeysMessage) Type() byte {
 	return SendKeysMessageType
 }
 
+func (m *SendKeysMessage) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	b = appendString(b, 2, m.PublicSpendKey)
+	b = appendString(b, 3, m.PublicViewKey)
+	b = appendString(b, 4, m.PrivateViewKey)
+	return appendString(b, 5, m.EthAddress)
+}
+
+func (m *SendKeysMessage) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.PublicSpendKey, err = f.string()
+		case 3:
+			m.PublicViewKey, err = f.string()
+		case 4:
+			m.PrivateViewKey, err = f.string()
+		case 5:
+			m.EthAddress, err = f.string()
+		}
+		return err
+	})
+}
+
+func (m *SendKeysMessage) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *SendKeysMessage) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
 // NotifyContractDeployed is sent by Alice to Bob after deploying the swap contract
 // and locking her ether in it
 type NotifyContractDeployed struct {
+	SwapID  common.SwapID
 	Address string
 }
 
@@ -178,20 +254,41 @@ func (m *NotifyContractDeployed) String() string {
 }
 
 func (m *NotifyContractDeployed) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{NotifyContractDeployedType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *NotifyContractDeployed) Type() byte {
 	return NotifyContractDeployedType
 }
 
+func (m *NotifyContractDeployed) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	return appendString(b, 2, m.Address)
+}
+
+func (m *NotifyContractDeployed) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.Address, err = f.string()
+		}
+		return err
+	})
+}
+
+func (m *NotifyContractDeployed) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *NotifyContractDeployed) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
 // NotifyXMRLock is sent by Bob to Alice after locking his XMR.
 type NotifyXMRLock struct {
+	SwapID  common.SwapID
 	Address string
 }
 
@@ -200,40 +297,79 @@ func (m *NotifyXMRLock) String() string {
 }
 
 func (m *NotifyXMRLock) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{NotifyXMRLockType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *NotifyXMRLock) Type() byte {
 	return NotifyXMRLockType
 }
 
+func (m *NotifyXMRLock) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	return appendString(b, 2, m.Address)
+}
+
+func (m *NotifyXMRLock) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.Address, err = f.string()
+		}
+		return err
+	})
+}
+
+func (m *NotifyXMRLock) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *NotifyXMRLock) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
 // NotifyReady is sent by Alice to Bob after calling Ready() on the contract.
-type NotifyReady struct{}
+type NotifyReady struct {
+	SwapID common.SwapID
+}
 
 func (m *NotifyReady) String() string {
 	return "NotifyReady"
 }
 
 func (m *NotifyReady) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{NotifyReadyType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *NotifyReady) Type() byte {
 	return NotifyReadyType
 }
 
+func (m *NotifyReady) marshal(b []byte) []byte {
+	return appendSwapID(b, 1, m.SwapID)
+}
+
+func (m *NotifyReady) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		if f.num == 1 {
+			m.SwapID, err = f.swapID()
+		}
+		return err
+	})
+}
+
+func (m *NotifyReady) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *NotifyReady) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
 // NotifyClaimed is sent by Bob to Alice after claiming his ETH.
 type NotifyClaimed struct {
+	SwapID common.SwapID
 	TxHash string
 }
 
@@ -242,36 +378,275 @@ func (m *NotifyClaimed) String() string {
 }
 
 func (m *NotifyClaimed) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{NotifyClaimedType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *NotifyClaimed) Type() byte {
 	return NotifyClaimedType
 }
 
+func (m *NotifyClaimed) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	return appendString(b, 2, m.TxHash)
+}
+
+func (m *NotifyClaimed) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.TxHash, err = f.string()
+		}
+		return err
+	})
+}
+
+func (m *NotifyClaimed) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *NotifyClaimed) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
 // NotifyRefund is sent by Alice to Bob after calling Refund() on the contract.
 type NotifyRefund struct {
+	SwapID common.SwapID
 	TxHash string
 }
 
 func (m *NotifyRefund) String() string {
-	return fmt.Sprintf("NotifyClaimed %s", m.TxHash)
+	return fmt.Sprintf("NotifyRefund %s", m.TxHash)
 }
 
 func (m *NotifyRefund) Encode() ([]byte, error) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return append([]byte{NotifyRefundType}, b...), nil
+	return encodeMessage(m)
 }
 
 func (m *NotifyRefund) Type() byte {
 	return NotifyRefundType
 }
+
+func (m *NotifyRefund) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	return appendString(b, 2, m.TxHash)
+}
+
+func (m *NotifyRefund) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.TxHash, err = f.string()
+		}
+		return err
+	})
+}
+
+func (m *NotifyRefund) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *NotifyRefund) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
+// Hello is sent by both peers at the start of a swap stream, to agree on the version of the
+// wire format and the optional features used for the swap.
+type Hello struct {
+	Version      uint32 // newest wire format version supported
+	MinVersion   uint32 // oldest wire format version supported
+	Capabilities []string
+}
+
+func (m *Hello) String() string {
+	return fmt.Sprintf("Hello Version=%d MinVersion=%d Capabilities=%v",
+		m.Version,
+		m.MinVersion,
+		m.Capabilities,
+	)
+}
+
+func (m *Hello) Encode() ([]byte, error) {
+	return encodeMessage(m)
+}
+
+func (m *Hello) Type() byte {
+	return HelloType
+}
+
+func (m *Hello) marshal(b []byte) []byte {
+	b = appendUint32(b, 1, m.Version)
+	b = appendUint32(b, 2, m.MinVersion)
+	return appendStrings(b, 3, m.Capabilities)
+}
+
+func (m *Hello) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.Version, err = f.uint32()
+		case 2:
+			m.MinVersion, err = f.uint32()
+		case 3:
+			var c string
+			c, err = f.string()
+			m.Capabilities = append(m.Capabilities, c)
+		}
+		return err
+	})
+}
+
+// ProposeMessage proposes the terms of a swap. The initiator sends the first proposal, and then
+// either side may counter the other's latest proposal with one of its own, up to a maximum number
+// of rounds. The terms are always from the initiator's point of view.
+type ProposeMessage struct {
+	Round uint32 // 1 for the first proposal, and one more for each counter-proposal
+	Terms
+}
+
+func (m *ProposeMessage) String() string {
+	return fmt.Sprintf("ProposeMessage Round=%d %s", m.Round, &m.Terms)
+}
+
+func (m *ProposeMessage) Encode() ([]byte, error) {
+	return encodeMessage(m)
+}
+
+func (m *ProposeMessage) Type() byte {
+	return ProposeMessageType
+}
+
+func (m *ProposeMessage) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	b = appendUint32(b, 2, m.Round)
+	if !m.OfferID.IsZero() {
+		b = appendBytes(b, 3, m.OfferID[:])
+	}
+	b = appendString(b, 4, string(m.Provides))
+	b = appendDouble(b, 5, m.ProvidesAmount)
+	b = appendDouble(b, 6, m.DesiredAmount)
+	return appendUint64(b, 7, uint64(m.Timeout/time.Second))
+}
+
+func (m *ProposeMessage) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.Round, err = f.uint32()
+		case 3:
+			m.OfferID, err = f.offerID()
+		case 4:
+			var c string
+			c, err = f.string()
+			m.Provides = common.ProvidesCoin(c)
+		case 5:
+			m.ProvidesAmount, err = f.double()
+		case 6:
+			m.DesiredAmount, err = f.double()
+		case 7:
+			var timeout uint64
+			timeout, err = f.uint64()
+			m.Timeout = time.Duration(timeout) * time.Second
+		}
+		return err
+	})
+}
+
+func (m *ProposeMessage) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *ProposeMessage) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
+// AcceptMessage accepts the other side's proposal in the given round, which ends the negotiation.
+type AcceptMessage struct {
+	SwapID common.SwapID
+	Round  uint32
+}
+
+func (m *AcceptMessage) String() string {
+	return fmt.Sprintf("AcceptMessage Round=%d", m.Round)
+}
+
+func (m *AcceptMessage) Encode() ([]byte, error) {
+	return encodeMessage(m)
+}
+
+func (m *AcceptMessage) Type() byte {
+	return AcceptMessageType
+}
+
+func (m *AcceptMessage) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	return appendUint32(b, 2, m.Round)
+}
+
+func (m *AcceptMessage) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.Round, err = f.uint32()
+		}
+		return err
+	})
+}
+
+func (m *AcceptMessage) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *AcceptMessage) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
+
+// RejectMessage ends the negotiation without a swap.
+type RejectMessage struct {
+	SwapID common.SwapID
+	Reason string
+}
+
+func (m *RejectMessage) String() string {
+	return fmt.Sprintf("RejectMessage Reason=%s", m.Reason)
+}
+
+func (m *RejectMessage) Encode() ([]byte, error) {
+	return encodeMessage(m)
+}
+
+func (m *RejectMessage) Type() byte {
+	return RejectMessageType
+}
+
+func (m *RejectMessage) marshal(b []byte) []byte {
+	b = appendSwapID(b, 1, m.SwapID)
+	return appendString(b, 2, m.Reason)
+}
+
+func (m *RejectMessage) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			m.SwapID, err = f.swapID()
+		case 2:
+			m.Reason, err = f.string()
+		}
+		return err
+	})
+}
+
+func (m *RejectMessage) GetSwapID() common.SwapID {
+	return m.SwapID
+}
+
+func (m *RejectMessage) SetSwapID(id common.SwapID) {
+	m.SwapID = id
+}
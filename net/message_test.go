@@ -0,0 +1,169 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func testSwapID(t *testing.T) common.SwapID {
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+	return id
+}
+
+// newTestMessages returns one of each message, with every field set.
+func newTestMessages(t *testing.T) []Message {
+	id := testSwapID(t)
+
+	keys := &SendKeysMessage{
+		SwapID:         id,
+		PublicSpendKey: "spend",
+		PublicViewKey:  "view",
+		PrivateViewKey: "privview",
+		EthAddress:     "0xabcd",
+	}
+
+	relayed := newTestOffer(t)
+	relayed.Addrs = []string{"/ip4/127.0.0.1/tcp/9933"}
+
+	return []Message{
+		&QueryResponse{
+			Offers: []*Offer{newTestOffer(t), relayed},
+		},
+		&InitiateMessage{
+			SwapID:          id,
+			OfferID:         OfferID{1, 2, 3},
+			Provides:        common.ProvidesETH,
+			ProvidesAmount:  0.1,
+			DesiredAmount:   2,
+			Timeout:         common.SwapTimeout,
+			SendKeysMessage: keys,
+		},
+		keys,
+		&NotifyContractDeployed{SwapID: id, Address: "0x1234"},
+		&NotifyXMRLock{SwapID: id, Address: "4abc"},
+		&NotifyReady{SwapID: id},
+		&NotifyClaimed{SwapID: id, TxHash: "0xclaim"},
+		&NotifyRefund{SwapID: id, TxHash: "0xrefund"},
+		&Hello{Version: 3, MinVersion: 2, Capabilities: []string{"a", "b"}},
+		&ProposeMessage{
+			Round: 2,
+			Terms: Terms{
+				SwapID:         id,
+				OfferID:        OfferID{4, 5, 6},
+				Provides:       common.ProvidesXMR,
+				ProvidesAmount: 1.5,
+				DesiredAmount:  0.075,
+				Timeout:        common.SwapTimeout,
+			},
+		},
+		&AcceptMessage{SwapID: id, Round: 3},
+		&RejectMessage{SwapID: id, Reason: "no"},
+	}
+}
+
+func TestDecodeMessage_RoundTrip(t *testing.T) {
+	for _, msg := range newTestMessages(t) {
+		enc, err := msg.Encode()
+		require.NoError(t, err)
+
+		dec, err := decodeMessage(enc)
+		require.NoError(t, err, msg.String())
+		require.Equal(t, msg, dec)
+		require.Equal(t, msg.Type(), dec.Type())
+	}
+}
+
+func TestNotifyRefund_String(t *testing.T) {
+	require.Equal(t, "NotifyRefund 0xab", (&NotifyRefund{TxHash: "0xab"}).String())
+}
+
+func TestDecodeMessage_Legacy(t *testing.T) {
+	bz, err := json.Marshal(&NotifyReady{})
+	require.NoError(t, err)
+
+	_, err = decodeMessage(append([]byte{NotifyReadyType}, bz...))
+	require.True(t, errors.Is(err, errIncompatibleVersion))
+}
+
+func TestDecodeMessage_UnsupportedVersion(t *testing.T) {
+	b := protowire.AppendTag(nil, envelopeVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(WireVersion+1))
+	b = protowire.AppendTag(b, envelopeType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(NotifyReadyType))
+
+	_, err := decodeMessage(b)
+	require.True(t, errors.Is(err, errIncompatibleVersion))
+}
+
+func TestDecodeMessage_UnknownFields(t *testing.T) {
+	msg := &NotifyClaimed{
+		SwapID: testSwapID(t),
+		TxHash: "0xclaim",
+	}
+
+	// fields added by a newer peer are skipped
+	body := msg.marshal(nil)
+	body = appendString(body, 100, "new field")
+	body = appendDouble(body, 101, 1.5)
+
+	b := protowire.AppendTag(nil, envelopeVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(WireVersion))
+	b = protowire.AppendTag(b, envelopeType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(NotifyClaimedType))
+	b = appendBytes(b, envelopeBody, body)
+
+	dec, err := decodeMessage(b)
+	require.NoError(t, err)
+	require.Equal(t, msg, dec)
+}
+
+func TestDecodeMessage_Invalid(t *testing.T) {
+	msg := &NotifyClaimed{
+		SwapID: testSwapID(t),
+		TxHash: "0xclaim",
+	}
+
+	enc, err := msg.Encode()
+	require.NoError(t, err)
+
+	_, err = decodeMessage(enc[:len(enc)-1])
+	require.Error(t, err)
+
+	_, err = decodeMessage(nil)
+	require.Error(t, err)
+}
+
+func TestNewHandshake(t *testing.T) {
+	hs, err := newHandshake(newHello())
+	require.NoError(t, err)
+	require.Equal(t, WireVersion, hs.version)
+
+	// a newer peer that still supports our version
+	hs, err = newHandshake(&Hello{
+		Version:    WireVersion + 1,
+		MinVersion: WireVersion,
+	})
+	require.NoError(t, err)
+	require.Equal(t, WireVersion, hs.version)
+
+	// a newer peer that no longer supports our version
+	_, err = newHandshake(&Hello{
+		Version:    WireVersion + 2,
+		MinVersion: WireVersion + 1,
+	})
+	require.True(t, errors.Is(err, errIncompatibleVersion))
+
+	// an older peer
+	_, err = newHandshake(&Hello{
+		Version:    minWireVersion - 1,
+		MinVersion: minWireVersion - 1,
+	})
+	require.True(t, errors.Is(err, errIncompatibleVersion))
+}
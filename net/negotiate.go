@@ -0,0 +1,218 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+const defaultMaxNegotiationRounds = 4
+
+var (
+	errSwapRejected  = errors.New("peer rejected the swap")
+	errNoAgreement   = errors.New("failed to agree on the swap's terms")
+	errTooManyRounds = errors.New("maximum number of negotiation rounds reached")
+	errChangedTerms  = errors.New("proposal changes the swap or the coins being swapped")
+	errWorsePrice    = errors.New("proposal's price is worse than ours")
+)
+
+// Terms are the terms of a swap that are agreed on before it starts. They're always from the
+// initiator's point of view.
+type Terms struct {
+	SwapID         common.SwapID
+	OfferID        OfferID             // the offer being taken
+	Provides       common.ProvidesCoin // the coin the initiator provides
+	ProvidesAmount float64
+	DesiredAmount  float64
+	Timeout        time.Duration // duration of each of the swap contract's timelocks
+}
+
+func (t *Terms) String() string {
+	return fmt.Sprintf("SwapID=%s OfferID=%s Provides=%v ProvidesAmount=%v DesiredAmount=%v Timeout=%s",
+		t.SwapID,
+		t.OfferID,
+		t.Provides,
+		t.ProvidesAmount,
+		t.DesiredAmount,
+		t.Timeout,
+	)
+}
+
+// negotiation is our side of the negotiation of a swap's terms. Each side takes turns to accept,
+// reject or counter the other's latest proposal, until the maximum number of rounds is reached.
+type negotiation struct {
+	swapID    common.SwapID
+	round     uint32 // round of the latest proposal
+	ours      *Terms // our latest proposal, if we've made one
+	maxRounds uint32
+
+	// respond decides on the peer's proposal. It returns nil to accept it, or a counter-proposal,
+	// or an error to reject it.
+	respond func(theirs *Terms) (*Terms, error)
+}
+
+func newNegotiation(maxRounds uint32, respond func(theirs *Terms) (*Terms, error)) *negotiation {
+	return &negotiation{
+		maxRounds: maxRounds,
+		respond:   respond,
+	}
+}
+
+// propose returns the first proposal of the negotiation; it's sent by the initiator.
+func (n *negotiation) propose(terms *Terms) *ProposeMessage {
+	n.swapID = terms.SwapID
+	n.round = 1
+	n.ours = terms
+	return &ProposeMessage{
+		Round: n.round,
+		Terms: *terms,
+	}
+}
+
+// handle handles a negotiation message from the peer. It returns the message to reply with, if
+// any, and the agreed terms once the negotiation is over. If the negotiation fails, the reply is
+// a RejectMessage, unless the peer rejected the swap.
+func (n *negotiation) handle(msg Message) (Message, *Terms, error) {
+	switch msg := msg.(type) {
+	case *AcceptMessage:
+		if n.ours == nil || msg.Round != n.round || msg.SwapID != n.swapID {
+			return n.reject(fmt.Errorf("peer accepted round %d, but our latest proposal is in round %d", msg.Round, n.round))
+		}
+
+		return nil, n.ours, nil
+	case *RejectMessage:
+		return nil, nil, fmt.Errorf("%w: %s", errSwapRejected, msg.Reason)
+	case *ProposeMessage:
+		return n.handleProposal(msg)
+	default:
+		return n.reject(fmt.Errorf("expected a negotiation message, received %T", msg))
+	}
+}
+
+func (n *negotiation) handleProposal(msg *ProposeMessage) (Message, *Terms, error) {
+	if msg.Round != n.round+1 {
+		return n.reject(fmt.Errorf("expected proposal for round %d, received round %d", n.round+1, msg.Round))
+	}
+
+	if msg.Round > n.maxRounds {
+		return n.reject(errTooManyRounds)
+	}
+
+	if msg.SwapID.IsZero() || (n.round > 0 && msg.SwapID != n.swapID) {
+		return n.reject(errChangedTerms)
+	}
+
+	n.swapID = msg.SwapID
+	n.round = msg.Round
+
+	theirs := msg.Terms
+	counter, err := n.respond(&theirs)
+	if err != nil {
+		return n.reject(err)
+	}
+
+	if counter == nil {
+		return &AcceptMessage{
+			SwapID: n.swapID,
+			Round:  n.round,
+		}, &theirs, nil
+	}
+
+	if n.round >= n.maxRounds {
+		return n.reject(errTooManyRounds)
+	}
+
+	// repeating ourselves won't get us anywhere
+	if n.ours != nil && *counter == *n.ours {
+		return n.reject(errNoAgreement)
+	}
+
+	n.round++
+	n.ours = counter
+	return &ProposeMessage{
+		Round: n.round,
+		Terms: *counter,
+	}, nil, nil
+}
+
+func (n *negotiation) reject(err error) (Message, *Terms, error) {
+	return &RejectMessage{
+		SwapID: n.swapID,
+		Reason: err.Error(),
+	}, nil, err
+}
+
+// counterAsInitiator decides on the receiver's proposal, given the terms we first proposed. We
+// never provide more than we first proposed to, or pay a worse price for what we desire.
+func counterAsInitiator(ours, theirs *Terms) (*Terms, error) {
+	if theirs.SwapID != ours.SwapID || theirs.OfferID != ours.OfferID || theirs.Provides != ours.Provides {
+		return nil, errChangedTerms
+	}
+
+	if theirs.ProvidesAmount <= 0 || theirs.DesiredAmount <= 0 {
+		return nil, errors.New("proposal's amounts must be above 0")
+	}
+
+	price := ours.ProvidesAmount / ours.DesiredAmount
+	if theirs.ProvidesAmount/theirs.DesiredAmount > price*(1+priceTolerance) {
+		return nil, fmt.Errorf("%w: %v %s for %v, we proposed %v for %v",
+			errWorsePrice, theirs.ProvidesAmount, ours.Provides, theirs.DesiredAmount, ours.ProvidesAmount, ours.DesiredAmount)
+	}
+
+	c := *theirs
+
+	// provide as much as we're willing to, at their price
+	if c.ProvidesAmount > ours.ProvidesAmount*(1+priceTolerance) {
+		c.DesiredAmount *= ours.ProvidesAmount / c.ProvidesAmount
+		c.ProvidesAmount = ours.ProvidesAmount
+	}
+
+	if c.Timeout < common.MinSwapTimeout || c.Timeout > common.MaxSwapTimeout {
+		c.Timeout = ours.Timeout
+	}
+
+	if c == *theirs {
+		return nil, nil
+	}
+
+	return &c, nil
+}
+
+// negotiate negotiates the terms of a swap over the stream, starting with the given proposal if
+// we're the initiator. It returns the agreed terms.
+func (h *host) negotiate(stream libp2pnetwork.Stream, n *negotiation, first *ProposeMessage) (*Terms, error) {
+	if first != nil {
+		if err := h.writeToStream(stream, first); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		msg, err := h.readMessage(stream)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("received negotiation message: peer=%s msg=%s", stream.Conn().RemotePeer(), msg)
+
+		reply, agreed, err := n.handle(msg)
+		if reply != nil {
+			if werr := h.writeToStream(stream, reply); werr != nil && err == nil {
+				err = werr
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if agreed != nil {
+			log.Infof("agreed on swap terms: peer=%s %s", stream.Conn().RemotePeer(), agreed)
+			return agreed, nil
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package net
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+type negotiationResult struct {
+	agreed *Terms
+	err    error
+}
+
+// runNegotiation passes messages between the initiator and the receiver, through the wire format,
+// until the negotiation is over.
+func runNegotiation(t *testing.T, initiator, receiver *negotiation, terms *Terms) (i, r negotiationResult) {
+	var msg Message = initiator.propose(terms)
+	sides := []*negotiation{receiver, initiator}
+	results := []*negotiationResult{&r, &i}
+
+	for turn := 0; msg != nil; turn = (turn + 1) % 2 {
+		enc, err := msg.Encode()
+		require.NoError(t, err)
+		dec, err := decodeMessage(enc)
+		require.NoError(t, err)
+
+		var res negotiationResult
+		msg, res.agreed, res.err = sides[turn].handle(dec)
+		if res.agreed != nil || res.err != nil {
+			*results[turn] = res
+		}
+	}
+
+	return i, r
+}
+
+func newTestTerms(t *testing.T, offer *Offer) *Terms {
+	return &Terms{
+		SwapID:         testSwapID(t),
+		OfferID:        offer.ID,
+		Provides:       common.ProvidesETH,
+		ProvidesAmount: 0.05,
+		DesiredAmount:  1,
+		Timeout:        common.SwapTimeout,
+	}
+}
+
+func newTestNegotiations(offer *Offer, terms *Terms) (initiator, receiver *negotiation) {
+	initiator = newNegotiation(defaultMaxNegotiationRounds, func(theirs *Terms) (*Terms, error) {
+		return counterAsInitiator(terms, theirs)
+	})
+	receiver = newNegotiation(defaultMaxNegotiationRounds, offer.counter)
+	return initiator, receiver
+}
+
+func TestNegotiation_Accept(t *testing.T) {
+	offer := newTestOffer(t)
+	terms := newTestTerms(t, offer)
+
+	i, r := runNegotiation(t, newNegotiation(defaultMaxNegotiationRounds, nil),
+		newNegotiation(defaultMaxNegotiationRounds, offer.counter), terms)
+	require.NoError(t, i.err)
+	require.NoError(t, r.err)
+	require.Equal(t, terms, i.agreed)
+	require.Equal(t, terms, r.agreed)
+}
+
+func TestNegotiation_Counter(t *testing.T) {
+	offer := newTestOffer(t)
+
+	// the offer is for up to 2 XMR, so the receiver counters with 2 XMR, for which the initiator
+	// would provide 0.1 ETH at its own price
+	terms := newTestTerms(t, offer)
+	terms.DesiredAmount = 4
+	terms.ProvidesAmount = 0.2
+	terms.Timeout = time.Hour
+
+	initiator, receiver := newTestNegotiations(offer, terms)
+	i, r := runNegotiation(t, initiator, receiver, terms)
+	require.NoError(t, i.err)
+	require.NoError(t, r.err)
+	require.Equal(t, i.agreed, r.agreed)
+	require.Equal(t, 2.0, i.agreed.DesiredAmount)
+	require.InDelta(t, 0.1, i.agreed.ProvidesAmount, 1e-12)
+	require.Equal(t, common.SwapTimeout, i.agreed.Timeout)
+}
+
+func TestNegotiation_CounterProvidesLess(t *testing.T) {
+	offer := newTestOffer(t)
+	offer.MinimumAmount = 1
+
+	// the receiver counters with its minimum amount, which costs more than the initiator will provide
+	terms := newTestTerms(t, offer)
+	terms.DesiredAmount = 0.5
+	terms.ProvidesAmount = 0.025
+
+	initiator, receiver := newTestNegotiations(offer, terms)
+	i, r := runNegotiation(t, initiator, receiver, terms)
+	require.True(t, errors.Is(r.err, errSwapRejected), r.err)
+	require.True(t, errors.Is(i.err, errNoAgreement), i.err)
+}
+
+func TestNegotiation_WorsePrice(t *testing.T) {
+	offer := newTestOffer(t)
+
+	terms := newTestTerms(t, offer)
+	terms.ProvidesAmount = 0.04
+
+	initiator, receiver := newTestNegotiations(offer, terms)
+	i, r := runNegotiation(t, initiator, receiver, terms)
+	require.True(t, errors.Is(i.err, errWorsePrice), i.err)
+	require.True(t, errors.Is(r.err, errSwapRejected), r.err)
+	require.Nil(t, i.agreed)
+	require.Nil(t, r.agreed)
+}
+
+func TestNegotiation_MaxRounds(t *testing.T) {
+	offer := newTestOffer(t)
+	terms := newTestTerms(t, offer)
+
+	// both sides keep asking for a bit more
+	haggle := func(theirs *Terms) (*Terms, error) {
+		c := *theirs
+		c.DesiredAmount += 0.01
+		return &c, nil
+	}
+
+	i, r := runNegotiation(t, newNegotiation(3, haggle), newNegotiation(defaultMaxNegotiationRounds, haggle), terms)
+	require.True(t, errors.Is(i.err, errTooManyRounds), i.err)
+	require.True(t, errors.Is(r.err, errSwapRejected), r.err)
+}
+
+func TestNegotiation_Unexpected(t *testing.T) {
+	offer := newTestOffer(t)
+	terms := newTestTerms(t, offer)
+
+	// the receiver hasn't proposed anything to accept
+	_, receiver := newTestNegotiations(offer, terms)
+	reply, agreed, err := receiver.handle(&AcceptMessage{SwapID: terms.SwapID, Round: 1})
+	require.Error(t, err)
+	require.Nil(t, agreed)
+	require.IsType(t, &RejectMessage{}, reply)
+
+	// the first proposal must be in round 1
+	_, receiver = newTestNegotiations(offer, terms)
+	_, _, err = receiver.handle(&ProposeMessage{Round: 2, Terms: *terms})
+	require.Error(t, err)
+
+	// the initiator doesn't take proposals for another swap
+	initiator, _ := newTestNegotiations(offer, terms)
+	initiator.propose(terms)
+	other := *terms
+	other.SwapID = testSwapID(t)
+	_, _, err = initiator.handle(&ProposeMessage{Round: 2, Terms: other})
+	require.True(t, errors.Is(err, errChangedTerms))
+
+	_, _, err = initiator.handle(&NotifyReady{SwapID: terms.SwapID})
+	require.Error(t, err)
+}
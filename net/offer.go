@@ -0,0 +1,513 @@
+package net
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+const (
+	// defaultOfferTTL is how long an offer is live for, if no time is given.
+	defaultOfferTTL = time.Hour * 24
+	// maxOfferTTL is the longest an offer can be live for. Peers drop offers that expire later.
+	maxOfferTTL = time.Hour * 24 * 7
+	// offers from peers whose clock is ahead of ours by up to this much aren't dropped
+	maxClockSkew = time.Minute * 5
+)
+
+// offerSigningPrefix is prepended to an offer before it's signed, so that the signature can't be
+// mistaken for one over some other message.
+const offerSigningPrefix = "atomic-swap offer:"
+
+// priceTolerance allows for floating point error when comparing prices.
+const priceTolerance = 1e-9
+
+var (
+	errInvalidOfferID      = errors.New("offer ID must be a 32-byte hex string")
+	errInvalidSignature    = errors.New("invalid offer signature")
+	errOfferNotFound       = errors.New("no live offer with given ID")
+	errOfferExpired        = errors.New("offer has expired")
+	errOfferMismatch       = errors.New("swap doesn't match the offer")
+	errInvalidOfferAmounts = errors.New("offer must have 0 <= minimum amount <= maximum amount, and a maximum amount above 0")
+	errOfferTTLTooLong     = fmt.Errorf("offer can't be live for longer than %s", maxOfferTTL)
+	errOfferTaken          = errors.New("offer's amount has been taken by other swaps")
+)
+
+// OfferID uniquely identifies an offer. It's chosen at random by the maker.
+type OfferID [32]byte
+
+// NewOfferIDFromString parses a hex-encoded offer ID.
+func NewOfferIDFromString(s string) (OfferID, error) {
+	var id OfferID
+	if err := id.UnmarshalText([]byte(s)); err != nil {
+		return OfferID{}, err
+	}
+
+	return id, nil
+}
+
+// IsZero returns true if the ID is unset.
+func (id OfferID) IsZero() bool {
+	return id == OfferID{}
+}
+
+func (id OfferID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes the ID as a hex string; it's used for JSON encoding.
+func (id OfferID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes a hex string into the ID; it's used for JSON decoding.
+func (id *OfferID) UnmarshalText(text []byte) error {
+	if len(text) != hex.EncodedLen(len(id)) {
+		return errInvalidOfferID
+	}
+
+	if _, err := hex.Decode(id[:], text); err != nil {
+		return errInvalidOfferID
+	}
+
+	return nil
+}
+
+// Offer is published by a maker to advertise a swap it's willing to do. Offers are signed with the
+// maker's libp2p key, so they can be passed on by other peers.
+type Offer struct {
+	ID       OfferID             `json:"id"`
+	Maker    peer.ID             `json:"maker"`
+	Provides common.ProvidesCoin `json:"provides"` // the coin the maker provides
+	Desires  common.ProvidesCoin `json:"desires"`  // the coin the maker wants in return
+	// MinimumAmount and MaximumAmount bound the amount of the provided coin that can be swapped.
+	MinimumAmount float64             `json:"minimumAmount"`
+	MaximumAmount float64             `json:"maximumAmount"`
+	ExchangeRate  common.ExchangeRate `json:"exchangeRate"` // ETH per XMR
+	ExpiresAt     time.Time           `json:"expiresAt"`
+	// Timeouts are the durations of the swap contract's timelocks that the maker supports.
+	Timeouts        []time.Duration `json:"timeouts"`
+	ContractVersion uint32          `json:"contractVersion"`
+	Signature       []byte          `json:"signature"`
+	// Addrs are the maker's addresses, including any through its relays. They aren't signed, as they
+	// change over time; they're set by the maker when it publishes the offer.
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+func (o *Offer) String() string {
+	return fmt.Sprintf("Offer ID=%s Maker=%s Provides=%s Desires=%s Amount=%v-%v ExchangeRate=%v ExpiresAt=%s",
+		o.ID,
+		o.Maker,
+		o.Provides,
+		o.Desires,
+		o.MinimumAmount,
+		o.MaximumAmount,
+		o.ExchangeRate,
+		o.ExpiresAt.Format(time.RFC3339),
+	)
+}
+
+// newOffer validates the given offer and returns a copy of it that's ready to be signed, with a new ID.
+func newOffer(o *Offer, ttl time.Duration) (*Offer, error) {
+	if o.Provides == o.Desires {
+		return nil, errors.New("offer must provide and desire different coins")
+	}
+
+	for _, c := range []common.ProvidesCoin{o.Provides, o.Desires} {
+		if _, err := common.NewProvidesCoin(string(c)); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.MinimumAmount < 0 || o.MaximumAmount <= 0 || o.MinimumAmount > o.MaximumAmount {
+		return nil, errInvalidOfferAmounts
+	}
+
+	if o.ExchangeRate <= 0 {
+		return nil, errors.New("offer must have an exchange rate above 0")
+	}
+
+	offer := *o
+	if _, err := rand.Read(offer.ID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate offer ID: %w", err)
+	}
+
+	// expiry is encoded in seconds
+	offer.ExpiresAt = time.Now().Add(ttl).Truncate(time.Second)
+	if len(offer.Timeouts) == 0 {
+		offer.Timeouts = []time.Duration{common.SwapTimeout}
+	}
+
+	offer.ContractVersion = common.SwapContractVersion
+	return &offer, nil
+}
+
+// Sign sets the offer's maker to the peer with the given key, and signs the offer.
+func (o *Offer) Sign(key crypto.PrivKey) error {
+	maker, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	o.Maker = maker
+	o.Signature, err = key.Sign(o.signingBytes())
+	return err
+}
+
+// Verify returns an error if the offer wasn't signed by its maker.
+func (o *Offer) Verify() error {
+	pub, err := o.Maker.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get maker's public key: %w", err)
+	}
+
+	ok, err := pub.Verify(o.signingBytes(), o.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidSignature, err)
+	}
+
+	if !ok {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// IsExpired returns true if the offer can no longer be taken.
+func (o *Offer) IsExpired() bool {
+	return !time.Now().Before(o.ExpiresAt)
+}
+
+// signingBytes returns the encoded offer without its signature.
+func (o *Offer) signingBytes() []byte {
+	return o.marshalUnsigned([]byte(offerSigningPrefix))
+}
+
+// checkTerms returns an error if the terms don't take the offer. The swap's amounts must be within
+// the offer's bounds and at least as good for the maker as the offer's exchange rate, and its
+// timeout must be one that the offer supports.
+func (o *Offer) checkTerms(t *Terms) error {
+	if o.IsExpired() {
+		return errOfferExpired
+	}
+
+	if t.Provides != o.Desires {
+		return fmt.Errorf("%w: offer desires %s, but initiator provides %s", errOfferMismatch, o.Desires, t.Provides)
+	}
+
+	if t.DesiredAmount <= 0 || t.DesiredAmount < o.MinimumAmount || t.DesiredAmount > o.MaximumAmount {
+		return fmt.Errorf("%w: desired amount %v is outside of offer's bounds %v-%v",
+			errOfferMismatch, t.DesiredAmount, o.MinimumAmount, o.MaximumAmount)
+	}
+
+	required := o.required(t.DesiredAmount)
+	if t.ProvidesAmount < required*(1-priceTolerance) {
+		return fmt.Errorf("%w: provided amount %v is less than %v at the offer's exchange rate",
+			errOfferMismatch, t.ProvidesAmount, required)
+	}
+
+	if !o.supportsTimeout(t.Timeout) {
+		return fmt.Errorf("%w: offer doesn't support timeout %s", errOfferMismatch, t.Timeout)
+	}
+
+	return nil
+}
+
+// required returns the amount the initiator must provide to receive the given amount of the
+// maker's coin at the offer's exchange rate, which is ETH per XMR.
+func (o *Offer) required(desired float64) float64 {
+	if o.Provides == common.ProvidesXMR {
+		return desired * float64(o.ExchangeRate)
+	}
+
+	return desired / float64(o.ExchangeRate)
+}
+
+func (o *Offer) supportsTimeout(timeout time.Duration) bool {
+	for _, t := range o.Timeouts {
+		if t == timeout {
+			return true
+		}
+	}
+
+	return false
+}
+
+// counter returns terms that take the offer and are as close as possible to the given ones, or
+// nil if the given terms already take it. An error is returned if the terms can't be countered.
+func (o *Offer) counter(t *Terms) (*Terms, error) {
+	err := o.checkTerms(t)
+	if err == nil {
+		return nil, nil
+	}
+
+	if !errors.Is(err, errOfferMismatch) || t.Provides != o.Desires || len(o.Timeouts) == 0 {
+		return nil, err
+	}
+
+	c := *t
+	c.DesiredAmount = math.Min(math.Max(t.DesiredAmount, o.MinimumAmount), o.MaximumAmount)
+	if c.DesiredAmount <= 0 {
+		return nil, err
+	}
+
+	// keep the initiator's price if it's better for us than the offer's
+	c.ProvidesAmount = o.required(c.DesiredAmount)
+	if t.DesiredAmount > 0 {
+		c.ProvidesAmount = math.Max(c.ProvidesAmount, t.ProvidesAmount*c.DesiredAmount/t.DesiredAmount)
+	}
+
+	if !o.supportsTimeout(t.Timeout) {
+		c.Timeout = o.Timeouts[0]
+	}
+
+	return &c, nil
+}
+
+func (o *Offer) marshal(b []byte) []byte {
+	b = o.marshalUnsigned(b)
+	b = appendBytes(b, 11, o.Signature)
+	for _, addr := range o.Addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			// we only ever set our own addresses, which are valid
+			continue
+		}
+
+		b = appendBytes(b, 12, maddr.Bytes())
+	}
+
+	return b
+}
+
+func (o *Offer) marshalUnsigned(b []byte) []byte {
+	if !o.ID.IsZero() {
+		b = appendBytes(b, 1, o.ID[:])
+	}
+
+	b = appendString(b, 2, string(o.Maker))
+	b = appendString(b, 3, string(o.Provides))
+	b = appendString(b, 4, string(o.Desires))
+	b = appendDouble(b, 5, o.MinimumAmount)
+	b = appendDouble(b, 6, o.MaximumAmount)
+	b = appendDouble(b, 7, float64(o.ExchangeRate))
+	if !o.ExpiresAt.IsZero() {
+		b = appendUint64(b, 8, uint64(o.ExpiresAt.Unix()))
+	}
+
+	for _, timeout := range o.Timeouts {
+		b = appendUint64(b, 9, uint64(timeout/time.Second))
+	}
+
+	return appendUint32(b, 10, o.ContractVersion)
+}
+
+func (o *Offer) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case 1:
+			o.ID, err = f.offerID()
+		case 2:
+			var maker string
+			maker, err = f.string()
+			o.Maker = peer.ID(maker)
+		case 3:
+			var c string
+			c, err = f.string()
+			o.Provides = common.ProvidesCoin(c)
+		case 4:
+			var c string
+			c, err = f.string()
+			o.Desires = common.ProvidesCoin(c)
+		case 5:
+			o.MinimumAmount, err = f.double()
+		case 6:
+			o.MaximumAmount, err = f.double()
+		case 7:
+			var rate float64
+			rate, err = f.double()
+			o.ExchangeRate = common.ExchangeRate(rate)
+		case 8:
+			var expiresAt uint64
+			expiresAt, err = f.uint64()
+			o.ExpiresAt = time.Unix(int64(expiresAt), 0)
+		case 9:
+			var timeout uint64
+			timeout, err = f.uint64()
+			o.Timeouts = append(o.Timeouts, time.Duration(timeout)*time.Second)
+		case 10:
+			o.ContractVersion, err = f.uint32()
+		case 11:
+			o.Signature, err = f.bytes()
+		case 12:
+			var addr []byte
+			if addr, err = f.bytes(); err != nil {
+				return err
+			}
+
+			var maddr ma.Multiaddr
+			if maddr, err = ma.NewMultiaddrBytes(addr); err != nil {
+				return err
+			}
+
+			o.Addrs = append(o.Addrs, maddr.String())
+		}
+		return err
+	})
+}
+
+// MakeOffer signs a new offer with the given terms, and broadcasts it on the offers topic. It's
+// live for the given duration, or for a day if the duration is 0. Peers keep only maxOffersPerMaker
+// of our offers, so we don't make more than that.
+func (h *host) MakeOffer(terms *Offer, ttl time.Duration) (*Offer, error) {
+	if ttl == 0 {
+		ttl = defaultOfferTTL
+	}
+
+	if ttl > maxOfferTTL {
+		return nil, errOfferTTLTooLong
+	}
+
+	if len(h.Offers()) >= maxOffersPerMaker {
+		return nil, fmt.Errorf("can't have more than %d live offers", maxOffersPerMaker)
+	}
+
+	offer, err := newOffer(terms, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = offer.Sign(h.key); err != nil {
+		return nil, err
+	}
+
+	h.offerMu.Lock()
+	h.offers[offer.ID] = offer
+	h.offerMu.Unlock()
+
+	log.Infof("made offer: %s", offer)
+	if err = h.publishOffers([]*Offer{offer}); err != nil {
+		log.Warnf("failed to broadcast offer: id=%s err=%s", offer.ID, err)
+	}
+
+	return offer, nil
+}
+
+// Offers returns our live offers.
+func (h *host) Offers() []*Offer {
+	h.offerMu.Lock()
+	defer h.offerMu.Unlock()
+
+	offers := make([]*Offer, 0, len(h.offers))
+	for id, o := range h.offers {
+		if o.IsExpired() {
+			delete(h.offers, id)
+			delete(h.committed, id)
+			continue
+		}
+
+		offers = append(offers, o)
+	}
+
+	sort.Slice(offers, func(i, j int) bool {
+		return offers[i].ExpiresAt.Before(offers[j].ExpiresAt)
+	})
+
+	return offers
+}
+
+// counterProposal decides on a proposal for a swap that takes one of our offers. It returns nil
+// if the proposal takes the offer, or a counter-proposal that does.
+func (h *host) counterProposal(t *Terms) (*Terms, error) {
+	if h.hasSwap(t.SwapID) {
+		return nil, fmt.Errorf("already have ongoing swap with id %s", t.SwapID)
+	}
+
+	h.offerMu.Lock()
+	offer, err := h.remaining(t.OfferID)
+	h.offerMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return offer.counter(t)
+}
+
+// remaining returns a copy of our offer with the given ID, whose maximum amount is what hasn't been
+// taken by other swaps. It must be called with offerMu held.
+func (h *host) remaining(id OfferID) (*Offer, error) {
+	offer, has := h.offers[id]
+	if !has {
+		return nil, errOfferNotFound
+	}
+
+	remaining := *offer
+	remaining.MaximumAmount -= h.committed[id]
+	if remaining.MaximumAmount <= 0 || remaining.MaximumAmount < remaining.MinimumAmount {
+		return nil, errOfferTaken
+	}
+
+	return &remaining, nil
+}
+
+// reserveOffer takes the amount of the agreed terms from their offer, if it hasn't been taken by
+// other swaps since the terms were agreed.
+func (h *host) reserveOffer(t *Terms) error {
+	h.offerMu.Lock()
+	defer h.offerMu.Unlock()
+
+	offer, err := h.remaining(t.OfferID)
+	if err != nil {
+		return err
+	}
+
+	if err = offer.checkTerms(t); err != nil {
+		return err
+	}
+
+	h.committed[t.OfferID] += t.DesiredAmount
+	return nil
+}
+
+// releaseOffer returns the amount of the terms to their offer, once their swap was aborted or refunded.
+func (h *host) releaseOffer(t *Terms) {
+	h.offerMu.Lock()
+	defer h.offerMu.Unlock()
+
+	committed, has := h.committed[t.OfferID]
+	if !has {
+		// the offer has expired
+		return
+	}
+
+	if committed -= t.DesiredAmount; committed <= 0 {
+		delete(h.committed, t.OfferID)
+		return
+	}
+
+	h.committed[t.OfferID] = committed
+}
+
+// settleOffer waits for the swap that took the offer to complete, and releases the amount it took
+// unless it succeeded. The swap may continue after its stream closes, eg. until it's refunded.
+func (h *host) settleOffer(t *Terms, s SwapState) {
+	select {
+	case <-s.Done():
+	case <-h.ctx.Done():
+		return
+	}
+
+	if s.Status() != common.CompletedSuccess {
+		h.releaseOffer(t)
+	}
+}
@@ -0,0 +1,239 @@
+package net
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func newTestHost(t *testing.T) *host {
+	key, err := generateKey(1, "")
+	require.NoError(t, err)
+
+	return &host{
+		key:       key,
+		offers:    make(map[OfferID]*Offer),
+		committed: make(map[OfferID]float64),
+	}
+}
+
+// newTestOffer returns a signed offer to swap up to 2 XMR at 0.05 ETH/XMR.
+func newTestOffer(t *testing.T) *Offer {
+	h := newTestHost(t)
+	offer, err := h.MakeOffer(&Offer{
+		Provides:      common.ProvidesXMR,
+		Desires:       common.ProvidesETH,
+		MinimumAmount: 0.5,
+		MaximumAmount: 2,
+		ExchangeRate:  0.05,
+	}, time.Hour)
+	require.NoError(t, err)
+	return offer
+}
+
+func TestOffer_Sign(t *testing.T) {
+	offer := newTestOffer(t)
+	require.False(t, offer.ID.IsZero())
+	require.Equal(t, common.SwapContractVersion, offer.ContractVersion)
+	require.Equal(t, []time.Duration{common.SwapTimeout}, offer.Timeouts)
+	require.NoError(t, offer.Verify())
+
+	// the signature survives encoding
+	dec := new(Offer)
+	require.NoError(t, dec.unmarshal(offer.marshal(nil)))
+	require.Equal(t, offer, dec)
+	require.NoError(t, dec.Verify())
+
+	// as do the maker's addresses, which aren't signed
+	offer.Addrs = []string{"/ip4/1.2.3.4/tcp/9933", "/ip4/5.6.7.8/tcp/9933/p2p-circuit"}
+	require.NoError(t, offer.Verify())
+	dec = new(Offer)
+	require.NoError(t, dec.unmarshal(offer.marshal(nil)))
+	require.Equal(t, offer, dec)
+	require.NoError(t, dec.Verify())
+
+	// changing any term invalidates the signature
+	dec.ExchangeRate = 0.01
+	require.True(t, errors.Is(dec.Verify(), errInvalidSignature))
+
+	// as does claiming that someone else made it
+	dec = new(Offer)
+	require.NoError(t, dec.unmarshal(offer.marshal(nil)))
+	other, err := generateKey(2, "")
+	require.NoError(t, err)
+	require.NoError(t, dec.Sign(other))
+	dec.Maker = offer.Maker
+	require.Error(t, dec.Verify())
+}
+
+func TestHost_MakeOffer_Invalid(t *testing.T) {
+	h := newTestHost(t)
+
+	for _, terms := range []*Offer{
+		{Provides: common.ProvidesXMR, Desires: common.ProvidesXMR, MaximumAmount: 1, ExchangeRate: 1},
+		{Provides: common.ProvidesXMR, Desires: "BTC", MaximumAmount: 1, ExchangeRate: 1},
+		{Provides: common.ProvidesXMR, Desires: common.ProvidesETH, ExchangeRate: 1},
+		{Provides: common.ProvidesXMR, Desires: common.ProvidesETH, MinimumAmount: 2, MaximumAmount: 1, ExchangeRate: 1},
+		{Provides: common.ProvidesXMR, Desires: common.ProvidesETH, MaximumAmount: 1},
+	} {
+		_, err := h.MakeOffer(terms, 0)
+		require.Error(t, err)
+	}
+
+	require.Empty(t, h.Offers())
+}
+
+func TestHost_Offers_Expire(t *testing.T) {
+	h := newTestHost(t)
+	offer, err := h.MakeOffer(&Offer{
+		Provides:      common.ProvidesETH,
+		Desires:       common.ProvidesXMR,
+		MaximumAmount: 1,
+		ExchangeRate:  0.05,
+	}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []*Offer{offer}, h.Offers())
+
+	offer.ExpiresAt = time.Now().Add(-time.Second)
+	require.Empty(t, h.Offers())
+}
+
+func TestOffer_CheckTerms(t *testing.T) {
+	offer := newTestOffer(t)
+
+	// the initiator provides ETH, and desires the maker's XMR
+	valid := func() *Terms {
+		return &Terms{
+			OfferID:        offer.ID,
+			Provides:       common.ProvidesETH,
+			ProvidesAmount: 0.05,
+			DesiredAmount:  1,
+			Timeout:        common.SwapTimeout,
+		}
+	}
+
+	require.NoError(t, offer.checkTerms(valid()))
+
+	terms := valid()
+	terms.Provides = common.ProvidesXMR
+	require.True(t, errors.Is(offer.checkTerms(terms), errOfferMismatch))
+
+	terms = valid()
+	terms.DesiredAmount = 3
+	terms.ProvidesAmount = 0.15
+	require.True(t, errors.Is(offer.checkTerms(terms), errOfferMismatch))
+
+	terms = valid()
+	terms.DesiredAmount = 0.1
+	terms.ProvidesAmount = 0.005
+	require.True(t, errors.Is(offer.checkTerms(terms), errOfferMismatch))
+
+	// paying less than the offer's rate isn't allowed, but paying more is
+	terms = valid()
+	terms.ProvidesAmount = 0.04
+	require.True(t, errors.Is(offer.checkTerms(terms), errOfferMismatch))
+
+	terms = valid()
+	terms.ProvidesAmount = 0.06
+	require.NoError(t, offer.checkTerms(terms))
+
+	terms = valid()
+	terms.Timeout = time.Hour
+	require.True(t, errors.Is(offer.checkTerms(terms), errOfferMismatch))
+
+	offer.ExpiresAt = time.Now().Add(-time.Second)
+	require.Equal(t, errOfferExpired, offer.checkTerms(valid()))
+}
+
+func TestOffer_Counter(t *testing.T) {
+	offer := newTestOffer(t)
+
+	terms := &Terms{
+		OfferID:        offer.ID,
+		Provides:       common.ProvidesETH,
+		ProvidesAmount: 0.05,
+		DesiredAmount:  1,
+		Timeout:        common.SwapTimeout,
+	}
+
+	counter, err := offer.counter(terms)
+	require.NoError(t, err)
+	require.Nil(t, counter)
+
+	// too much XMR is desired, at a price that's too low, with an unsupported timeout
+	terms.DesiredAmount = 4
+	terms.ProvidesAmount = 0.1
+	terms.Timeout = time.Hour
+	counter, err = offer.counter(terms)
+	require.NoError(t, err)
+	require.Equal(t, 2.0, counter.DesiredAmount)
+	require.InDelta(t, 0.1, counter.ProvidesAmount, 1e-12)
+	require.Equal(t, common.SwapTimeout, counter.Timeout)
+	require.NoError(t, offer.checkTerms(counter))
+
+	// a better price than the offer's is kept
+	terms.DesiredAmount = 4
+	terms.ProvidesAmount = 0.4
+	counter, err = offer.counter(terms)
+	require.NoError(t, err)
+	require.Equal(t, 2.0, counter.DesiredAmount)
+	require.InDelta(t, 0.2, counter.ProvidesAmount, 1e-12)
+
+	// the wrong coin can't be countered
+	terms.Provides = common.ProvidesXMR
+	_, err = offer.counter(terms)
+	require.True(t, errors.Is(err, errOfferMismatch))
+}
+
+func TestHost_CounterProposal(t *testing.T) {
+	h := newTestHost(t)
+	h.swaps = make(map[common.SwapID]*swap)
+
+	_, err := h.counterProposal(&Terms{OfferID: OfferID{1}})
+	require.Equal(t, errOfferNotFound, err)
+}
+
+func TestHost_ReserveOffer(t *testing.T) {
+	h := newTestHost(t)
+	h.swaps = make(map[common.SwapID]*swap)
+	offer, err := h.MakeOffer(&Offer{
+		Provides:      common.ProvidesXMR,
+		Desires:       common.ProvidesETH,
+		MinimumAmount: 0.5,
+		MaximumAmount: 2,
+		ExchangeRate:  0.05,
+	}, time.Hour)
+	require.NoError(t, err)
+
+	terms := func(amount float64) *Terms {
+		return &Terms{
+			OfferID:        offer.ID,
+			Provides:       common.ProvidesETH,
+			ProvidesAmount: amount * 0.05,
+			DesiredAmount:  amount,
+			Timeout:        common.SwapTimeout,
+		}
+	}
+
+	first := terms(1.5)
+	require.NoError(t, h.reserveOffer(first))
+
+	// only what's left of the offer can be taken
+	counter, err := h.counterProposal(terms(1))
+	require.NoError(t, err)
+	require.Equal(t, 0.5, counter.DesiredAmount)
+	require.True(t, errors.Is(h.reserveOffer(terms(1)), errOfferMismatch))
+	require.NoError(t, h.reserveOffer(terms(0.5)))
+	_, err = h.counterProposal(terms(0.5))
+	require.Equal(t, errOfferTaken, err)
+
+	// an aborted or refunded swap gives back what it took
+	h.releaseOffer(first)
+	require.NoError(t, h.reserveOffer(terms(1)))
+	require.NoError(t, h.reserveOffer(terms(0.5)))
+	require.Equal(t, errOfferTaken, h.reserveOffer(terms(0.5)))
+}
@@ -0,0 +1,289 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+const (
+	offersTopicID = "/offers/0"
+
+	// offerBroadcastInterval is how often we re-broadcast our live offers, so that peers who
+	// joined the network since we made them learn about them.
+	offerBroadcastInterval = time.Minute
+	// maxOrderBookSize bounds the number of offers we keep from other peers.
+	maxOrderBookSize = 4096
+	// maxOffersPerMaker bounds the number of offers we keep from a single maker, so that one
+	// peer can't fill the book.
+	maxOffersPerMaker = 64
+)
+
+var (
+	errOrderBookFull    = errors.New("order book is full")
+	errTooManyOffers    = errors.New("maker has too many offers in the order book")
+	errOfferExpiresLate = errors.New("offer expires too far in the future")
+)
+
+// offerKey identifies an offer in the book. Offer IDs are chosen by their maker, so they're only
+// unique per maker.
+type offerKey struct {
+	maker peer.ID
+	id    OfferID
+}
+
+// orderBook holds the live offers broadcast by makers on the offers topic.
+type orderBook struct {
+	sync.Mutex
+	offers   map[offerKey]*Offer
+	perMaker map[peer.ID]int
+	maxSize  int
+}
+
+func newOrderBook(maxSize int) *orderBook {
+	return &orderBook{
+		offers:   make(map[offerKey]*Offer),
+		perMaker: make(map[peer.ID]int),
+		maxSize:  maxSize,
+	}
+}
+
+// add adds the offer to the book. It returns false if we already have the offer, or if it's expired.
+// Offers that expire later than any offer we'd make are rejected, as they'd take up room in the
+// book until then.
+func (b *orderBook) add(o *Offer) (bool, error) {
+	if o.IsExpired() {
+		return false, nil
+	}
+
+	if time.Until(o.ExpiresAt) > maxOfferTTL+maxClockSkew {
+		return false, errOfferExpiresLate
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	key := offerKey{maker: o.Maker, id: o.ID}
+	if _, has := b.offers[key]; has {
+		return false, nil
+	}
+
+	if len(b.offers) >= b.maxSize || b.perMaker[o.Maker] >= maxOffersPerMaker {
+		b.prune()
+	}
+
+	if b.perMaker[o.Maker] >= maxOffersPerMaker {
+		return false, errTooManyOffers
+	}
+
+	if len(b.offers) >= b.maxSize {
+		return false, errOrderBookFull
+	}
+
+	b.offers[key] = o
+	b.perMaker[o.Maker]++
+	return true, nil
+}
+
+// prune removes expired offers. It must be called with the lock held.
+func (b *orderBook) prune() {
+	for key, o := range b.offers {
+		if !o.IsExpired() {
+			continue
+		}
+
+		delete(b.offers, key)
+		if b.perMaker[key.maker]--; b.perMaker[key.maker] == 0 {
+			delete(b.perMaker, key.maker)
+		}
+	}
+}
+
+// get returns the live offers that provide the given coin, or all live offers if it's empty.
+// Offers are grouped by the coin they provide, and sorted with the best exchange rate for the
+// taker first: the lowest rate for offers providing XMR, and the highest for offers providing ETH.
+func (b *orderBook) get(provides common.ProvidesCoin) []*Offer {
+	b.Lock()
+	defer b.Unlock()
+
+	b.prune()
+
+	offers := make([]*Offer, 0, len(b.offers))
+	for _, o := range b.offers {
+		if provides == "" || o.Provides == provides {
+			offers = append(offers, o)
+		}
+	}
+
+	sort.Slice(offers, func(i, j int) bool {
+		a, b := offers[i], offers[j]
+		if a.Provides != b.Provides {
+			return a.Provides < b.Provides
+		}
+
+		if a.ExchangeRate == b.ExchangeRate {
+			return a.ExpiresAt.After(b.ExpiresAt)
+		}
+
+		if a.Provides == common.ProvidesXMR {
+			return a.ExchangeRate < b.ExchangeRate
+		}
+
+		return a.ExchangeRate > b.ExchangeRate
+	})
+
+	return offers
+}
+
+// OrderBook returns the live offers that have been broadcast by makers on the network, including
+// ours, sorted by exchange rate. If provides is empty, offers for both coins are returned.
+func (h *host) OrderBook(provides common.ProvidesCoin) []*Offer {
+	return h.book.get(provides)
+}
+
+// startOrderBook joins the offers topic, which is scoped to our environment and chain ID, and
+// starts building the order book from the offers broadcast on it.
+func (h *host) startOrderBook() error {
+	ps, err := pubsub.NewGossipSub(h.ctx, h.h)
+	if err != nil {
+		return fmt.Errorf("failed to create pubsub router: %w", err)
+	}
+
+	topic := h.protocolID + offersTopicID
+	if err = ps.RegisterTopicValidator(topic, h.validateOffers); err != nil {
+		return err
+	}
+
+	h.offersTopic, err = ps.Join(topic)
+	if err != nil {
+		return fmt.Errorf("failed to join offers topic: %w", err)
+	}
+
+	sub, err := h.offersTopic.Subscribe()
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to offers topic: %w", err)
+	}
+
+	go h.receiveOffers(sub)
+	if !h.noAdvertise {
+		go h.broadcastOffers()
+	}
+
+	return nil
+}
+
+// validateOffers checks that a message on the offers topic only holds live offers signed by the
+// peer that published it. Invalid messages aren't added to the book or passed on to other peers.
+func (h *host) validateOffers(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if h.reputation.isBanned(msg.GetFrom()) {
+		return pubsub.ValidationIgnore
+	}
+
+	offers, err := decodeOffers(msg.GetFrom(), msg.Data)
+	if err != nil {
+		log.Debugf("rejecting offers: peer=%s err=%s", msg.GetFrom(), err)
+		h.reputation.record(msg.GetFrom(), ProtocolViolation)
+		return pubsub.ValidationReject
+	}
+
+	msg.ValidatorData = offers
+	return pubsub.ValidationAccept
+}
+
+// decodeOffers decodes a QueryResponse published on the offers topic, and verifies its offers.
+func decodeOffers(from peer.ID, data []byte) ([]*Offer, error) {
+	msg, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, ok := msg.(*QueryResponse)
+	if !ok {
+		return nil, fmt.Errorf("expected QueryResponse, received %T", msg)
+	}
+
+	for _, o := range resp.Offers {
+		if o.Maker != from {
+			return nil, fmt.Errorf("offer %s was made by another peer %s", o.ID, o.Maker)
+		}
+
+		if err = o.Verify(); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp.Offers, nil
+}
+
+func (h *host) receiveOffers(sub *pubsub.Subscription) {
+	defer sub.Cancel()
+
+	for {
+		msg, err := sub.Next(h.ctx)
+		if err != nil {
+			// the context was cancelled, or the subscription was closed
+			return
+		}
+
+		offers, ok := msg.ValidatorData.([]*Offer)
+		if !ok {
+			continue
+		}
+
+		for _, o := range offers {
+			added, err := h.book.add(o)
+			if err != nil {
+				log.Debugf("failed to add offer to order book: id=%s err=%s", o.ID, err)
+				continue
+			}
+
+			if added {
+				log.Debugf("added offer to order book: %s", o)
+			}
+		}
+	}
+}
+
+func (h *host) broadcastOffers() {
+	ticker := time.NewTicker(offerBroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			offers := h.Offers()
+			if len(offers) == 0 {
+				continue
+			}
+
+			if err := h.publishOffers(offers); err != nil {
+				log.Debugf("failed to broadcast offers: err=%s", err)
+			}
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// publishOffers broadcasts the given offers on the offers topic, with our current addresses, unless
+// we don't advertise.
+func (h *host) publishOffers(offers []*Offer) error {
+	if h.offersTopic == nil || h.noAdvertise {
+		return nil
+	}
+
+	enc, err := (&QueryResponse{Offers: h.withAddrs(offers)}).Encode()
+	if err != nil {
+		return err
+	}
+
+	return h.offersTopic.Publish(h.ctx, enc)
+}
@@ -0,0 +1,164 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func newTestOfferWithRate(t *testing.T, provides common.ProvidesCoin, rate common.ExchangeRate) *Offer {
+	h := newTestHost(t)
+	offer, err := h.MakeOffer(&Offer{
+		Provides:      provides,
+		Desires:       provides.Counterpart(),
+		MaximumAmount: 1,
+		ExchangeRate:  rate,
+	}, time.Hour)
+	require.NoError(t, err)
+	return offer
+}
+
+func TestOrderBook_Add(t *testing.T) {
+	book := newOrderBook(2)
+	offer := newTestOffer(t)
+
+	added, err := book.add(offer)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// offers are deduplicated by ID
+	added, err = book.add(offer)
+	require.NoError(t, err)
+	require.False(t, added)
+
+	expired := newTestOffer(t)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	added, err = book.add(expired)
+	require.NoError(t, err)
+	require.False(t, added)
+
+	added, err = book.add(newTestOffer(t))
+	require.NoError(t, err)
+	require.True(t, added)
+
+	_, err = book.add(newTestOffer(t))
+	require.Equal(t, errOrderBookFull, err)
+
+	// expired offers make room for new ones
+	offer.ExpiresAt = time.Now().Add(-time.Second)
+	added, err = book.add(newTestOffer(t))
+	require.NoError(t, err)
+	require.True(t, added)
+	require.Len(t, book.get(""), 2)
+}
+
+func TestOrderBook_Get(t *testing.T) {
+	book := newOrderBook(maxOrderBookSize)
+
+	xmr1 := newTestOfferWithRate(t, common.ProvidesXMR, 0.05)
+	xmr2 := newTestOfferWithRate(t, common.ProvidesXMR, 0.04)
+	eth1 := newTestOfferWithRate(t, common.ProvidesETH, 0.05)
+	eth2 := newTestOfferWithRate(t, common.ProvidesETH, 0.06)
+	expired := newTestOfferWithRate(t, common.ProvidesXMR, 0.01)
+	for _, o := range []*Offer{xmr1, eth1, expired, xmr2, eth2} {
+		_, err := book.add(o)
+		require.NoError(t, err)
+	}
+
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+
+	// the taker gets the most XMR per ETH first, and the most ETH per XMR first
+	require.Equal(t, []*Offer{xmr2, xmr1}, book.get(common.ProvidesXMR))
+	require.Equal(t, []*Offer{eth2, eth1}, book.get(common.ProvidesETH))
+	require.Equal(t, []*Offer{eth2, eth1, xmr2, xmr1}, book.get(""))
+}
+
+func TestDecodeOffers(t *testing.T) {
+	offer := newTestOffer(t)
+
+	enc, err := (&QueryResponse{Offers: []*Offer{offer}}).Encode()
+	require.NoError(t, err)
+
+	offers, err := decodeOffers(offer.Maker, enc)
+	require.NoError(t, err)
+	require.Equal(t, []*Offer{offer}, offers)
+
+	// offers can only be published by their maker
+	key, err := generateKey(2, "")
+	require.NoError(t, err)
+	other, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	_, err = decodeOffers(other, enc)
+	require.Error(t, err)
+
+	// and must be signed
+	offer.ExchangeRate = 1
+	enc, err = (&QueryResponse{Offers: []*Offer{offer}}).Encode()
+	require.NoError(t, err)
+	_, err = decodeOffers(offer.Maker, enc)
+	require.Error(t, err)
+
+	enc, err = (&NotifyReady{}).Encode()
+	require.NoError(t, err)
+	_, err = decodeOffers(offer.Maker, enc)
+	require.Error(t, err)
+}
+
+func TestOrderBook_PerMaker(t *testing.T) {
+	book := newOrderBook(maxOrderBookSize)
+	offer := newTestOffer(t)
+
+	// IDs are only unique per maker
+	key, err := generateKey(2, "")
+	require.NoError(t, err)
+	other := newTestOffer(t)
+	other.ID = offer.ID
+	other.Maker, err = peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	for _, o := range []*Offer{offer, other} {
+		added, err := book.add(o)
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+
+	for i := 1; i < maxOffersPerMaker; i++ {
+		o := *offer
+		o.ID = OfferID{byte(i)}
+		added, err := book.add(&o)
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+
+	o := *offer
+	o.ID = OfferID{0, 1}
+	_, err = book.add(&o)
+	require.Equal(t, errTooManyOffers, err)
+
+	// expired offers make room for the maker's new ones
+	offer.ExpiresAt = time.Now().Add(-time.Second)
+	added, err := book.add(&o)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.Len(t, book.get(""), maxOffersPerMaker+1)
+}
+
+func TestOrderBook_ExpiresLate(t *testing.T) {
+	book := newOrderBook(maxOrderBookSize)
+	offer := newTestOffer(t)
+	offer.ExpiresAt = time.Now().Add(maxOfferTTL + maxClockSkew + time.Minute)
+	_, err := book.add(offer)
+	require.Equal(t, errOfferExpiresLate, err)
+
+	h := newTestHost(t)
+	_, err = h.MakeOffer(&Offer{
+		Provides:      common.ProvidesXMR,
+		Desires:       common.ProvidesETH,
+		MaximumAmount: 1,
+		ExchangeRate:  0.05,
+	}, maxOfferTTL+time.Second)
+	require.Equal(t, errOfferTTLTooLong, err)
+}
@@ -1,14 +1,11 @@
 package net
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/protocol"
 )
 
 const (
@@ -17,7 +14,20 @@ const (
 )
 
 func (h *host) handleQueryStream(stream libp2pnetwork.Stream) {
-	if err := h.writeToStream(stream, h.queryResponse); err != nil {
+	who := stream.Conn().RemotePeer()
+	if !h.reputation.isAllowed(who) {
+		log.Debugf("rejecting query: peer=%s err=%s", who, errPeerNotAllowed)
+		_ = stream.Reset()
+		return
+	}
+
+	if !h.limiter.allowQuery(who) {
+		log.Debugf("rejecting query: peer=%s err=%s", who, errRateLimited)
+		_ = stream.Reset()
+		return
+	}
+
+	if err := h.writeToStream(stream, &QueryResponse{Offers: h.withAddrs(h.Offers())}); err != nil {
 		log.Warnf("failed to send QueryResponse message to peer: err=%s", err)
 	}
 
@@ -25,14 +35,7 @@ func (h *host) handleQueryStream(stream libp2pnetwork.Stream) {
 }
 
 func (h *host) Query(who peer.AddrInfo) (*QueryResponse, error) {
-	ctx, cancel := context.WithTimeout(h.ctx, queryTimeout)
-	defer cancel()
-
-	if err := h.h.Connect(ctx, who); err != nil {
-		return nil, err
-	}
-
-	stream, err := h.h.NewStream(ctx, who.ID, protocol.ID(h.protocolID+queryID))
+	stream, err := h.newStream(who, queryID, queryTimeout, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open stream with peer: err=%w", err)
 	}
@@ -45,28 +48,58 @@ func (h *host) Query(who peer.AddrInfo) (*QueryResponse, error) {
 		_ = stream.Close()
 	}()
 
-	return h.receiveQueryResponse(stream)
+	resp, err := h.receiveQueryResponse(stream)
+	if err != nil {
+		h.recordError(who.ID, err)
+		return nil, err
+	}
+
+	return resp, nil
 }
 
 func (h *host) receiveQueryResponse(stream libp2pnetwork.Stream) (*QueryResponse, error) {
-	h.queryMu.Lock()
-	defer h.queryMu.Unlock()
-
-	buf := h.queryBuf
+	if err := stream.SetReadDeadline(time.Now().Add(queryTimeout)); err != nil {
+		return nil, err
+	}
 
-	n, err := readStream(stream, buf)
+	buf, err := readStream(stream, h.maxMessageSize)
 	if err != nil {
 		return nil, fmt.Errorf("read stream error: %w", err)
 	}
 
-	if n == 0 {
+	if len(buf) == 0 {
 		return nil, fmt.Errorf("received empty message")
 	}
 
-	var resp *QueryResponse
-	if err := json.Unmarshal(buf[1:n], &resp); err != nil {
+	msg, err := decodeMessage(buf)
+	if err != nil {
 		return nil, err
 	}
 
+	resp, ok := msg.(*QueryResponse)
+	if !ok {
+		return nil, fmt.Errorf("expected QueryResponse, received %T", msg)
+	}
+
+	// only keep offers that the peer made and signed itself
+	who := stream.Conn().RemotePeer()
+	offers := resp.Offers[:0]
+	for _, o := range resp.Offers {
+		if o.Maker != who {
+			log.Debugf("ignoring offer made by another peer: peer=%s maker=%s", who, o.Maker)
+			h.reputation.record(who, ProtocolViolation)
+			continue
+		}
+
+		if err = o.Verify(); err != nil {
+			log.Warnf("ignoring offer: peer=%s id=%s err=%s", who, o.ID, err)
+			h.reputation.record(who, ProtocolViolation)
+			continue
+		}
+
+		offers = append(offers, o)
+	}
+
+	resp.Offers = offers
 	return resp, nil
 }
@@ -0,0 +1,122 @@
+package net
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxQueriesPerMinute     = 30
+	defaultMaxSwapStreamsPerMinute = 5
+	defaultMaxSwapsPerPeer         = 2
+
+	// limits of peers we haven't heard from for this long are forgotten
+	limiterIdleTimeout   = time.Minute * 10
+	limiterPruneInterval = time.Minute
+)
+
+var (
+	errRateLimited         = errors.New("peer is opening streams too quickly")
+	errTooManySwapsPerPeer = errors.New("peer has reached the maximum number of concurrent swaps")
+)
+
+// peerLimiter limits how often each peer can query us and open swap streams with us, and how
+// many swap streams each peer can have open with us at once, including those still negotiating.
+type peerLimiter struct {
+	sync.Mutex
+	queryRate, swapRate   rate.Limit
+	queryBurst, swapBurst int
+	maxSwapsPerPeer       int
+	peers                 map[peer.ID]*peerLimits
+	lastPrune             time.Time
+}
+
+type peerLimits struct {
+	queries     *rate.Limiter
+	swapStreams *rate.Limiter
+	activeSwaps int
+	lastSeen    time.Time
+}
+
+func newPeerLimiter(queriesPerMinute, swapStreamsPerMinute, maxSwapsPerPeer int) *peerLimiter {
+	return &peerLimiter{
+		queryRate:       rate.Limit(float64(queriesPerMinute) / 60),
+		queryBurst:      queriesPerMinute,
+		swapRate:        rate.Limit(float64(swapStreamsPerMinute) / 60),
+		swapBurst:       swapStreamsPerMinute,
+		maxSwapsPerPeer: maxSwapsPerPeer,
+		peers:           make(map[peer.ID]*peerLimits),
+		lastPrune:       time.Now(),
+	}
+}
+
+// allowQuery returns true if the peer can query us now.
+func (l *peerLimiter) allowQuery(who peer.ID) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.get(who).queries.Allow()
+}
+
+// acquireSwap reserves one of the peer's swap streams. It must be released with releaseSwap
+// once the stream is closed.
+func (l *peerLimiter) acquireSwap(who peer.ID) error {
+	l.Lock()
+	defer l.Unlock()
+
+	p := l.get(who)
+	if p.activeSwaps >= l.maxSwapsPerPeer {
+		return errTooManySwapsPerPeer
+	}
+
+	if !p.swapStreams.Allow() {
+		return errRateLimited
+	}
+
+	p.activeSwaps++
+	return nil
+}
+
+func (l *peerLimiter) releaseSwap(who peer.ID) {
+	l.Lock()
+	defer l.Unlock()
+
+	if p, has := l.peers[who]; has && p.activeSwaps > 0 {
+		p.activeSwaps--
+		p.lastSeen = time.Now()
+	}
+}
+
+// get must be called with the lock held.
+func (l *peerLimiter) get(who peer.ID) *peerLimits {
+	now := time.Now()
+	if now.Sub(l.lastPrune) > limiterPruneInterval {
+		l.prune(now)
+	}
+
+	p, has := l.peers[who]
+	if !has {
+		p = &peerLimits{
+			queries:     rate.NewLimiter(l.queryRate, l.queryBurst),
+			swapStreams: rate.NewLimiter(l.swapRate, l.swapBurst),
+		}
+		l.peers[who] = p
+	}
+
+	p.lastSeen = now
+	return p
+}
+
+// prune forgets idle peers, whose limiters have refilled by now. It must be called with the lock held.
+func (l *peerLimiter) prune(now time.Time) {
+	for who, p := range l.peers {
+		if p.activeSwaps == 0 && now.Sub(p.lastSeen) > limiterIdleTimeout {
+			delete(l.peers, who)
+		}
+	}
+
+	l.lastPrune = now
+}
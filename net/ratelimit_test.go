@@ -0,0 +1,82 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func TestPeerLimiter_Query(t *testing.T) {
+	l := newPeerLimiter(2, 1, 1)
+	a, b := newTestPeerID(t), newTestPeerID(t)
+
+	require.True(t, l.allowQuery(a))
+	require.True(t, l.allowQuery(a))
+	require.False(t, l.allowQuery(a))
+
+	// peers are limited separately
+	require.True(t, l.allowQuery(b))
+}
+
+func TestPeerLimiter_Swap(t *testing.T) {
+	l := newPeerLimiter(1, 2, 1)
+	who := newTestPeerID(t)
+
+	require.NoError(t, l.acquireSwap(who))
+	require.ErrorIs(t, l.acquireSwap(who), errTooManySwapsPerPeer)
+
+	l.releaseSwap(who)
+	require.NoError(t, l.acquireSwap(who))
+	l.releaseSwap(who)
+
+	// the peer has used up both of its swap streams for this minute
+	require.ErrorIs(t, l.acquireSwap(who), errRateLimited)
+}
+
+func TestHost_SwapSlots(t *testing.T) {
+	h := &host{
+		swaps:    make(map[common.SwapID]*swap),
+		maxSwaps: 2,
+	}
+
+	// slots are taken as soon as a stream is accepted, not once its swap starts
+	h.swapMu.Lock()
+	require.NoError(t, h.reserveSwapSlot())
+	require.NoError(t, h.reserveSwapSlot())
+	require.ErrorIs(t, h.reserveSwapSlot(), errTooManySwaps)
+	h.swapMu.Unlock()
+
+	h.releaseSwapSlot()
+	h.swapMu.Lock()
+	require.NoError(t, h.reserveSwapSlot())
+	h.swapMu.Unlock()
+}
+
+func TestPeerLimiter_Prune(t *testing.T) {
+	l := newPeerLimiter(1, 1, 1)
+	idle, active := newTestPeerID(t), newTestPeerID(t)
+
+	require.True(t, l.allowQuery(idle))
+	require.NoError(t, l.acquireSwap(active))
+
+	// peers with open swap streams are kept, even if they've been idle
+	l.prune(time.Now().Add(limiterIdleTimeout * 2))
+	require.Len(t, l.peers, 1)
+	require.Contains(t, l.peers, active)
+}
+
+func TestHost_Query_RateLimited(t *testing.T) {
+	maker := newTestNetHost(t, &Config{MaxQueriesPerMinute: 1})
+	taker := newTestNetHost(t, &Config{})
+
+	who, err := StringToAddrInfo(loopbackAddr(t, maker))
+	require.NoError(t, err)
+
+	_, err = taker.Query(who)
+	require.NoError(t, err)
+	_, err = taker.Query(who)
+	require.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+const (
+	// holePunchTimeout is how long we wait for a relayed connection to be upgraded to a direct one
+	// before opening a long-lived stream over it.
+	holePunchTimeout       = time.Second * 10
+	directConnPollInterval = time.Millisecond * 100
+)
+
+// relayLimit bounds each connection relayed by our relay service. Swap streams are kept open for
+// the whole swap, which lasts at most both of the swap contract's timelocks, but its messages are small.
+var relayLimit = &relayv2.RelayLimit{
+	Duration: 2 * common.MaxSwapTimeout,
+	Data:     1 << 22, // 4 MiB
+}
+
+// startRelayService starts a circuit relay v2 service, which lets peers that can't be reached
+// directly reserve a slot with us, and be dialled through us.
+func (h *host) startRelayService() error {
+	res := relayv2.DefaultResources()
+	res.Limit = relayLimit
+
+	r, err := relayv2.New(h.h, relayv2.WithResources(res))
+	if err != nil {
+		return fmt.Errorf("failed to start relay service: %w", err)
+	}
+
+	h.relay = r
+	log.Info("started relay service")
+	return nil
+}
+
+// connectRelays connects to our static relays, which makes us reserve a slot with them.
+func (h *host) connectRelays() {
+	for _, relay := range h.relays {
+		if err := h.h.Connect(h.ctx, relay); err != nil {
+			log.Warnf("failed to connect to relay: peer=%s err=%s", relay.ID, err)
+		}
+	}
+}
+
+// newStream connects to the peer and opens a stream with it. If we can only reach the peer through
+// a relay and the stream is long-lived, we first give hole punching a chance to connect us directly,
+// as relayed connections are limited. If it doesn't, the stream is opened over the relay anyway.
+func (h *host) newStream(who peer.AddrInfo, pid string, timeout time.Duration, longLived bool) (libp2pnetwork.Stream, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
+
+	if err := h.h.Connect(ctx, who); err != nil {
+		return nil, err
+	}
+
+	if longLived && h.holePunching && !h.hasDirectConn(who.ID) {
+		if err := h.waitForDirectConn(who.ID); err != nil {
+			log.Warnf("failed to connect directly to peer, using relayed connection: peer=%s err=%s", who.ID, err)
+		}
+
+		ctx, cancel = context.WithTimeout(h.ctx, timeout)
+		defer cancel()
+	}
+
+	return h.h.NewStream(libp2pnetwork.WithUseTransient(ctx, "atomic-swap"), who.ID, protocol.ID(h.protocolID+pid))
+}
+
+// hasDirectConn returns true if we have a connection to the peer that isn't through a relay.
+func (h *host) hasDirectConn(who peer.ID) bool {
+	for _, conn := range h.h.Network().ConnsToPeer(who) {
+		if !conn.Stat().Transient {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *host) waitForDirectConn(who peer.ID) error {
+	timer := time.NewTimer(holePunchTimeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(directConnPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if h.hasDirectConn(who) {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("hole punching timed out after %s", holePunchTimeout)
+		case <-h.ctx.Done():
+			return h.ctx.Err()
+		}
+	}
+}
+
+// withAddrs returns copies of the offers with our current addresses, which include the addresses
+// that we can be reached at through our relays.
+func (h *host) withAddrs(offers []*Offer) []*Offer {
+	var addrs []string
+	for _, addr := range h.h.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+
+	copies := make([]*Offer, len(offers))
+	for i, o := range offers {
+		c := *o
+		c.Addrs = addrs
+		copies[i] = &c
+	}
+
+	return copies
+}
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func newTestNetHost(t *testing.T, cfg *Config) *host {
+	cfg.Ctx = context.Background()
+	cfg.Environment = common.Development
+	cfg.ChainID = 1337
+	cfg.KeyFile = filepath.Join(t.TempDir(), "net.key")
+
+	h, err := NewHost(cfg)
+	require.NoError(t, err)
+	require.NoError(t, h.Start())
+	t.Cleanup(func() {
+		_ = h.Stop()
+	})
+	return h
+}
+
+// loopbackAddr returns the host's loopback multiaddr, including its peer ID.
+func loopbackAddr(t *testing.T, h *host) string {
+	for _, addr := range h.multiaddrs() {
+		if strings.HasPrefix(addr.String(), "/ip4/127.0.0.1/") {
+			return addr.String()
+		}
+	}
+
+	t.Fatal("host isn't listening on a loopback address")
+	return ""
+}
+
+func TestHost_Relay(t *testing.T) {
+	relay := newTestNetHost(t, &Config{RelayService: true})
+
+	// the maker reserves a slot with the relay, and can then be reached through it
+	maker := newTestNetHost(t, &Config{Relays: []string{loopbackAddr(t, relay)}})
+	offer, err := maker.MakeOffer(&Offer{
+		Provides:      common.ProvidesXMR,
+		Desires:       common.ProvidesETH,
+		MaximumAmount: 1,
+		ExchangeRate:  0.05,
+	}, time.Hour)
+	require.NoError(t, err)
+
+	taker := newTestNetHost(t, &Config{})
+	who, err := StringToAddrInfo(fmt.Sprintf("%s/p2p-circuit/p2p/%s", loopbackAddr(t, relay), maker.h.ID()))
+	require.NoError(t, err)
+
+	var resp *QueryResponse
+	require.Eventually(t, func() bool {
+		resp, err = taker.Query(who)
+		return err == nil
+	}, time.Second*10, time.Millisecond*100, "failed to query maker through relay: %s", err)
+
+	// the offer comes with the addresses that the maker can be reached at
+	require.Len(t, resp.Offers, 1)
+	require.Equal(t, offer.ID, resp.Offers[0].ID)
+	require.NotEmpty(t, resp.Offers[0].Addrs)
+	require.NoError(t, resp.Offers[0].Verify())
+}
+
+func TestHost_Relay_NotReserved(t *testing.T) {
+	relay := newTestNetHost(t, &Config{RelayService: true})
+	maker := newTestNetHost(t, &Config{})
+	taker := newTestNetHost(t, &Config{})
+
+	// the maker doesn't use the relay, so it can't be reached through it
+	who, err := StringToAddrInfo(fmt.Sprintf("%s/p2p-circuit/p2p/%s", loopbackAddr(t, relay), maker.h.ID()))
+	require.NoError(t, err)
+	_, err = taker.Query(who)
+	require.Error(t, err)
+}
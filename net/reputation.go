@@ -0,0 +1,449 @@
+package net
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/control"
+	libp2pnetwork "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+)
+
+const (
+	// defaultBanThreshold is the score at or below which a peer is banned.
+	defaultBanThreshold = -20
+
+	// peerRecordsFlushInterval is how often changed peer records are saved. Bans are saved straight away.
+	peerRecordsFlushInterval = time.Minute
+	// maxPeerRecords bounds the number of peers we keep records of. Once it's reached, the records
+	// of unbanned peers are evicted to make room, those with a zero score first.
+	maxPeerRecords = 4096
+)
+
+var errPeerNotAllowed = errors.New("peer is banned, or isn't on the allow-list")
+
+// PeerEvent is something a peer did that affects its reputation.
+type PeerEvent byte
+
+const (
+	// SwapCompleted means a swap with the peer succeeded.
+	SwapCompleted PeerEvent = iota
+	// SwapAborted means the peer closed a swap's stream before any funds were locked.
+	SwapAborted
+	// SwapAbortedAfterLock means the peer closed a swap's stream after funds were locked, which
+	// leaves them locked until the contract's timelocks expire.
+	SwapAbortedAfterLock
+	// PeerTimedOut means the peer didn't reply in time.
+	PeerTimedOut
+	// ProtocolViolation means the peer sent a malformed or unexpected message, or an invalid offer.
+	ProtocolViolation
+)
+
+var peerEventNames = map[PeerEvent]string{
+	SwapCompleted:        "SwapCompleted",
+	SwapAborted:          "SwapAborted",
+	SwapAbortedAfterLock: "SwapAbortedAfterLock",
+	PeerTimedOut:         "PeerTimedOut",
+	ProtocolViolation:    "ProtocolViolation",
+}
+
+// peerEventScores is how much each event adds to a peer's score.
+var peerEventScores = map[PeerEvent]int{
+	SwapCompleted:        1,
+	SwapAborted:          -1,
+	SwapAbortedAfterLock: -10,
+	PeerTimedOut:         -2,
+	ProtocolViolation:    -5,
+}
+
+func (e PeerEvent) String() string {
+	if name, has := peerEventNames[e]; has {
+		return name
+	}
+
+	return fmt.Sprintf("PeerEvent(%d)", byte(e))
+}
+
+// PeerRecord is what we know about a peer's behaviour.
+type PeerRecord struct {
+	ID                 peer.ID   `json:"id"`
+	Score              int       `json:"score"`
+	CompletedSwaps     uint64    `json:"completedSwaps"`
+	AbortedSwaps       uint64    `json:"abortedSwaps"`
+	AbortsAfterLock    uint64    `json:"abortsAfterLock"`
+	Timeouts           uint64    `json:"timeouts"`
+	ProtocolViolations uint64    `json:"protocolViolations"`
+	Banned             bool      `json:"banned"`
+	Allowed            bool      `json:"allowed"` // the peer is on the allow-list
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// reputation scores peers by what they do, and bans them once their score reaches the ban threshold.
+// In allow-list mode, only peers on the allow-list can query us or start swaps with us.
+type reputation struct {
+	sync.Mutex
+	path         string // file the records are saved to; if it's empty, they're only kept in memory
+	banThreshold int
+	allowList    map[peer.ID]struct{}
+	peers        map[peer.ID]*PeerRecord
+	maxPeers     int
+	dirty        bool // the records have changed since they were saved
+
+	// onBan is called when a peer is banned, without the lock held
+	onBan func(peer.ID)
+}
+
+var _ connmgr.ConnectionGater = &reputation{}
+
+// newReputation returns the peer records saved at the given path, if it's not empty.
+func newReputation(path string, banThreshold int, allowList []peer.ID) (*reputation, error) {
+	r := &reputation{
+		path:         path,
+		banThreshold: banThreshold,
+		allowList:    make(map[peer.ID]struct{}, len(allowList)),
+		peers:        make(map[peer.ID]*PeerRecord),
+		maxPeers:     maxPeerRecords,
+	}
+
+	for _, p := range allowList {
+		r.allowList[p] = struct{}{}
+	}
+
+	if path == "" {
+		return r, nil
+	}
+
+	bz, err := ioutil.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer records: %w", err)
+	}
+
+	var records []*PeerRecord
+	if err = json.Unmarshal(bz, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode peer records: %w", err)
+	}
+
+	for _, rec := range records {
+		r.peers[rec.ID] = rec
+	}
+
+	return r, nil
+}
+
+// record records the event for the peer, and bans it if its score reaches the ban threshold.
+// Peers on the allow-list are never banned automatically.
+func (r *reputation) record(who peer.ID, ev PeerEvent) {
+	r.Lock()
+	rec := r.getOrCreate(who)
+	rec.Score += peerEventScores[ev]
+	switch ev {
+	case SwapCompleted:
+		rec.CompletedSwaps++
+	case SwapAborted:
+		rec.AbortedSwaps++
+	case SwapAbortedAfterLock:
+		rec.AbortsAfterLock++
+	case PeerTimedOut:
+		rec.Timeouts++
+	case ProtocolViolation:
+		rec.ProtocolViolations++
+	}
+
+	_, allowed := r.allowList[who]
+	banned := !rec.Banned && !allowed && rec.Score <= r.banThreshold
+	if banned {
+		rec.Banned = true
+	}
+
+	score := rec.Score
+	r.dirty = true
+	if banned {
+		if err := r.save(); err != nil {
+			log.Warnf("failed to save peer records: err=%s", err)
+		}
+	}
+	r.Unlock()
+
+	log.Debugf("recorded peer event: peer=%s event=%s score=%d", who, ev, score)
+	if banned {
+		log.Infof("banned peer: peer=%s score=%d", who, score)
+		r.banned(who)
+	}
+}
+
+// setBanned bans or unbans the peer. Unbanning a peer resets its score.
+func (r *reputation) setBanned(who peer.ID, ban bool) error {
+	r.Lock()
+	rec := r.getOrCreate(who)
+	changed := rec.Banned != ban
+	rec.Banned = ban
+	if !ban {
+		rec.Score = 0
+	}
+
+	err := r.save()
+	r.Unlock()
+
+	if ban && changed {
+		r.banned(who)
+	}
+
+	return err
+}
+
+func (r *reputation) banned(who peer.ID) {
+	if r.onBan != nil {
+		r.onBan(who)
+	}
+}
+
+func (r *reputation) isBanned(who peer.ID) bool {
+	r.Lock()
+	defer r.Unlock()
+	rec, has := r.peers[who]
+	return has && rec.Banned
+}
+
+// isAllowed returns true if the peer can query us and start swaps with us.
+func (r *reputation) isAllowed(who peer.ID) bool {
+	if r.isBanned(who) {
+		return false
+	}
+
+	if len(r.allowList) == 0 {
+		return true
+	}
+
+	_, has := r.allowList[who]
+	return has
+}
+
+// records returns the records of every peer we know about, including those on the allow-list,
+// from the highest score to the lowest.
+func (r *reputation) records() []*PeerRecord {
+	r.Lock()
+	defer r.Unlock()
+
+	records := make([]*PeerRecord, 0, len(r.peers)+len(r.allowList))
+	for id, rec := range r.peers {
+		c := *rec
+		_, c.Allowed = r.allowList[id]
+		records = append(records, &c)
+	}
+
+	for id := range r.allowList {
+		if _, has := r.peers[id]; !has {
+			records = append(records, &PeerRecord{ID: id, Allowed: true})
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Score == records[j].Score {
+			return records[i].ID < records[j].ID
+		}
+
+		return records[i].Score > records[j].Score
+	})
+
+	return records
+}
+
+// getOrCreate must be called with the lock held.
+func (r *reputation) getOrCreate(who peer.ID) *PeerRecord {
+	rec, has := r.peers[who]
+	if !has {
+		if len(r.peers) >= r.maxPeers {
+			r.evict()
+		}
+
+		rec = &PeerRecord{ID: who}
+		r.peers[who] = rec
+	}
+
+	rec.UpdatedAt = time.Now()
+	return rec
+}
+
+// evict removes the record of the least recently updated unbanned peer with a zero score, or of
+// the least recently updated unbanned peer if none have a zero score. Banned peers are kept, so
+// that they stay banned. It must be called with the lock held.
+func (r *reputation) evict() {
+	var oldest, oldestZero *PeerRecord
+	for _, rec := range r.peers {
+		if rec.Banned {
+			continue
+		}
+
+		if oldest == nil || rec.UpdatedAt.Before(oldest.UpdatedAt) {
+			oldest = rec
+		}
+
+		if rec.Score == 0 && (oldestZero == nil || rec.UpdatedAt.Before(oldestZero.UpdatedAt)) {
+			oldestZero = rec
+		}
+	}
+
+	if oldestZero != nil {
+		oldest = oldestZero
+	}
+
+	if oldest != nil {
+		delete(r.peers, oldest.ID)
+		r.dirty = true
+	}
+}
+
+// flushLoop saves the records every peerRecordsFlushInterval if they've changed, until the context
+// is cancelled.
+func (r *reputation) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(peerRecordsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.flush(); err != nil {
+				log.Warnf("failed to save peer records: err=%s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush saves the records if they've changed since they were last saved.
+func (r *reputation) flush() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+
+	return r.save()
+}
+
+// save atomically replaces the saved records. It must be called with the lock held.
+func (r *reputation) save() error {
+	if r.path == "" {
+		r.dirty = false
+		return nil
+	}
+
+	records := make([]*PeerRecord, 0, len(r.peers))
+	for _, rec := range r.peers {
+		records = append(records, rec)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	bz, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(r.path), keystore.DirPerms); err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bz, keystore.FilePerms); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, r.path); err != nil {
+		return err
+	}
+
+	r.dirty = false
+	return nil
+}
+
+// InterceptPeerDial stops us from dialling banned peers.
+func (r *reputation) InterceptPeerDial(p peer.ID) bool {
+	return !r.isBanned(p)
+}
+
+// InterceptAddrDial stops us from dialling banned peers.
+func (r *reputation) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return !r.isBanned(p)
+}
+
+// InterceptAccept accepts every connection; we don't know who the peer is until it's secured.
+func (r *reputation) InterceptAccept(libp2pnetwork.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured rejects connections from banned peers.
+func (r *reputation) InterceptSecured(_ libp2pnetwork.Direction, p peer.ID, _ libp2pnetwork.ConnMultiaddrs) bool {
+	return !r.isBanned(p)
+}
+
+// InterceptUpgraded accepts every connection that's been secured.
+func (r *reputation) InterceptUpgraded(libp2pnetwork.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// isTimeout returns true if the error is from a read or write deadline being reached.
+func isTimeout(err error) bool {
+	var te interface{ Timeout() bool }
+	return errors.As(err, &te) && te.Timeout()
+}
+
+// recordSwapOutcome records how a swap with the peer ended. status is the swap's status when its
+// stream closed, and aborted is true if the peer closed the stream before the swap was done.
+func (h *host) recordSwapOutcome(who peer.ID, status common.Status, aborted bool) {
+	switch {
+	case status == common.CompletedSuccess:
+		h.reputation.record(who, SwapCompleted)
+	case !aborted || status.IsComplete():
+	case status == common.ExpectingKeys || status == common.KeysExchanged:
+		h.reputation.record(who, SwapAborted)
+	default:
+		h.reputation.record(who, SwapAbortedAfterLock)
+	}
+}
+
+// recordError records a timeout or an oversized message from the peer.
+func (h *host) recordError(who peer.ID, err error) {
+	switch {
+	case isTimeout(err):
+		h.reputation.record(who, PeerTimedOut)
+	case errors.Is(err, errMessageTooLarge):
+		h.reputation.record(who, ProtocolViolation)
+	}
+}
+
+// Peers returns our records of the peers we know about.
+func (h *host) Peers() []*PeerRecord {
+	return h.reputation.records()
+}
+
+// BanPeer bans the peer, and disconnects from it. Banned peers can't connect to us.
+func (h *host) BanPeer(who peer.ID) error {
+	return h.reputation.setBanned(who, true)
+}
+
+// UnbanPeer unbans the peer, and resets its score.
+func (h *host) UnbanPeer(who peer.ID) error {
+	return h.reputation.setBanned(who, false)
+}
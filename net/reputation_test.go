@@ -0,0 +1,197 @@
+package net
+
+import (
+	gonet "net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+func newTestPeerID(t *testing.T) peer.ID {
+	key, err := generateKey(0, filepath.Join(t.TempDir(), "net.key"))
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return id
+}
+
+func TestReputation_Ban(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	r, err := newReputation(path, -10, nil)
+	require.NoError(t, err)
+
+	var bans []peer.ID
+	r.onBan = func(who peer.ID) {
+		bans = append(bans, who)
+	}
+
+	who := newTestPeerID(t)
+	r.record(who, SwapCompleted)
+	r.record(who, ProtocolViolation)
+	require.False(t, r.isBanned(who))
+	require.True(t, r.isAllowed(who))
+	require.True(t, r.InterceptPeerDial(who))
+
+	r.record(who, SwapAbortedAfterLock)
+	require.True(t, r.isBanned(who))
+	require.False(t, r.isAllowed(who))
+	require.False(t, r.InterceptPeerDial(who))
+	require.False(t, r.InterceptSecured(0, who, nil))
+	require.Equal(t, []peer.ID{who}, bans)
+
+	// the records survive a restart
+	r, err = newReputation(path, -10, nil)
+	require.NoError(t, err)
+	require.True(t, r.isBanned(who))
+	records := r.records()
+	require.Len(t, records, 1)
+	require.Equal(t, -14, records[0].Score)
+	require.Equal(t, uint64(1), records[0].CompletedSwaps)
+	require.Equal(t, uint64(1), records[0].ProtocolViolations)
+	require.Equal(t, uint64(1), records[0].AbortsAfterLock)
+
+	// unbanning resets the score
+	require.NoError(t, r.setBanned(who, false))
+	require.False(t, r.isBanned(who))
+	require.Equal(t, 0, r.records()[0].Score)
+}
+
+func TestReputation_AllowList(t *testing.T) {
+	allowed, other := newTestPeerID(t), newTestPeerID(t)
+	r, err := newReputation("", -10, []peer.ID{allowed})
+	require.NoError(t, err)
+
+	require.True(t, r.isAllowed(allowed))
+	require.False(t, r.isAllowed(other))
+
+	// peers on the allow-list aren't banned automatically, but can be banned by hand
+	r.record(allowed, SwapAbortedAfterLock)
+	r.record(allowed, SwapAbortedAfterLock)
+	require.True(t, r.isAllowed(allowed))
+
+	records := r.records()
+	require.Len(t, records, 1)
+	require.True(t, records[0].Allowed)
+
+	require.NoError(t, r.setBanned(allowed, true))
+	require.False(t, r.isAllowed(allowed))
+}
+
+func TestHost_RecordSwapOutcome(t *testing.T) {
+	r, err := newReputation("", defaultBanThreshold, nil)
+	require.NoError(t, err)
+	h := &host{reputation: r}
+	who := newTestPeerID(t)
+
+	h.recordSwapOutcome(who, common.CompletedSuccess, false)
+	h.recordSwapOutcome(who, common.KeysExchanged, true)
+	h.recordSwapOutcome(who, common.ETHLocked, true)
+	h.recordSwapOutcome(who, common.CompletedRefund, true)
+	h.recordSwapOutcome(who, common.ETHLocked, false)
+
+	rec := r.records()[0]
+	require.Equal(t, uint64(1), rec.CompletedSwaps)
+	require.Equal(t, uint64(1), rec.AbortedSwaps)
+	require.Equal(t, uint64(1), rec.AbortsAfterLock)
+	require.Equal(t, 1-1-10, rec.Score)
+}
+
+func TestReputation_Flush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	r, err := newReputation(path, -10, nil)
+	require.NoError(t, err)
+
+	// events are saved when the records are flushed, not as they happen
+	who := newTestPeerID(t)
+	r.record(who, SwapCompleted)
+	saved, err := newReputation(path, -10, nil)
+	require.NoError(t, err)
+	require.Empty(t, saved.records())
+
+	require.NoError(t, r.flush())
+	saved, err = newReputation(path, -10, nil)
+	require.NoError(t, err)
+	require.Len(t, saved.records(), 1)
+	require.Equal(t, uint64(1), saved.records()[0].CompletedSwaps)
+}
+
+func TestReputation_Evict(t *testing.T) {
+	r, err := newReputation("", -10, nil)
+	require.NoError(t, err)
+	r.maxPeers = 3
+
+	banned, good, zero, bad, other := newTestPeerID(t), newTestPeerID(t), newTestPeerID(t), newTestPeerID(t),
+		newTestPeerID(t)
+	require.NoError(t, r.setBanned(banned, true))
+	r.record(good, SwapCompleted)
+	r.record(zero, SwapCompleted)
+	r.record(zero, SwapAborted)
+
+	// peers with a zero score are evicted first
+	r.record(bad, SwapAborted)
+	ids := func() []peer.ID {
+		var ids []peer.ID
+		for _, rec := range r.records() {
+			ids = append(ids, rec.ID)
+		}
+		return ids
+	}
+	require.ElementsMatch(t, []peer.ID{banned, good, bad}, ids())
+
+	// then the least recently updated, but banned peers are kept
+	r.record(other, SwapCompleted)
+	require.ElementsMatch(t, []peer.ID{banned, bad, other}, ids())
+}
+
+// mockSwapState is a swap that we may be sending transactions for.
+type mockSwapState struct {
+	SwapState
+	sync.Mutex
+	lastTx time.Time
+}
+
+func (s *mockSwapState) ID() common.SwapID {
+	return common.SwapID{}
+}
+
+func (s *mockSwapState) LastTransaction() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.lastTx
+}
+
+func TestHost_ReadSwapMessage_Timeout(t *testing.T) {
+	h := newTestHost(t)
+	h.messageTimeout = time.Millisecond * 200
+	h.maxMessageSize = 1024
+
+	local, remote := gonet.Pipe()
+	defer local.Close()  //nolint:errcheck
+	defer remote.Close() //nolint:errcheck
+
+	// a silent peer times out
+	_, err := h.readSwapMessage(local, new(mockSwapState))
+	require.True(t, isTimeout(err))
+
+	// unless we were sending a transaction, in which case the peer has the timeout from when it was sent
+	s := new(mockSwapState)
+	go func() {
+		time.Sleep(time.Millisecond * 150)
+		s.Lock()
+		s.lastTx = time.Now()
+		s.Unlock()
+
+		time.Sleep(time.Millisecond * 150)
+		_, _ = remote.Write([]byte{3, 'a', 'b', 'c'})
+	}()
+
+	msg, err := h.readSwapMessage(local, s)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), msg)
+}
@@ -4,7 +4,6 @@ import (
 	crand "crypto/rand"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
 	mrand "math/rand"
@@ -112,37 +111,37 @@ func uint64ToLEB128(in uint64) []byte {
 	return out
 }
 
-func readLEB128ToUint64(r io.Reader, buf []byte) (uint64, int, error) {
-	if len(buf) == 0 {
-		return 0, 0, errors.New("buffer has length 0")
-	}
-
-	var out uint64
-	var shift uint
+// readLEB128ToUint64 reads a LEB128-encoded uint64 from r, one byte at a time.
+func readLEB128ToUint64(r io.Reader) (uint64, error) {
+	const maxSize = 10 // max bytes in LEB128 encoding of uint64 is 10
 
-	maxSize := 10 // Max bytes in LEB128 encoding of uint64 is 10.
-	bytesRead := 0
+	var (
+		out uint64
+		buf [1]byte
+	)
 
-	for {
-		n, err := r.Read(buf[:1])
-		if err != nil {
-			return 0, bytesRead, err
+	for i := 0; i < maxSize; i++ {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, err
 		}
 
-		bytesRead += n
-
 		b := buf[0]
-		out |= uint64(0x7F&b) << shift
-		if b&0x80 == 0 {
-			break
+
+		// the 10th byte can only hold the top bit of a uint64
+		if i == maxSize-1 && b > 1 {
+			return 0, errors.New("LEB128 encoded data overflows uint64")
 		}
 
-		maxSize--
-		if maxSize == 0 {
-			return 0, bytesRead, fmt.Errorf("invalid LEB128 encoded data")
+		// a trailing zero byte means the value could have been encoded in fewer bytes
+		if i > 0 && b == 0 {
+			return 0, errors.New("LEB128 encoded data isn't minimal")
 		}
 
-		shift += 7
+		out |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return out, nil
+		}
 	}
-	return out, bytesRead, nil
+
+	return 0, errors.New("invalid LEB128 encoded data")
 }
@@ -0,0 +1,151 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testMaxMessageSize = 1 << 16
+
+// frame prefixes the message with its LEB128-encoded length, as writeToStream does.
+func frame(msg []byte) []byte {
+	return append(uint64ToLEB128(uint64(len(msg))), msg...)
+}
+
+func TestLEB128(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 300, math.MaxUint32, math.MaxUint64} {
+		res, err := readLEB128ToUint64(bytes.NewReader(uint64ToLEB128(v)))
+		require.NoError(t, err)
+		require.Equal(t, v, res)
+	}
+}
+
+func TestLEB128_Invalid(t *testing.T) {
+	// longer than 10 bytes
+	_, err := readLEB128ToUint64(bytes.NewReader(bytes.Repeat([]byte{0x80}, 11)))
+	require.Error(t, err)
+
+	// overflows uint64
+	overflow := append(bytes.Repeat([]byte{0xff}, 9), 0x02)
+	_, err = readLEB128ToUint64(bytes.NewReader(overflow))
+	require.Error(t, err)
+
+	// not minimal
+	_, err = readLEB128ToUint64(bytes.NewReader([]byte{0x88, 0x00}))
+	require.Error(t, err)
+
+	// truncated
+	_, err = readLEB128ToUint64(bytes.NewReader([]byte{0x80}))
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF))
+}
+
+func TestReadStream(t *testing.T) {
+	msg := bytes.Repeat([]byte{0xab}, 10000)
+	r := bytes.NewReader(append(frame(msg), frame([]byte("next"))...))
+
+	res, err := readStream(r, testMaxMessageSize)
+	require.NoError(t, err)
+	require.Equal(t, msg, res)
+
+	// the following message is read separately
+	res, err = readStream(r, testMaxMessageSize)
+	require.NoError(t, err)
+	require.Equal(t, []byte("next"), res)
+}
+
+// oneByteReader returns at most one byte per Read, like a slow stream.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	return r.r.Read(p[:1])
+}
+
+func TestReadStream_PartialReads(t *testing.T) {
+	msg := bytes.Repeat([]byte{0xcd}, 5000)
+	res, err := readStream(&oneByteReader{bytes.NewReader(frame(msg))}, testMaxMessageSize)
+	require.NoError(t, err)
+	require.Equal(t, msg, res)
+}
+
+func TestReadStream_TooLarge(t *testing.T) {
+	msg := make([]byte, testMaxMessageSize+1)
+	_, err := readStream(bytes.NewReader(frame(msg)), testMaxMessageSize)
+	require.True(t, errors.Is(err, errMessageTooLarge))
+
+	// the length is checked before anything is allocated
+	_, err = readStream(bytes.NewReader(uint64ToLEB128(math.MaxUint64)), testMaxMessageSize)
+	require.True(t, errors.Is(err, errMessageTooLarge))
+}
+
+func TestReadStream_Truncated(t *testing.T) {
+	b := frame(bytes.Repeat([]byte{1}, 100))
+	_, err := readStream(bytes.NewReader(b[:50]), testMaxMessageSize)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func FuzzLEB128(f *testing.F) {
+	for _, v := range []uint64{0, 1, 128, math.MaxUint64} {
+		f.Add(v)
+	}
+
+	f.Fuzz(func(t *testing.T, v uint64) {
+		res, err := readLEB128ToUint64(bytes.NewReader(uint64ToLEB128(v)))
+		require.NoError(t, err)
+		require.Equal(t, v, res)
+	})
+}
+
+func FuzzReadStream(f *testing.F) {
+	f.Add(frame([]byte("hello")))
+	f.Add(frame(nil))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add([]byte{0x80, 0x80})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		res, err := readStream(bytes.NewReader(b), testMaxMessageSize)
+		if err != nil {
+			return
+		}
+
+		// a message that was read must be exactly what was framed
+		require.LessOrEqual(t, len(res), testMaxMessageSize)
+		require.True(t, bytes.HasPrefix(b, frame(res)))
+	})
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, msg := range []Message{
+		&QueryResponse{Offers: []*Offer{{MaximumAmount: 1}}},
+		&NotifyClaimed{TxHash: "0xab"},
+		newHello(),
+	} {
+		enc, err := msg.Encode()
+		require.NoError(f, err)
+		f.Add(enc)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := decodeMessage(b)
+		if err != nil {
+			return
+		}
+
+		// anything that decodes must encode and decode to the same message
+		enc, err := msg.Encode()
+		require.NoError(t, err)
+		dec, err := decodeMessage(enc)
+		require.NoError(t, err)
+		require.Equal(t, msg.String(), dec.String())
+	})
+}
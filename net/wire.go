@@ -0,0 +1,389 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+// Messages are encoded as protobuf, following the schema in message.proto. Each message is wrapped
+// in an envelope which holds the wire format version it was encoded with, its type and its body.
+const (
+	// WireVersion is the newest version of the wire format that we support.
+	// Version 2 replaced the QueryResponse's single exchange rate with signed offers.
+	// Version 3 added the negotiation of a swap's terms before the InitiateMessage.
+	WireVersion uint32 = 3
+	// minWireVersion is the oldest version of the wire format that we support.
+	minWireVersion uint32 = 3
+	// legacyWireVersion is the original format: a type byte followed by the message as JSON.
+	legacyWireVersion uint32 = 0
+)
+
+// capabilities are the optional protocol features we support. A feature is only used in a swap
+// if both peers advertise it in their Hello.
+var capabilities = []string{}
+
+var (
+	errIncompatibleVersion = errors.New("peer uses an incompatible wire format version")
+	errInvalidEnvelope     = errors.New("invalid message envelope")
+)
+
+// envelope field numbers
+const (
+	envelopeVersion protowire.Number = 1
+	envelopeType    protowire.Number = 2
+	envelopeBody    protowire.Number = 3
+)
+
+// wireMarshaler is implemented by types that can be encoded in the wire format.
+type wireMarshaler interface {
+	marshal(b []byte) []byte
+	unmarshal(b []byte) error
+}
+
+// wireMessage is a Message that can be encoded in the wire format.
+type wireMessage interface {
+	Message
+	wireMarshaler
+}
+
+// encodeMessage encodes the message in an envelope with the current wire format version.
+func encodeMessage(m wireMessage) ([]byte, error) {
+	b := appendUint32(nil, envelopeVersion, WireVersion)
+	b = appendUint32(b, envelopeType, uint32(m.Type()))
+	return appendBytes(b, envelopeBody, m.marshal(nil)), nil
+}
+
+func decodeMessage(b []byte) (Message, error) {
+	if len(b) == 0 {
+		return nil, errors.New("invalid message bytes")
+	}
+
+	// legacy messages start with their type byte, which is never a valid protobuf tag
+	if b[0] < byte(protowire.EncodeTag(envelopeVersion, protowire.VarintType)) {
+		return nil, versionError(legacyWireVersion, legacyWireVersion)
+	}
+
+	var (
+		version uint32
+		typ     byte
+		body    []byte
+	)
+
+	err := unmarshalFields(b, func(f *field) (err error) {
+		switch f.num {
+		case envelopeVersion:
+			version, err = f.uint32()
+		case envelopeType:
+			var t uint32
+			t, err = f.uint32()
+			if t > math.MaxUint8 {
+				return fmt.Errorf("%w: message type %d", errInvalidEnvelope, t)
+			}
+			typ = byte(t)
+		case envelopeBody:
+			body, err = f.bytes()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the Hello has to be readable by every version, so that peers can negotiate
+	if typ != HelloType && (version < minWireVersion || version > WireVersion) {
+		return nil, versionError(version, version)
+	}
+
+	var m wireMessage
+	switch typ {
+	case QueryResponseType:
+		m = new(QueryResponse)
+	case InitiateMessageType:
+		m = new(InitiateMessage)
+	case SendKeysMessageType:
+		m = new(SendKeysMessage)
+	case NotifyContractDeployedType:
+		m = new(NotifyContractDeployed)
+	case NotifyXMRLockType:
+		m = new(NotifyXMRLock)
+	case NotifyReadyType:
+		m = new(NotifyReady)
+	case NotifyClaimedType:
+		m = new(NotifyClaimed)
+	case NotifyRefundType:
+		m = new(NotifyRefund)
+	case HelloType:
+		m = new(Hello)
+	case ProposeMessageType:
+		m = new(ProposeMessage)
+	case AcceptMessageType:
+		m = new(AcceptMessage)
+	case RejectMessageType:
+		m = new(RejectMessage)
+	default:
+		return nil, errors.New("invalid message type")
+	}
+
+	if err = m.unmarshal(body); err != nil {
+		return nil, fmt.Errorf("failed to decode %T: %w", m, err)
+	}
+
+	return m, nil
+}
+
+func versionError(minVersion, version uint32) error {
+	return fmt.Errorf("%w: peer supports versions %d to %d, we support %d to %d",
+		errIncompatibleVersion, minVersion, version, minWireVersion, WireVersion)
+}
+
+// handshake is the outcome of the Hello exchange at the start of a swap stream.
+type handshake struct {
+	version      uint32
+	capabilities []string
+}
+
+func newHello() *Hello {
+	return &Hello{
+		Version:      WireVersion,
+		MinVersion:   minWireVersion,
+		Capabilities: capabilities,
+	}
+}
+
+// newHandshake returns the newest version supported by both us and the peer, and the capabilities
+// we both support.
+func newHandshake(theirs *Hello) (*handshake, error) {
+	version := WireVersion
+	if theirs.Version < version {
+		version = theirs.Version
+	}
+
+	if version < minWireVersion || version < theirs.MinVersion {
+		return nil, versionError(theirs.MinVersion, theirs.Version)
+	}
+
+	hs := &handshake{
+		version: version,
+	}
+
+	for _, c := range theirs.Capabilities {
+		for _, ours := range capabilities {
+			if c == ours {
+				hs.capabilities = append(hs.capabilities, c)
+			}
+		}
+	}
+
+	return hs, nil
+}
+
+// field is a single field of an encoded message. Decoding the field's value sets n to the
+// number of bytes it took up; fields that aren't decoded are skipped.
+type field struct {
+	num protowire.Number
+	typ protowire.Type
+	b   []byte
+	n   int
+}
+
+// unmarshalFields calls fn for each field in b. Unknown fields are skipped, so that fields
+// can be added to a message without breaking peers that don't know about them.
+func unmarshalFields(b []byte, fn func(f *field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		f := &field{
+			num: num,
+			typ: typ,
+			b:   b[n:],
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+
+		if f.n == 0 {
+			f.n = protowire.ConsumeFieldValue(num, typ, f.b)
+		}
+
+		if f.n < 0 {
+			return protowire.ParseError(f.n)
+		}
+
+		b = f.b[f.n:]
+	}
+
+	return nil
+}
+
+func (f *field) checkType(typ protowire.Type) error {
+	if f.typ != typ {
+		return fmt.Errorf("field %d has wire type %d, expected %d", f.num, f.typ, typ)
+	}
+
+	return nil
+}
+
+func (f *field) uint64() (uint64, error) {
+	if err := f.checkType(protowire.VarintType); err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	v, f.n = protowire.ConsumeVarint(f.b)
+	if f.n < 0 {
+		return 0, protowire.ParseError(f.n)
+	}
+
+	return v, nil
+}
+
+func (f *field) uint32() (uint32, error) {
+	v, err := f.uint64()
+	if err != nil {
+		return 0, err
+	}
+
+	if v > math.MaxUint32 {
+		return 0, fmt.Errorf("field %d overflows uint32", f.num)
+	}
+
+	return uint32(v), nil
+}
+
+func (f *field) bytes() ([]byte, error) {
+	if err := f.checkType(protowire.BytesType); err != nil {
+		return nil, err
+	}
+
+	var v []byte
+	v, f.n = protowire.ConsumeBytes(f.b)
+	if f.n < 0 {
+		return nil, protowire.ParseError(f.n)
+	}
+
+	return v, nil
+}
+
+func (f *field) string() (string, error) {
+	v, err := f.bytes()
+	return string(v), err
+}
+
+func (f *field) swapID() (common.SwapID, error) {
+	var id common.SwapID
+	v, err := f.bytes()
+	if err != nil {
+		return id, err
+	}
+
+	if len(v) != len(id) {
+		return id, fmt.Errorf("swap ID has length %d, expected %d", len(v), len(id))
+	}
+
+	copy(id[:], v)
+	return id, nil
+}
+
+func (f *field) offerID() (OfferID, error) {
+	var id OfferID
+	v, err := f.bytes()
+	if err != nil {
+		return id, err
+	}
+
+	if len(v) != len(id) {
+		return id, errInvalidOfferID
+	}
+
+	copy(id[:], v)
+	return id, nil
+}
+
+func (f *field) double() (float64, error) {
+	if err := f.checkType(protowire.Fixed64Type); err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	v, f.n = protowire.ConsumeFixed64(f.b)
+	if f.n < 0 {
+		return 0, protowire.ParseError(f.n)
+	}
+
+	return math.Float64frombits(v), nil
+}
+
+// The append functions follow proto3 rules, where fields with zero values aren't encoded.
+
+func appendUint32(b []byte, num protowire.Number, v uint32) []byte {
+	return appendUint64(b, num, uint64(v))
+}
+
+func appendUint64(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendStrings appends a repeated string field.
+func appendStrings(b []byte, num protowire.Number, vs []string) []byte {
+	for _, v := range vs {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+
+	return b
+}
+
+func appendSwapID(b []byte, num protowire.Number, id common.SwapID) []byte {
+	if id.IsZero() {
+		return b
+	}
+
+	return appendBytes(b, num, id[:])
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// appendMessage appends an embedded message field.
+func appendMessage(b []byte, num protowire.Number, m wireMarshaler) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.marshal(nil))
+}
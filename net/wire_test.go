@@ -0,0 +1,305 @@
+package net
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"text/scanner"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var protoScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// protoParser parses the subset of the protobuf language that message.proto uses into a file
+// descriptor, so that the encoding in wire.go can be checked against the schema without protoc.
+type protoParser struct {
+	s   scanner.Scanner
+	tok rune
+}
+
+func parseProtoFile(t *testing.T, path string) protoreflect.FileDescriptor {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close() //nolint:errcheck
+
+	p := &protoParser{}
+	p.s.Init(f)
+	p.s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanStrings | scanner.ScanComments |
+		scanner.SkipComments
+	p.next()
+
+	fd := &descriptorpb.FileDescriptorProto{Name: proto.String("message.proto")}
+	for p.tok != scanner.EOF {
+		switch keyword := p.ident(t); keyword {
+		case "syntax":
+			p.expect(t, "=")
+			fd.Syntax = proto.String(p.string(t))
+			p.expect(t, ";")
+		case "package":
+			fd.Package = proto.String(p.ident(t))
+			p.expect(t, ";")
+		case "message":
+			fd.MessageType = append(fd.MessageType, p.message(t, fd))
+		default:
+			t.Fatalf("unsupported keyword %q at %s", keyword, p.s.Position)
+		}
+	}
+
+	file, err := protodesc.NewFile(fd, new(protoregistry.Files))
+	require.NoError(t, err)
+	return file
+}
+
+func (p *protoParser) next() {
+	p.tok = p.s.Scan()
+}
+
+func (p *protoParser) expect(t *testing.T, tok string) {
+	require.Equal(t, tok, p.s.TokenText(), "at %s", p.s.Position)
+	p.next()
+}
+
+func (p *protoParser) ident(t *testing.T) string {
+	require.Equal(t, rune(scanner.Ident), p.tok, "expected identifier at %s", p.s.Position)
+	text := p.s.TokenText()
+	p.next()
+	return text
+}
+
+func (p *protoParser) int(t *testing.T) int32 {
+	require.Equal(t, rune(scanner.Int), p.tok, "expected number at %s", p.s.Position)
+	n, err := strconv.ParseInt(p.s.TokenText(), 10, 32)
+	require.NoError(t, err)
+	p.next()
+	return int32(n)
+}
+
+func (p *protoParser) string(t *testing.T) string {
+	require.Equal(t, rune(scanner.String), p.tok, "expected string at %s", p.s.Position)
+	s, err := strconv.Unquote(p.s.TokenText())
+	require.NoError(t, err)
+	p.next()
+	return s
+}
+
+func (p *protoParser) message(t *testing.T, fd *descriptorpb.FileDescriptorProto) *descriptorpb.DescriptorProto {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(p.ident(t))}
+	p.expect(t, "{")
+
+	for p.s.TokenText() != "}" {
+		if p.s.TokenText() == "reserved" {
+			p.next()
+			for {
+				n := p.int(t)
+				msg.ReservedRange = append(msg.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+					Start: proto.Int32(n),
+					End:   proto.Int32(n + 1),
+				})
+
+				if p.s.TokenText() != "," {
+					break
+				}
+				p.next()
+			}
+
+			p.expect(t, ";")
+			continue
+		}
+
+		field := &descriptorpb.FieldDescriptorProto{
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+
+		typ := p.ident(t)
+		if typ == "repeated" {
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			typ = p.ident(t)
+		}
+
+		if scalar, has := protoScalarTypes[typ]; has {
+			field.Type = scalar.Enum()
+		} else {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(fmt.Sprintf(".%s.%s", fd.GetPackage(), typ))
+		}
+
+		field.Name = proto.String(p.ident(t))
+		p.expect(t, "=")
+		field.Number = proto.Int32(p.int(t))
+
+		if p.s.TokenText() == "[" {
+			p.next()
+			require.Equal(t, "packed", p.ident(t), "unsupported field option at %s", p.s.Position)
+			p.expect(t, "=")
+			packed, err := strconv.ParseBool(p.ident(t))
+			require.NoError(t, err)
+			field.Options = &descriptorpb.FieldOptions{Packed: proto.Bool(packed)}
+			p.expect(t, "]")
+		}
+
+		p.expect(t, ";")
+		msg.Field = append(msg.Field, field)
+	}
+
+	p.expect(t, "}")
+	return msg
+}
+
+// TestWire_MatchesSchema checks that messages encoded by wire.go are valid encodings of the
+// messages in message.proto: they decode without unknown fields, into the same values, and
+// re-encode byte-for-byte.
+func TestWire_MatchesSchema(t *testing.T) {
+	file := parseProtoFile(t, "message.proto")
+	envelope := file.Messages().ByName("Envelope")
+	require.NotNil(t, envelope)
+
+	for _, msg := range newTestMessages(t) {
+		name := reflect.TypeOf(msg).Elem().Name()
+		desc := file.Messages().ByName(protoreflect.Name(name))
+		require.NotNil(t, desc, "message.proto has no message %s", name)
+
+		enc, err := msg.Encode()
+		require.NoError(t, err)
+
+		env := requireSchemaRoundTrip(t, envelope, enc)
+		require.Equal(t, uint64(WireVersion), env.Get(envelope.Fields().ByName("version")).Uint())
+		require.Equal(t, uint64(msg.Type()), env.Get(envelope.Fields().ByName("type")).Uint())
+
+		body := env.Get(envelope.Fields().ByName("body")).Bytes()
+		require.Equal(t, msg.(wireMessage).marshal(nil), body)
+
+		dec := requireSchemaRoundTrip(t, desc, body)
+		requireSameValues(t, dec, reflect.ValueOf(msg).Elem())
+	}
+}
+
+// requireSchemaRoundTrip decodes the bytes as the given message, and checks that they re-encode
+// to the same bytes.
+func requireSchemaRoundTrip(t *testing.T, desc protoreflect.MessageDescriptor, b []byte) protoreflect.Message {
+	msg := dynamicpb.NewMessage(desc)
+	require.NoError(t, proto.Unmarshal(b, msg), desc.Name())
+	requireNoUnknownFields(t, msg)
+
+	enc, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	require.NoError(t, err)
+	require.Equal(t, b, enc, "%s doesn't re-encode to the same bytes", desc.Name())
+	return msg
+}
+
+func requireNoUnknownFields(t *testing.T, msg protoreflect.Message) {
+	require.Empty(t, msg.GetUnknown(), "%s has unknown fields", msg.Descriptor().Name())
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Kind() != protoreflect.MessageKind:
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				requireNoUnknownFields(t, v.List().Get(i).Message())
+			}
+		default:
+			requireNoUnknownFields(t, v.Message())
+		}
+		return true
+	})
+}
+
+// goFields returns the struct's fields by their lowercased name, which is how they're matched
+// with the fields in message.proto. Embedded structs' fields are included.
+func goFields(v reflect.Value) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for name, field := range goFields(v.Field(i)) {
+				fields[name] = field
+			}
+			continue
+		}
+
+		fields[strings.ToLower(f.Name)] = v.Field(i)
+	}
+
+	return fields
+}
+
+// requireSameValues checks that the decoded message has the values of the Go message.
+func requireSameValues(t *testing.T, msg protoreflect.Message, v reflect.Value) {
+	fields := goFields(v)
+	fds := msg.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		gv, has := fields[strings.ReplaceAll(string(fd.Name()), "_", "")]
+		require.True(t, has, "%T has no field for %s", v.Interface(), fd.FullName())
+
+		switch {
+		case fd.IsList():
+			list := msg.Get(fd).List()
+			require.Equal(t, gv.Len(), list.Len(), fd.FullName())
+			for j := 0; j < list.Len(); j++ {
+				requireSameValue(t, fd, list.Get(j), gv.Index(j))
+			}
+		case fd.Kind() == protoreflect.MessageKind && gv.IsNil():
+			require.False(t, msg.Has(fd), fd.FullName())
+		default:
+			requireSameValue(t, fd, msg.Get(fd), gv)
+		}
+	}
+}
+
+func requireSameValue(t *testing.T, fd protoreflect.FieldDescriptor, pv protoreflect.Value, gv reflect.Value) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		require.Equal(t, gv.String(), pv.String(), fd.FullName())
+	case protoreflect.DoubleKind:
+		require.Equal(t, gv.Float(), pv.Float(), fd.FullName())
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+		var expected uint64
+		switch v := gv.Interface().(type) {
+		case time.Duration:
+			expected = uint64(v / time.Second)
+		case time.Time:
+			expected = uint64(v.Unix())
+		default:
+			expected = gv.Uint()
+		}
+		require.Equal(t, expected, pv.Uint(), fd.FullName())
+	case protoreflect.BytesKind:
+		var expected []byte
+		switch {
+		case fd.Name() == "addrs":
+			addr, err := ma.NewMultiaddrBytes(pv.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, gv.String(), addr.String(), fd.FullName())
+			return
+		case gv.Kind() == reflect.Array:
+			expected = make([]byte, gv.Len())
+			reflect.Copy(reflect.ValueOf(expected), gv)
+		case gv.Kind() == reflect.String:
+			expected = []byte(gv.String())
+		default:
+			expected = gv.Bytes()
+		}
+		require.Equal(t, expected, pv.Bytes(), fd.FullName())
+	case protoreflect.MessageKind:
+		requireSameValues(t, pv.Message(), gv.Elem())
+	default:
+		t.Fatalf("unsupported field type %s of %s", fd.Kind(), fd.FullName())
+	}
+}
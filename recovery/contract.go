@@ -0,0 +1,92 @@
+package recovery
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+
+	"github.com/noot/atomic-swap/swap-contract"
+)
+
+// contractState is the state of a swap contract, as derived from its timeouts and emitted events.
+type contractState struct {
+	t0, t1 time.Time
+	ready  bool
+
+	// secrets revealed by calling Claim() or Refund(); nil if not called
+	claimSecret  *[32]byte
+	refundSecret *[32]byte
+}
+
+func (r *recoverer) getContractState(contract *swap.Swap) (*contractState, error) {
+	st0, err := contract.Timeout0(r.callOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	st1, err := contract.Timeout1(r.callOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &contractState{
+		t0: time.Unix(st0.Int64(), 0),
+		t1: time.Unix(st1.Int64(), 0),
+	}
+
+	opts := &bind.FilterOpts{
+		Context: r.ctx,
+	}
+
+	readyIter, err := contract.FilterIsReady(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = readyIter.Close()
+	}()
+
+	state.ready = readyIter.Next()
+	if err = readyIter.Error(); err != nil {
+		return nil, err
+	}
+
+	claimedIter, err := contract.FilterClaimed(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = claimedIter.Close()
+	}()
+
+	if claimedIter.Next() {
+		s := claimedIter.Event.S
+		state.claimSecret = &s
+	}
+
+	if err = claimedIter.Error(); err != nil {
+		return nil, err
+	}
+
+	refundedIter, err := contract.FilterRefunded(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = refundedIter.Close()
+	}()
+
+	if refundedIter.Next() {
+		s := refundedIter.Event.S
+		state.refundSecret = &s
+	}
+
+	if err = refundedIter.Error(); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
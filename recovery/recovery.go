@@ -0,0 +1,282 @@
+package recovery
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/monero"
+	"github.com/noot/atomic-swap/swap-contract"
+
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("recovery")
+
+// Config is used to configure a recoverer.
+type Config struct {
+	Ctx                  context.Context
+	Environment          common.Environment
+	MoneroWalletEndpoint string
+	EthereumEndpoint     string
+	ChainID              int64
+	EthereumSigner       chain.Signer       // signs our transactions
+	Confirmations        uint64             // blocks a transaction must be included under to be final
+	Fees                 *chain.FeeConfig   // fees paid by our transactions; optional
+	Keystore             *keystore.Keystore // used to encrypt the shared swap secret, if it's computed
+}
+
+// recoverer recovers funds from a swap that was interrupted, using only the secrets
+// saved to disk during the swap and the state of the swap contract.
+type recoverer struct {
+	ctx    context.Context
+	env    common.Environment
+	ks     *keystore.Keystore
+	client monero.Client
+
+	ethClient *ethclient.Client
+	auth      *bind.TransactOpts
+	callOpts  *bind.CallOpts
+	txTracker *chain.TxTracker
+	fees      *chain.FeeManager
+}
+
+// Result describes the action taken by the recoverer.
+type Result struct {
+	// set if we called Claim() or Refund() on the contract
+	Claimed  bool
+	Refunded bool
+	TxHash   ethcommon.Hash
+
+	// set if we created a wallet containing the swap's XMR
+	MoneroAddress monero.Address
+	WalletName    string
+}
+
+// NewRecoverer returns a new recoverer.
+func NewRecoverer(cfg *Config) (*recoverer, error) {
+	if cfg.Keystore == nil {
+		return nil, errors.New("must provide keystore")
+	}
+
+	if cfg.EthereumSigner == nil {
+		return nil, errors.New("must provide ethereum signer")
+	}
+
+	ec, err := ethclient.Dial(cfg.EthereumEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := chain.NewTxTracker(ec, &chain.TrackerConfig{
+		Confirmations: cfg.Confirmations,
+	})
+
+	return &recoverer{
+		ctx:       cfg.Ctx,
+		env:       cfg.Environment,
+		ks:        cfg.Keystore,
+		client:    monero.NewClient(cfg.MoneroWalletEndpoint),
+		ethClient: ec,
+		auth:      chain.NewTransactOpts(cfg.Ctx, cfg.EthereumSigner, big.NewInt(cfg.ChainID)),
+		txTracker: tracker,
+		fees:      chain.NewFeeManager(ec, tracker, cfg.Fees),
+		callOpts: &bind.CallOpts{
+			From:    cfg.EthereumSigner.Address(),
+			Context: cfg.Ctx,
+		},
+	}, nil
+}
+
+// Recover determines the state of the swap contract at the given address and takes the appropriate action:
+// - if the shared swap keys are already known, the XMR wallet is re-created from them.
+// - as Alice, if Bob has claimed, the XMR wallet is created from his revealed secret; otherwise we refund, if the contract allows it.
+// - as Bob, if Alice has refunded, the XMR wallet is created from her revealed secret; otherwise we claim, if the contract allows it.
+// The swap directory `dir` is where the shared swap keys are written, if they are computed.
+func (r *recoverer) Recover(dir string, secrets *SwapSecrets, contractAddr ethcommon.Address) (*Result, error) {
+	if secrets.SwapKeys != nil {
+		log.Info("found shared swap secret, creating XMR wallet...")
+		return r.createMoneroWallet(secrets.SwapKeys)
+	}
+
+	contract, err := swap.NewSwap(contractAddr, r.ethClient)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := r.getContractState(contract)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("contract state: address=%s ready=%v claimed=%v refunded=%v t0=%s t1=%s",
+		contractAddr,
+		state.ready,
+		state.claimSecret != nil,
+		state.refundSecret != nil,
+		state.t0,
+		state.t1,
+	)
+
+	switch secrets.Provides {
+	case common.ProvidesETH:
+		return r.recoverAsAlice(dir, secrets.Keys, contract, state)
+	case common.ProvidesXMR:
+		return r.recoverAsBob(dir, secrets.Keys, contract, state)
+	default:
+		return nil, errors.New("invalid swap secrets")
+	}
+}
+
+func (r *recoverer) recoverAsAlice(dir string, kp *monero.PrivateKeyPair, contract *swap.Swap, state *contractState) (*Result, error) {
+	if state.refundSecret != nil {
+		return nil, errors.New("swap was already refunded")
+	}
+
+	if state.claimSecret != nil {
+		// Bob claimed, so we can use his secret to claim the XMR
+		return r.recoverMonero(dir, kp, *state.claimSecret)
+	}
+
+	now := time.Now()
+	if state.ready && now.Before(state.t1) {
+		return nil, fmt.Errorf("cannot refund until t1=%s", state.t1)
+	}
+
+	if !state.ready && !now.Before(state.t0) && now.Before(state.t1) {
+		return nil, fmt.Errorf("past t0 without calling ready, cannot refund until t1=%s", state.t1)
+	}
+
+	secret := kp.SpendKeyBytes()
+	var sc [32]byte
+	copy(sc[:], common.Reverse(secret))
+
+	// before t0, we can only refund until t0. after t1, we can refund whenever
+	var deadline time.Time
+	if now.Before(state.t0) {
+		deadline = state.t0
+	}
+
+	receipt, err := r.fees.Transact(r.ctx, r.auth, deadline, func(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+		tx, err := contract.Refund(opts, sc)
+		if err == nil {
+			log.Infof("sent Refund tx, tx hash=%s", tx.Hash())
+		}
+
+		return tx, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Refund in Swap.sol: %w", err)
+	}
+
+	return &Result{
+		Refunded: true,
+		TxHash:   receipt.TxHash,
+	}, nil
+}
+
+func (r *recoverer) recoverAsBob(dir string, kp *monero.PrivateKeyPair, contract *swap.Swap, state *contractState) (*Result, error) {
+	if state.claimSecret != nil {
+		return nil, errors.New("swap was already claimed")
+	}
+
+	if state.refundSecret != nil {
+		// Alice refunded, so we can use her secret to regain our XMR
+		return r.recoverMonero(dir, kp, *state.refundSecret)
+	}
+
+	now := time.Now()
+	if !now.Before(state.t1) {
+		return nil, errors.New("past t1, cannot claim; wait for Alice to refund, then try again")
+	}
+
+	if !state.ready && now.Before(state.t0) {
+		return nil, fmt.Errorf("contract is not ready, cannot claim until t0=%s", state.t0)
+	}
+
+	secret := kp.SpendKeyBytes()
+	var sc [32]byte
+	copy(sc[:], common.Reverse(secret))
+
+	// we can only claim until t1
+	receipt, err := r.fees.Transact(r.ctx, r.auth, state.t1, func(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+		tx, err := contract.Claim(opts, sc)
+		if err == nil {
+			log.Infof("sent Claim tx, tx hash=%s", tx.Hash())
+		}
+
+		return tx, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Claim in Swap.sol: %w", err)
+	}
+
+	return &Result{
+		Claimed: true,
+		TxHash:  receipt.TxHash,
+	}, nil
+}
+
+// recoverMonero combines our keys with the counterparty's secret revealed in the contract,
+// and creates a wallet containing the swap's XMR.
+func (r *recoverer) recoverMonero(dir string, kp *monero.PrivateKeyPair, counterpartySecret [32]byte) (*Result, error) {
+	log.Debug("got counterparty's secret: ", hex.EncodeToString(counterpartySecret[:]))
+
+	sk, err := monero.NewPrivateSpendKey(common.Reverse(counterpartySecret[:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert counterparty's secret into a key: %w", err)
+	}
+
+	vk, err := sk.View()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get counterparty's view key: %w", err)
+	}
+
+	skAB := monero.SumPrivateSpendKeys(sk, kp.SpendKey())
+	vkAB := monero.SumPrivateViewKeys(vk, kp.ViewKey())
+	kpAB := monero.NewPrivateKeyPair(skAB, vkAB)
+
+	// write keys to file in case something goes wrong
+	if err = monero.WriteKeysToFile(filepath.Join(dir, swapSecretPrefix), kpAB, r.env, r.ks); err != nil {
+		return nil, err
+	}
+
+	return r.createMoneroWallet(kpAB)
+}
+
+func (r *recoverer) createMoneroWallet(kpAB *monero.PrivateKeyPair) (*Result, error) {
+	t := time.Now().Format("2006-Jan-2-15:04:05")
+	walletName := fmt.Sprintf("recovered-swap-wallet-%s", t)
+	if err := r.client.GenerateFromKeys(kpAB, walletName, "", r.env); err != nil {
+		return nil, err
+	}
+
+	log.Info("created wallet: ", walletName)
+
+	if err := r.client.Refresh(); err != nil {
+		return nil, err
+	}
+
+	balance, err := r.client.GetBalance(0)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("wallet balance: ", balance.Balance)
+	return &Result{
+		MoneroAddress: kpAB.Address(r.env),
+		WalletName:    walletName,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package recovery
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/monero"
+	"github.com/noot/atomic-swap/swap-contract"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRecoverer(t *testing.T, privkey string) *recoverer {
+	ks, err := keystore.NewKeystore([]byte("password"))
+	require.NoError(t, err)
+
+	signer, err := chain.NewKeySignerFromHex(privkey)
+	require.NoError(t, err)
+
+	r, err := NewRecoverer(&Config{
+		Ctx:                  context.Background(),
+		Environment:          common.Development,
+		MoneroWalletEndpoint: common.DefaultAliceMoneroEndpoint,
+		EthereumEndpoint:     common.DefaultEthEndpoint,
+		EthereumSigner:       signer,
+		ChainID:              common.GanacheChainID,
+		Keystore:             ks,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+func TestRecoverer_Recover_AliceRefund(t *testing.T) {
+	r := newTestRecoverer(t, common.DefaultPrivKeyAlice)
+
+	aliceKeys, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	bobKeys, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	var pka, pkb [32]byte
+	copy(pka[:], common.Reverse(aliceKeys.SpendKey().Public().Bytes()))
+	copy(pkb[:], common.Reverse(bobKeys.SpendKey().Public().Bytes()))
+
+	addr, tx, _, err := swap.DeploySwap(r.auth, r.ethClient, pkb, pka, ethcommon.Address{}, big.NewInt(60*60))
+	require.NoError(t, err)
+	_, err = r.txTracker.Wait(r.ctx, tx)
+	require.NoError(t, err)
+
+	secrets := &SwapSecrets{
+		Provides: common.ProvidesETH,
+		Keys:     aliceKeys,
+	}
+
+	// we haven't called Ready() and are before t0, so we should be able to refund
+	res, err := r.Recover(t.TempDir(), secrets, addr)
+	require.NoError(t, err)
+	require.True(t, res.Refunded)
+
+	// the contract is refunded, so we can't do anything else
+	_, err = r.Recover(t.TempDir(), secrets, addr)
+	require.Error(t, err)
+}
+
+func TestRecoverer_Recover_BobNotReady(t *testing.T) {
+	alice := newTestRecoverer(t, common.DefaultPrivKeyAlice)
+	r := newTestRecoverer(t, common.DefaultPrivKeyBob)
+
+	bobKeys, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	var pkb [32]byte
+	copy(pkb[:], common.Reverse(bobKeys.SpendKey().Public().Bytes()))
+
+	addr, tx, _, err := swap.DeploySwap(alice.auth, alice.ethClient, pkb, [32]byte{}, r.callOpts.From, big.NewInt(60*60))
+	require.NoError(t, err)
+	_, err = r.txTracker.Wait(r.ctx, tx)
+	require.NoError(t, err)
+
+	secrets := &SwapSecrets{
+		Provides: common.ProvidesXMR,
+		Keys:     bobKeys,
+	}
+
+	// Alice hasn't called Ready() and we're before t0, so we can't claim yet
+	_, err = r.Recover(t.TempDir(), secrets, addr)
+	require.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/monero"
+)
+
+const (
+	aliceSecretPrefix = "alice-secret"
+	bobSecretPrefix   = "bob-secret"
+	swapSecretPrefix  = "swap-secret"
+)
+
+// SwapSecrets are the keys found in a swap directory, ie. <basepath>/<id>.
+type SwapSecrets struct {
+	// Provides is ETH if the directory contains Alice's secret, or XMR if it contains Bob's.
+	Provides common.ProvidesCoin
+
+	// Keys is our key pair for the swap.
+	Keys *monero.PrivateKeyPair
+
+	// SwapKeys is the shared key pair (s_a + s_b, v_a + v_b), if it was computed before the daemon exited.
+	SwapKeys *monero.PrivateKeyPair
+}
+
+// LoadSwapSecrets loads and decrypts the secrets written during a swap from the given swap directory.
+func LoadSwapSecrets(dir string, ks *keystore.Keystore) (*SwapSecrets, error) {
+	secrets := &SwapSecrets{}
+
+	aliceKeys, err := readSecret(dir, aliceSecretPrefix, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	bobKeys, err := readSecret(dir, bobSecretPrefix, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case aliceKeys != nil && bobKeys != nil:
+		return nil, errors.New("swap directory contains both Alice's and Bob's secrets")
+	case aliceKeys != nil:
+		secrets.Provides = common.ProvidesETH
+		secrets.Keys = aliceKeys
+	case bobKeys != nil:
+		secrets.Provides = common.ProvidesXMR
+		secrets.Keys = bobKeys
+	default:
+		return nil, fmt.Errorf("no %s or %s file found in %s", aliceSecretPrefix, bobSecretPrefix, dir)
+	}
+
+	secrets.SwapKeys, err = readSecret(dir, swapSecretPrefix, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// readSecret reads the key file with the given prefix, as written by monero.WriteKeysToFile.
+// It returns nil if there is no such file.
+func readSecret(dir, prefix string, ks *keystore.Keystore) (*monero.PrivateKeyPair, error) {
+	files, err := filepath.Glob(filepath.Join(dir, prefix+"-*.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if len(files) > 1 {
+		log.Warnf("found multiple %s files, using %s", prefix, files[0])
+	}
+
+	kp, err := monero.ReadKeysFromFile(files[0], ks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", files[0], err)
+	}
+
+	return kp, nil
+}
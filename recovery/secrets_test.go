@@ -0,0 +1,47 @@
+package recovery
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/keystore"
+	"github.com/noot/atomic-swap/monero"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSwapSecrets(t *testing.T) {
+	dir := t.TempDir()
+
+	ks, err := keystore.NewKeystore([]byte("password"))
+	require.NoError(t, err)
+
+	_, err = LoadSwapSecrets(dir, ks)
+	require.Error(t, err)
+
+	kp, err := monero.GenerateKeys()
+	require.NoError(t, err)
+
+	err = monero.WriteKeysToFile(filepath.Join(dir, bobSecretPrefix), kp, common.Development, ks)
+	require.NoError(t, err)
+
+	secrets, err := LoadSwapSecrets(dir, ks)
+	require.NoError(t, err)
+	require.Equal(t, common.ProvidesXMR, secrets.Provides)
+	require.Equal(t, kp.SpendKey().Hex(), secrets.Keys.SpendKey().Hex())
+	require.Nil(t, secrets.SwapKeys)
+
+	err = monero.WriteKeysToFile(filepath.Join(dir, swapSecretPrefix), kp, common.Development, ks)
+	require.NoError(t, err)
+
+	secrets, err = LoadSwapSecrets(dir, ks)
+	require.NoError(t, err)
+	require.NotNil(t, secrets.SwapKeys)
+
+	err = monero.WriteKeysToFile(filepath.Join(dir, aliceSecretPrefix), kp, common.Development, ks)
+	require.NoError(t, err)
+
+	_, err = LoadSwapSecrets(dir, ks)
+	require.Error(t, err)
+}
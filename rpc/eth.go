@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+)
+
+var errNoOfflineSigner = errors.New("transactions aren't signed offline by this node")
+
+// EthService exposes the settings of the Ethereum transactions sent by the daemon, and the
+// transactions waiting to be signed offline.
+type EthService struct {
+	protocol Protocol
+	offline  *chain.OfflineSigner
+}
+
+func NewEthService(protocol Protocol, offline *chain.OfflineSigner) *EthService {
+	return &EthService{
+		protocol: protocol,
+		offline:  offline,
+	}
+}
+
+type GetFeeSettingsRequest struct{}
+
+// GetFeeSettingsResponse describes the fees paid by our transactions, and the network's current fees.
+// All fees are per gas, in gwei.
+type GetFeeSettingsResponse struct {
+	MaxFeePerGas         float64 `json:"maxFeePerGas,omitempty"` // unset if there's no limit
+	PriorityFee          float64 `json:"priorityFee,omitempty"`  // unset if the node's suggestion is used
+	BumpInterval         uint64  `json:"bumpInterval"`           // in seconds; zero if transactions aren't replaced
+	BaseFee              float64 `json:"baseFee,omitempty"`      // unset if the chain doesn't support EIP-1559
+	SuggestedPriorityFee float64 `json:"suggestedPriorityFee,omitempty"`
+}
+
+// GetFeeSettings returns the fees paid by our transactions. Transactions that are pending for longer than
+// the bump interval are replaced with ones paying higher fees, up to the maximum fee.
+func (s *EthService) GetFeeSettings(_ *http.Request, _ *GetFeeSettingsRequest, resp *GetFeeSettingsResponse) error {
+	settings, err := s.protocol.FeeSettings()
+	if err != nil {
+		return err
+	}
+
+	resp.MaxFeePerGas = weiToGwei(settings.MaxFeePerGas)
+	resp.PriorityFee = weiToGwei(settings.PriorityFee)
+	resp.BumpInterval = uint64(settings.BumpInterval.Seconds())
+	resp.BaseFee = weiToGwei(settings.BaseFee)
+	resp.SuggestedPriorityFee = weiToGwei(settings.SuggestedPriorityFee)
+	return nil
+}
+
+type GetUnsignedTxsRequest struct{}
+
+// UnsignedTx is a transaction waiting to be signed offline.
+type UnsignedTx struct {
+	ID      uint64 `json:"id"`
+	Label   string `json:"label"`   // what the transaction does
+	ChainID uint64 `json:"chainID"` // the chain the transaction must be signed for
+	Tx      string `json:"tx"`      // hex-encoded unsigned transaction
+	From    string `json:"from"`
+	Nonce   uint64 `json:"nonce"`
+	Created uint64 `json:"created"` // unix timestamp
+
+	// unix timestamp that the transaction must be signed and included by, and the seconds left until
+	// then; unset if there's no deadline
+	Deadline uint64 `json:"deadline,omitempty"`
+	TimeLeft int64  `json:"timeLeft,omitempty"`
+
+	// set if the deadline is closer than signing usually takes
+	Warning string `json:"warning,omitempty"`
+}
+
+type GetUnsignedTxsResponse struct {
+	Txs []*UnsignedTx `json:"txs"`
+}
+
+// GetUnsignedTxs returns the transactions waiting to be signed offline, oldest first. They must be
+// signed in that order, as each uses the next nonce.
+func (s *EthService) GetUnsignedTxs(_ *http.Request, _ *GetUnsignedTxsRequest, resp *GetUnsignedTxsResponse) error {
+	if s.offline == nil {
+		return errNoOfflineSigner
+	}
+
+	resp.Txs = []*UnsignedTx{}
+	for _, tx := range s.offline.Pending() {
+		raw, err := tx.Tx.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		unsigned := &UnsignedTx{
+			ID:      tx.ID,
+			Label:   tx.Label,
+			ChainID: tx.ChainID.Uint64(),
+			Tx:      hexutil.Encode(raw),
+			From:    s.offline.Address().String(),
+			Nonce:   tx.Tx.Nonce(),
+			Created: uint64(tx.Created.Unix()),
+		}
+
+		if !tx.Deadline.IsZero() {
+			timeLeft := time.Until(tx.Deadline)
+			unsigned.Deadline = uint64(tx.Deadline.Unix())
+			unsigned.TimeLeft = int64(timeLeft.Seconds())
+			if timeLeft < s.offline.Latency() {
+				unsigned.Warning = fmt.Sprintf("deadline is in %s, sooner than signing usually takes (%s)",
+					timeLeft.Round(time.Second), s.offline.Latency())
+			}
+		}
+
+		resp.Txs = append(resp.Txs, unsigned)
+	}
+
+	return nil
+}
+
+type ImportSignedTxRequest struct {
+	Tx string `json:"tx"` // hex-encoded signed transaction
+}
+
+type ImportSignedTxResponse struct {
+	ID     uint64 `json:"id"` // ID of the unsigned transaction that was signed
+	TxHash string `json:"txHash"`
+
+	// set if the transaction's deadline is closer than signing usually takes, or has passed
+	Warning string `json:"warning,omitempty"`
+}
+
+// ImportSignedTx imports a signed version of a transaction waiting to be signed offline, which
+// is then sent.
+func (s *EthService) ImportSignedTx(_ *http.Request, req *ImportSignedTxRequest, resp *ImportSignedTxResponse) error {
+	if s.offline == nil {
+		return errNoOfflineSigner
+	}
+
+	raw, err := hexutil.Decode(req.Tx)
+	if err != nil {
+		return fmt.Errorf("failed to decode signed transaction: %w", err)
+	}
+
+	tx := new(ethtypes.Transaction)
+	if err = tx.UnmarshalBinary(raw); err != nil {
+		return fmt.Errorf("failed to decode signed transaction: %w", err)
+	}
+
+	unsigned, err := s.offline.Import(tx)
+	if err != nil {
+		return err
+	}
+
+	resp.ID = unsigned.ID
+	resp.TxHash = tx.Hash().String()
+	if !unsigned.Deadline.IsZero() {
+		switch timeLeft := time.Until(unsigned.Deadline); {
+		case timeLeft <= 0:
+			resp.Warning = "deadline has passed, the transaction is likely to fail"
+		case timeLeft < s.offline.Latency():
+			resp.Warning = fmt.Sprintf("deadline is in %s, the transaction may not be included in time",
+				timeLeft.Round(time.Second))
+		}
+	}
+
+	return nil
+}
+
+func weiToGwei(amount *big.Int) float64 {
+	if amount == nil {
+		return 0
+	}
+
+	return common.WeiToGwei(amount)
+}
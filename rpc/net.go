@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/noot/atomic-swap/chain"
 	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
 	"github.com/noot/atomic-swap/net"
 
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -17,12 +19,23 @@ const defaultSearchTime = time.Second * 12
 type Net interface {
 	Discover(provides common.ProvidesCoin, searchTime time.Duration) ([]peer.AddrInfo, error)
 	Query(who peer.AddrInfo) (*net.QueryResponse, error)
-	Initiate(who peer.AddrInfo, msg *net.InitiateMessage, s net.SwapState) error
+	MakeOffer(terms *net.Offer, ttl time.Duration) (*net.Offer, error)
+	OrderBook(provides common.ProvidesCoin) []*net.Offer
+	Initiate(who peer.AddrInfo, terms *net.Terms, initiate net.InitiateFunc) error
+	Peers() []*net.PeerRecord
+	BanPeer(who peer.ID) error
+	UnbanPeer(who peer.ID) error
 }
 
 type Protocol interface {
 	Provides() common.ProvidesCoin
-	InitiateProtocol(providesAmount, desiredAmount float64) (net.SwapState, error)
+	InitiateProtocol(who peer.ID, terms *net.Terms) (net.SwapState, error)
+	GetSwap(id common.SwapID) (*db.SwapInfo, error)
+	GetSwaps() ([]*db.SwapInfo, error)
+	Cancel(id common.SwapID) error
+	Refund(id common.SwapID) (string, error)
+	Claim(id common.SwapID) (string, error)
+	FeeSettings() (*chain.FeeSettings, error)
 }
 
 type NetService struct {
@@ -84,11 +97,10 @@ type QueryPeerRequest struct {
 }
 
 type QueryPeerResponse struct {
-	Provides      []common.ProvidesCoin `json:"provides"`
-	MaximumAmount []float64             `json:"maximumAmount"`
-	ExchangeRate  common.ExchangeRate   `json:"exchangeRate"`
+	Offers []*net.Offer `json:"offers"`
 }
 
+// QueryPeer returns the peer's live offers. Only offers signed by the peer are returned.
 func (s *NetService) QueryPeer(_ *http.Request, req *QueryPeerRequest, resp *QueryPeerResponse) error {
 	who, err := net.StringToAddrInfo(req.Multiaddr)
 	if err != nil {
@@ -100,51 +112,102 @@ func (s *NetService) QueryPeer(_ *http.Request, req *QueryPeerRequest, resp *Que
 		return err
 	}
 
-	resp.Provides = msg.Provides
-	resp.MaximumAmount = msg.MaximumAmount
-	resp.ExchangeRate = msg.ExchangeRate
+	resp.Offers = msg.Offers
+	return nil
+}
+
+type GetOffersRequest struct {
+	Provides common.ProvidesCoin `json:"provides"` // optional; if empty, offers for both coins are returned
+}
+
+type GetOffersResponse struct {
+	Offers []*net.Offer `json:"offers"`
+}
+
+// GetOffers returns the live offers that makers have broadcast on the network, with the best
+// exchange rate for the taker first.
+func (s *NetService) GetOffers(_ *http.Request, req *GetOffersRequest, resp *GetOffersResponse) error {
+	if req.Provides != "" {
+		if _, err := common.NewProvidesCoin(string(req.Provides)); err != nil {
+			return err
+		}
+	}
+
+	resp.Offers = s.net.OrderBook(req.Provides)
 	return nil
 }
 
 type InitiateRequest struct {
 	Multiaddr      string              `json:"multiaddr"`
+	OfferID        net.OfferID         `json:"offerID"`
 	ProvidesCoin   common.ProvidesCoin `json:"provides"`
 	ProvidesAmount float64             `json:"providesAmount"`
 	DesiredAmount  float64             `json:"desiredAmount"`
+	Timeout        uint64              `json:"timeout"` // in seconds; defaults to a day
 }
 
 type InitiateResponse struct {
-	Success bool `json:"success"`
+	ID      common.SwapID `json:"id"`
+	Success bool          `json:"success"`
+	// the agreed amounts, which may be less than the requested ones
+	ProvidesAmount float64 `json:"providesAmount"`
+	DesiredAmount  float64 `json:"desiredAmount"`
 }
 
+// Initiate proposes a swap to the peer that takes one of its offers. The provided and desired amounts
+// are the most we'll provide and the price we'll pay; the peer may counter with a smaller swap, or one
+// at a better price for us. Once the terms are agreed, the swap is performed.
 func (s *NetService) Initiate(_ *http.Request, req *InitiateRequest, resp *InitiateResponse) error {
 	if req.ProvidesCoin == "" {
 		return errors.New("must specify 'provides' coin")
 	}
 
-	swapState, err := s.protocol.InitiateProtocol(req.ProvidesAmount, req.DesiredAmount)
-	if err != nil {
-		return err
+	if req.OfferID.IsZero() {
+		return errors.New("must specify the 'offerID' of the peer's offer to take")
 	}
 
-	skm, err := swapState.SendKeysMessage()
+	if req.ProvidesAmount <= 0 || req.DesiredAmount <= 0 {
+		return errors.New("'providesAmount' and 'desiredAmount' must be above 0")
+	}
+
+	who, err := net.StringToAddrInfo(req.Multiaddr)
 	if err != nil {
 		return err
 	}
 
-	msg := &net.InitiateMessage{
-		Provides:        req.ProvidesCoin,
-		ProvidesAmount:  req.ProvidesAmount,
-		DesiredAmount:   req.DesiredAmount,
-		SendKeysMessage: skm,
+	timeout := common.SwapTimeout
+	if req.Timeout != 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
 	}
 
-	who, err := net.StringToAddrInfo(req.Multiaddr)
+	if timeout < common.MinSwapTimeout || timeout > common.MaxSwapTimeout {
+		return fmt.Errorf("'timeout' must be between %s and %s", common.MinSwapTimeout, common.MaxSwapTimeout)
+	}
+
+	id, err := common.NewSwapID()
 	if err != nil {
 		return err
 	}
 
-	if err = s.net.Initiate(who, msg, swapState); err != nil {
+	resp.ID = id
+
+	terms := &net.Terms{
+		SwapID:         id,
+		OfferID:        req.OfferID,
+		Provides:       req.ProvidesCoin,
+		ProvidesAmount: req.ProvidesAmount,
+		DesiredAmount:  req.DesiredAmount,
+		Timeout:        timeout,
+	}
+
+	// the swap state, and our keys for it, are only created once the peer agrees to the swap
+	initiate := func(agreed *net.Terms) (net.SwapState, error) {
+		resp.ProvidesAmount = agreed.ProvidesAmount
+		resp.DesiredAmount = agreed.DesiredAmount
+		return s.protocol.InitiateProtocol(who.ID, agreed)
+	}
+
+	if err = s.net.Initiate(who, terms, initiate); err != nil {
 		resp.Success = false
 		return err
 	}
@@ -152,3 +215,71 @@ func (s *NetService) Initiate(_ *http.Request, req *InitiateRequest, resp *Initi
 	resp.Success = true
 	return nil
 }
+
+type MakeOfferRequest struct {
+	MinimumAmount float64             `json:"minimumAmount"`
+	MaximumAmount float64             `json:"maximumAmount"`
+	ExchangeRate  common.ExchangeRate `json:"exchangeRate"`
+	TTL           uint64              `json:"ttl"` // in seconds; defaults to a day, and can be at most a week
+}
+
+type MakeOfferResponse struct {
+	Offer *net.Offer `json:"offer"`
+}
+
+// MakeOffer publishes a signed offer to swap the coin we provide. It's broadcast to the network,
+// and returned to peers that query us.
+func (s *NetService) MakeOffer(_ *http.Request, req *MakeOfferRequest, resp *MakeOfferResponse) error {
+	provides := s.protocol.Provides()
+	offer, err := s.net.MakeOffer(&net.Offer{
+		Provides:      provides,
+		Desires:       provides.Counterpart(),
+		MinimumAmount: req.MinimumAmount,
+		MaximumAmount: req.MaximumAmount,
+		ExchangeRate:  req.ExchangeRate,
+	}, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	resp.Offer = offer
+	return nil
+}
+
+type GetPeersRequest struct{}
+
+type GetPeersResponse struct {
+	Peers []*net.PeerRecord `json:"peers"`
+}
+
+// GetPeers returns our records of the peers we know about, from the highest score to the lowest.
+func (s *NetService) GetPeers(_ *http.Request, _ *GetPeersRequest, resp *GetPeersResponse) error {
+	resp.Peers = s.net.Peers()
+	return nil
+}
+
+type PeerIDRequest struct {
+	PeerID string `json:"peerID"`
+}
+
+type BanPeerResponse struct{}
+
+// BanPeer bans the peer, which disconnects it and stops it from connecting to us.
+func (s *NetService) BanPeer(_ *http.Request, req *PeerIDRequest, _ *BanPeerResponse) error {
+	who, err := peer.Decode(req.PeerID)
+	if err != nil {
+		return err
+	}
+
+	return s.net.BanPeer(who)
+}
+
+// UnbanPeer unbans the peer, and resets its score.
+func (s *NetService) UnbanPeer(_ *http.Request, req *PeerIDRequest, _ *BanPeerResponse) error {
+	who, err := peer.Decode(req.PeerID)
+	if err != nil {
+		return err
+	}
+
+	return s.net.UnbanPeer(who)
+}
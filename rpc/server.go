@@ -7,6 +7,9 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/rpc/v2"
 
+	"github.com/noot/atomic-swap/chain"
+	"github.com/noot/atomic-swap/common"
+
 	logging "github.com/ipfs/go-log"
 )
 
@@ -14,6 +17,7 @@ var log = logging.Logger("rpc")
 
 type Server struct {
 	s    *rpc.Server
+	ws   *wsHandler
 	port uint32
 }
 
@@ -21,6 +25,10 @@ type Config struct {
 	Port     uint32
 	Net      Net
 	Protocol Protocol
+	Events   *common.EventFeed    // if set, clients can subscribe to swap events over a websocket at /ws
+	Requests *common.RequestQueue // if set, incoming swap requests can be accepted or declined
+	// if set, transactions waiting to be signed offline can be exported, and imported once signed
+	OfflineSigner *chain.OfflineSigner
 }
 
 func NewServer(cfg *Config) (*Server, error) {
@@ -30,16 +38,33 @@ func NewServer(cfg *Config) (*Server, error) {
 		return nil, err
 	}
 
-	return &Server{
+	if err := s.RegisterService(NewSwapService(cfg.Protocol, cfg.Requests), "swap"); err != nil {
+		return nil, err
+	}
+
+	if err := s.RegisterService(NewEthService(cfg.Protocol, cfg.OfflineSigner), "eth"); err != nil {
+		return nil, err
+	}
+
+	server := &Server{
 		s:    s,
 		port: cfg.Port,
-	}, nil
+	}
+
+	if cfg.Events != nil {
+		server.ws = newWSHandler(cfg.Events)
+	}
+
+	return server, nil
 }
 
 func (s *Server) Start() {
 	go func() {
 		r := mux.NewRouter()
 		r.Handle("/", s.s)
+		if s.ws != nil {
+			r.Handle("/ws", s.ws)
+		}
 
 		log.Infof("starting RPC server on http://localhost:%d", s.port)
 
@@ -0,0 +1,232 @@
+package rpc
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/noot/atomic-swap/common"
+	"github.com/noot/atomic-swap/db"
+)
+
+var errNoRequestQueue = errors.New("swap requests aren't queued by this node")
+
+type SwapService struct {
+	protocol Protocol
+	requests *common.RequestQueue
+}
+
+func NewSwapService(protocol Protocol, requests *common.RequestQueue) *SwapService {
+	return &SwapService{
+		protocol: protocol,
+		requests: requests,
+	}
+}
+
+// SwapSummary is a short description of a swap, as returned by GetOngoing and GetPast.
+type SwapSummary struct {
+	ID        common.SwapID       `json:"id"`
+	Provides  common.ProvidesCoin `json:"provides"`
+	ETHAmount float64             `json:"ethAmount"`
+	XMRAmount float64             `json:"xmrAmount"`
+	Status    common.Status       `json:"status"`
+	CreatedAt time.Time           `json:"createdAt"`
+	UpdatedAt time.Time           `json:"updatedAt"`
+}
+
+type GetSwapsRequest struct{}
+
+type GetSwapsResponse struct {
+	Swaps []*SwapSummary `json:"swaps"`
+}
+
+// GetOngoing returns every swap that hasn't completed, ordered by the time they were created.
+func (s *SwapService) GetOngoing(_ *http.Request, _ *GetSwapsRequest, resp *GetSwapsResponse) error {
+	return s.getSwaps(resp, func(status common.Status) bool {
+		return !status.IsComplete()
+	})
+}
+
+// GetPast returns every swap that has completed, ordered by the time they were created.
+func (s *SwapService) GetPast(_ *http.Request, _ *GetSwapsRequest, resp *GetSwapsResponse) error {
+	return s.getSwaps(resp, func(status common.Status) bool {
+		return status.IsComplete()
+	})
+}
+
+func (s *SwapService) getSwaps(resp *GetSwapsResponse, include func(common.Status) bool) error {
+	swaps, err := s.protocol.GetSwaps()
+	if err != nil {
+		return err
+	}
+
+	resp.Swaps = []*SwapSummary{}
+	for _, info := range swaps {
+		if include(info.Status) {
+			resp.Swaps = append(resp.Swaps, newSwapSummary(info))
+		}
+	}
+
+	return nil
+}
+
+func newSwapSummary(info *db.SwapInfo) *SwapSummary {
+	summary := &SwapSummary{
+		ID:        info.ID,
+		Provides:  info.Provides,
+		XMRAmount: info.XMRAmount.AsMonero(),
+		Status:    info.Status,
+		CreatedAt: info.CreatedAt,
+		UpdatedAt: info.UpdatedAt,
+	}
+
+	if info.ETHAmount != nil {
+		summary.ETHAmount = common.EtherAmount(*info.ETHAmount).AsEther()
+	}
+
+	return summary
+}
+
+type GetDetailsRequest struct {
+	ID common.SwapID `json:"id"`
+}
+
+// GetDetailsResponse describes a swap. Fields that aren't known yet, such as the contract address
+// before the contract is deployed, are empty.
+type GetDetailsResponse struct {
+	SwapSummary
+	CounterpartyPeerID     string                    `json:"counterpartyPeerID"`
+	CounterpartyEthAddress string                    `json:"counterpartyEthAddress,omitempty"`
+	ContractAddress        string                    `json:"contractAddress,omitempty"`
+	Timeout0               *time.Time                `json:"timeout0,omitempty"`
+	Timeout1               *time.Time                `json:"timeout1,omitempty"`
+	DeployTxHash           string                    `json:"deployTxHash,omitempty"`
+	ReadyTxHash            string                    `json:"readyTxHash,omitempty"`
+	ClaimTxHash            string                    `json:"claimTxHash,omitempty"`
+	RefundTxHash           string                    `json:"refundTxHash,omitempty"`
+	StatusHistory          []common.StatusTransition `json:"statusHistory"`
+}
+
+// GetDetails returns the details of the swap with the given ID.
+func (s *SwapService) GetDetails(_ *http.Request, req *GetDetailsRequest, resp *GetDetailsResponse) error {
+	info, err := s.protocol.GetSwap(req.ID)
+	if err != nil {
+		return err
+	}
+
+	resp.SwapSummary = *newSwapSummary(info)
+	resp.CounterpartyPeerID = info.CounterpartyPeerID
+	resp.CounterpartyEthAddress = info.CounterpartyEthAddress
+	resp.ContractAddress = info.ContractAddress
+	resp.DeployTxHash = info.DeployTxHash
+	resp.ReadyTxHash = info.ReadyTxHash
+	resp.ClaimTxHash = info.ClaimTxHash
+	resp.RefundTxHash = info.RefundTxHash
+	resp.StatusHistory = info.StatusHistory
+
+	if !info.Timeout0.IsZero() {
+		resp.Timeout0 = &info.Timeout0
+	}
+
+	if !info.Timeout1.IsZero() {
+		resp.Timeout1 = &info.Timeout1
+	}
+
+	return nil
+}
+
+type GetStatusRequest struct {
+	ID common.SwapID `json:"id"`
+}
+
+type GetStatusResponse struct {
+	Status  common.Status             `json:"status"`
+	History []common.StatusTransition `json:"history"`
+}
+
+// GetStatus returns the status of the swap with the given ID, and the time it entered each status.
+func (s *SwapService) GetStatus(_ *http.Request, req *GetStatusRequest, resp *GetStatusResponse) error {
+	info, err := s.protocol.GetSwap(req.ID)
+	if err != nil {
+		return err
+	}
+
+	resp.Status = info.Status
+	resp.History = info.StatusHistory
+	return nil
+}
+
+type SwapIDRequest struct {
+	ID common.SwapID `json:"id"`
+}
+
+type CancelResponse struct{}
+
+// Cancel aborts the swap with the given ID. It's only possible before our funds have been locked.
+func (s *SwapService) Cancel(_ *http.Request, req *SwapIDRequest, _ *CancelResponse) error {
+	return s.protocol.Cancel(req.ID)
+}
+
+type TxHashResponse struct {
+	TxHash string `json:"txHash"`
+}
+
+// Refund refunds the ETH locked in the swap with the given ID. It's only possible if we provided ETH,
+// and the contract's timelocks allow it.
+func (s *SwapService) Refund(_ *http.Request, req *SwapIDRequest, resp *TxHashResponse) error {
+	txHash, err := s.protocol.Refund(req.ID)
+	if err != nil {
+		return err
+	}
+
+	resp.TxHash = txHash
+	return nil
+}
+
+// Claim claims the ETH locked in the swap with the given ID. It's only possible if we provided XMR,
+// and the contract's timelocks allow it.
+func (s *SwapService) Claim(_ *http.Request, req *SwapIDRequest, resp *TxHashResponse) error {
+	txHash, err := s.protocol.Claim(req.ID)
+	if err != nil {
+		return err
+	}
+
+	resp.TxHash = txHash
+	return nil
+}
+
+type GetRequestsRequest struct{}
+
+type GetRequestsResponse struct {
+	Requests []*common.SwapRequest `json:"requests"`
+}
+
+// GetRequests returns the incoming swap requests that are waiting to be accepted, oldest first.
+func (s *SwapService) GetRequests(_ *http.Request, _ *GetRequestsRequest, resp *GetRequestsResponse) error {
+	if s.requests == nil {
+		return errNoRequestQueue
+	}
+
+	resp.Requests = s.requests.Pending()
+	return nil
+}
+
+type RequestDecisionResponse struct{}
+
+// AcceptRequest accepts the pending swap request with the given ID, which starts the swap.
+func (s *SwapService) AcceptRequest(_ *http.Request, req *SwapIDRequest, _ *RequestDecisionResponse) error {
+	if s.requests == nil {
+		return errNoRequestQueue
+	}
+
+	return s.requests.Accept(req.ID)
+}
+
+// DeclineRequest declines the pending swap request with the given ID.
+func (s *SwapService) DeclineRequest(_ *http.Request, req *SwapIDRequest, _ *RequestDecisionResponse) error {
+	if s.requests == nil {
+		return errNoRequestQueue
+	}
+
+	return s.requests.Decline(req.ID)
+}
@@ -0,0 +1,257 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+const (
+	subscribeSwapMethod     = "swap_subscribe"
+	subscribeRequestsMethod = "swap_subscribeRequests"
+	unsubscribeMethod       = "swap_unsubscribe"
+	eventMethod             = "swap_event"
+
+	// JSON-RPC 2.0 error codes
+	errCodeInvalidRequest = -32600
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+)
+
+var upgrader = websocket.Upgrader{}
+
+// wsRequest is a JSON-RPC 2.0 request received over a websocket.
+type wsRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// wsResponse is a JSON-RPC 2.0 response or notification sent over a websocket.
+type wsResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  interface{}     `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *wsError        `json:"error,omitempty"`
+}
+
+type wsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// SubscribeSwapRequest is the params of swap_subscribe. If ID is set, only events for
+// that swap are sent; otherwise, lifecycle events for every swap are sent.
+type SubscribeSwapRequest struct {
+	ID *common.SwapID `json:"id"`
+}
+
+type UnsubscribeRequest struct {
+	Subscription uint64 `json:"subscription"`
+}
+
+// SwapEventNotification is the params of a swap_event notification.
+type SwapEventNotification struct {
+	Subscription uint64            `json:"subscription"`
+	Result       *common.SwapEvent `json:"result"`
+}
+
+// wsHandler upgrades HTTP connections to websockets, over which clients can subscribe to swap events.
+type wsHandler struct {
+	events *common.EventFeed
+}
+
+func newWSHandler(events *common.EventFeed) *wsHandler {
+	return &wsHandler{
+		events: events,
+	}
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnf("failed to upgrade websocket connection: err=%s", err)
+		return
+	}
+
+	c := &wsConn{
+		conn:   conn,
+		events: h.events,
+		subs:   make(map[uint64]func()),
+	}
+
+	c.serve()
+}
+
+// wsConn is a websocket connection and its subscriptions.
+type wsConn struct {
+	conn   *websocket.Conn
+	events *common.EventFeed
+
+	writeMu sync.Mutex
+
+	subMu   sync.Mutex
+	nextSub uint64
+	subs    map[uint64]func()
+}
+
+// serve handles requests from the connection until it's closed.
+func (c *wsConn) serve() {
+	defer func() {
+		c.unsubscribeAll()
+		_ = c.conn.Close()
+	}()
+
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			log.Debugf("websocket connection closed: err=%s", err)
+			return
+		}
+
+		var req wsRequest
+		if err = json.Unmarshal(msg, &req); err != nil {
+			c.writeError(nil, errCodeInvalidRequest, err)
+			continue
+		}
+
+		c.handleRequest(&req)
+	}
+}
+
+func (c *wsConn) handleRequest(req *wsRequest) {
+	switch req.Method {
+	case subscribeSwapMethod:
+		var params SubscribeSwapRequest
+		if err := decodeParams(req.Params, &params); err != nil {
+			c.writeError(req.ID, errCodeInvalidParams, err)
+			return
+		}
+
+		c.subscribe(req.ID, func(event *common.SwapEvent) bool {
+			if isRequestEvent(event) {
+				return false
+			}
+
+			return params.ID == nil || *params.ID == event.ID
+		})
+	case subscribeRequestsMethod:
+		c.subscribe(req.ID, isRequestEvent)
+	case unsubscribeMethod:
+		var params UnsubscribeRequest
+		if err := decodeParams(req.Params, &params); err != nil {
+			c.writeError(req.ID, errCodeInvalidParams, err)
+			return
+		}
+
+		_ = c.write(&wsResponse{
+			ID:     req.ID,
+			Result: c.unsubscribe(params.Subscription),
+		})
+	default:
+		c.writeError(req.ID, errCodeMethodNotFound, fmt.Errorf("method %s not found", req.Method))
+	}
+}
+
+func isRequestEvent(event *common.SwapEvent) bool {
+	return event.Type == common.EventSwapRequest || event.Type == common.EventRequestDeclined
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(params, v)
+}
+
+// subscribe sends every event that passes the filter to the client, until it unsubscribes.
+// The subscription ID is sent in the response to the request.
+func (c *wsConn) subscribe(reqID json.RawMessage, filter func(*common.SwapEvent) bool) {
+	ch, unsubscribe := c.events.Subscribe()
+
+	c.subMu.Lock()
+	c.nextSub++
+	id := c.nextSub
+	c.subs[id] = unsubscribe
+	c.subMu.Unlock()
+
+	if err := c.write(&wsResponse{
+		ID:     reqID,
+		Result: id,
+	}); err != nil {
+		c.unsubscribe(id)
+		return
+	}
+
+	go func() {
+		for event := range ch {
+			if !filter(event) {
+				continue
+			}
+
+			err := c.write(&wsResponse{
+				Method: eventMethod,
+				Params: &SwapEventNotification{
+					Subscription: id,
+					Result:       event,
+				},
+			})
+			if err != nil {
+				log.Debugf("failed to send swap event: err=%s", err)
+				c.unsubscribe(id)
+			}
+		}
+	}()
+}
+
+// unsubscribe cancels the subscription with the given ID, and returns whether it existed.
+func (c *wsConn) unsubscribe(id uint64) bool {
+	c.subMu.Lock()
+	unsubscribe, has := c.subs[id]
+	delete(c.subs, id)
+	c.subMu.Unlock()
+
+	if has {
+		unsubscribe()
+	}
+
+	return has
+}
+
+func (c *wsConn) unsubscribeAll() {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for id, unsubscribe := range c.subs {
+		unsubscribe()
+		delete(c.subs, id)
+	}
+}
+
+func (c *wsConn) writeError(reqID json.RawMessage, code int, err error) {
+	_ = c.write(&wsResponse{
+		ID: reqID,
+		Error: &wsError{
+			Code:    code,
+			Message: err.Error(),
+		},
+	})
+}
+
+func (c *wsConn) write(resp *wsResponse) error {
+	resp.JSONRPC = "2.0"
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.conn.WriteJSON(resp)
+}
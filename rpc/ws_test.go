@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/noot/atomic-swap/common"
+)
+
+type testNotification struct {
+	Method string                 `json:"method"`
+	Params *SwapEventNotification `json:"params"`
+}
+
+func newTestWSConn(t *testing.T, events *common.EventFeed) *websocket.Conn {
+	server := httptest.NewServer(newWSHandler(events))
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+func subscribe(t *testing.T, conn *websocket.Conn, method string, params interface{}) uint64 {
+	bz, err := json.Marshal(params)
+	require.NoError(t, err)
+
+	err = conn.WriteJSON(&wsRequest{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage("1"),
+		Method:  method,
+		Params:  bz,
+	})
+	require.NoError(t, err)
+
+	var resp struct {
+		Result uint64   `json:"result"`
+		Error  *wsError `json:"error"`
+	}
+	require.NoError(t, conn.ReadJSON(&resp))
+	require.Nil(t, resp.Error)
+	require.NotZero(t, resp.Result)
+	return resp.Result
+}
+
+func TestWS_SubscribeSwap(t *testing.T) {
+	events := common.NewEventFeed()
+	conn := newTestWSConn(t, events)
+
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+
+	other, err := common.NewSwapID()
+	require.NoError(t, err)
+
+	sub := subscribe(t, conn, subscribeSwapMethod, &SubscribeSwapRequest{ID: &id})
+
+	// only lifecycle events for the given swap are sent
+	events.Publish(common.NewRequestEvent(&common.SwapRequest{
+		ID:       id,
+		Peer:     "peer",
+		Provides: common.ProvidesETH,
+	}))
+	events.Publish(common.NewStatusEvent(other, common.KeysExchanged))
+	events.Publish(common.NewStatusEvent(id, common.KeysExchanged))
+
+	var n testNotification
+	require.NoError(t, conn.ReadJSON(&n))
+	require.Equal(t, eventMethod, n.Method)
+	require.Equal(t, sub, n.Params.Subscription)
+	require.Equal(t, common.EventKeysExchanged, n.Params.Result.Type)
+	require.Equal(t, id, n.Params.Result.ID)
+}
+
+func TestWS_SubscribeRequests(t *testing.T) {
+	events := common.NewEventFeed()
+	conn := newTestWSConn(t, events)
+
+	id, err := common.NewSwapID()
+	require.NoError(t, err)
+
+	sub := subscribe(t, conn, subscribeRequestsMethod, nil)
+
+	events.Publish(common.NewStatusEvent(id, common.KeysExchanged))
+	events.Publish(common.NewRequestEvent(&common.SwapRequest{
+		ID:             id,
+		Peer:           "peer",
+		Provides:       common.ProvidesXMR,
+		ProvidesAmount: 2,
+		DesiredAmount:  0.1,
+	}))
+
+	var n testNotification
+	require.NoError(t, conn.ReadJSON(&n))
+	require.Equal(t, sub, n.Params.Subscription)
+	require.Equal(t, common.EventSwapRequest, n.Params.Result.Type)
+	require.Equal(t, "peer", n.Params.Result.Peer)
+	require.Equal(t, 2.0, n.Params.Result.ProvidesAmount)
+}
+
+func TestWS_UnknownMethod(t *testing.T) {
+	conn := newTestWSConn(t, common.NewEventFeed())
+
+	err := conn.WriteJSON(&wsRequest{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage("7"),
+		Method:  "swap_nope",
+	})
+	require.NoError(t, err)
+
+	var resp wsResponse
+	require.NoError(t, conn.ReadJSON(&resp))
+	require.Equal(t, "7", string(resp.ID))
+	require.Equal(t, errCodeMethodNotFound, resp.Error.Code)
+}